@@ -0,0 +1,118 @@
+package main
+
+// Dumping of raw elementary streams for analysis with tools like ffprobe.
+
+import (
+	"log"
+	"os"
+
+	"github.com/aboukirev/ouro/net/aac"
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+type (
+	// dumper writes H.264 video to .h264 file in Annex B format and AAC audio to .aac file with ADTS headers.
+	dumper struct {
+		files   []*os.File
+		streams map[byte]func(p *rtp.Packet) error
+		video   bool
+		audio   bool
+	}
+)
+
+// newDumper creates files named with prefix for the first video and audio feeds.
+func newDumper(prefix string, feeds []*rtsp.Feed) (*dumper, error) {
+	d := &dumper{streams: make(map[byte]func(p *rtp.Packet) error)}
+	for _, f := range feeds {
+		switch {
+		case f.Type == sdp.H264 && !d.video:
+			d.video = true
+			file, err := d.create(prefix + ".h264")
+			if err != nil {
+				return nil, err
+			}
+			w := h264.NewAnnexBWriter(file)
+			var sps, pps [][]byte
+			for _, b := range f.SpropParameterSets {
+				if len(b) > 0 && b[0]&0x1F == 7 {
+					sps = append(sps, b)
+				} else if len(b) > 0 && b[0]&0x1F == 8 {
+					pps = append(pps, b)
+				}
+			}
+			w.SetParameterSets(sps, pps)
+			asm := h264.NewAssembler()
+			asm.Sets = f.Sets
+			asm.SetFmtp(f.Fmtp)
+			d.streams[f.Channel()] = func(p *rtp.Packet) error {
+				units, err := asm.Push(p.PL, p.SN, p.TS, p.M())
+				if err != nil {
+					return err
+				}
+				for i := range units {
+					if units[i].Format != nil && units[i].Format.Old != nil {
+						log.Println("Format changed:", units[i].Format)
+					}
+					if err = w.WriteAccessUnit(&units[i]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		case f.Type == sdp.AAC && !d.audio:
+			config, err := aac.ParseConfig(f.Config)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			d.audio = true
+			file, err := d.create(prefix + ".aac")
+			if err != nil {
+				return nil, err
+			}
+			w := aac.NewADTSWriter(file, config)
+			depack := aac.NewDepacketizer(&f.Media)
+			d.streams[f.Channel()] = func(p *rtp.Packet) error {
+				units, err := depack.Unpack(p.PL, p.M())
+				if err != nil {
+					return err
+				}
+				for _, au := range units {
+					if err = w.WriteFrame(au); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+	}
+	return d, nil
+}
+
+func (d *dumper) create(name string) (*os.File, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	d.files = append(d.files, f)
+	return f, nil
+}
+
+// Write passes RTP packet to the stream on its channel.
+func (d *dumper) Write(ch byte, p *rtp.Packet) error {
+	if write, ok := d.streams[ch]; ok {
+		return write(p)
+	}
+	return nil
+}
+
+// Close closes all files.
+func (d *dumper) Close() {
+	for _, f := range d.files {
+		f.Close()
+	}
+}
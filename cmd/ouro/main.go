@@ -5,22 +5,41 @@ import (
 	"flag"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/capture"
+	"github.com/aboukirev/ouro/net/jpeg"
+	"github.com/aboukirev/ouro/net/onvif"
+	"github.com/aboukirev/ouro/net/record"
 	"github.com/aboukirev/ouro/net/rtcp"
 	"github.com/aboukirev/ouro/net/rtp"
 	"github.com/aboukirev/ouro/net/rtsp"
 )
 
-func handleStates(s *rtsp.Session) {
+var (
+	recordDir  = flag.String("record", "", "Directory to record session into MP4 files")
+	fragmented = flag.Bool("fragmented", false, "Keep recorded files fragmented")
+	rotate     = flag.Duration("rotate", 10*time.Minute, "Maximum duration of recorded file")
+	dump       = flag.String("dump", "", "Prefix of .h264 and .aac files to dump raw elementary streams into")
+	captureTo  = flag.String("capture", "", "File to capture session into, pcapng unless extension is .rtpdump which gets .sdp file alongside")
+	replay     = flag.String("replay", "", "Capture file in pcap, pcapng, or rtpdump format to replay instead of connecting to camera")
+	speed      = flag.Float64("speed", 1, "Pace of replay relative to original, 0 to replay as fast as possible")
+	snapshot   = flag.String("snapshot", "", "File to write the latest frame of Motion JPEG feed into at the end of session")
+)
+
+func handleStates(s *rtsp.Session, rtpdump *capture.RTPDumpWriter) {
+	var rec *record.Recorder
+	var dmp *dumper
+	snap := &jpeg.Snapshot{}
+	loggers := make(map[*onvif.Depacketizer]bool) // Metadata depacketizers with events being logged.
 	state := rtsp.StageInit
-	nalsink := h264.NewNALSink()
 	tmr := time.NewTimer(time.Minute)
 	tmr.Stop()
 	for {
 		select {
-		case <- tmr.C:
+		case <-tmr.C:
 			log.Printf("Timer: stage=%d\n", state)
 			if state == rtsp.StagePlay {
 				if err := s.Pause(); err != nil {
@@ -34,11 +53,56 @@ func handleStates(s *rtsp.Session) {
 			} else if state == rtsp.StageDone {
 				return
 			}
-		case state = <- s.State:
+		case state = <-s.State:
 			switch state {
 			case rtsp.StageReady:
 				log.Println("Stage: Ready")
-				if err := s.Play(); err != nil {
+				if rtpdump != nil {
+					// rtpdump keeps packets only.  Replay needs session description to depacketize them.
+					if err := os.WriteFile(sdpFile(*captureTo), s.SDP(), 0644); err != nil {
+						log.Println(err)
+					}
+				}
+				if *recordDir != "" && rec == nil {
+					var err error
+					if rec, err = record.NewRecorder(*recordDir, s.Feeds()); err != nil {
+						log.Fatal(err)
+					}
+					rec.Fragmented = *fragmented
+					rec.MaxDuration = *rotate
+					if names, err := rec.Recover(); err != nil {
+						log.Println(err)
+					} else if len(names) > 0 {
+						log.Println("Recovered", names)
+					}
+				}
+				if *dump != "" && dmp == nil {
+					var err error
+					if dmp, err = newDumper(*dump, s.Feeds()); err != nil {
+						log.Fatal(err)
+					}
+				}
+				current := make(map[*onvif.Depacketizer]bool)
+				for _, f := range s.Feeds() {
+					switch d := f.Depacketizer.(type) {
+					case *jpeg.Depacketizer:
+						d.Snapshot = snap
+					case *onvif.Depacketizer:
+						current[d] = true
+						if !loggers[d] {
+							loggers[d] = true
+							go logEvents(d.Events)
+						}
+					}
+				}
+				// Feeds replaced upon redirect do not receive packets anymore.
+				for d := range loggers {
+					if !current[d] {
+						d.Close()
+						delete(loggers, d)
+					}
+				}
+				if err := s.Play(nil); err != nil {
 					log.Fatal(err)
 				}
 			case rtsp.StagePlay:
@@ -49,36 +113,54 @@ func handleStates(s *rtsp.Session) {
 				tmr.Reset(time.Second * 5)
 			case rtsp.StageDone:
 				log.Println("Stage: Done")
+				if rec != nil {
+					if err := rec.Close(); err != nil {
+						log.Println(err)
+					}
+				}
+				if dmp != nil {
+					dmp.Close()
+				}
+				for d := range loggers {
+					d.Close()
+					delete(loggers, d)
+				}
+				if *snapshot != "" {
+					if err := snap.WriteFile(*snapshot); err != nil {
+						log.Println(err)
+					}
+				}
 				tmr.Reset(time.Second * 5)
 			default:
 				log.Printf("Stage: %d\n", state)
 			}
-		case pkt := <- s.Data:
-            if pkt.Channel % 2 == 0 {
-				var p *rtp.Packet
-				var err error
-				if p, err = rtp.Unpack(pkt.Payload); err != nil {
+		case pkt := <-s.Data:
+			if rtpdump != nil {
+				if err := rtpdump.WritePacket(time.Now(), pkt); err != nil {
 					log.Println(err)
-					return
 				}
-			
-				log.Printf("RTP [%d] PT=%d, CC=%d, M=%t, SN=%d\r\n", pkt.Channel, p.PT(), p.CC(), p.M(), p.SN)
-			
-				buf := p.PL
-				if buf != nil {
-					err := nalsink.Push(buf, p.TS)
-					if err != nil {
-						log.Println(err)
-						// log.Println(hex.Dump(buf))
-						return
-					}
-					for _, nal := range nalsink.Units {
-						log.Printf("NAL Zero=%t, RefIdc=%d, Type=%d, Size=%d\r\n", nal.ZeroBit(), nal.RefIdc(), nal.Type(), len(nal.Data))
-						// log.Println(hex.Dump(nal.Data))
-						// TODO: Detect IDR (Type == 5)
-						// TODO: Feed video packets to HLS/MP4/DASH emitter.
+			}
+			if rec != nil {
+				if err := rec.WritePacket(pkt); err != nil {
+					log.Println(err)
+				}
+			}
+			f, frames, err := s.Frames(pkt)
+			if err != nil {
+				log.Println(err)
+			}
+			if f != nil {
+				if dmp != nil {
+					if p, err := rtp.Unpack(pkt.Payload); err == nil {
+						if err = dmp.Write(pkt.Channel, p); err != nil {
+							log.Println(err)
+						}
 					}
 				}
+				for _, fr := range frames {
+					log.Printf("Frame [%d] %s TS=%d, Sync=%t, Size=%d\r\n", pkt.Channel, f.EncodingName, fr.TS, fr.Sync, len(fr.Data))
+					// TODO: Feed frames to HLS/MP4/DASH emitter.
+				}
 			} else {
 				var p *rtcp.Packet
 				var err error
@@ -87,25 +169,97 @@ func handleStates(s *rtsp.Session) {
 					// log.Panicln(hex.Dump(pkt.Payload))
 					return
 				}
-			
+
 				log.Printf("RTCP [%d] PT=%d, LN=%d, C=%d\r\n", pkt.Channel, p.PT, p.LN, p.C())
 			}
 		}
 	}
 }
 
+// logEvents logs events from ONVIF metadata stream until depacketizer is closed.
+func logEvents(events <-chan onvif.Event) {
+	for e := range events {
+		log.Printf("Event %s %s %s Active=%t, Objects=%d\r\n", e.Time.Format(time.RFC3339), e.Kind, e.Topic, e.Active, len(e.Objects))
+	}
+}
+
 func main() {
 	flag.Parse()
-	if flag.NArg() != 1 {
+	url := "rtsp://replay/"
+	if flag.NArg() == 1 {
+		url = flag.Arg(0)
+	} else if flag.NArg() != 0 || *replay == "" {
 		log.Panic("Wrong number of arguments.  Exactly one is expected.")
 	}
-	url := flag.Arg(0)
 
 	log.SetOutput(os.Stdout)
-	sess := rtsp.NewSession()
-	err := sess.Open(url, rtsp.ProtoTCP)
+	var conn *rtsp.Conn
+	var err error
+	if *replay != "" {
+		conn, err = openReplay(*replay, url)
+	} else {
+		conn, err = rtsp.Dial(url, rtsp.ProtoTCP)
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
-	handleStates(sess)
+	var rtpdump *capture.RTPDumpWriter
+	if *captureTo != "" {
+		f, err := os.Create(*captureTo)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		if filepath.Ext(*captureTo) == ".rtpdump" {
+			rtpdump, err = capture.NewRTPDumpWriter(f, conn.RemoteAddr(), time.Now())
+		} else {
+			var w *capture.PcapWriter
+			if w, err = capture.NewPcapWriter(f, conn.LocalAddr(), conn.RemoteAddr()); err == nil {
+				conn.Tap = w.Tap
+			}
+		}
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+	sess := rtsp.NewSession()
+	if filepath.Ext(*replay) == ".rtpdump" {
+		// Replay of rtpdump file is made up around packets and does not negotiate version.
+		sess.Version = rtsp.Version10
+	}
+	if err = sess.Attach(conn); err != nil {
+		log.Fatalln(err)
+	}
+	handleStates(sess, rtpdump)
+}
+
+// openReplay reads captured session and serves it over in-memory connection.  Packets from rtpdump file are
+// served with session description from .sdp file next to it.
+func openReplay(name, url string) (*rtsp.Conn, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(name) == ".rtpdump" {
+		desc, err := os.ReadFile(sdpFile(name))
+		if err != nil {
+			return nil, err
+		}
+		packets, err := capture.ReadRTPDump(f)
+		if err != nil {
+			return nil, err
+		}
+		return rtsp.NewConn(capture.ReplayRTPDump(desc, packets, *speed), url, rtsp.ProtoTCP)
+	}
+	segments, err := capture.ReadPcap(f)
+	if err != nil {
+		return nil, err
+	}
+	return rtsp.NewConn(capture.Replay(segments, *speed), url, rtsp.ProtoTCP)
+}
+
+// sdpFile returns name of session description file kept alongside rtpdump file.
+func sdpFile(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".sdp"
 }
@@ -1 +1,3 @@
 module github.com/aboukirev/ouro
+
+go 1.16
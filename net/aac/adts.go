@@ -0,0 +1,54 @@
+package aac
+
+import (
+	"errors"
+	"io"
+)
+
+// Size of ADTS header without CRC.
+const adtsHeaderSize = 7
+
+var (
+	errFrameTooLarge = errors.New("Access unit is too large for ADTS frame")
+)
+
+// ADTSWriter writes AAC access units as elementary stream with ADTS headers, e.g. for ffprobe.
+type ADTSWriter struct {
+	w      io.Writer
+	config Config
+}
+
+// NewADTSWriter creates writer of ADTS stream for audio with given configuration.
+func NewADTSWriter(w io.Writer, config Config) *ADTSWriter {
+	return &ADTSWriter{w: w, config: config}
+}
+
+// WriteFrame writes access unit preceded by ADTS header.
+func (a *ADTSWriter) WriteFrame(au []byte) error {
+	hdr, err := ADTSHeader(a.config, len(au))
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(append(hdr, au...))
+	return err
+}
+
+// ADTSHeader formats ADTS header without CRC for access unit of a given size.
+func ADTSHeader(c Config, size int) ([]byte, error) {
+	n := size + adtsHeaderSize
+	if n > 0x1FFF {
+		return nil, errFrameTooLarge
+	}
+	profile := byte(c.ObjectType-1) & 0x03
+	idx := byte(c.SampleRateIndex())
+	ch := byte(c.Channels) & 0x07
+	return []byte{
+		0xFF,
+		0xF1, // MPEG-4, layer 0, no CRC.
+		profile<<6 | idx<<2 | ch>>2,
+		ch<<6 | byte(n>>11),
+		byte(n >> 3),
+		byte(n<<5) | 0x1F, // Buffer fullness 0x7FF signals variable bit rate.
+		0xFC,
+	}, nil
+}
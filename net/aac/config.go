@@ -0,0 +1,95 @@
+package aac
+
+import (
+	"errors"
+
+	"github.com/aboukirev/ouro/net/h264"
+)
+
+var (
+	errConfigTooShort      = errors.New("Audio specific config is too short")
+	errInvalidSampleRate   = errors.New("Invalid sampling frequency index")
+	errInvalidAUHeaderSize = errors.New("Invalid AU header section")
+)
+
+// Sampling frequencies indexed by sampling frequency index from ISO/IEC 14496-3.
+var sampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+type (
+	// Config holds essential fields of AudioSpecificConfig as defined in ISO/IEC 14496-3.
+	Config struct {
+		ObjectType int // Audio object type, e.g. 2 for AAC LC.
+		SampleRate int
+		Channels   int
+	}
+)
+
+// ParseConfig parses AudioSpecificConfig, e.g. from `config` parameter in SDP.
+func ParseConfig(buf []byte) (c Config, err error) {
+	if len(buf) < 2 {
+		return c, errConfigTooShort
+	}
+	return readConfig(h264.NewBitReader(buf))
+}
+
+// readConfig reads essential fields of AudioSpecificConfig that may start at any bit, e.g. within StreamMuxConfig.
+func readConfig(br *h264.BitReader) (c Config, err error) {
+	var val uint32
+	if val, err = br.ReadBits(5); err != nil {
+		return
+	}
+	c.ObjectType = int(val)
+	if c.ObjectType == 31 {
+		if val, err = br.ReadBits(6); err != nil {
+			return
+		}
+		c.ObjectType = 32 + int(val)
+	}
+	if val, err = br.ReadBits(4); err != nil {
+		return
+	}
+	switch {
+	case val == 15:
+		if val, err = br.ReadBits(24); err != nil {
+			return
+		}
+		c.SampleRate = int(val)
+	case int(val) < len(sampleRates):
+		c.SampleRate = sampleRates[val]
+	default:
+		return c, errInvalidSampleRate
+	}
+	if val, err = br.ReadBits(4); err != nil {
+		return
+	}
+	c.Channels = int(val)
+	if c.Channels == 7 {
+		c.Channels = 8
+	}
+	return
+}
+
+// SampleRateIndex returns sampling frequency index for the configured rate or 15 if rate is not standard.
+func (c Config) SampleRateIndex() int {
+	for i, rate := range sampleRates {
+		if rate == c.SampleRate {
+			return i
+		}
+	}
+	return 15
+}
+
+// Bytes formats AudioSpecificConfig.
+func (c Config) Bytes() []byte {
+	idx := c.SampleRateIndex()
+	if idx == 15 {
+		return []byte{
+			byte(c.ObjectType<<3) | 0x07,
+			byte(0x80 | c.SampleRate>>17),
+			byte(c.SampleRate >> 9),
+			byte(c.SampleRate >> 1),
+			byte(c.SampleRate<<7) | byte(c.Channels<<3),
+		}
+	}
+	return []byte{byte(c.ObjectType<<3) | byte(idx>>1), byte(idx<<7) | byte(c.Channels<<3)}
+}
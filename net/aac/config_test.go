@@ -0,0 +1,59 @@
+package aac
+
+import (
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig([]byte{0x14, 0x08})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ObjectType != 2 || c.SampleRate != 16000 || c.Channels != 1 {
+		t.Errorf("Wrong config %+v", c)
+	}
+	if b := c.Bytes(); string(b) != "\x14\x08" {
+		t.Errorf("Wrong encoding % x", b)
+	}
+	c.SampleRate = 12345
+	c2, err := ParseConfig(c.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c2 != c {
+		t.Errorf("Expected %+v, got %+v", c, c2)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	d := &Depacketizer{SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+	// Two AU headers of 16 bits each: sizes 3 and 2.
+	buf := []byte{0x00, 0x20, 0x00, 0x18, 0x00, 0x10, 1, 2, 3, 4, 5}
+	units, err := d.Unpack(buf, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || string(units[0]) != "\x01\x02\x03" || string(units[1]) != "\x04\x05" {
+		t.Errorf("Wrong units %v", units)
+	}
+	// Access unit of 4 bytes fragmented over two packets.
+	if units, err = d.Unpack([]byte{0x00, 0x10, 0x00, 0x20, 1, 2}, false); err != nil || len(units) != 0 {
+		t.Fatal(units, err)
+	}
+	if units, err = d.Unpack([]byte{0x00, 0x10, 0x00, 0x20, 3, 4}, true); err != nil || len(units) != 1 || len(units[0]) != 4 {
+		t.Fatal(units, err)
+	}
+}
+
+func TestADTSHeader(t *testing.T) {
+	hdr, err := ADTSHeader(Config{ObjectType: 2, SampleRate: 16000, Channels: 1}, 124)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\xff\xf1\x60\x40\x10\x7f\xfc"; string(hdr) != expected {
+		t.Errorf("Expected % x, got % x", expected, hdr)
+	}
+	if _, err = ADTSHeader(Config{ObjectType: 2, SampleRate: 16000, Channels: 1}, 0x2000); err == nil {
+		t.Error("Expected error for oversized frame")
+	}
+}
@@ -0,0 +1,325 @@
+package aac
+
+// LATM payload format of AAC defined in RFC 6416, formerly RFC 3016, with StreamMuxConfig and AudioMuxElement
+// of ISO/IEC 14496-3 section 1.7.3.
+
+import (
+	"errors"
+
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+var (
+	errInvalidMuxConfig     = errors.New("Invalid LATM StreamMuxConfig")
+	errUnsupportedMuxConfig = errors.New("Unsupported LATM StreamMuxConfig")
+	errMissingMuxConfig     = errors.New("Missing LATM StreamMuxConfig")
+	errInvalidMuxElement    = errors.New("Invalid LATM AudioMuxElement")
+)
+
+type (
+	// StreamMuxConfig holds LATM multiplex configuration of a single program and layer.
+	StreamMuxConfig struct {
+		AudioMuxVersion int
+		SameTimeFraming bool // All payloads of a multiplex element share the same time.
+		NumSubFrames    int  // Number of access units in each multiplex element.
+		FrameLengthType int  // Only variable frame length, type 0, is supported.
+		Config          Config
+		OtherDataBits   int  // Length of other data that follows payloads in bits.
+		CRCPresent      bool // Checksum of the configuration follows it.
+	}
+
+	// LATMDepacketizer extracts AAC access units from RTP payload in MP4A-LATM format.  Multiplex
+	// configuration comes from SDP unless it is carried in-band.
+	LATMDepacketizer struct {
+		MuxConfig *StreamMuxConfig
+		InBand    bool // Configuration may precede each multiplex element, cpresent=1.
+		marker    rtp.MarkerDepacketizer
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("MP4A-LATM", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		d, err := NewLATMDepacketizer(m)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	})
+}
+
+// NewLATMDepacketizer creates depacketizer with multiplex configuration from `config` parameter in SDP.
+// Configuration is expected in-band only if `cpresent` is explicitly 1, as most devices omit the parameter
+// and send configuration out of band despite default of RFC 6416.
+func NewLATMDepacketizer(m *sdp.Media) (*LATMDepacketizer, error) {
+	d := &LATMDepacketizer{InBand: m.Fmtp["cpresent"] == "1"}
+	if len(m.Config) > 0 {
+		c, err := ParseStreamMuxConfig(m.Config)
+		if err != nil {
+			return nil, err
+		}
+		d.MuxConfig = c
+	} else if !d.InBand {
+		return nil, errMissingMuxConfig
+	}
+	return d, nil
+}
+
+// ParseStreamMuxConfig parses StreamMuxConfig, e.g. from `config` parameter in SDP.
+func ParseStreamMuxConfig(buf []byte) (*StreamMuxConfig, error) {
+	return readStreamMuxConfig(h264.NewBitReader(buf))
+}
+
+// latmValue reads variable length value of LATM, LatmGetValue.
+func latmValue(br *h264.BitReader) (val int, err error) {
+	n, err := br.ReadBits(2)
+	if err != nil {
+		return
+	}
+	for i := uint32(0); i <= n; i++ {
+		b, err := br.ReadBits(8)
+		if err != nil {
+			return 0, err
+		}
+		val = val<<8 | int(b)
+	}
+	return
+}
+
+// skipBits skips any number of bits.
+func skipBits(br *h264.BitReader, n uint) error {
+	for ; n > 32; n -= 32 {
+		if err := br.SkipBits(32); err != nil {
+			return err
+		}
+	}
+	return br.SkipBits(n)
+}
+
+func readStreamMuxConfig(br *h264.BitReader) (c *StreamMuxConfig, err error) {
+	c = &StreamMuxConfig{}
+	var val uint32
+	if val, err = br.ReadBits(1); err != nil {
+		return nil, errInvalidMuxConfig
+	}
+	c.AudioMuxVersion = int(val)
+	if c.AudioMuxVersion == 1 {
+		// Version A other than 0 is reserved.  Buffer fullness is not needed.
+		if val, err = br.ReadBits(1); err != nil || val != 0 {
+			return nil, errUnsupportedMuxConfig
+		}
+		if _, err = latmValue(br); err != nil {
+			return nil, errInvalidMuxConfig
+		}
+	}
+	if val, err = br.ReadBits(1 + 6 + 4 + 3); err != nil {
+		return nil, errInvalidMuxConfig
+	}
+	c.SameTimeFraming = val>>13 != 0
+	c.NumSubFrames = int(val>>7&0x3F) + 1
+	// Number of programs and layers minus 1.
+	if val&0x7F != 0 {
+		return nil, errUnsupportedMuxConfig
+	}
+	if c.AudioMuxVersion == 1 {
+		var size int
+		if size, err = latmValue(br); err != nil {
+			return nil, errInvalidMuxConfig
+		}
+		start := br.Available()
+		if c.Config, err = readFullConfig(br); err != nil {
+			return nil, err
+		}
+		if used := int(start - br.Available()); used > size || skipBits(br, uint(size-used)) != nil {
+			return nil, errInvalidMuxConfig
+		}
+	} else if c.Config, err = readFullConfig(br); err != nil {
+		return nil, err
+	}
+	if val, err = br.ReadBits(3); err != nil {
+		return nil, errInvalidMuxConfig
+	}
+	if c.FrameLengthType = int(val); c.FrameLengthType != 0 {
+		return nil, errUnsupportedMuxConfig
+	}
+	if err = br.SkipBits(8); err != nil { // Buffer fullness.
+		return nil, errInvalidMuxConfig
+	}
+	var flag bool
+	if flag, err = br.ReadFlag(); err != nil {
+		return nil, errInvalidMuxConfig
+	}
+	if flag {
+		if c.AudioMuxVersion == 1 {
+			c.OtherDataBits, err = latmValue(br)
+		} else {
+			for escape := true; escape && err == nil; {
+				if escape, err = br.ReadFlag(); err == nil {
+					val, err = br.ReadBits(8)
+					c.OtherDataBits = c.OtherDataBits<<8 | int(val)
+				}
+			}
+		}
+		if err != nil {
+			return nil, errInvalidMuxConfig
+		}
+	}
+	if c.CRCPresent, err = br.ReadFlag(); err != nil {
+		return nil, errInvalidMuxConfig
+	}
+	if c.CRCPresent {
+		if err = br.SkipBits(8); err != nil {
+			return nil, errInvalidMuxConfig
+		}
+	}
+	return c, nil
+}
+
+// readFullConfig reads AudioSpecificConfig including explicit SBR and PS signalling, GASpecificConfig, and
+// error protection configuration, so that fields that follow it can be read.
+func readFullConfig(br *h264.BitReader) (c Config, err error) {
+	if c, err = readConfig(br); err != nil {
+		return
+	}
+	typ := c.ObjectType
+	if typ == 5 || typ == 29 {
+		// Extension sampling frequency and object type of the core.
+		var val uint32
+		if val, err = br.ReadBits(4); err == nil && val == 15 {
+			err = br.SkipBits(24)
+		}
+		if err == nil {
+			val, err = br.ReadBits(5)
+		}
+		if err == nil && val == 31 {
+			val, err = br.ReadBits(6)
+			val += 32
+		}
+		if err != nil {
+			return c, errInvalidMuxConfig
+		}
+		typ = int(val)
+	}
+	switch typ {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+		if err = skipGASpecificConfig(br, typ, c.Channels); err != nil {
+			return
+		}
+	default:
+		return c, errUnsupportedMuxConfig
+	}
+	if typ == 17 || (typ >= 19 && typ <= 27) {
+		if err = br.SkipBits(2); err != nil { // Error protection configuration.
+			return c, errInvalidMuxConfig
+		}
+	}
+	return
+}
+
+// skipGASpecificConfig skips GASpecificConfig of general audio object types.  Program configuration element
+// used when channel configuration is 0 is not supported.
+func skipGASpecificConfig(br *h264.BitReader, typ, channels int) error {
+	if channels == 0 {
+		return errUnsupportedMuxConfig
+	}
+	if br.SkipBits(1) != nil { // Frame length flag.
+		return errInvalidMuxConfig
+	}
+	if core, err := br.ReadFlag(); err != nil || (core && br.SkipBits(14) != nil) {
+		return errInvalidMuxConfig
+	}
+	ext, err := br.ReadFlag()
+	if err != nil {
+		return errInvalidMuxConfig
+	}
+	if typ == 6 || typ == 20 {
+		err = br.SkipBits(3) // Layer number.
+	}
+	if ext && err == nil {
+		if typ == 22 {
+			err = br.SkipBits(5 + 11)
+		}
+		if err == nil && (typ == 17 || typ == 19 || typ == 20 || typ == 23) {
+			err = br.SkipBits(3)
+		}
+		if err == nil {
+			err = br.SkipBits(1) // Extension flag 3.
+		}
+	}
+	if err != nil {
+		return errInvalidMuxConfig
+	}
+	return nil
+}
+
+// Push takes RTP packet and returns frames for access units of multiplex elements completed by it.
+func (d *LATMDepacketizer) Push(p *rtp.Packet) (frames []rtp.Frame, err error) {
+	elements, err := d.marker.Push(p)
+	for _, e := range elements {
+		units, e := d.Unpack(e.Data)
+		for _, au := range units {
+			frames = append(frames, rtp.Frame{TS: p.TS + uint32(len(frames)*frameSamples), Data: au, Sync: true})
+		}
+		if e != nil {
+			err = e
+		}
+	}
+	return
+}
+
+// Unpack splits one or more complete AudioMuxElements into access units.
+func (d *LATMDepacketizer) Unpack(buf []byte) (units [][]byte, err error) {
+	br := h264.NewBitReader(buf)
+	for br.Available() >= 8 {
+		if d.InBand {
+			var same bool
+			if same, err = br.ReadFlag(); err != nil {
+				return units, errInvalidMuxElement
+			}
+			if !same {
+				if d.MuxConfig, err = readStreamMuxConfig(br); err != nil {
+					return
+				}
+			}
+		}
+		if d.MuxConfig == nil {
+			return units, errMissingMuxConfig
+		}
+		// Payload length of each subframe is followed by its payload, PayloadLengthInfo and PayloadMux.
+		for i := 0; i < d.MuxConfig.NumSubFrames; i++ {
+			size := 0
+			for {
+				val, e := br.ReadBits(8)
+				if e != nil {
+					return units, errInvalidMuxElement
+				}
+				size += int(val)
+				if val != 255 {
+					break
+				}
+			}
+			if uint(size)*8 > br.Available() {
+				return units, errInvalidMuxElement
+			}
+			au := make([]byte, size)
+			if off := len(buf) - int(br.Available()/8); br.Available()%8 == 0 {
+				copy(au, buf[off:off+size])
+				skipBits(br, uint(size)*8)
+			} else {
+				for j := range au {
+					au[j], _ = br.ReadByteBits(8)
+				}
+			}
+			units = append(units, au)
+		}
+		if err = skipBits(br, uint(d.MuxConfig.OtherDataBits)); err != nil {
+			return units, errInvalidMuxElement
+		}
+		if d.InBand {
+			// Multiplex elements are byte aligned in RTP payload.
+			br.SkipBits(br.Available() % 8)
+		}
+	}
+	return
+}
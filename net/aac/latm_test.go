@@ -0,0 +1,66 @@
+package aac
+
+import (
+	"testing"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// bitString packs string of 0 and 1 into bytes padded with zero bits.
+func bitString(s string) []byte {
+	buf := make([]byte, (len(s)+7)/8)
+	for i, c := range s {
+		if c == '1' {
+			buf[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return buf
+}
+
+func TestParseStreamMuxConfig(t *testing.T) {
+	c, err := ParseStreamMuxConfig([]byte{0x40, 0x00, 0x26, 0x20, 0x3f, 0xc0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AudioMuxVersion != 0 || !c.SameTimeFraming || c.NumSubFrames != 1 || c.Config != (Config{ObjectType: 2, SampleRate: 24000, Channels: 2}) {
+		t.Errorf("Wrong config %+v", c)
+	}
+	if b := c.Config.Bytes(); string(b) != "\x13\x10" {
+		t.Errorf("Wrong AudioSpecificConfig % x", b)
+	}
+	if _, err = ParseStreamMuxConfig([]byte{0x40, 0x02}); err != errUnsupportedMuxConfig {
+		t.Errorf("Expected unsupported config for several programs, got %v", err)
+	}
+}
+
+func TestUnpackLATM(t *testing.T) {
+	d, err := NewLATMDepacketizer(&sdp.Media{Config: []byte{0x40, 0x00, 0x26, 0x20, 0x3f, 0xc0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Access unit of 265 bytes whose length takes two bytes, fragmented over two packets.
+	buf := append([]byte{0xFF, 0x0A}, make([]byte, 265)...)
+	buf[266] = 0x77
+	if frames, err := d.Push(rtp.NewPacket(96, false, 1, 1000, 1, buf[:100])); frames != nil || err != nil {
+		t.Fatal(frames, err)
+	}
+	frames, err := d.Push(rtp.NewPacket(96, true, 2, 1000, 1, buf[100:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || len(frames[0].Data) != 265 || frames[0].Data[264] != 0x77 || frames[0].TS != 1000 {
+		t.Errorf("Wrong frames %+v", frames)
+	}
+
+	// In-band configuration shifts payload off byte boundary.
+	d = &LATMDepacketizer{InBand: true}
+	config := "01000000000000000010011000100000001111111100"
+	units, err := d.Unpack(bitString("0" + config + "00000010" + "1010101111001101" + "000" + "1" + "00000001" + "11110000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || string(units[0]) != "\xab\xcd" || string(units[1]) != "\xf0" || d.MuxConfig.Config.SampleRate != 24000 {
+		t.Errorf("Wrong units % x", units)
+	}
+}
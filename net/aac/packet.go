@@ -0,0 +1,102 @@
+package aac
+
+import (
+	"encoding/binary"
+	"strconv"
+
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+var be = binary.BigEndian
+
+// Number of samples in AAC frame, which advances RTP timestamp of each subsequent access unit in a packet.
+const frameSamples = 1024
+
+type (
+	// Depacketizer extracts AAC access units from RTP payload in MPEG4-GENERIC format as defined in RFC 3640.
+	// Sizes of fields in AU headers come from format specific parameters in SDP.
+	Depacketizer struct {
+		SizeLength       int
+		IndexLength      int
+		IndexDeltaLength int
+		frag             []byte // Fragments of access unit that spans several packets.
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("MPEG4-GENERIC", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		return NewDepacketizer(m), nil
+	})
+}
+
+// NewDepacketizer creates depacketizer with sizes of AU header fields from format specific parameters of media.
+func NewDepacketizer(m *sdp.Media) *Depacketizer {
+	d := &Depacketizer{SizeLength: m.SizeLength, IndexLength: m.IndexLength}
+	d.IndexDeltaLength, _ = strconv.Atoi(m.Fmtp["indexdeltalength"])
+	return d
+}
+
+// Push takes RTP packet and returns frames for access units completed by it.
+func (d *Depacketizer) Push(p *rtp.Packet) (frames []rtp.Frame, err error) {
+	units, err := d.Unpack(p.PL, p.M())
+	for i, au := range units {
+		frames = append(frames, rtp.Frame{TS: p.TS + uint32(i*frameSamples), Data: au, Sync: true})
+	}
+	return frames, err
+}
+
+// Unpack splits RTP payload into access units.  Fragmented access unit is returned once
+// packet with the last fragment, i.e. with marker bit set, arrives.
+func (d *Depacketizer) Unpack(buf []byte, marker bool) (units [][]byte, err error) {
+	if d.SizeLength == 0 {
+		// No AU headers section, the payload is a single access unit.
+		return [][]byte{buf}, nil
+	}
+	if len(buf) < 2 {
+		return nil, errInvalidAUHeaderSize
+	}
+	bits := int(be.Uint16(buf))
+	n := (bits + 7) / 8
+	if len(buf) < 2+n {
+		return nil, errInvalidAUHeaderSize
+	}
+	br := h264.NewBitReader(buf[2 : 2+n])
+	data := buf[2+n:]
+	var sizes []int
+	for i := 0; bits > 0; i++ {
+		var size uint32
+		if size, err = br.ReadBits(uint(d.SizeLength)); err != nil {
+			return nil, errInvalidAUHeaderSize
+		}
+		idx := d.IndexDeltaLength
+		if i == 0 {
+			idx = d.IndexLength
+		}
+		if err = br.SkipBits(uint(idx)); err != nil {
+			return nil, errInvalidAUHeaderSize
+		}
+		bits -= d.SizeLength + idx
+		sizes = append(sizes, int(size))
+	}
+	if len(sizes) == 1 && sizes[0] > len(data) {
+		// Fragment of a large access unit.
+		d.frag = append(d.frag, data...)
+		if !marker {
+			return nil, nil
+		}
+		units = append(units, d.frag)
+		d.frag = nil
+		return
+	}
+	d.frag = nil
+	for _, size := range sizes {
+		if size > len(data) {
+			return units, errInvalidAUHeaderSize
+		}
+		units = append(units, data[:size])
+		data = data[size:]
+	}
+	return
+}
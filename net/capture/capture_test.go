@@ -0,0 +1,166 @@
+package capture
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/rtsp/rtsptest"
+)
+
+// play attaches session using a given RTSP version to connection, plays it, and collects packets until stream stops.
+func play(t *testing.T, conn *rtsp.Conn, version string) (packets []rtsp.RawPacket) {
+	t.Helper()
+	s := rtsp.NewSession()
+	s.Version = version
+	if err := s.Attach(conn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Conn.Close() })
+	rtsptest.ExpectStage(t, s, rtsp.StageReady)
+	if err := s.Play(nil); err != nil {
+		t.Fatal(err)
+	}
+	rtsptest.ExpectStage(t, s, rtsp.StagePlay)
+	for {
+		select {
+		case pkt := <-s.Data:
+			packets = append(packets, pkt)
+		case <-time.After(500 * time.Millisecond):
+			return
+		}
+	}
+}
+
+// capture plays camera stream over a given protocol and returns pcapng capture of the session.
+func capture(t *testing.T, proto int) (*rtsptest.Camera, []rtsp.RawPacket, []byte) {
+	t.Helper()
+	cam := &rtsptest.Camera{SDP: []byte(rtsptest.SDP), Auth: rtsp.NewAuthenticator("rtsptest", map[string]string{"admin": "secret"}, true)}
+	var err error
+	if cam.Packets, err = rtsptest.LoadPackets(filepath.Join("..", "rtsp", "rtsptest", "testdata", "h264_aac.rtp")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cam.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cam.Close() })
+
+	conn, err := rtsp.Dial(cam.URL[:7]+"admin:secret@"+cam.URL[7:], proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	w, err := NewPcapWriter(buf, conn.LocalAddr(), conn.RemoteAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Tap = w.Tap
+	packets := play(t, conn, rtsp.Version20)
+	conn.Close()
+	if err = w.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return cam, packets, buf.Bytes()
+}
+
+func testReplay(t *testing.T, proto int) {
+	cam, packets, data := capture(t, proto)
+	if len(packets) != len(cam.Packets) {
+		t.Fatalf("expected %d packets, got %d", len(cam.Packets), len(packets))
+	}
+	segments, err := ReadPcap(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	captured := Packets(segments)
+	if len(captured) != len(packets) {
+		t.Fatalf("expected %d captured packets, got %d", len(packets), len(captured))
+	}
+
+	conn, err := rtsp.NewConn(Replay(segments, 0), "rtsp://127.0.0.1/stream", rtsp.ProtoTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := play(t, conn, rtsp.Version20)
+	if len(replayed) != len(packets) {
+		t.Fatalf("expected %d replayed packets, got %d", len(packets), len(replayed))
+	}
+	for i, pkt := range replayed {
+		if pkt.Channel != packets[i].Channel || !bytes.Equal(pkt.Payload, packets[i].Payload) {
+			t.Fatalf("packet %d differs", i)
+		}
+	}
+}
+
+func TestReplayTCP(t *testing.T) {
+	testReplay(t, rtsp.ProtoTCP)
+}
+
+func TestReplayUDP(t *testing.T) {
+	testReplay(t, rtsp.ProtoUnicast)
+}
+
+func TestRTPDump(t *testing.T) {
+	packets, err := rtsptest.LoadPackets(filepath.Join("..", "rtsp", "rtsptest", "testdata", "h264_aac.rtp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	buf := &bytes.Buffer{}
+	w, err := NewRTPDumpWriter(buf, nil, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range packets {
+		if err = w.WritePacket(start.Add(time.Duration(i)*time.Millisecond), rtsp.RawPacket{Channel: byte(p.Track * 2), Payload: p.Data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read, err := ReadRTPDump(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(packets) {
+		t.Fatalf("expected %d packets, got %d", len(packets), len(read))
+	}
+	for i, p := range read {
+		if p.Channel != byte(packets[i].Track*2) || !bytes.Equal(p.Payload, packets[i].Data) || !p.At.Equal(start.Add(time.Duration(i)*time.Millisecond)) {
+			t.Fatalf("packet %d differs: channel %d at %v", i, p.Channel, p.At)
+		}
+	}
+}
+
+func TestReplayRTPDump(t *testing.T) {
+	_, packets, _ := capture(t, rtsp.ProtoTCP)
+	start := time.Now()
+	buf := &bytes.Buffer{}
+	w, err := NewRTPDumpWriter(buf, nil, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, pkt := range packets {
+		if err = w.WritePacket(start.Add(time.Duration(i)*time.Millisecond), pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read, err := ReadRTPDump(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := rtsp.NewConn(ReplayRTPDump([]byte(rtsptest.SDP), read, 0), "rtsp://replay/", rtsp.ProtoTCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := play(t, conn, rtsp.Version10)
+	if len(replayed) != len(packets) {
+		t.Fatalf("expected %d replayed packets, got %d", len(packets), len(replayed))
+	}
+	for i, pkt := range replayed {
+		if pkt.Channel != packets[i].Channel || !bytes.Equal(pkt.Payload, packets[i].Payload) {
+			t.Fatalf("packet %d differs: channel %d instead of %d", i, pkt.Channel, packets[i].Channel)
+		}
+	}
+}
@@ -0,0 +1,536 @@
+package capture
+
+// Capture of RTSP sessions in pcapng format.  Traffic is written as IPv4 packets with TCP and UDP headers
+// so that Wireshark dissects RTSP and, following SETUP, RTP and RTCP.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+)
+
+// Block types of pcapng.
+const (
+	blockSection   = 0x0A0D0D0A
+	blockInterface = 0x00000001
+	blockSimple    = 0x00000003
+	blockEnhanced  = 0x00000006
+	byteOrderMagic = 0x1A2B3C4D
+)
+
+// Link types of captured packets.
+const (
+	linkEthernet = 1
+	linkRaw      = 101
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkSLL2     = 276
+)
+
+// Largest TCP payload in a single synthesized packet.
+const maxSegment = 0xFFFF - 40
+
+var (
+	errNotCapture     = errors.New("Not a pcap or pcapng file")
+	errTruncatedBlock = errors.New("Truncated capture block")
+	errNoRTSP         = errors.New("No RTSP conversation in capture")
+)
+
+var le = binary.LittleEndian
+var be = binary.BigEndian
+
+type (
+	// PcapWriter writes traffic of RTSP connection to pcapng file.  Its Tap method is meant to be set as
+	// rtsp.Conn.Tap.
+	PcapWriter struct {
+		mu     sync.Mutex
+		w      io.Writer
+		client endpoint
+		server endpoint
+		seq    [2]uint32 // Next TCP sequence number from client and from server.
+		id     uint16
+		err    error
+	}
+
+	endpoint struct {
+		ip   [4]byte
+		port int
+	}
+)
+
+// NewPcapWriter writes section and interface headers followed by TCP handshake between client and server.
+// Addresses that are not IPv4, e.g. of in-memory connections, are replaced with loopback ones.
+func NewPcapWriter(w io.Writer, client, server net.Addr) (*PcapWriter, error) {
+	p := &PcapWriter{
+		w:      w,
+		client: newEndpoint(client, [4]byte{127, 0, 0, 1}, 50000),
+		server: newEndpoint(server, [4]byte{127, 0, 0, 2}, 554),
+	}
+	b := make([]byte, 0, 48)
+	b = le.AppendUint32(b, blockSection)
+	b = le.AppendUint32(b, 28)
+	b = le.AppendUint32(b, byteOrderMagic)
+	b = le.AppendUint16(b, 1) // Version 1.0.
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint64(b, 0xFFFFFFFFFFFFFFFF) // Section length is not specified.
+	b = le.AppendUint32(b, 28)
+	b = le.AppendUint32(b, blockInterface)
+	b = le.AppendUint32(b, 20)
+	b = le.AppendUint16(b, linkIPv4)
+	b = le.AppendUint16(b, 0)
+	b = le.AppendUint32(b, 0) // No snapshot length limit.
+	b = le.AppendUint32(b, 20)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	// Handshake lets Wireshark follow the stream from the first byte.
+	now := time.Now()
+	p.tcp(now, false, 0x02, nil)
+	p.seq[0]++
+	p.tcp(now, true, 0x12, nil)
+	p.seq[1]++
+	p.tcp(now, false, 0x10, nil)
+	return p, p.err
+}
+
+func newEndpoint(addr net.Addr, ip [4]byte, port int) endpoint {
+	e := endpoint{ip: ip, port: port}
+	if a, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := a.IP.To4(); ip4 != nil {
+			copy(e.ip[:], ip4)
+			e.port = a.Port
+		}
+	}
+	return e
+}
+
+// Tap writes segment of traffic as one or more packets.
+func (p *PcapWriter) Tap(seg rtsp.Segment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if seg.UDP {
+		p.udp(seg)
+		return
+	}
+	for data := seg.Data; len(data) > 0; {
+		n := len(data)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		p.tcp(seg.At, seg.In, 0x18, data[:n])
+		data = data[n:]
+	}
+}
+
+// Err returns the first error that occurred while writing.
+func (p *PcapWriter) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// tcp writes packet with TCP segment in either direction.  Sequence numbers advance with data.
+func (p *PcapWriter) tcp(at time.Time, in bool, flags byte, data []byte) {
+	src, dst := p.client, p.server
+	seq, ack := &p.seq[0], p.seq[1]
+	if in {
+		src, dst = dst, src
+		seq, ack = &p.seq[1], p.seq[0]
+	}
+	h := make([]byte, 20, 20+len(data))
+	be.PutUint16(h, uint16(src.port))
+	be.PutUint16(h[2:], uint16(dst.port))
+	be.PutUint32(h[4:], *seq)
+	if flags&0x10 != 0 {
+		be.PutUint32(h[8:], ack)
+	}
+	h[12] = 5 << 4
+	h[13] = flags
+	be.PutUint16(h[14:], 0xFFFF) // Window.
+	h = append(h, data...)
+	*seq += uint32(len(data))
+	p.packet(at, 6, src, dst, h, 16)
+}
+
+// udp writes packet with UDP datagram.  Client ports are local ones.
+func (p *PcapWriter) udp(seg rtsp.Segment) {
+	src := endpoint{ip: p.client.ip, port: seg.Local}
+	dst := endpoint{ip: p.server.ip, port: seg.Remote}
+	if seg.In {
+		src, dst = dst, src
+	}
+	h := make([]byte, 8, 8+len(seg.Data))
+	be.PutUint16(h, uint16(src.port))
+	be.PutUint16(h[2:], uint16(dst.port))
+	be.PutUint16(h[4:], uint16(8+len(seg.Data)))
+	h = append(h, seg.Data...)
+	p.packet(seg.At, 17, src, dst, h, 6)
+}
+
+// packet wraps transport segment into IPv4 header and writes enhanced packet block.  Checksum of transport
+// header is at a given offset.
+func (p *PcapWriter) packet(at time.Time, proto byte, src, dst endpoint, payload []byte, checksum int) {
+	if p.err != nil {
+		return
+	}
+	ip := make([]byte, 20, 20+len(payload))
+	ip[0] = 0x45
+	be.PutUint16(ip[2:], uint16(20+len(payload)))
+	p.id++
+	be.PutUint16(ip[4:], p.id)
+	be.PutUint16(ip[6:], 0x4000) // Do not fragment.
+	ip[8] = 64
+	ip[9] = proto
+	copy(ip[12:], src.ip[:])
+	copy(ip[16:], dst.ip[:])
+	be.PutUint16(ip[10:], sum(0, ip))
+
+	pseudo := make([]byte, 12)
+	copy(pseudo, ip[12:20])
+	pseudo[9] = proto
+	be.PutUint16(pseudo[10:], uint16(len(payload)))
+	c := sum(uint32(sum(0, pseudo)^0xFFFF), payload)
+	if c == 0 && proto == 17 {
+		c = 0xFFFF
+	}
+	be.PutUint16(payload[checksum:], c)
+	ip = append(ip, payload...)
+
+	ts := uint64(at.UnixNano() / 1000)
+	size := 32 + (len(ip)+3)&^3
+	b := make([]byte, 0, size)
+	b = le.AppendUint32(b, blockEnhanced)
+	b = le.AppendUint32(b, uint32(size))
+	b = le.AppendUint32(b, 0) // Interface.
+	b = le.AppendUint32(b, uint32(ts>>32))
+	b = le.AppendUint32(b, uint32(ts))
+	b = le.AppendUint32(b, uint32(len(ip)))
+	b = le.AppendUint32(b, uint32(len(ip)))
+	b = append(b, ip...)
+	b = append(b, make([]byte, size-4-len(b))...)
+	b = le.AppendUint32(b, uint32(size))
+	_, p.err = p.w.Write(b)
+}
+
+// sum computes Internet checksum of data continuing from initial sum.
+func sum(initial uint32, data []byte) uint16 {
+	s := initial
+	for i := 0; i+1 < len(data); i += 2 {
+		s += uint32(be.Uint16(data[i:]))
+	}
+	if len(data)%2 == 1 {
+		s += uint32(data[len(data)-1]) << 8
+	}
+	for s > 0xFFFF {
+		s = s&0xFFFF + s>>16
+	}
+	return ^uint16(s)
+}
+
+type (
+	// frame is a captured link layer frame.
+	frame struct {
+		at   time.Time
+		link int
+		data []byte
+	}
+
+	// flow is a direction of TCP conversation being reassembled.
+	flow struct {
+		next    uint32
+		started bool
+	}
+)
+
+// ReadPcap reads RTSP session from pcap or pcapng file, e.g. one made by PcapWriter, tcpdump, or Wireshark.
+// The first TCP conversation that carries RTSP is reassembled into stream segments.  UDP datagrams between
+// the same hosts are returned as is; their channels are resolved from SETUP requests upon replay.
+func ReadPcap(r io.Reader) ([]rtsp.Segment, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var frames []frame
+	if len(data) >= 4 && le.Uint32(data) == blockSection {
+		frames, err = readBlocks(data)
+	} else {
+		frames, err = readRecords(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var client, server endpoint
+	var flows [2]flow
+	var loopback []int // Datagrams between client and server on the same host.
+	found := false
+	var segments []rtsp.Segment
+	for _, f := range frames {
+		proto, src, dst, payload, ok := parseIPv4(f.link, f.data)
+		if !ok {
+			continue
+		}
+		switch proto {
+		case 6:
+			if len(payload) < 20 {
+				continue
+			}
+			src.port = int(be.Uint16(payload))
+			dst.port = int(be.Uint16(payload[2:]))
+			seq := be.Uint32(payload[4:])
+			flags := payload[13]
+			off := int(payload[12]>>4) * 4
+			if off < 20 || off > len(payload) {
+				continue
+			}
+			body := payload[off:]
+			if !found {
+				if len(body) == 0 {
+					continue
+				}
+				// Server is the side that sends responses or interleaved data.
+				client, server = src, dst
+				if bytes.HasPrefix(body, []byte("RTSP/")) || body[0] == '$' {
+					client, server = dst, src
+				} else if !isRequest(body) {
+					continue
+				}
+				found = true
+			}
+			var in bool
+			switch {
+			case src == client && dst == server:
+			case src == server && dst == client:
+				in = true
+			default:
+				continue
+			}
+			fl := &flows[0]
+			if in {
+				fl = &flows[1]
+			}
+			if flags&0x02 != 0 {
+				fl.next, fl.started = seq+1, true
+				continue
+			}
+			if fl.started {
+				// Drop retransmitted data and accept anything beyond a gap.
+				if d := int32(fl.next - seq); d > 0 {
+					if int(d) >= len(body) {
+						continue
+					}
+					body = body[d:]
+					seq = fl.next
+				}
+			}
+			fl.next, fl.started = seq+uint32(len(body)), true
+			if len(body) > 0 {
+				segments = append(segments, rtsp.Segment{At: f.at, In: in, Data: body})
+			}
+		case 17:
+			if !found || len(payload) < 8 {
+				continue
+			}
+			seg := rtsp.Segment{At: f.at, UDP: true, Data: payload[8:]}
+			sport, dport := int(be.Uint16(payload)), int(be.Uint16(payload[2:]))
+			switch {
+			case src.ip == client.ip && dst.ip == server.ip:
+				seg.Local, seg.Remote = sport, dport
+				if client.ip == server.ip {
+					// Direction is resolved by client ports later.
+					loopback = append(loopback, len(segments))
+				}
+			case src.ip == server.ip && dst.ip == client.ip:
+				seg.In, seg.Local, seg.Remote = true, dport, sport
+			default:
+				continue
+			}
+			if n := int(be.Uint16(payload[4:])); n >= 8 && n <= len(payload) {
+				seg.Data = payload[8:n]
+			}
+			segments = append(segments, seg)
+		}
+	}
+	if !found {
+		return nil, errNoRTSP
+	}
+	if len(loopback) > 0 {
+		ports := channels(messages(segments, false))
+		for _, i := range loopback {
+			seg := &segments[i]
+			if _, ok := ports[seg.Remote]; ok {
+				seg.In, seg.Local, seg.Remote = true, seg.Remote, seg.Local
+			}
+		}
+	}
+	return segments, nil
+}
+
+// readBlocks extracts packets from pcapng sections.
+func readBlocks(data []byte) (frames []frame, err error) {
+	var order binary.ByteOrder = le
+	type iface struct {
+		link  int
+		scale uint64 // Units per second of timestamps.
+	}
+	var ifaces []iface
+	for len(data) >= 12 {
+		// Type of section header block reads the same in either byte order.
+		typ := order.Uint32(data)
+		if typ == blockSection {
+			if be.Uint32(data[8:]) == byteOrderMagic {
+				order = be
+			} else {
+				order = le
+			}
+			ifaces = ifaces[:0]
+		}
+		size := int(order.Uint32(data[4:]))
+		if size < 12 || size > len(data) {
+			return nil, errTruncatedBlock
+		}
+		body := data[8 : size-4]
+		data = data[size:]
+		switch typ {
+		case blockInterface:
+			if len(body) < 8 {
+				return nil, errTruncatedBlock
+			}
+			ifc := iface{link: int(order.Uint16(body)), scale: 1000000}
+			// Timestamp resolution option.
+			for opts := body[8:]; len(opts) >= 4; {
+				code, n := order.Uint16(opts), int(order.Uint16(opts[2:]))
+				if code == 0 || 4+n > len(opts) {
+					break
+				}
+				if code == 9 && n >= 1 {
+					v := opts[4]
+					ifc.scale = 1
+					for i := 0; i < int(v&0x7F); i++ {
+						if v&0x80 != 0 {
+							ifc.scale *= 2
+						} else {
+							ifc.scale *= 10
+						}
+					}
+				}
+				opts = opts[4+(n+3)&^3:]
+			}
+			ifaces = append(ifaces, ifc)
+		case blockEnhanced:
+			if len(body) < 20 {
+				return nil, errTruncatedBlock
+			}
+			id := int(order.Uint32(body))
+			n := int(order.Uint32(body[12:]))
+			if id >= len(ifaces) || 20+n > len(body) {
+				return nil, errTruncatedBlock
+			}
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			ifc := ifaces[id]
+			at := time.Unix(int64(ts/ifc.scale), int64(ts%ifc.scale*1000000000/ifc.scale))
+			frames = append(frames, frame{at: at, link: ifc.link, data: body[20 : 20+n]})
+		case blockSimple:
+			if len(body) < 4 || len(ifaces) == 0 {
+				return nil, errTruncatedBlock
+			}
+			frames = append(frames, frame{link: ifaces[0].link, data: body[4:]})
+		}
+	}
+	return frames, nil
+}
+
+// readRecords extracts packets from classic pcap file.
+func readRecords(data []byte) (frames []frame, err error) {
+	if len(data) < 24 {
+		return nil, errNotCapture
+	}
+	var order binary.ByteOrder
+	nano := false
+	switch magic := le.Uint32(data); magic {
+	case 0xA1B2C3D4, 0xA1B23C4D:
+		order, nano = le, magic == 0xA1B23C4D
+	default:
+		switch be.Uint32(data) {
+		case 0xA1B2C3D4, 0xA1B23C4D:
+			order, nano = be, be.Uint32(data) == 0xA1B23C4D
+		default:
+			return nil, errNotCapture
+		}
+	}
+	link := int(order.Uint32(data[20:]) & 0xFFFF)
+	for data = data[24:]; len(data) >= 16; {
+		n := int(order.Uint32(data[8:]))
+		if 16+n > len(data) {
+			return nil, errTruncatedBlock
+		}
+		frac := int64(order.Uint32(data[4:]))
+		if !nano {
+			frac *= 1000
+		}
+		at := time.Unix(int64(order.Uint32(data)), frac)
+		frames = append(frames, frame{at: at, link: link, data: data[16 : 16+n]})
+		data = data[16+n:]
+	}
+	return frames, nil
+}
+
+// parseIPv4 strips link layer and IPv4 headers.  Fragments and other protocols are skipped.
+func parseIPv4(link int, data []byte) (proto byte, src, dst endpoint, payload []byte, ok bool) {
+	switch link {
+	case linkEthernet:
+		if len(data) < 14 {
+			return
+		}
+		typ := be.Uint16(data[12:])
+		data = data[14:]
+		for typ == 0x8100 && len(data) >= 4 {
+			// VLAN tag.
+			typ = be.Uint16(data[2:])
+			data = data[4:]
+		}
+		if typ != 0x0800 {
+			return
+		}
+	case linkSLL:
+		if len(data) < 16 || be.Uint16(data[14:]) != 0x0800 {
+			return
+		}
+		data = data[16:]
+	case linkSLL2:
+		if len(data) < 20 || be.Uint16(data) != 0x0800 {
+			return
+		}
+		data = data[20:]
+	case linkRaw, linkIPv4:
+	default:
+		return
+	}
+	if len(data) < 20 || data[0]>>4 != 4 {
+		return
+	}
+	hl := int(data[0]&0x0F) * 4
+	total := int(be.Uint16(data[2:]))
+	if hl < 20 || total < hl || total > len(data) || be.Uint16(data[6:])&0x3FFF != 0 {
+		return
+	}
+	copy(src.ip[:], data[12:16])
+	copy(dst.ip[:], data[16:20])
+	return data[9], src, dst, data[hl:total], true
+}
+
+// isRequest checks whether data starts with RTSP request line.
+func isRequest(b []byte) bool {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line := bytes.TrimSpace(b[:i])
+		return bytes.HasSuffix(line, []byte(" RTSP/1.0")) || bytes.HasSuffix(line, []byte(" RTSP/2.0"))
+	}
+	return false
+}
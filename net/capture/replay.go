@@ -0,0 +1,281 @@
+package capture
+
+// Offline replay of captured sessions so that depacketizer and muxer issues can be reproduced without camera.
+
+import (
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+)
+
+type (
+	// item is a message or packet in one direction of captured session.  Message is either *rtsp.Request,
+	// *rtsp.Response, or rtsp.RawPacket.
+	item struct {
+		at  time.Time
+		msg interface{}
+	}
+
+	// exchange is a response to the request with a given verb followed by whatever server sent until the next response.
+	exchange struct {
+		verb      string
+		challenge bool // Response asks for credentials.
+		items     []item
+	}
+
+	// segmentReader reads stream in one direction from a list of segments.  It remembers arrival time of
+	// the segment it reads from.
+	segmentReader struct {
+		segments []rtsp.Segment
+		in       bool
+		at       time.Time
+		data     []byte
+	}
+)
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if len(r.segments) == 0 {
+			return 0, io.EOF
+		}
+		seg := r.segments[0]
+		r.segments = r.segments[1:]
+		if !seg.UDP && seg.In == r.in {
+			r.at, r.data = seg.At, seg.Data
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// messages parses RTSP stream in one direction.  Incomplete message at the end of capture is dropped.
+func messages(segments []rtsp.Segment, in bool) []item {
+	sr := &segmentReader{segments: segments, in: in}
+	rdr := rtsp.NewReader(sr)
+	var items []item
+	for {
+		msg, err := rdr.ReadMessage()
+		if err != nil {
+			return items
+		}
+		items = append(items, item{at: sr.at, msg: msg})
+	}
+}
+
+// channels assigns interleaved channels to client UDP ports in order of SETUP requests.
+func channels(requests []item) map[int]byte {
+	ports := make(map[int]byte)
+	for _, it := range requests {
+		req, ok := it.msg.(*rtsp.Request)
+		if !ok || req.Verb != rtsp.VerbSetup {
+			continue
+		}
+		t := &rtsp.Transport{}
+		if t.Parse(strings.Split(req.Header.Get(rtsp.HeaderTransport), ",")[0]) != nil || t.ClientPort.One == 0 {
+			continue
+		}
+		if _, ok := ports[t.ClientPort.One]; !ok {
+			ch := byte(len(ports))
+			ports[t.ClientPort.One] = ch
+			ports[t.ClientPort.Two] = ch + 1
+		}
+	}
+	return ports
+}
+
+// incoming merges messages and interleaved packets from the server with UDP datagrams in order of arrival.
+func incoming(segments []rtsp.Segment) []item {
+	items := messages(segments, true)
+	ports := channels(messages(segments, false))
+	for _, seg := range segments {
+		if !seg.UDP || !seg.In {
+			continue
+		}
+		if ch, ok := ports[seg.Local]; ok {
+			items = append(items, item{at: seg.At, msg: rtsp.RawPacket{Channel: ch, Payload: seg.Data}})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].at.Before(items[j].at)
+	})
+	return items
+}
+
+// Packets extracts RTP and RTCP packets that the server sent either interleaved or over UDP.
+func Packets(segments []rtsp.Segment) []Packet {
+	var packets []Packet
+	for _, it := range incoming(segments) {
+		if pkt, ok := it.msg.(rtsp.RawPacket); ok {
+			packets = append(packets, Packet{At: it.at, RawPacket: pkt})
+		}
+	}
+	return packets
+}
+
+// Play sends packets to a channel at original pace multiplied by speed, or as fast as possible if speed is 0.
+func Play(packets []Packet, speed float64, out chan<- rtsp.RawPacket) {
+	base := time.Now()
+	for _, p := range packets {
+		wait(base, p.At.Sub(packets[0].At), speed, nil)
+		out <- p.RawPacket
+	}
+}
+
+// wait sleeps until offset from base scaled by speed or until stop is closed.  It reports whether
+// the time has come.
+func wait(base time.Time, offset time.Duration, speed float64, stop <-chan struct{}) bool {
+	if speed <= 0 {
+		return true
+	}
+	d := time.Until(base.Add(time.Duration(float64(offset) / speed)))
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Replay serves captured session to a client over an in-memory connection to be wrapped with rtsp.NewConn.
+// Every request is answered with the next captured response to the same verb, with CSeq adjusted, and followed
+// by what server sent afterwards at original pace multiplied by speed, or as fast as possible if speed is 0.
+// UDP transport is turned into interleaved one so that the whole session goes over the connection.
+// Authentication challenges are skipped so that credentials are not needed.
+func Replay(segments []rtsp.Segment, speed float64) net.Conn {
+	exchanges := split(segments)
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		var stop chan struct{}
+		var wg sync.WaitGroup
+		rdr := rtsp.NewReader(server)
+		next := 0
+		for {
+			msg, err := rdr.ReadMessage()
+			req, ok := msg.(*rtsp.Request)
+			if err == nil && !ok {
+				// Client responds to request from the server.
+				continue
+			}
+			// What remains of the previous exchange is dropped.
+			if stop != nil {
+				close(stop)
+				wg.Wait()
+				stop = nil
+			}
+			if err != nil {
+				return
+			}
+			var ex *exchange
+			for i := next; i < len(exchanges); i++ {
+				if exchanges[i].verb == req.Verb && !exchanges[i].challenge {
+					ex, next = &exchanges[i], i+1
+					break
+				}
+			}
+			if ex == nil {
+				code := rtsp.RtspMethodNotValidInThisState
+				rsp := rtsp.Response{Proto: req.Proto, Status: strconv.Itoa(code) + " " + rtsp.StatusText(code), Header: rtsp.MessageHeader{}}
+				rsp.Header.Set(rtsp.HeaderCSeq, strconv.Itoa(req.Cseq))
+				if _, err = server.Write(rsp.Pack()); err != nil {
+					return
+				}
+				continue
+			}
+			stop = make(chan struct{})
+			wg.Add(1)
+			go func(items []item, cseq int, stop <-chan struct{}) {
+				defer wg.Done()
+				base := time.Now()
+				for _, it := range items {
+					if !wait(base, it.at.Sub(items[0].at), speed, stop) {
+						return
+					}
+					var b []byte
+					switch m := it.msg.(type) {
+					case *rtsp.Response:
+						r := *m
+						r.Header = m.Header.Clone()
+						r.Header.Set(rtsp.HeaderCSeq, strconv.Itoa(cseq))
+						b = r.Pack()
+					case *rtsp.Request:
+						b = m.Pack()
+					case rtsp.RawPacket:
+						b = append([]byte{'$', m.Channel, byte(len(m.Payload) >> 8), byte(len(m.Payload))}, m.Payload...)
+					}
+					if _, err := server.Write(b); err != nil {
+						return
+					}
+				}
+			}(ex.items, req.Cseq, stop)
+		}
+	}()
+	return client
+}
+
+// split groups what server sent into exchanges that start with responses.  Transport of responses to SETUP
+// is rewritten to interleaved with channels that UDP datagrams are assigned to.
+func split(segments []rtsp.Segment) []exchange {
+	requests := messages(segments, false)
+	ports := channels(requests)
+	sent := make(map[int]*rtsp.Request)
+	for _, it := range requests {
+		if req, ok := it.msg.(*rtsp.Request); ok {
+			sent[req.Cseq] = req
+		}
+	}
+	var exchanges []exchange
+	var early []item
+	for _, it := range incoming(segments) {
+		rsp, ok := it.msg.(*rtsp.Response)
+		if !ok {
+			if len(exchanges) == 0 {
+				early = append(early, it)
+			} else {
+				ex := &exchanges[len(exchanges)-1]
+				ex.items = append(ex.items, it)
+			}
+			continue
+		}
+		ex := exchange{challenge: rsp.StatusCode == rtsp.RtspUnauthorized}
+		if req, ok := sent[rsp.Cseq]; ok {
+			ex.verb = req.Verb
+			if req.Verb == rtsp.VerbSetup {
+				rsp = interleave(rsp, ports)
+			}
+		}
+		ex.items = append([]item{{at: it.at, msg: rsp}}, early...)
+		early = nil
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges
+}
+
+// interleave replaces UDP unicast transport in response to SETUP with interleaved one.
+func interleave(rsp *rtsp.Response, ports map[int]byte) *rtsp.Response {
+	t := &rtsp.Transport{}
+	if t.Parse(rsp.Header.Get(rtsp.HeaderTransport)) != nil || t.IsTCP || t.IsMulticast {
+		return rsp
+	}
+	ch, ok := ports[t.ClientPort.One]
+	if !ok {
+		return rsp
+	}
+	r := *rsp
+	r.Header = rsp.Header.Clone()
+	tcp := rtsp.Transport{IsTCP: true, IsInterleaved: true, Interleave: rtsp.Pair{One: int(ch), Two: int(ch) + 1}, SSRC: t.SSRC, Mode: t.Mode}
+	r.Header.Set(rtsp.HeaderTransport, tcp.String())
+	return &r
+}
@@ -0,0 +1,184 @@
+package capture
+
+// Capture of RTP and RTCP packets in rtpdump format of rtptools.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+var (
+	errNotRTPDump  = errors.New("Not an rtpdump file")
+	errPacketSize  = errors.New("Packet is too large for rtpdump")
+	errShortPacket = errors.New("Packet is too short for rtpdump")
+)
+
+type (
+	// Packet is RTP or RTCP packet with its arrival time.
+	Packet struct {
+		At time.Time
+		rtsp.RawPacket
+	}
+
+	// RTPDumpWriter writes RTP and RTCP packets of all channels into a single rtpdump file.
+	// RTCP packets are marked with zero RTP length as the format prescribes.
+	RTPDumpWriter struct {
+		w     io.Writer
+		start time.Time
+	}
+)
+
+// NewRTPDumpWriter writes file header with the address of the source, e.g. a camera, and the time
+// from which offsets of packets are counted.
+func NewRTPDumpWriter(w io.Writer, source net.Addr, start time.Time) (*RTPDumpWriter, error) {
+	ip, port := net.IPv4zero.To4(), 0
+	if a, ok := source.(*net.TCPAddr); ok && a.IP.To4() != nil {
+		ip, port = a.IP.To4(), a.Port
+	}
+	b := []byte(fmt.Sprintf("#!rtpplay1.0 %s/%d\n", ip, port))
+	b = be.AppendUint32(b, uint32(start.Unix()))
+	b = be.AppendUint32(b, uint32(start.Nanosecond()/1000))
+	b = append(b, ip...)
+	b = be.AppendUint16(b, uint16(port))
+	b = be.AppendUint16(b, 0)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	return &RTPDumpWriter{w: w, start: start}, nil
+}
+
+// WritePacket writes packet received at a given time.  Channel parity tells RTP from RTCP.
+func (d *RTPDumpWriter) WritePacket(at time.Time, pkt rtsp.RawPacket) error {
+	if len(pkt.Payload) > 0xFFFF-8 {
+		return errPacketSize
+	}
+	plen := len(pkt.Payload)
+	if pkt.Channel&1 == 1 {
+		plen = 0
+	}
+	b := make([]byte, 0, 8+len(pkt.Payload))
+	b = be.AppendUint16(b, uint16(8+len(pkt.Payload)))
+	b = be.AppendUint16(b, uint16(plen))
+	b = be.AppendUint32(b, uint32(at.Sub(d.start)/time.Millisecond))
+	b = append(b, pkt.Payload...)
+	_, err := d.w.Write(b)
+	return err
+}
+
+// ReadRTPDump reads packets from rtpdump file.  Since the format does not keep channels, they are assigned
+// by SSRC in order of appearance: RTP stream gets the next even channel and its sender reports the odd one.
+func ReadRTPDump(r io.Reader) ([]Packet, error) {
+	rdr := bufio.NewReader(r)
+	line, err := rdr.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#!rtpplay1.0 ") {
+		return nil, errNotRTPDump
+	}
+	var hdr [16]byte
+	if _, err = io.ReadFull(rdr, hdr[:]); err != nil {
+		return nil, errNotRTPDump
+	}
+	start := time.Unix(int64(be.Uint32(hdr[:])), int64(be.Uint32(hdr[4:]))*1000)
+	channels := make(map[uint32]byte)
+	var packets []Packet
+	for {
+		var ph [8]byte
+		if _, err = io.ReadFull(rdr, ph[:]); err == io.EOF {
+			return packets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		size := int(be.Uint16(ph[:]))
+		if size < 8 {
+			return nil, errShortPacket
+		}
+		data := make([]byte, size-8)
+		if _, err = io.ReadFull(rdr, data); err != nil {
+			return nil, err
+		}
+		rtcp := be.Uint16(ph[2:]) == 0
+		// SSRC of RTP packet follows sequence number and timestamp, SSRC of RTCP sender follows length.
+		off := 8
+		if rtcp {
+			off = 4
+		}
+		if len(data) < off+4 {
+			return nil, errShortPacket
+		}
+		ssrc := be.Uint32(data[off:])
+		ch, ok := channels[ssrc]
+		if !ok {
+			ch = byte(len(channels) * 2)
+			channels[ssrc] = ch
+		}
+		if rtcp {
+			ch++
+		}
+		at := start.Add(time.Duration(be.Uint32(ph[4:])) * time.Millisecond)
+		packets = append(packets, Packet{At: at, RawPacket: rtsp.RawPacket{Channel: ch, Payload: data}})
+	}
+}
+
+// ReplayRTPDump serves packets read from rtpdump file like Replay does with captured session.  Since the file
+// keeps packets only, RTSP 1.0 exchange is made up around them: session description is that of the capture,
+// every media is set up interleaved, and packets follow response to PLAY.  Channels are reassigned to media by
+// RTP payload type.  Client should use RTSP 1.0 as there is nothing to negotiate version with.
+func ReplayRTPDump(desc []byte, packets []Packet, speed float64) net.Conn {
+	media := sdp.Parse(desc)
+	remap := make(map[byte]byte)
+	for _, p := range packets {
+		if _, ok := remap[p.Channel&^1]; ok || p.Channel&1 == 1 || len(p.Payload) < 2 {
+			continue
+		}
+		for i, m := range media {
+			if m.PayloadType == int(p.Payload[1]&0x7F) {
+				remap[p.Channel&^1] = byte(i * 2)
+				break
+			}
+		}
+	}
+	var at time.Time
+	if len(packets) > 0 {
+		at = packets[0].At
+	}
+	var segments []rtsp.Segment
+	cseq := 0
+	exchange := func(verb string, req, rsp rtsp.MessageHeader, body []byte) {
+		cseq++
+		r := rtsp.Request{Verb: verb, URI: "rtsp://replay/", Cseq: cseq, Header: req}
+		ok := rtsp.Response{Status: "200 OK", Header: rsp, Body: body}
+		ok.Header.Set(rtsp.HeaderCSeq, strconv.Itoa(cseq))
+		segments = append(segments, rtsp.Segment{At: at, Data: r.Pack()}, rtsp.Segment{At: at, In: true, Data: ok.Pack()})
+	}
+	exchange(rtsp.VerbOptions, rtsp.MessageHeader{}, rtsp.MessageHeader{}, nil)
+	exchange(rtsp.VerbDescribe, rtsp.MessageHeader{}, rtsp.MessageHeader{}, desc)
+	for i := range media {
+		t := rtsp.Transport{IsTCP: true, IsInterleaved: true, Interleave: rtsp.Pair{One: i * 2, Two: i*2 + 1}}
+		req := rtsp.MessageHeader{}
+		req.Set(rtsp.HeaderTransport, t.String())
+		rsp := req.Clone()
+		rsp.Set(rtsp.HeaderSession, "replay")
+		exchange(rtsp.VerbSetup, req, rsp, nil)
+	}
+	rsp := rtsp.MessageHeader{}
+	rsp.Set(rtsp.HeaderSession, "replay")
+	exchange(rtsp.VerbPlay, rtsp.MessageHeader{}, rsp, nil)
+	for _, p := range packets {
+		ch, ok := remap[p.Channel&^1]
+		if !ok {
+			continue
+		}
+		ch |= p.Channel & 1
+		b := append([]byte{'$', ch, byte(len(p.Payload) >> 8), byte(len(p.Payload))}, p.Payload...)
+		segments = append(segments, rtsp.Segment{At: p.At, In: true, Data: b})
+	}
+	return Replay(segments, speed)
+}
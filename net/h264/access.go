@@ -0,0 +1,204 @@
+package h264
+
+import (
+	"fmt"
+)
+
+type (
+	// AccessUnit is a set of NAL units sharing the same timestamp that make up a single picture.
+	AccessUnit struct {
+		TS     uint32
+		Units  []NALUnit
+		Format *FormatChange // Set if access unit carries parameter sets that change stream format.
+	}
+
+	// FormatChange describes change of stream format by in-band parameter sets, e.g. after camera changed
+	// resolution.  Muxers should start a new initialization segment or discontinuity with the access unit.
+	FormatChange struct {
+		Old *SPSInfo // Sequence parameter set in effect before the change, nil if there was none.
+		New *SPSInfo // Sequence parameter set referenced by the access unit after the change.
+	}
+
+	// Assembler collects NAL units from RTP payloads into access units.  Access unit ends with
+	// RTP packet that has marker bit set or when packet with different timestamp arrives.
+	// In-band parameter sets update Sets, which may be shared, e.g. with feed.
+	Assembler struct {
+		Sets   *ParameterSets // Parameter sets in effect, e.g. populated from SDP.
+		sink   *NALSink
+		au     AccessUnit
+		active *SPSInfo // Sequence parameter set referenced by the last access unit.
+	}
+)
+
+// NewAssembler creates assembler of access units.
+func NewAssembler() *Assembler {
+	return &Assembler{Sets: NewParameterSets(), sink: NewNALSink()}
+}
+
+// IsIDR reports whether access unit contains IDR picture.
+func (au *AccessUnit) IsIDR() bool {
+	for i := range au.Units {
+		if au.Units[i].Type() == typeIDR {
+			return true
+		}
+	}
+	return false
+}
+
+// ParameterSets returns SPS and PPS units, including NAL header, carried in-band in the access unit.
+func (au *AccessUnit) ParameterSets() (sps, pps [][]byte) {
+	for i := range au.Units {
+		switch au.Units[i].Type() {
+		case typeSPS:
+			sps = append(sps, au.Units[i].Bytes())
+		case typePPS:
+			pps = append(pps, au.Units[i].Bytes())
+		}
+	}
+	return
+}
+
+// AVC formats access unit as a sequence of units each prefixed with 4-byte length as stored in MP4.
+// Emulation prevention bytes removed by depacketizer are restored.
+func (au *AccessUnit) AVC() []byte {
+	var buf []byte
+	for i := range au.Units {
+		nal := au.Units[i].Bytes()
+		buf = append(buf, byte(len(nal)>>24), byte(len(nal)>>16), byte(len(nal)>>8), byte(len(nal)))
+		buf = append(buf, nal...)
+	}
+	return buf
+}
+
+// Bytes returns encapsulated NAL unit including header.
+func (u *NALUnit) Bytes() []byte {
+	return append([]byte{u.Header}, RBSPToEncapsulated(u.Data)...)
+}
+
+// SetFmtp configures depacketization from format specific parameters of SDP, i.e. enables
+// de-interleaving in interleaved packetization mode.
+func (a *Assembler) SetFmtp(fmtp map[string]string) {
+	a.sink.Deinterleave = NewDeinterleaver(fmtp)
+}
+
+// Sink returns underlying sink, e.g. to limit size of NAL units or read counters of discarded ones.
+func (a *Assembler) Sink() *NALSink {
+	return a.sink
+}
+
+// Push takes RTP payload with sequence number, timestamp, and marker bit and returns access units
+// completed by it.
+func (a *Assembler) Push(buf []byte, sn uint16, ts uint32, marker bool) (units []AccessUnit, err error) {
+	if a.sink.Deinterleave != nil {
+		return a.pushInterleaved(buf, sn, ts)
+	}
+	if len(a.au.Units) > 0 && a.au.TS != ts {
+		units = append(units, a.complete())
+	}
+	if err = a.sink.Push(buf, sn, ts); err != nil {
+		return
+	}
+	a.au.TS = ts
+	a.au.Units = append(a.au.Units, a.sink.Units...)
+	if marker && len(a.au.Units) > 0 {
+		units = append(units, a.complete())
+	}
+	return
+}
+
+// pushInterleaved groups units released by deinterleaver in decoding order into access units by their own
+// timestamps as packets carry units of different pictures and their marker bits do not end access units.
+func (a *Assembler) pushInterleaved(buf []byte, sn uint16, ts uint32) (units []AccessUnit, err error) {
+	if err = a.sink.Push(buf, sn, ts); err != nil {
+		return
+	}
+	return a.appendOrdered(nil, a.sink.Units), nil
+}
+
+// appendOrdered adds units in decoding order to access unit being assembled and appends access units
+// completed by them.
+func (a *Assembler) appendOrdered(units []AccessUnit, list []NALUnit) []AccessUnit {
+	for _, u := range list {
+		if len(a.au.Units) > 0 && a.au.TS != u.TS {
+			units = append(units, a.complete())
+		}
+		a.au.TS = u.TS
+		a.au.Units = append(a.au.Units, u)
+	}
+	return units
+}
+
+// Flush returns access unit being assembled along with units held for de-interleaving, e.g. at the end
+// of stream.
+func (a *Assembler) Flush() (units []AccessUnit) {
+	if a.sink.Deinterleave != nil {
+		units = a.appendOrdered(units, a.sink.Deinterleave.Flush())
+	}
+	if len(a.au.Units) > 0 {
+		units = append(units, a.complete())
+	}
+	return
+}
+
+// String describes change of resolution and codec, e.g. for logging.
+func (c *FormatChange) String() string {
+	old := "none"
+	if c.Old != nil {
+		old = fmt.Sprintf("%dx%d %s", c.Old.Width, c.Old.Height, c.Old.Codec())
+	}
+	return fmt.Sprintf("%s -> %dx%d %s", old, c.New.Width, c.New.Height, c.New.Codec())
+}
+
+// complete updates parameter sets from the current access unit and starts the next one.
+func (a *Assembler) complete() (au AccessUnit) {
+	au, a.au = a.au, AccessUnit{}
+	var last *SPSInfo // The last sequence parameter set carried in-band.
+	carried, changed := false, false
+	for i := range au.Units {
+		u := &au.Units[i]
+		typ := u.Type()
+		if typ != typeSPS && typ != typePPS {
+			continue
+		}
+		id, err := parameterSetID(u)
+		if err != nil {
+			// Malformed parameter set is left for decoder to deal with.
+			continue
+		}
+		carried = true
+		if a.active == nil && !changed {
+			// Stream starts with parameter sets from SDP in effect.
+			var prior *SPSInfo
+			if typ == typeSPS {
+				prior = a.Sets.spset[id]
+			}
+			a.active = a.referenced(&au, prior)
+		}
+		if ok, err := a.Sets.Update(u); err == nil && ok {
+			changed = true
+		}
+		if typ == typeSPS {
+			last = a.Sets.spset[id]
+		}
+	}
+	sps := a.referenced(&au, last)
+	// Sets shared with another assembler may have been updated already.
+	if carried && sps != nil && (changed || sps != a.active) {
+		au.Format = &FormatChange{Old: a.active, New: sps}
+	}
+	if sps != nil {
+		a.active = sps
+	}
+	return
+}
+
+// referenced returns sequence parameter set referenced by the first slice of access unit or a fallback one
+// if slice header cannot be parsed.
+func (a *Assembler) referenced(au *AccessUnit, fallback *SPSInfo) *SPSInfo {
+	for i := range au.Units {
+		if h, err := a.Sets.ParseSliceHeader(&au.Units[i]); err == nil {
+			return a.Sets.spset[a.Sets.ppset[h.PpsID].SpsID]
+		}
+	}
+	return fallback
+}
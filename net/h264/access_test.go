@@ -0,0 +1,134 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAssembler(t *testing.T) {
+	a := NewAssembler()
+	// SPS and PPS followed by IDR slice fragmented with FU-A, then P slice with new timestamp.
+	packets := []struct {
+		buf    []byte
+		ts     uint32
+		marker bool
+	}{
+		{[]byte{0x67, 0x42, 0x00, 0x1f}, 3000, false},
+		{[]byte{0x68, 0xce, 0x3c, 0x80}, 3000, false},
+		{[]byte{0x7c, 0x85, 0x88, 0x84}, 3000, false},
+		{[]byte{0x7c, 0x45, 0x21, 0x80}, 3000, true},
+		{[]byte{0x41, 0x9a, 0x02}, 6000, false},
+		{[]byte{0x41, 0x9a, 0x04}, 9000, true},
+	}
+	var units []AccessUnit
+	for i, p := range packets {
+		aus, err := a.Push(p.buf, uint16(i), p.ts, p.marker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, aus...)
+	}
+	if len(units) != 3 {
+		t.Fatalf("Expected 3 access units, got %d", len(units))
+	}
+	if !units[0].IsIDR() || units[1].IsIDR() {
+		t.Error("Wrong IDR detection")
+	}
+	sps, pps := units[0].ParameterSets()
+	if len(sps) != 1 || len(pps) != 1 || sps[0][0] != 0x67 {
+		t.Errorf("Wrong parameter sets %x %x", sps, pps)
+	}
+	avc := units[0].AVC()
+	expected := []byte{0, 0, 0, 4, 0x67, 0x42, 0x00, 0x1f, 0, 0, 0, 4, 0x68, 0xce, 0x3c, 0x80, 0, 0, 0, 5, 0x65, 0x88, 0x84, 0x21, 0x80}
+	if string(avc) != string(expected) {
+		t.Errorf("Expected % x, got % x", expected, avc)
+	}
+	if units[1].TS != 6000 || units[2].TS != 9000 {
+		t.Errorf("Wrong timestamps %d, %d", units[1].TS, units[2].TS)
+	}
+}
+
+func TestAnnexBWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewAnnexBWriter(buf)
+	w.SetParameterSets([][]byte{{0x67, 0x42, 0x00, 0x1f}}, [][]byte{{0x68, 0xce, 0x3c, 0x80}})
+	idr := &AccessUnit{Units: []NALUnit{{Header: 0x65, Data: []byte{0x88, 0x00, 0x00, 0x01}}}}
+	slice := &AccessUnit{Units: []NALUnit{{Header: 0x41, Data: []byte{0x9a, 0x02}}}}
+	for _, au := range []*AccessUnit{idr, slice, idr} {
+		if err := w.WriteAccessUnit(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nals := SplitAnnexB(buf.Bytes())
+	var types []byte
+	for _, nal := range nals {
+		types = append(types, nal[0]&0x1F)
+	}
+	if string(types) != "\x07\x08\x05\x01\x07\x08\x05" {
+		t.Errorf("Unexpected NAL unit types %v", types)
+	}
+	// Emulation prevention byte is restored in the stream and removed again when split.
+	if !bytes.Contains(buf.Bytes(), []byte{0x88, 0x00, 0x00, 0x03, 0x01}) || string(nals[2][1:]) != "\x88\x00\x00\x01" {
+		t.Errorf("Emulation prevention is broken: % x", buf.Bytes())
+	}
+}
+
+// baselineSPS composes baseline SPS with picture order count type 2 and 4 bits of frame number.
+func baselineSPS(widthMbs, heightMbs uint32) []byte {
+	w := (&bitWriter{}).bits(0x67, 8).bits(66, 8).bits(0xC0, 8).bits(40, 8).ue(0).ue(0).ue(2).ue(1).bits(0, 1)
+	w.ue(widthMbs-1).ue(heightMbs-1).bits(1, 1).bits(1, 1).bits(0, 1).bits(0, 1)
+	return w.rbsp()
+}
+
+func TestAssemblerFormatChange(t *testing.T) {
+	sps720, sps1080 := baselineSPS(80, 45), baselineSPS(120, 68)
+	pps := (&bitWriter{}).bits(0x68, 8).ue(0).ue(0).bits(0, 2).ue(0).ue(0).ue(0).bits(0, 3).se(0).se(0).se(0).bits(4, 3).rbsp()
+	idr := (&bitWriter{}).bits(0x65, 8).ue(0).ue(7).ue(0).bits(0, 4).ue(0).rbsp()
+	p := (&bitWriter{}).bits(0x41, 8).ue(0).ue(5).ue(0).bits(1, 4).rbsp()
+	a := NewAssembler()
+	a.Sets.ParseSprop(sps720)
+	a.Sets.ParseSprop(pps)
+	var changes []*FormatChange
+	sn := uint16(0)
+	for i, units := range [][][]byte{{sps720, pps, idr}, {p}, {sps1080, pps, idr}, {p}, {sps1080, pps, idr}} {
+		for _, buf := range units {
+			aus, err := a.Push(buf, sn, uint32(i*3000), false)
+			sn++
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, au := range aus {
+				changes = append(changes, au.Format)
+			}
+		}
+	}
+	if len(changes) != 4 || changes[0] != nil || changes[1] != nil || changes[2] == nil || changes[3] != nil {
+		t.Fatalf("Unexpected format changes %v", changes)
+	}
+	if s := changes[2].String(); s != "1280x720 avc1.42c028 -> 1920x1088 avc1.42c028" {
+		t.Errorf("Unexpected format change %s", s)
+	}
+	sps, _ := a.Sets.Units()
+	if len(sps) != 1 || string(sps[0]) != string(sps1080) {
+		t.Errorf("Parameter sets are not updated: % x", sps)
+	}
+
+	// Assembler sharing parameter sets that are already updated still detects the change.
+	b := NewAssembler()
+	b.Sets = a.Sets
+	b.active = changes[2].Old
+	b.Push(sps1080, 0, 0, false)
+	b.Push(pps, 1, 0, false)
+	if aus, _ := b.Push(idr, 2, 0, true); len(aus) != 1 || aus[0].Format == nil {
+		t.Errorf("Change is not detected with shared parameter sets %+v", aus)
+	}
+
+	// Without parameter sets from SDP the first in-band ones establish format.
+	a = NewAssembler()
+	a.Push(sps720, 0, 0, false)
+	a.Push(pps, 1, 0, false)
+	aus, _ := a.Push(idr, 2, 0, true)
+	if len(aus) != 1 || aus[0].Format == nil || aus[0].Format.Old != nil || aus[0].Format.New.Width != 1280 {
+		t.Errorf("Unexpected initial format %+v", aus)
+	}
+}
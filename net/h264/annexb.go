@@ -5,6 +5,15 @@ const shortStartCodeLen = 3
 // SplitAnnexB attempts to recognize a sequence of NALUs separated by start codes in the buffer.
 // Returns a list of raw/unparsed units with emulation bytes removed.
 func SplitAnnexB(buf []byte) [][]byte {
+	units := splitAnnexB(buf)
+	for i := range units {
+		units[i] = EBSPToRaw(units[i])
+	}
+	return units
+}
+
+// splitAnnexB splits the buffer on start codes into encapsulated units that share its memory.
+func splitAnnexB(buf []byte) [][]byte {
 	units := [][]byte{}
 	end := len(buf) - shortStartCodeLen
 	prev := 0
@@ -16,10 +25,10 @@ func SplitAnnexB(buf []byte) [][]byte {
 			// Do not insert 0-length slices.
 			if off > 0 && buf[off-1] == 0 {
 				if off > prev+1 {
-					units = append(units, EBSPToRaw(buf[prev:off-1]))
+					units = append(units, buf[prev:off-1])
 				}
 			} else if off > prev {
-				units = append(units, EBSPToRaw(buf[prev:off]))
+				units = append(units, buf[prev:off])
 			}
 			off += 3
 			prev = off
@@ -27,7 +36,7 @@ func SplitAnnexB(buf []byte) [][]byte {
 			off++
 		}
 	}
-	units = append(units, EBSPToRaw(buf[prev:]))
+	units = append(units, buf[prev:])
 	return units
 }
 
@@ -53,18 +62,25 @@ func EBSPToRaw(buf []byte) []byte {
 // RBSPToEncapsulated adds emulation prevention bytes to the buffer transforming
 // Raw Byte Sequence Payload (RBSP) into Encapsulated Byte Sequence Payload (EBSP).
 func RBSPToEncapsulated(buf []byte) []byte {
-	zero := byte(0)
 	epb := byte(3)
-	size := len(buf)
-	ebsp := make([]byte, 0, size*2)
-	for off := 0; off < size; {
-		if size-off >= 2 && buf[off] == 0 && buf[off+1] == 0 && buf[off+2] <= 3 {
-			// Add emulation prevention bytes.
-			ebsp = append(ebsp, zero, zero, epb)
-			off += 2
+	ebsp := make([]byte, 0, len(buf)+len(buf)/2)
+	zeros := 0
+	for _, b := range buf {
+		if zeros >= 2 && b <= 3 {
+			// Add emulation prevention byte.
+			ebsp = append(ebsp, epb)
+			zeros = 0
 		}
-		ebsp = append(ebsp, buf[off])
-		off++
+		ebsp = append(ebsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	// RBSP ending in cabac_zero_word gets a final emulation prevention byte.
+	if zeros > 0 {
+		ebsp = append(ebsp, epb)
 	}
 	return ebsp
 }
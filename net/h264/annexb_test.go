@@ -65,3 +65,15 @@ func TestRBSPToEncapsulated(t *testing.T) {
 		}
 	}
 }
+
+func TestRBSPToEncapsulatedZeroRuns(t *testing.T) {
+	data := []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00}
+	expected := []byte{0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x05, 0x00, 0x00, 0x03}
+	buf := RBSPToEncapsulated(data)
+	if string(buf) != string(expected) {
+		t.Fatalf("Expected % x, got % x", expected, buf)
+	}
+	if raw := EBSPToRaw(buf[:len(buf)-1]); string(raw) != string(data) {
+		t.Fatalf("Round trip produced % x", raw)
+	}
+}
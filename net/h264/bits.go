@@ -27,22 +27,44 @@ func (r *BitReader) Available() uint {
 	return (r.count-r.byten)*8 - r.bitn
 }
 
+// MoreData reports whether there is more data before trailing stop bit and alignment zero-bits,
+// i.e. more_rbsp_data() of the standard.
+func (r *BitReader) MoreData() bool {
+	last := int(r.count) - 1
+	for last >= 0 && r.buffer[last] == 0 {
+		last--
+	}
+	if last < 0 {
+		return false
+	}
+	// Position of the stop bit, i.e. the last bit set.
+	stop := uint(last)*8 + 7
+	for b := r.buffer[last]; b&1 == 0; b >>= 1 {
+		stop--
+	}
+	return r.byten*8+r.bitn < stop
+}
+
 // ReadBits attempts to read requested number of bits from the bit stream.
 // Returns an error if running into end of stream prematurely.
 func (r *BitReader) ReadBits(n uint) (val uint32, err error) {
 	if n > 32 || n > r.Available() {
 		return 0, io.ErrUnexpectedEOF
 	}
-	val = uint32(r.buffer[r.byten])
+	if n == 0 {
+		return 0, nil
+	}
+	// Up to 32 bits may span 5 bytes.
+	acc := uint64(r.buffer[r.byten])
 	bits := 8 - r.bitn
 	for n > bits {
 		// No check here as we already know there is enough data from AvailableBits().
 		r.byten++
-		val = (val << 8) | uint32(r.buffer[r.byten])
+		acc = (acc << 8) | uint64(r.buffer[r.byten])
 		bits += 8
 	}
 	// Align and nask out most significant bits that are not requested.
-	val = (val >> (bits - n)) & ((1 << n) - 1)
+	val = uint32((acc >> (bits - n)) & ((1 << n) - 1))
 	r.bitn = 8 - (bits - n)
 	return
 }
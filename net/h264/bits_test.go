@@ -77,3 +77,35 @@ func TestSignedGolomb(t *testing.T) {
 		}
 	}
 }
+
+func TestMoreData(t *testing.T) {
+	// Flag followed by stop bit and alignment, then cabac_zero_word.
+	r := NewBitReader([]byte{0xC0, 0x00, 0x00})
+	if !r.MoreData() {
+		t.Error("Expected more data before flag")
+	}
+	if _, err := r.ReadFlag(); err != nil {
+		t.Error(err)
+	}
+	if r.MoreData() {
+		t.Error("Expected no more data after flag")
+	}
+	// Last value ends exactly at the end of the buffer.
+	r = NewBitReader([]byte{0x01})
+	r.SkipBits(7)
+	if v, err := r.ReadUnsignedGolomb(); err != nil || v != 0 {
+		t.Errorf("Read value %d, %v, expected 0", v, err)
+	}
+}
+
+func TestReadUnaligned32(t *testing.T) {
+	r := NewBitReader([]byte{0x5A, 0xBC, 0xDE, 0xF0, 0x12})
+	r.ReadBits(4)
+	v, err := r.ReadBits(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0xABCDEF01 {
+		t.Errorf("Read value %x, expected 0xabcdef01", v)
+	}
+}
@@ -0,0 +1,244 @@
+package h264
+
+// Codec identification, level limits, and decoder configuration record for containers.
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	errInvalidDecoderConfig = errors.New("Invalid AVC decoder configuration record")
+)
+
+type (
+	// levelLimit holds limits of a level, Table A-1.
+	levelLimit struct {
+		maxDpbMbs uint32
+		maxBR     uint32 // In units of cpbBrNalFactor bits per second.
+	}
+
+	// DecoderConfig is AVCDecoderConfigurationRecord of ISO/IEC 14496-15 stored in avcC box.  Parameter
+	// sets are encapsulated and include NAL header.
+	DecoderConfig struct {
+		ProfileIdc           byte
+		ProfileCompatibility byte
+		LevelIdc             byte
+		LengthSize           int // Size of NAL unit length prefix in bytes.
+		SPS                  [][]byte
+		PPS                  [][]byte
+		ChromaFormat         byte // Chroma format and bit depths are only present for high profiles.
+		BitDepthLumaMinus8   byte
+		BitDepthChromaMinus8 byte
+		SPSExt               [][]byte
+	}
+)
+
+// Level limits by level, where 9 is level 1b.
+var levelLimits = map[byte]levelLimit{
+	9: {396, 128}, 10: {396, 64}, 11: {900, 192}, 12: {2376, 384}, 13: {2376, 768},
+	20: {2376, 2000}, 21: {4752, 4000}, 22: {8100, 4000},
+	30: {8100, 10000}, 31: {18000, 14000}, 32: {20480, 20000},
+	40: {32768, 20000}, 41: {32768, 50000}, 42: {34816, 50000},
+	50: {110400, 135000}, 51: {184320, 240000}, 52: {184320, 240000},
+	60: {696320, 240000}, 61: {696320, 480000}, 62: {696320, 800000},
+}
+
+// CodedWidth returns width of decoded picture in luma samples before cropping.
+func (sps *SPSInfo) CodedWidth() uint32 {
+	return (sps.PicWidthInMbsMinus1 + 1) * 16
+}
+
+// CodedHeight returns height of decoded frame in luma samples before cropping.  Field pictures are half of it.
+func (sps *SPSInfo) CodedHeight() uint32 {
+	return (2 - sps.FrameMbsOnly) * (sps.PicHeightInMapUnitsMinus1 + 1) * 16
+}
+
+// Crop returns frame cropping offsets in luma samples.  Width and Height are the coded size less these.
+func (sps *SPSInfo) Crop() (left, right, top, bottom uint32) {
+	// Chroma subsampling determines crop units, monochrome and separate color planes crop by luma sample.
+	unitX, unitY := uint32(1), 2-sps.FrameMbsOnly
+	if !sps.SeparateColorPlane {
+		// Chroma format 0 - monochrome, 1 - 4:2:0, 2 - 4:2:2, 3 - 4:4:4
+		if sps.ChromaFormatIdc == 1 || sps.ChromaFormatIdc == 2 {
+			unitX = 2
+		}
+		if sps.ChromaFormatIdc == 1 {
+			unitY *= 2
+		}
+	}
+	return sps.FrameCropLeftOffset * unitX, sps.FrameCropRightOffset * unitX,
+		sps.FrameCropTopOffset * unitY, sps.FrameCropBottomOffset * unitY
+}
+
+// Codec returns codec string of RFC 6381 for CODECS attribute of HLS or codecs parameter of MIME type,
+// e.g. avc1.64001f.
+func (sps *SPSInfo) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", sps.ProfileIdc, sps.ConstraintSet, sps.LevelIdc)
+}
+
+// MIMEType returns MIME type of fragmented MP4 with the video track suitable for Media Source Extensions.
+func (sps *SPSInfo) MIMEType() string {
+	return `video/mp4; codecs="` + sps.Codec() + `"`
+}
+
+// level returns level limits accounting for level 1b signalled with constraint flag.
+func (sps *SPSInfo) level() (levelLimit, bool) {
+	level := sps.LevelIdc
+	if level == 11 && sps.ConstraintSet&0x10 != 0 && (sps.ProfileIdc == 66 || sps.ProfileIdc == 77 || sps.ProfileIdc == 88) {
+		level = 9
+	}
+	limit, ok := levelLimits[level]
+	return limit, ok
+}
+
+// MaxBitRate returns largest bit rate in bits per second of NAL HRD allowed by profile and level, Table A-1
+// and A.3.3.  It is 0 for unknown level.
+func (sps *SPSInfo) MaxBitRate() uint64 {
+	limit, _ := sps.level()
+	factor := uint64(1200)
+	switch sps.ProfileIdc {
+	case 100:
+		factor = 1500
+	case 110:
+		factor = 3600
+	case 122, 244, 44:
+		factor = 4800
+	}
+	return uint64(limit.maxBR) * factor
+}
+
+// MaxDpbFrames returns size of decoded picture buffer in frames allowed by level for the picture size.
+// It is capped at 16 and is 16 for unknown level.
+func (sps *SPSInfo) MaxDpbFrames() uint32 {
+	limit, ok := sps.level()
+	mbs := (sps.PicWidthInMbsMinus1 + 1) * (sps.PicHeightInMapUnitsMinus1 + 1) * (2 - sps.FrameMbsOnly)
+	if !ok || mbs == 0 {
+		return 16
+	}
+	if frames := limit.maxDpbMbs / mbs; frames < 16 {
+		return frames
+	}
+	return 16
+}
+
+// hasChromaInfo reports whether decoder configuration of the profile carries chroma format and bit depths.
+func hasChromaInfo(profile byte) bool {
+	return profile == 100 || profile == 110 || profile == 122 || profile == 144
+}
+
+// NewDecoderConfig creates decoder configuration record from sequence and picture parameter sets that include
+// NAL header.  Profile, level, and chroma information come from the first sequence parameter set.
+func NewDecoderConfig(sps, pps [][]byte) (*DecoderConfig, error) {
+	if len(sps) == 0 || len(pps) == 0 || len(sps[0]) < 4 {
+		return nil, errInvalidDecoderConfig
+	}
+	c := &DecoderConfig{ProfileIdc: sps[0][1], ProfileCompatibility: sps[0][2], LevelIdc: sps[0][3], LengthSize: 4, SPS: sps, PPS: pps}
+	if hasChromaInfo(c.ProfileIdc) {
+		sets := NewParameterSets()
+		if err := sets.ParseSPS(EBSPToRaw(sps[0][1:])); err != nil {
+			return nil, err
+		}
+		for _, info := range sets.spset {
+			c.ChromaFormat = byte(info.ChromaFormatIdc)
+			c.BitDepthLumaMinus8 = byte(info.BitDepthLuma)
+			c.BitDepthChromaMinus8 = byte(info.BitDepthChroma)
+		}
+	}
+	return c, nil
+}
+
+// ParseDecoderConfig parses decoder configuration record from payload of avcC box.
+func ParseDecoderConfig(buf []byte) (*DecoderConfig, error) {
+	if len(buf) < 7 || buf[0] != 1 {
+		return nil, errInvalidDecoderConfig
+	}
+	c := &DecoderConfig{ProfileIdc: buf[1], ProfileCompatibility: buf[2], LevelIdc: buf[3], LengthSize: int(buf[4]&3) + 1}
+	sps, off, err := readParameterSets(buf, 6, int(buf[5]&0x1F))
+	if err != nil {
+		return nil, err
+	}
+	c.SPS = sps
+	if off >= len(buf) {
+		return nil, errInvalidDecoderConfig
+	}
+	if c.PPS, off, err = readParameterSets(buf, off+1, int(buf[off])); err != nil {
+		return nil, err
+	}
+	// Some writers omit chroma information of high profiles.
+	if hasChromaInfo(c.ProfileIdc) && off+4 <= len(buf) {
+		c.ChromaFormat = buf[off] & 3
+		c.BitDepthLumaMinus8 = buf[off+1] & 7
+		c.BitDepthChromaMinus8 = buf[off+2] & 7
+		if c.SPSExt, _, err = readParameterSets(buf, off+4, int(buf[off+3])); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// readParameterSets reads a number of parameter sets each prefixed with 2-byte length.
+func readParameterSets(buf []byte, off, n int) (sets [][]byte, next int, err error) {
+	for i := 0; i < n; i++ {
+		if off+2 > len(buf) {
+			return nil, off, errInvalidDecoderConfig
+		}
+		size := int(buf[off])<<8 | int(buf[off+1])
+		off += 2
+		if off+size > len(buf) {
+			return nil, off, errInvalidDecoderConfig
+		}
+		sets = append(sets, buf[off:off+size])
+		off += size
+	}
+	return sets, off, nil
+}
+
+// Bytes formats decoder configuration record as payload of avcC box.
+func (c *DecoderConfig) Bytes() []byte {
+	buf := []byte{1, c.ProfileIdc, c.ProfileCompatibility, c.LevelIdc, 0xFC | byte(c.LengthSize-1), 0xE0 | byte(len(c.SPS))}
+	buf = appendParameterSets(buf, c.SPS)
+	buf = append(buf, byte(len(c.PPS)))
+	buf = appendParameterSets(buf, c.PPS)
+	if hasChromaInfo(c.ProfileIdc) {
+		buf = append(buf, 0xFC|c.ChromaFormat, 0xF8|c.BitDepthLumaMinus8, 0xF8|c.BitDepthChromaMinus8, byte(len(c.SPSExt)))
+		buf = appendParameterSets(buf, c.SPSExt)
+	}
+	return buf
+}
+
+// appendParameterSets appends parameter sets each prefixed with 2-byte length.
+func appendParameterSets(buf []byte, sets [][]byte) []byte {
+	for _, b := range sets {
+		buf = append(buf, byte(len(b)>>8), byte(len(b)))
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+// ParameterSets parses all sequence and picture parameter sets of the record.
+func (c *DecoderConfig) ParameterSets() (*ParameterSets, error) {
+	sets := NewParameterSets()
+	for _, b := range c.SPS {
+		if len(b) < 2 {
+			return nil, errInvalidDecoderConfig
+		}
+		if err := sets.ParseSPS(EBSPToRaw(b[1:])); err != nil {
+			return nil, err
+		}
+	}
+	for _, b := range c.PPS {
+		if len(b) < 2 {
+			return nil, errInvalidDecoderConfig
+		}
+		if err := sets.ParsePPS(EBSPToRaw(b[1:])); err != nil {
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
+// Codec returns codec string of RFC 6381 from profile and level of the record, e.g. avc1.64001f.
+func (c *DecoderConfig) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", c.ProfileIdc, c.ProfileCompatibility, c.LevelIdc)
+}
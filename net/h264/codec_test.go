@@ -0,0 +1,80 @@
+package h264
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	params := amcrestParameterSets(t)
+	sps, _ := params.GetSPS(0)
+	if s := sps.Codec(); s != "avc1.64001f" {
+		t.Errorf("Unexpected codec %s", s)
+	}
+	if s := sps.MIMEType(); s != `video/mp4; codecs="avc1.64001f"` {
+		t.Errorf("Unexpected MIME type %s", s)
+	}
+	if r := sps.MaxBitRate(); r != 14000*1500 {
+		t.Errorf("Unexpected maximum bit rate %d", r)
+	}
+	if n := sps.MaxDpbFrames(); n != 5 {
+		t.Errorf("Unexpected DPB size %d", n)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	// Baseline 1920x1080 coded as 1920x1088 with 8 lines cropped at the bottom.
+	w := (&bitWriter{}).bits(66, 8).bits(0xC0, 8).bits(40, 8).ue(0).ue(0).ue(2).ue(1).bits(0, 1)
+	w.ue(119).ue(67).bits(1, 1).bits(1, 1).bits(1, 1).ue(0).ue(0).ue(0).ue(4).bits(0, 1)
+	params := NewParameterSets()
+	if err := params.ParseSPS(w.rbsp()); err != nil {
+		t.Fatal(err)
+	}
+	sps, _ := params.GetSPS(0)
+	if sps.CodedWidth() != 1920 || sps.CodedHeight() != 1088 || sps.Width != 1920 || sps.Height != 1080 {
+		t.Errorf("Unexpected size %dx%d coded as %dx%d", sps.Width, sps.Height, sps.CodedWidth(), sps.CodedHeight())
+	}
+	if l, r, tp, b := sps.Crop(); l != 0 || r != 0 || tp != 0 || b != 8 {
+		t.Errorf("Unexpected crop %d %d %d %d", l, r, tp, b)
+	}
+	if n := sps.MaxDpbFrames(); n != 4 {
+		t.Errorf("Unexpected DPB size %d", n)
+	}
+	if r := sps.MaxBitRate(); r != 20000*1200 {
+		t.Errorf("Unexpected maximum bit rate %d", r)
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x1f, 0xac, 0x34, 0xc8, 0x05, 0x00, 0x5b, 0xff, 0x01, 0x6e, 0x02, 0x02, 0x02, 0x80,
+		0x00, 0x01, 0xf4, 0x00, 0x00, 0x3a, 0x98, 0x74, 0x30, 0x00, 0x4e, 0x2a, 0x00, 0x01, 0x38, 0xa8,
+		0x5d, 0xe5, 0xc6, 0x86, 0x00, 0x09, 0xc5, 0x40, 0x00, 0x27, 0x15, 0x0b, 0xbc, 0xb8, 0x50,
+	}
+	pps := []byte{0x68, 0xee, 0x3c, 0x30}
+	config, err := NewDecoderConfig([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := config.Bytes()
+	if buf[4] != 0xFF || buf[5] != 0xE1 || buf[len(buf)-4] != 0xFD || buf[len(buf)-1] != 0 {
+		t.Errorf("Unexpected record % x", buf)
+	}
+	parsed, err := ParseDecoderConfig(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.Bytes(), buf) || parsed.LengthSize != 4 || parsed.Codec() != "avc1.64001f" {
+		t.Errorf("Record does not round-trip: %+v", parsed)
+	}
+	params, err := parsed.ParameterSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := params.GetSPS(0); !ok || info.Width != 1280 || info.Height != 720 {
+		t.Errorf("Unexpected parameter sets %+v", info)
+	}
+	if _, err = ParseDecoderConfig(buf[:20]); err != errInvalidDecoderConfig {
+		t.Errorf("Expected error on truncated record, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package h264
+
+import (
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+type (
+	// Depacketizer adapts assembler to generic RTP depacketizer.  Frames carry access units with NAL units
+	// prefixed by 4-byte length and *AccessUnit as details.
+	Depacketizer struct {
+		Assembler *Assembler
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("H264", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		d, err := NewDepacketizer(m)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	})
+}
+
+// NewDepacketizer creates depacketizer for H.264 media with packetization mode and parameter sets from SDP.
+func NewDepacketizer(m *sdp.Media) (*Depacketizer, error) {
+	a := NewAssembler()
+	a.SetFmtp(m.Fmtp)
+	for _, b := range m.SpropParameterSets {
+		if err := a.Sets.ParseSprop(b); err != nil {
+			return nil, err
+		}
+	}
+	return &Depacketizer{Assembler: a}, nil
+}
+
+// Push takes RTP packet and returns frames for access units completed by it.
+func (d *Depacketizer) Push(p *rtp.Packet) (frames []rtp.Frame, err error) {
+	units, err := d.Assembler.Push(p.PL, p.SN, p.TS, p.M())
+	for i := range units {
+		au := &units[i]
+		frames = append(frames, rtp.Frame{TS: au.TS, Data: au.AVC(), Sync: au.IsIDR(), Info: au})
+	}
+	return frames, err
+}
@@ -0,0 +1,96 @@
+package h264
+
+// De-interleaving of NAL units in interleaved packetization mode, RFC 6184 section 7.2.
+
+import (
+	"strconv"
+)
+
+// Packetization mode that allows transmission order of NAL units to differ from decoding order.
+const interleavedMode = "2"
+
+type (
+	// Deinterleaver buffers NAL units received in interleaved packetization mode and releases them in
+	// decoding order by DON.  Buffer is bound by interleaving depth, size, and DON distance signalled
+	// in SDP.  Units that arrive after ones following them in decoding order were released are dropped.
+	Deinterleaver struct {
+		Depth      int // Most VCL units that precede any VCL unit in transmission and follow it in decoding order.
+		BufReq     int // Most bytes of NAL units kept in buffer.  No limit if zero.
+		MaxDonDiff int // Most distance of DON between units that follow each other in decoding order.  No limit if zero.
+		units      []NALUnit
+		vcl        int    // Number of VCL units in buffer.
+		size       int    // Size of units in buffer.
+		last       uint16 // DON of the last released unit.
+		released   bool
+	}
+)
+
+// NewDeinterleaver creates deinterleaver from format specific parameters of SDP if packetization mode
+// is interleaved, and returns nil otherwise.
+func NewDeinterleaver(fmtp map[string]string) *Deinterleaver {
+	if fmtp["packetization-mode"] != interleavedMode {
+		return nil
+	}
+	d := &Deinterleaver{}
+	d.Depth, _ = strconv.Atoi(fmtp["sprop-interleaving-depth"])
+	d.BufReq, _ = strconv.Atoi(fmtp["sprop-deint-buf-req"])
+	d.MaxDonDiff, _ = strconv.Atoi(fmtp["sprop-max-don-diff"])
+	return d
+}
+
+// isVCL reports whether unit carries coded slice data.
+func isVCL(typ byte) bool {
+	return typ >= typeNIDR && typ <= typeIDR
+}
+
+// Push adds units in transmission order and returns units released in decoding order.
+func (d *Deinterleaver) Push(units []NALUnit) (out []NALUnit) {
+	for _, u := range units {
+		if d.released && DonDiff(d.last, u.Don) <= 0 {
+			continue
+		}
+		// Insert after units that precede it or have the same DON.
+		i := len(d.units)
+		for i > 0 && DonDiff(d.units[i-1].Don, u.Don) < 0 {
+			i--
+		}
+		d.units = append(d.units, NALUnit{})
+		copy(d.units[i+1:], d.units[i:])
+		d.units[i] = u
+		d.size += len(u.Data) + 1
+		if isVCL(u.Type()) {
+			d.vcl++
+		}
+		for len(d.units) > 0 && d.full() {
+			out = append(out, d.release())
+		}
+	}
+	return
+}
+
+// full reports whether buffer exceeds any of its bounds.
+func (d *Deinterleaver) full() bool {
+	return d.vcl > d.Depth ||
+		(d.BufReq > 0 && d.size > d.BufReq) ||
+		(d.MaxDonDiff > 0 && DonDiff(d.units[0].Don, d.units[len(d.units)-1].Don) > d.MaxDonDiff)
+}
+
+// release removes the first unit in decoding order from buffer.
+func (d *Deinterleaver) release() NALUnit {
+	u := d.units[0]
+	d.units = d.units[1:]
+	d.size -= len(u.Data) + 1
+	if isVCL(u.Type()) {
+		d.vcl--
+	}
+	d.last, d.released = u.Don, true
+	return u
+}
+
+// Flush releases all buffered units in decoding order, e.g. at the end of stream.
+func (d *Deinterleaver) Flush() (out []NALUnit) {
+	for len(d.units) > 0 {
+		out = append(out, d.release())
+	}
+	return
+}
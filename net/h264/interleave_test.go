@@ -0,0 +1,105 @@
+package h264
+
+import (
+	"testing"
+)
+
+func TestMTAP(t *testing.T) {
+	s := NewNALSink()
+	// MTAP24 with DONB 65535 carrying two units: DOND 0 with offset 0x010203 and DOND 2 with offset 0.
+	buf := []byte{0x1b, 0xff, 0xff,
+		0x00, 0x07, 0x00, 0x01, 0x02, 0x03, 0x41, 0xaa, 0xbb,
+		0x00, 0x06, 0x02, 0x00, 0x00, 0x00, 0x41, 0xcc,
+	}
+	if err := s.Push(buf, 0, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Units) != 2 {
+		t.Fatalf("Expected 2 units, got %d", len(s.Units))
+	}
+	if u := s.Units[0]; u.Don != 65535 || u.TS != 1000+0x010203 || string(u.Data) != "\xaa\xbb" {
+		t.Errorf("Unexpected first unit %+v", u)
+	}
+	if u := s.Units[1]; u.Don != 1 || u.TS != 1000 || string(u.Data) != "\xcc" {
+		t.Errorf("Unexpected second unit %+v", u)
+	}
+
+	// MTAP16 with unit size that runs past the end of packet.
+	if err := s.Push([]byte{0x1a, 0x00, 0x01, 0x00, 0x09, 0x00, 0x00, 0x10, 0x41, 0xaa}, 1, 0); err != errPacketTooShort {
+		t.Errorf("Expected error on truncated unit, got %v", err)
+	}
+}
+
+func TestFUB(t *testing.T) {
+	s := NewNALSink()
+	// FU-B with DON 300 followed by FU-A with the rest of the unit.
+	if err := s.Push([]byte{0x7d, 0x85, 0x01, 0x2c, 0x88}, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Push([]byte{0x7c, 0x45, 0x84}, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Units) != 1 || s.Units[0].Don != 300 || s.Units[0].Header != 0x65 || string(s.Units[0].Data) != "\x88\x84" {
+		t.Errorf("Unexpected units %+v", s.Units)
+	}
+}
+
+func TestDeinterleaver(t *testing.T) {
+	if NewDeinterleaver(map[string]string{"packetization-mode": "1"}) != nil {
+		t.Error("Deinterleaver is created for non-interleaved mode")
+	}
+	d := NewDeinterleaver(map[string]string{"packetization-mode": "2", "sprop-interleaving-depth": "2"})
+	if d == nil || d.Depth != 2 {
+		t.Fatalf("Unexpected deinterleaver %+v", d)
+	}
+	unit := func(don uint16) NALUnit { return NALUnit{Header: 0x41, Don: don} }
+	var out []NALUnit
+	for _, don := range []uint16{65534, 0, 65535, 2, 1, 65533, 3} {
+		out = append(out, d.Push([]NALUnit{unit(don)})...)
+	}
+	out = append(out, d.Flush()...)
+	// Unit 65533 arrives after 65534 was released and is dropped.
+	var dons []uint16
+	for _, u := range out {
+		dons = append(dons, u.Don)
+	}
+	if len(dons) != 6 || dons[0] != 65534 || dons[1] != 65535 || dons[2] != 0 || dons[5] != 3 {
+		t.Errorf("Unexpected decoding order %v", dons)
+	}
+
+	// Distance of DON releases units even if depth is not reached.
+	d = &Deinterleaver{Depth: 10, MaxDonDiff: 2}
+	if out = d.Push([]NALUnit{unit(5), unit(7), unit(8)}); len(out) != 1 || out[0].Don != 5 {
+		t.Errorf("Unexpected units released by DON distance %+v", out)
+	}
+}
+
+func TestAssemblerInterleaved(t *testing.T) {
+	a := NewAssembler()
+	a.SetFmtp(map[string]string{"packetization-mode": "2", "sprop-interleaving-depth": "1"})
+	// STAP-B with parameter sets and MTAP16 with slices of two pictures where the second one precedes
+	// the first in transmission order.
+	packets := [][]byte{
+		{0x19, 0x00, 0x00, 0x00, 0x04, 0x67, 0x42, 0x00, 0x1f, 0x00, 0x04, 0x68, 0xce, 0x3c, 0x80},
+		{0x1a, 0x00, 0x02, 0x00, 0x05, 0x01, 0x0b, 0xb8, 0x41, 0x9a, 0x00, 0x05, 0x00, 0x00, 0x00, 0x65, 0x88},
+		{0x1a, 0x00, 0x04, 0x00, 0x05, 0x00, 0x17, 0x70, 0x41, 0x9b},
+	}
+	var units []AccessUnit
+	for i, p := range packets {
+		aus, err := a.Push(p, uint16(i), 3000, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		units = append(units, aus...)
+	}
+	units = append(units, a.Flush()...)
+	if len(units) != 3 {
+		t.Fatalf("Expected 3 access units, got %d", len(units))
+	}
+	if !units[0].IsIDR() || len(units[0].Units) != 3 || units[0].TS != 3000 {
+		t.Errorf("Unexpected first access unit %+v", units[0])
+	}
+	if units[1].TS != 6000 || units[1].Units[0].Data[0] != 0x9a || units[2].TS != 9000 {
+		t.Errorf("Unexpected order of access units %+v", units[1:])
+	}
+}
@@ -77,12 +77,24 @@ type (
 
 	// NALSink handles NAL unit aggreagates and fragments
 	NALSink struct {
-		Units     []NALUnit
-		Fragments []NALFragment
-		Don       uint16 // Decoding Order Number
+		Units        []NALUnit
+		Don          uint16         // Decoding Order Number
+		Deinterleave *Deinterleaver // Restores decoding order of units in interleaved packetization mode.
+		MaxNALSize   int            // Most bytes of reassembled NAL unit.  DefaultMaxNALSize if zero.
+		Incomplete   int            // Number of fragmented NAL units discarded for lost or missing fragments.
+		Oversized    int            // Number of fragmented NAL units discarded for exceeding MaxNALSize.
+		frag         NALUnit        // NAL unit being reassembled from fragments.
+		assembling   bool
+		skipping     bool   // Fragments of a discarded unit are skipped until its end or next start.
+		hint         int    // Size of the last reassembled unit to preallocate buffer for the next one.
+		seq          uint16 // Expected sequence number of the next RTP packet.
+		sequenced    bool
 	}
 )
 
+// DefaultMaxNALSize is the default limit of reassembled NAL unit size.
+const DefaultMaxNALSize = 4 << 20
+
 var (
 	errNeedPacket           = errors.New("Allocate packet to parse incoming data into")
 	errInvalidPayloadHeader = errors.New("Invalid or malformed payload header")
@@ -118,40 +130,68 @@ func (f NALFragment) IsEnd() bool {
 
 // NewNALSink creates a sink to handle NAL unit aggreagates and fragments.
 // Sink combines fragments to emit a unit into the queue and resets unit queue
-// on each subsequent RTP packet.  Fragment queue is reset upon receiving first
+// on each subsequent RTP packet.  Reassembly restarts upon receiving first
 // fragment in a series.
 func NewNALSink() *NALSink {
 	return &NALSink{
-		Units:     make([]NALUnit, 0, 20),
-		Fragments: make([]NALFragment, 0, 20),
+		Units: make([]NALUnit, 0, 20),
 	}
 }
 
-// AddFragment pushes NAL unit fragment into the fragment queue, resetting queue before
-// accepting first fragment in a series.  Upon receiving last fragment in a series, all fragments
-// are combined into a NAL unit and added to the unit queue.
+// AddFragment appends encapsulated NAL unit fragment to the unit being reassembled, starting a new one upon
+// receiving first fragment in a series.  Upon receiving last fragment in a series, emulation prevention
+// bytes are removed from reassembled NAL unit, as they may straddle fragments, and it is added to the unit queue.  Unit missing its first fragment, or with fragments that do not belong
+// to it, is discarded together with the rest of its fragments.
 func (s *NALSink) AddFragment(indicator byte, header byte, don uint16, ts uint32, data []byte) {
-	if (header & 0x80) != 0 {
-		s.Fragments = s.Fragments[:0]
-	}
-	s.Fragments = append(s.Fragments, NALFragment{
-		NALUnit: NALUnit{
-			Header: indicator,
-			Don:    don,
-			TS:     ts,
-			Data:   data,
-		},
-		Flags: header,
-	})
-	if (header & 0x40) != 0 {
-		// TODO: Sort fragments by TS, Don (TBD).
-		// Combine fragments and append complete NAL.
-		data := []byte{}
-		for _, frag := range s.Fragments {
-			data = append(data, frag.Data...)
+	f := NALFragment{Flags: header}
+	if f.IsStart() {
+		if s.assembling {
+			s.Incomplete++ // Previous unit never got its last fragment.
 		}
-		s.Units = append(s.Units, NALUnit{Header: indicator, Don: don, TS: ts, Data: data})
+		// Decoding order number only comes with the first fragment in FU-B.
+		s.frag = NALUnit{Header: indicator, Don: don, TS: ts, Data: make([]byte, 0, s.hint)}
+		s.assembling, s.skipping = true, false
+	} else if !s.assembling || indicator != s.frag.Header || ts != s.frag.TS {
+		if s.assembling || !s.skipping {
+			s.Incomplete++
+		}
+		s.assembling, s.skipping = false, !f.IsEnd()
+		return
+	}
+	if len(s.frag.Data)+len(data) > s.maxNALSize() {
+		s.Oversized++
+		s.assembling, s.skipping = false, !f.IsEnd()
+		s.frag = NALUnit{}
+		return
+	}
+	s.frag.Data = append(s.frag.Data, data...)
+	if f.IsEnd() {
+		s.hint = len(s.frag.Data)
+		s.frag.Data = EBSPToRaw(s.frag.Data)
+		s.Units = append(s.Units, s.frag)
+		s.assembling = false
+		// Unit owns reassembled data, the next one gets a fresh buffer.
+		s.frag = NALUnit{}
+	}
+}
+
+// maxNALSize returns limit of reassembled NAL unit size.
+func (s *NALSink) maxNALSize() int {
+	if s.MaxNALSize > 0 {
+		return s.MaxNALSize
 	}
+	return DefaultMaxNALSize
+}
+
+// sequence checks continuity of RTP sequence numbers and discards unit being reassembled if any
+// packets were lost or reordered.
+func (s *NALSink) sequence(sn uint16) {
+	if s.sequenced && sn != s.seq && s.assembling {
+		s.Incomplete++
+		s.assembling, s.skipping = false, true
+		s.frag = NALUnit{}
+	}
+	s.seq, s.sequenced = sn+1, true
 }
 
 func (s *NALSink) parseSTAP(typ byte, buf []byte, ts uint32) error {
@@ -166,13 +206,14 @@ func (s *NALSink) parseSTAP(typ byte, buf []byte, ts uint32) error {
 		if len(buf) < 2 {
 			return nil // Nothing more to process
 		}
-		size := be.Uint16(buf)
-		if len(buf) < int(size+2) {
+		// Size covers NAL unit with its header.
+		size := int(be.Uint16(buf))
+		if size < 1 || len(buf) < 2+size {
 			return errPacketTooShort
 		}
-		s.Units = append(s.Units, NALUnit{Header: buf[2], Don: s.Don, TS: ts, Data: buf[3 : size+2]})
+		s.Units = append(s.Units, NALUnit{Header: buf[2], Don: s.Don, TS: ts, Data: EBSPToRaw(buf[3 : 2+size])})
 		s.Don++
-		buf = buf[size+2:]
+		buf = buf[2+size:]
 	}
 }
 
@@ -180,45 +221,55 @@ func (s *NALSink) parseMTAP(typ byte, buf []byte, ts uint32) error {
 	if len(buf) < 2 {
 		return errPacketTooShort
 	}
-	s.Don = be.Uint16(buf)
+	donb := be.Uint16(buf)
 	buf = buf[2:]
-	off := uint16(2 + 1 + 2) // size, donb, 16-bit ts offset
+	off := 2 + 1 + 2 // size, dond, 16-bit ts offset
 	if typ == typeMtap24 {
 		off++ // 24-bit ts offset instead of 16-bit
 	}
 	for {
-		if len(buf) < int(off) {
+		if len(buf) < off {
 			return nil // Nothing more to process
 		}
-		size := be.Uint16(buf)
+		size := int(be.Uint16(buf))
 		dond := uint16(buf[2])
 		// Read and handle 16-bit or 24-bit timestamp offset.
-		tsoff := uint32(buf[3])<<8 + uint32(buf[4])
+		tsoff := uint32(buf[3])<<8 | uint32(buf[4])
 		if typ == typeMtap24 {
-			tsoff = ts<<8 + uint32(buf[5])
+			tsoff = tsoff<<8 | uint32(buf[5])
 		}
-		if len(buf) < int(size+off) {
+		// Size covers DON difference, timestamp offset, and NAL unit with its header.
+		if size < off-1 || len(buf) < 2+size {
 			return errPacketTooShort
 		}
-		s.Units = append(s.Units, NALUnit{Header: buf[off], Don: s.Don + dond, TS: ts + tsoff, Data: buf[off+1 : size+off]})
-		s.Don++
-		buf = buf[size+off:]
+		// Each unit has its own decoding order number and timestamp.  Both wrap around.
+		s.Don = donb + dond
+		s.Units = append(s.Units, NALUnit{Header: buf[off], Don: s.Don, TS: ts + tsoff, Data: EBSPToRaw(buf[off+1 : 2+size])})
+		buf = buf[2+size:]
 	}
 }
 
-// Push RTP payload parsing NAL units and handling aggregation and fragmenting.
-func (s *NALSink) Push(buf []byte, ts uint32) error {
+// Push RTP payload parsing NAL units and handling aggregation and fragmenting.  Units completed by
+// the payload replace ones in the unit queue.  In interleaved mode these are units released by deinterleaver
+// in decoding order.  Sequence number of RTP packet detects loss of fragments.
+func (s *NALSink) Push(buf []byte, sn uint16, ts uint32) error {
+	s.Units = s.Units[:0]
+	s.sequence(sn)
 	// TODO: Detect Annex B vs AVC payloads.  If starts with Annex B start code then split on start code.
-	for _, nal := range SplitAnnexB(buf) {
+	// Emulation prevention bytes are removed from each NAL unit rather than payload, as lengths in
+	// aggregation packets and boundaries of fragments count them.
+	for _, nal := range splitAnnexB(buf) {
 		if err := s.parseNAL(nal, ts); err != nil {
 			return err
 		}
 	}
+	if s.Deinterleave != nil {
+		s.Units = append(s.Units[:0], s.Deinterleave.Push(s.Units)...)
+	}
 	return nil
 }
 
 func (s *NALSink) parseNAL(buf []byte, ts uint32) error {
-	s.Units = s.Units[:0]
 	if len(buf) < 1 {
 		return errPacketTooShort
 	}
@@ -246,13 +297,14 @@ func (s *NALSink) parseNAL(buf []byte, ts uint32) error {
 		}
 		s.AddFragment(nri+(buf[1]&0x1F), buf[1], be.Uint16(buf[2:]), ts, buf[4:])
 	default:
-		s.Units = append(s.Units, NALUnit{Header: buf[0], Don: s.Don, TS: ts, Data: buf[1:]})
+		s.Units = append(s.Units, NALUnit{Header: buf[0], Don: s.Don, TS: ts, Data: EBSPToRaw(buf[1:])})
 		s.Don++
 	}
 	return nil
 }
 
-// DonDiff may not be needed as uint16 automatically wraps on under/overflow.
+// DonDiff returns distance from the first decoding order number to the second accounting for wrap around,
+// i.e. positive if the second follows the first in decoding order.
 func DonDiff(don1 uint16, don2 uint16) (diff int) {
 	diff = int(don2) - int(don1)
 	if diff >= 32768 {
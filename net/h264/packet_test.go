@@ -0,0 +1,112 @@
+package h264
+
+import (
+	"testing"
+)
+
+// fua composes FU-A payload of IDR slice with start and end flags.
+func fua(start, end bool, data ...byte) []byte {
+	header := byte(0x05)
+	if start {
+		header |= 0x80
+	}
+	if end {
+		header |= 0x40
+	}
+	return append([]byte{0x7c, header}, data...)
+}
+
+func TestFragmentLoss(t *testing.T) {
+	s := NewNALSink()
+	packets := []struct {
+		buf   []byte
+		sn    uint16
+		ts    uint32
+		units int
+	}{
+		{fua(true, false, 1), 65534, 0, 0},
+		{fua(false, false, 2), 65535, 0, 0},
+		{fua(false, true, 3), 0, 0, 1}, // Sequence number wraps around.
+		{fua(true, false, 4), 1, 3000, 0},
+		{fua(false, true, 6), 3, 3000, 0}, // Middle fragment is lost.
+		{fua(false, false, 7), 4, 6000, 0},
+		{fua(false, true, 8), 5, 6000, 0}, // Start fragment is lost.
+		{fua(true, false, 9), 6, 9000, 0},
+		{fua(true, true, 10), 7, 9000, 1}, // End fragment is lost.
+		{fua(true, false, 11), 8, 12000, 0},
+		{fua(false, true, 12), 9, 15000, 0}, // Fragment of another picture.
+		{[]byte{0x41, 0x9a}, 10, 18000, 1},
+	}
+	var units []NALUnit
+	for _, p := range packets {
+		if err := s.Push(p.buf, p.sn, p.ts); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.Units) != p.units {
+			t.Fatalf("Expected %d units for packet %d, got %d", p.units, p.sn, len(s.Units))
+		}
+		units = append(units, s.Units...)
+	}
+	if string(units[0].Data) != "\x01\x02\x03" || string(units[1].Data) != "\x0a" || units[2].Header != 0x41 {
+		t.Errorf("Unexpected units %+v", units)
+	}
+	if s.Incomplete != 4 {
+		t.Errorf("Expected 4 incomplete units, got %d", s.Incomplete)
+	}
+}
+
+func TestFragmentSize(t *testing.T) {
+	s := NewNALSink()
+	s.MaxNALSize = 3
+	s.Push(fua(true, false, 1, 2), 0, 0)
+	s.Push(fua(false, false, 3, 4), 1, 0)
+	s.Push(fua(false, true, 5), 2, 0)
+	if len(s.Units) != 0 || s.Oversized != 1 || s.Incomplete != 0 {
+		t.Errorf("Oversized unit is not discarded: %+v", s)
+	}
+	s.Push(fua(true, false, 1, 2), 3, 0)
+	s.Push(fua(false, true, 3), 4, 0)
+	if len(s.Units) != 1 || string(s.Units[0].Data) != "\x01\x02\x03" {
+		t.Errorf("Unexpected units %+v", s.Units)
+	}
+	// Reassembled unit keeps its data when buffer for the next one is filled.
+	data := s.Units[0].Data
+	s.Push(fua(true, false, 7, 7), 5, 0)
+	if string(data) != "\x01\x02\x03" {
+		t.Errorf("Reassembled data is overwritten: % x", data)
+	}
+}
+
+func TestFragmentEmulation(t *testing.T) {
+	s := NewNALSink()
+	// Emulation prevention byte follows zeros that end the first fragment.
+	s.Push(fua(true, false, 0xaa, 0, 0), 0, 0)
+	s.Push(fua(false, true, 3, 1, 0xbb), 1, 0)
+	if len(s.Units) != 1 || string(s.Units[0].Data) != "\xaa\x00\x00\x01\xbb" {
+		t.Fatalf("Unexpected units %+v", s.Units)
+	}
+	if b := s.Units[0].Bytes(); string(b) != "\x65\xaa\x00\x00\x03\x01\xbb" {
+		t.Errorf("Wrong encapsulated unit % x", b)
+	}
+	// Lengths in aggregation packet count emulation prevention bytes.
+	if err := s.Push([]byte{0x78, 0, 5, 0x06, 0, 0, 3, 1, 0, 2, 0x68, 0xce}, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Units) != 2 || string(s.Units[0].Data) != "\x00\x00\x01" || s.Units[1].Header != 0x68 {
+		t.Errorf("Unexpected units %+v", s.Units)
+	}
+}
+
+func TestAggregationMalformed(t *testing.T) {
+	for _, buf := range [][]byte{
+		{24, 0, 0},                      // STAP-A with empty unit.
+		{24, 0xff, 0xff, 0x65},          // STAP-A with unit size that overflows 16 bits with its prefix.
+		{26, 0, 0, 0, 0, 0, 0, 0},       // MTAP16 with empty unit.
+		{26, 0, 0, 0xff, 0xff, 0, 0, 0}, // MTAP16 with unit size beyond payload.
+	} {
+		s := NewNALSink()
+		if err := s.Push(buf, 1, 0); err != errPacketTooShort {
+			t.Errorf("Expected %v for % x, got %v", errPacketTooShort, buf, err)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package h264
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Types of supplemental enhancement information payloads decoded by ParseSEI.
+const (
+	SEIBufferingPeriod      = 0
+	SEIPicTiming            = 1
+	SEIUserDataRegistered   = 4
+	SEIUserDataUnregistered = 5
+	SEIRecoveryPoint        = 6
+)
+
+// ITU-T T.35 identification of ATSC A/53 closed captions.
+const (
+	t35CountryUSA            = 0xB5
+	t35ProviderATSC          = 0x0031
+	atscUserDataTypeCaptions = 0x03
+)
+
+var (
+	errNotSEI          = errors.New("NAL unit is not supplemental enhancement information")
+	errTruncatedSEI    = errors.New("SEI message is truncated")
+	errInvalidUserData = errors.New("Invalid user data in SEI message")
+)
+
+// Number of clock timestamps by picture structure, Table D-1.
+var numClockTS = []int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+type (
+	// SEIMessage is a single message of supplemental enhancement information.  Payload is always present
+	// while only the field matching the type is set for the types decoded.
+	SEIMessage struct {
+		Type                 uint32
+		Payload              []byte
+		BufferingPeriod      *BufferingPeriod
+		PicTiming            *PicTiming
+		RecoveryPoint        *RecoveryPoint
+		UserDataRegistered   *UserDataRegistered
+		UserDataUnregistered *UserDataUnregistered
+	}
+
+	// BufferingPeriod holds initial removal delays of coded picture buffer per scheduling alternative.
+	BufferingPeriod struct {
+		SpsID                           uint32
+		NalInitialCpbRemovalDelay       []uint32
+		NalInitialCpbRemovalDelayOffset []uint32
+		VclInitialCpbRemovalDelay       []uint32
+		VclInitialCpbRemovalDelayOffset []uint32
+	}
+
+	// PicTiming holds removal and output delays of the picture and its structure with optional timecodes.
+	PicTiming struct {
+		CpbRemovalDelay uint32
+		DpbOutputDelay  uint32
+		PicStruct       byte
+		Timecodes       []Timecode
+	}
+
+	// Timecode is a clock timestamp of a field or frame.  Hours, minutes, and seconds are only meaningful
+	// when the respective flag is set.
+	Timecode struct {
+		CtType         byte
+		NuitFieldBased bool
+		CountingType   byte
+		Discontinuity  bool
+		CntDropped     bool
+		Frames         byte
+		SecondsValid   bool
+		Seconds        byte
+		MinutesValid   bool
+		Minutes        byte
+		HoursValid     bool
+		Hours          byte
+		TimeOffset     int32
+	}
+
+	// RecoveryPoint indicates that output pictures are correct after a number of frames when decoding
+	// starts at the picture, i.e. random access point without IDR.
+	RecoveryPoint struct {
+		FrameCnt              uint32
+		ExactMatch            bool
+		BrokenLink            bool
+		ChangingSliceGroupIdc byte
+	}
+
+	// UserDataRegistered is user data registered by ITU-T T.35.  Closed captions of ATSC A/53 are decoded.
+	UserDataRegistered struct {
+		CountryCode          byte
+		CountryCodeExtension byte
+		Data                 []byte // Following country code.
+		Captions             []CaptionData
+	}
+
+	// CaptionData is a single construct of CEA-708 caption data carrying two bytes.
+	CaptionData struct {
+		Valid bool
+		Type  byte // 0 and 1 for CEA-608 fields, 2 and 3 for DTVCC packets.
+		Data  [2]byte
+	}
+
+	// UserDataUnregistered is user data identified by UUID, e.g. wall clock or analytics of a camera vendor.
+	UserDataUnregistered struct {
+		UUID [16]byte
+		Data []byte
+	}
+)
+
+// ParseSEI splits supplemental enhancement information unit into messages and decodes known payloads.
+// Buffering period and picture timing depend on active sequence parameter set and are only decoded when
+// one is given.
+func ParseSEI(u *NALUnit, sps *SPSInfo) (messages []SEIMessage, err error) {
+	if u.Type() != typeSEI {
+		return nil, errNotSEI
+	}
+	br := NewBitReader(u.Data)
+	for br.MoreData() {
+		var m SEIMessage
+		var size uint32
+		if m.Type, err = br.ReadPayloadParam(); err != nil {
+			return
+		}
+		if size, err = br.ReadPayloadParam(); err != nil {
+			return
+		}
+		if uint(size)*8 > br.Available() {
+			return messages, errTruncatedSEI
+		}
+		m.Payload = make([]byte, size)
+		for i := range m.Payload {
+			m.Payload[i], _ = br.ReadByteBits(8)
+		}
+		if err = m.decode(sps); err != nil {
+			return
+		}
+		messages = append(messages, m)
+	}
+	return
+}
+
+// decode parses payload of known message types.
+func (m *SEIMessage) decode(sps *SPSInfo) (err error) {
+	switch m.Type {
+	case SEIBufferingPeriod:
+		if sps != nil {
+			m.BufferingPeriod, err = parseBufferingPeriod(NewBitReader(m.Payload), sps)
+		}
+	case SEIPicTiming:
+		if sps != nil {
+			m.PicTiming, err = parsePicTiming(NewBitReader(m.Payload), sps)
+		}
+	case SEIRecoveryPoint:
+		m.RecoveryPoint, err = parseRecoveryPoint(NewBitReader(m.Payload))
+	case SEIUserDataRegistered:
+		m.UserDataRegistered, err = parseUserDataRegistered(m.Payload)
+	case SEIUserDataUnregistered:
+		if len(m.Payload) < 16 {
+			return errInvalidUserData
+		}
+		m.UserDataUnregistered = &UserDataUnregistered{Data: m.Payload[16:]}
+		copy(m.UserDataUnregistered.UUID[:], m.Payload)
+	}
+	return
+}
+
+func parseBufferingPeriod(br *BitReader, sps *SPSInfo) (bp *BufferingPeriod, err error) {
+	bp = &BufferingPeriod{}
+	if bp.SpsID, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	if sps.NalHrd != nil {
+		if bp.NalInitialCpbRemovalDelay, bp.NalInitialCpbRemovalDelayOffset, err = readInitialDelays(br, sps.NalHrd); err != nil {
+			return
+		}
+	}
+	if sps.VclHrd != nil {
+		if bp.VclInitialCpbRemovalDelay, bp.VclInitialCpbRemovalDelayOffset, err = readInitialDelays(br, sps.VclHrd); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readInitialDelays reads initial removal delay and its offset for each scheduling alternative.
+func readInitialDelays(br *BitReader, hrd *HRDInfo) (delays, offsets []uint32, err error) {
+	n := uint(hrd.InitialCpbRemovalDelayLengthMinus1) + 1
+	delays = make([]uint32, len(hrd.CbrFlag))
+	offsets = make([]uint32, len(hrd.CbrFlag))
+	for i := range delays {
+		if delays[i], err = br.ReadBits(n); err != nil {
+			return
+		}
+		if offsets[i], err = br.ReadBits(n); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func parsePicTiming(br *BitReader, sps *SPSInfo) (pt *PicTiming, err error) {
+	pt = &PicTiming{}
+	hrd := sps.NalHrd
+	if hrd == nil {
+		hrd = sps.VclHrd
+	}
+	if hrd != nil {
+		if pt.CpbRemovalDelay, err = br.ReadBits(uint(hrd.CpbRemovalDelayLengthMinus1) + 1); err != nil {
+			return
+		}
+		if pt.DpbOutputDelay, err = br.ReadBits(uint(hrd.DpbOutputDelayLengthMinus1) + 1); err != nil {
+			return
+		}
+	}
+	if !sps.PicStructPresent {
+		return
+	}
+	if pt.PicStruct, err = br.ReadByteBits(4); err != nil {
+		return
+	}
+	if int(pt.PicStruct) >= len(numClockTS) {
+		return
+	}
+	var offsetLength uint
+	if hrd != nil {
+		offsetLength = uint(hrd.TimeOffsetLength)
+	}
+	for i := 0; i < numClockTS[pt.PicStruct]; i++ {
+		var present bool
+		if present, err = br.ReadFlag(); err != nil {
+			return
+		}
+		if !present {
+			continue
+		}
+		var tc Timecode
+		if tc, err = readTimecode(br, offsetLength); err != nil {
+			return
+		}
+		pt.Timecodes = append(pt.Timecodes, tc)
+	}
+	return
+}
+
+// readTimecode reads clock timestamp that follows its presence flag.
+func readTimecode(br *BitReader, offsetLength uint) (tc Timecode, err error) {
+	if tc.CtType, err = br.ReadByteBits(2); err != nil {
+		return
+	}
+	if tc.NuitFieldBased, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if tc.CountingType, err = br.ReadByteBits(5); err != nil {
+		return
+	}
+	var full bool
+	if full, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if tc.Discontinuity, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if tc.CntDropped, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if tc.Frames, err = br.ReadByteBits(8); err != nil {
+		return
+	}
+	if full {
+		tc.SecondsValid, tc.MinutesValid, tc.HoursValid = true, true, true
+	} else if tc.SecondsValid, err = br.ReadFlag(); err != nil {
+		return
+	}
+	// Each of seconds, minutes, and hours is only present if the preceding one is.
+	if tc.SecondsValid {
+		if tc.Seconds, err = br.ReadByteBits(6); err != nil {
+			return
+		}
+		if !full {
+			if tc.MinutesValid, err = br.ReadFlag(); err != nil {
+				return
+			}
+		}
+	}
+	if tc.MinutesValid {
+		if tc.Minutes, err = br.ReadByteBits(6); err != nil {
+			return
+		}
+		if !full {
+			if tc.HoursValid, err = br.ReadFlag(); err != nil {
+				return
+			}
+		}
+	}
+	if tc.HoursValid {
+		if tc.Hours, err = br.ReadByteBits(5); err != nil {
+			return
+		}
+	}
+	if offsetLength > 0 {
+		var v uint32
+		if v, err = br.ReadBits(offsetLength); err != nil {
+			return
+		}
+		// Sign extend two's complement value.
+		tc.TimeOffset = int32(v<<(32-offsetLength)) >> (32 - offsetLength)
+	}
+	return
+}
+
+// String formats timecode as HH:MM:SS:FF, or with semicolon before frames when frames are dropped.
+func (tc Timecode) String() string {
+	sep := ':'
+	if tc.CntDropped {
+		sep = ';'
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%02d", tc.Hours, tc.Minutes, tc.Seconds, sep, tc.Frames)
+}
+
+func parseRecoveryPoint(br *BitReader) (rp *RecoveryPoint, err error) {
+	rp = &RecoveryPoint{}
+	if rp.FrameCnt, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	if rp.ExactMatch, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if rp.BrokenLink, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if rp.ChangingSliceGroupIdc, err = br.ReadByteBits(2); err != nil {
+		return
+	}
+	return
+}
+
+// parseUserDataRegistered splits T.35 country code from data and decodes ATSC A/53 captions.
+func parseUserDataRegistered(payload []byte) (*UserDataRegistered, error) {
+	if len(payload) < 1 {
+		return nil, errInvalidUserData
+	}
+	ud := &UserDataRegistered{CountryCode: payload[0], Data: payload[1:]}
+	if ud.CountryCode == 0xFF {
+		if len(ud.Data) < 1 {
+			return nil, errInvalidUserData
+		}
+		ud.CountryCodeExtension, ud.Data = ud.Data[0], ud.Data[1:]
+	}
+	// Provider code, user identifier, user data type code, and flags with count of constructs.
+	d := ud.Data
+	if ud.CountryCode != t35CountryUSA || len(d) < 9 || int(d[0])<<8|int(d[1]) != t35ProviderATSC ||
+		string(d[2:6]) != "GA94" || d[6] != atscUserDataTypeCaptions || d[7]&0x40 == 0 {
+		return ud, nil
+	}
+	count := int(d[7] & 0x1F)
+	// Reserved byte, em_data, follows the count.
+	d = d[9:]
+	if len(d) < count*3 {
+		return nil, errInvalidUserData
+	}
+	for i := 0; i < count; i++ {
+		c := d[i*3:]
+		ud.Captions = append(ud.Captions, CaptionData{Valid: c[0]&0x04 != 0, Type: c[0] & 0x03, Data: [2]byte{c[1], c[2]}})
+	}
+	return ud, nil
+}
+
+// SEI parses supplemental enhancement information carried in the access unit.  Sequence parameter set
+// active for the access unit is looked up through the first slice when parameter sets are given.
+func (au *AccessUnit) SEI(params *ParameterSets) (messages []SEIMessage, err error) {
+	var sps *SPSInfo
+	if params != nil {
+		for i := range au.Units {
+			if h, err := params.ParseSliceHeader(&au.Units[i]); err == nil {
+				sps = params.spset[params.ppset[h.PpsID].SpsID]
+				break
+			}
+		}
+	}
+	for i := range au.Units {
+		if au.Units[i].Type() != typeSEI {
+			continue
+		}
+		var m []SEIMessage
+		if m, err = ParseSEI(&au.Units[i], sps); err != nil {
+			return
+		}
+		messages = append(messages, m...)
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package h264
+
+import (
+	"testing"
+)
+
+// seiMessage prefixes payload with its type and size.
+func seiMessage(typ byte, payload []byte) []byte {
+	return append([]byte{typ, byte(len(payload))}, payload...)
+}
+
+func TestParseSEI(t *testing.T) {
+	params := amcrestParameterSets(t)
+	sps, _ := params.GetSPS(0)
+	// Amcrest HRD has 24 bits of initial delays, 16 bits of removal delay, 6 bits of output delay,
+	// and 24 bits of time offset in both NAL and VCL parameters.
+	bp := (&bitWriter{}).ue(0).bits(90000, 24).bits(0, 24).bits(45000, 24).bits(0, 24).rbsp()
+	pt := (&bitWriter{}).bits(2, 16).bits(4, 6).bits(0, 4).
+		bits(1, 1).bits(0, 2).bits(0, 1).bits(4, 5).bits(1, 1).bits(0, 1).bits(1, 1).bits(12, 8).
+		bits(56, 6).bits(34, 6).bits(12, 5).bits(0xFFFFFE, 24).rbsp()
+	rp := (&bitWriter{}).ue(30).bits(1, 1).bits(0, 1).bits(0, 2).rbsp()
+	uuid := []byte("0123456789abcdef")
+	cc := []byte{0xB5, 0x00, 0x31, 'G', 'A', '9', '4', 0x03, 0x42, 0xFF, 0xFC, 0x94, 0x20, 0xFD, 0x80, 0x80, 0xFF}
+	var data []byte
+	data = append(data, seiMessage(SEIBufferingPeriod, bp)...)
+	data = append(data, seiMessage(SEIPicTiming, pt)...)
+	data = append(data, seiMessage(SEIRecoveryPoint, rp)...)
+	data = append(data, seiMessage(SEIUserDataUnregistered, append(uuid, "2023-11-14T22:13:20Z"...))...)
+	data = append(data, seiMessage(SEIUserDataRegistered, cc)...)
+	data = append(data, 0x80)
+	u := &NALUnit{Header: 0x06, Data: data}
+
+	messages, err := ParseSEI(u, sps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d", len(messages))
+	}
+	if b := messages[0].BufferingPeriod; b == nil || b.NalInitialCpbRemovalDelay[0] != 90000 || b.VclInitialCpbRemovalDelay[0] != 45000 {
+		t.Errorf("Unexpected buffering period %+v", b)
+	}
+	p := messages[1].PicTiming
+	if p == nil || p.CpbRemovalDelay != 2 || p.DpbOutputDelay != 4 || len(p.Timecodes) != 1 {
+		t.Fatalf("Unexpected picture timing %+v", p)
+	}
+	if tc := p.Timecodes[0]; tc.String() != "12:34:56;12" || tc.TimeOffset != -2 || tc.CountingType != 4 {
+		t.Errorf("Unexpected timecode %s %+v", tc, tc)
+	}
+	if r := messages[2].RecoveryPoint; r == nil || r.FrameCnt != 30 || !r.ExactMatch || r.BrokenLink {
+		t.Errorf("Unexpected recovery point %+v", r)
+	}
+	if ud := messages[3].UserDataUnregistered; ud == nil || string(ud.UUID[:]) != string(uuid) || string(ud.Data) != "2023-11-14T22:13:20Z" {
+		t.Errorf("Unexpected unregistered user data %+v", ud)
+	}
+	ud := messages[4].UserDataRegistered
+	if ud == nil || len(ud.Captions) != 2 {
+		t.Fatalf("Unexpected registered user data %+v", ud)
+	}
+	if c := ud.Captions[0]; !c.Valid || c.Type != 0 || c.Data != [2]byte{0x94, 0x20} {
+		t.Errorf("Unexpected caption data %+v", c)
+	}
+
+	// Without parameter set timing messages are split but not decoded.
+	if messages, err = ParseSEI(u, nil); err != nil || len(messages) != 5 || messages[1].PicTiming != nil || messages[2].RecoveryPoint == nil {
+		t.Errorf("Unexpected messages without parameter set %+v, %v", messages, err)
+	}
+	if _, err = ParseSEI(&NALUnit{Header: 0x06, Data: []byte{0x05, 0x20, 0x00, 0x80}}, nil); err != errTruncatedSEI {
+		t.Errorf("Expected truncated message error, got %v", err)
+	}
+}
+
+func TestAccessUnitSEI(t *testing.T) {
+	params := amcrestParameterSets(t)
+	rp := (&bitWriter{}).ue(0).bits(1, 1).bits(0, 1).bits(0, 2).rbsp()
+	pt := (&bitWriter{}).bits(0, 16).bits(0, 6).bits(0, 4).bits(0, 1).rbsp()
+	sei := NALUnit{Header: 0x06, Data: append(append(seiMessage(SEIRecoveryPoint, rp), seiMessage(SEIPicTiming, pt)...), 0x80)}
+	au := &AccessUnit{Units: []NALUnit{sei, *slice(0x41, 0, 5, 1, 0, 2)}}
+	messages, err := au.SEI(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0].RecoveryPoint == nil || messages[1].PicTiming == nil {
+		t.Errorf("Unexpected messages %+v", messages)
+	}
+}
@@ -0,0 +1,252 @@
+package h264
+
+import (
+	"errors"
+)
+
+// Slice types.  Values 5 to 9 in slice header mean the same as 0 to 4 and indicate that all slices
+// of the picture have the same type.
+const (
+	SliceP  = 0
+	SliceB  = 1
+	SliceI  = 2
+	SliceSP = 3
+	SliceSI = 4
+)
+
+var (
+	errNotSlice   = errors.New("NAL unit is not a coded slice")
+	errUnknownPPS = errors.New("Slice refers to unknown picture parameter set")
+	errUnknownSPS = errors.New("Picture parameter set refers to unknown sequence parameter set")
+)
+
+type (
+	// SliceHeader holds the leading part of coded slice header that identifies the picture the slice
+	// belongs to and its order.  Reference list modification, weighted prediction, and decoded reference
+	// picture marking that follow are not parsed.
+	SliceHeader struct {
+		IDR                    bool
+		RefIdc                 byte
+		FirstMbInSlice         uint32
+		SliceType              uint32
+		PpsID                  uint32
+		ColorPlaneID           byte
+		FrameNum               uint32
+		FieldPic               bool
+		BottomField            bool
+		IdrPicID               uint32
+		PicOrderCntLsb         uint32
+		DeltaPicOrderCntBottom int32
+		DeltaPicOrderCnt       [2]int32
+		RedundantPicCnt        uint32
+	}
+
+	// PicOrderCounter derives picture order count of pictures in decoding order as described in 8.2.1
+	// of the standard.  Memory management operation that resets picture order is not taken into account.
+	PicOrderCounter struct {
+		prevMsb       int32
+		prevLsb       int32
+		prevFrameNum  uint32
+		prevNumOffset uint32
+	}
+)
+
+// ParseSliceHeader parses header of coded slice in IDR or non-IDR picture, or in data partition A, using
+// referenced parameter sets.
+func (s *ParameterSets) ParseSliceHeader(u *NALUnit) (h *SliceHeader, err error) {
+	typ := u.Type()
+	if typ != typeNIDR && typ != typeDPA && typ != typeIDR {
+		return nil, errNotSlice
+	}
+	h = &SliceHeader{IDR: typ == typeIDR, RefIdc: u.RefIdc()}
+	br := NewBitReader(u.Data)
+	if h.FirstMbInSlice, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	if h.SliceType, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	if h.PpsID, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	pps, ok := s.ppset[h.PpsID]
+	if !ok {
+		return nil, errUnknownPPS
+	}
+	sps, ok := s.spset[pps.SpsID]
+	if !ok {
+		return nil, errUnknownSPS
+	}
+	if sps.SeparateColorPlane {
+		if h.ColorPlaneID, err = br.ReadByteBits(2); err != nil {
+			return
+		}
+	}
+	if h.FrameNum, err = br.ReadBits(uint(sps.Log2MaxFrameNum + 4)); err != nil {
+		return
+	}
+	if sps.FrameMbsOnly == 0 {
+		if h.FieldPic, err = br.ReadFlag(); err != nil {
+			return
+		}
+		if h.FieldPic {
+			if h.BottomField, err = br.ReadFlag(); err != nil {
+				return
+			}
+		}
+	}
+	if h.IDR {
+		if h.IdrPicID, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+	}
+	if sps.PicOrderCntType == 0 {
+		if h.PicOrderCntLsb, err = br.ReadBits(uint(sps.Log2MaxPicOrderCnt + 4)); err != nil {
+			return
+		}
+		if pps.BottomFieldPicOorderInFramePresent && !h.FieldPic {
+			if h.DeltaPicOrderCntBottom, err = br.ReadSignedGolomb(); err != nil {
+				return
+			}
+		}
+	}
+	if sps.PicOrderCntType == 1 && !sps.DeltaPicOrderAlways0 {
+		if h.DeltaPicOrderCnt[0], err = br.ReadSignedGolomb(); err != nil {
+			return
+		}
+		if pps.BottomFieldPicOorderInFramePresent && !h.FieldPic {
+			if h.DeltaPicOrderCnt[1], err = br.ReadSignedGolomb(); err != nil {
+				return
+			}
+		}
+	}
+	if pps.RedundantPicCntPresent {
+		if h.RedundantPicCnt, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Type returns slice type in range from SliceP to SliceSI.
+func (h *SliceHeader) Type() int {
+	return int(h.SliceType % 5)
+}
+
+// IsIntra reports whether slice does not refer to other pictures.
+func (h *SliceHeader) IsIntra() bool {
+	return h.Type() == SliceI || h.Type() == SliceSI
+}
+
+// IsB reports whether slice is bi-predicted and may refer to pictures that follow in output order.
+func (h *SliceHeader) IsB() bool {
+	return h.Type() == SliceB
+}
+
+// NewPicture reports whether slice starts a new primary picture after the previous slice, if any,
+// by comparing headers as described in 7.4.1.2.4 of the standard.  Redundant slices never do.
+func (h *SliceHeader) NewPicture(prev *SliceHeader) bool {
+	if h.RedundantPicCnt > 0 {
+		return false
+	}
+	if prev == nil {
+		return true
+	}
+	return h.FrameNum != prev.FrameNum ||
+		h.PpsID != prev.PpsID ||
+		h.FieldPic != prev.FieldPic ||
+		h.BottomField != prev.BottomField ||
+		(h.RefIdc == 0) != (prev.RefIdc == 0) ||
+		h.PicOrderCntLsb != prev.PicOrderCntLsb ||
+		h.DeltaPicOrderCntBottom != prev.DeltaPicOrderCntBottom ||
+		h.DeltaPicOrderCnt != prev.DeltaPicOrderCnt ||
+		h.IDR != prev.IDR ||
+		(h.IDR && h.IdrPicID != prev.IdrPicID)
+}
+
+// Count returns picture order count of the picture the slice belongs to.  It must be called once per picture
+// in decoding order.  Frame gets the lesser of its top and bottom field counts.
+func (c *PicOrderCounter) Count(sps *SPSInfo, h *SliceHeader) int32 {
+	var top, bottom int32
+	switch sps.PicOrderCntType {
+	case 0:
+		if h.IDR {
+			c.prevMsb, c.prevLsb = 0, 0
+		}
+		max := int32(1) << (sps.Log2MaxPicOrderCnt + 4)
+		lsb := int32(h.PicOrderCntLsb)
+		msb := c.prevMsb
+		if lsb < c.prevLsb && c.prevLsb-lsb >= max/2 {
+			msb += max
+		} else if lsb > c.prevLsb && lsb-c.prevLsb > max/2 {
+			msb -= max
+		}
+		top = msb + lsb
+		bottom = top + h.DeltaPicOrderCntBottom
+		if h.FieldPic {
+			bottom = top
+		}
+		if h.RefIdc != 0 {
+			c.prevMsb, c.prevLsb = msb, lsb
+		}
+	case 1:
+		offset := c.frameNumOffset(sps, h)
+		n := uint32(len(sps.OffsetForRefFrame))
+		abs := uint32(0)
+		if n != 0 {
+			abs = offset + h.FrameNum
+		}
+		if h.RefIdc == 0 && abs > 0 {
+			abs--
+		}
+		var expected int32
+		if abs > 0 {
+			var delta int32
+			for _, v := range sps.OffsetForRefFrame {
+				delta += v
+			}
+			cycle, in := (abs-1)/n, (abs-1)%n
+			expected = int32(cycle) * delta
+			for i := uint32(0); i <= in; i++ {
+				expected += sps.OffsetForRefFrame[i]
+			}
+		}
+		if h.RefIdc == 0 {
+			expected += sps.OffsetForNonRefPic
+		}
+		top = expected + h.DeltaPicOrderCnt[0]
+		bottom = top + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[1]
+		if h.FieldPic {
+			bottom = expected + sps.OffsetForTopToBottomField + h.DeltaPicOrderCnt[0]
+		}
+	default:
+		offset := c.frameNumOffset(sps, h)
+		if !h.IDR {
+			top = 2 * int32(offset+h.FrameNum)
+			if h.RefIdc == 0 {
+				top--
+			}
+		}
+		bottom = top
+	}
+	switch {
+	case h.FieldPic && h.BottomField:
+		return bottom
+	case h.FieldPic || top < bottom:
+		return top
+	}
+	return bottom
+}
+
+// frameNumOffset accounts for wrapping of frame number.
+func (c *PicOrderCounter) frameNumOffset(sps *SPSInfo, h *SliceHeader) uint32 {
+	offset := uint32(0)
+	if !h.IDR {
+		offset = c.prevNumOffset
+		if c.prevFrameNum > h.FrameNum {
+			offset += 1 << (sps.Log2MaxFrameNum + 4)
+		}
+	}
+	c.prevFrameNum, c.prevNumOffset = h.FrameNum, offset
+	return offset
+}
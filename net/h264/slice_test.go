@@ -0,0 +1,184 @@
+package h264
+
+import (
+	"testing"
+)
+
+// bitWriter composes bit streams for tests.
+type bitWriter struct {
+	buf []byte
+	n   uint
+}
+
+func (w *bitWriter) bits(v uint32, n uint) *bitWriter {
+	for i := n; i > 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((v>>(i-1))&1) << (7 - w.n%8)
+		w.n++
+	}
+	return w
+}
+
+func (w *bitWriter) ue(v uint32) *bitWriter {
+	v++
+	n := uint(0)
+	for x := v; x > 1; x >>= 1 {
+		n++
+	}
+	return w.bits(0, n).bits(v, n+1)
+}
+
+func (w *bitWriter) se(v int32) *bitWriter {
+	if v > 0 {
+		return w.ue(uint32(2*v - 1))
+	}
+	return w.ue(uint32(-2 * v))
+}
+
+// rbsp appends stop bit and alignment.
+func (w *bitWriter) rbsp() []byte {
+	w.bits(1, 1)
+	for w.n%8 != 0 {
+		w.bits(0, 1)
+	}
+	return w.buf
+}
+
+func amcrestParameterSets(t *testing.T) *ParameterSets {
+	t.Helper()
+	params := NewParameterSets()
+	sps := []byte{
+		0x64, 0x00, 0x1f, 0xac, 0x34, 0xc8, 0x05, 0x00, 0x5b, 0xff, 0x01, 0x6e, 0x02, 0x02, 0x02, 0x80,
+		0x00, 0x01, 0xf4, 0x00, 0x00, 0x3a, 0x98, 0x74, 0x30, 0x00, 0x4e, 0x2a, 0x00, 0x01, 0x38, 0xa8,
+		0x5d, 0xe5, 0xc6, 0x86, 0x00, 0x09, 0xc5, 0x40, 0x00, 0x27, 0x15, 0x0b, 0xbc, 0xb8, 0x50, 0x00,
+	}
+	if err := params.ParseSPS(sps); err != nil {
+		t.Fatal(err)
+	}
+	if err := params.ParsePPS([]byte{0xee, 0x3c, 0x30, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// slice composes slice header for Amcrest parameter sets: 9 bits of frame number and picture order count.
+func slice(header byte, firstMb, typ, frameNum, idrPicID, lsb uint32) *NALUnit {
+	w := (&bitWriter{}).ue(firstMb).ue(typ).ue(0).bits(frameNum, 9)
+	if header&0x1F == typeIDR {
+		w.ue(idrPicID)
+	}
+	w.bits(lsb, 9)
+	return &NALUnit{Header: header, Data: w.rbsp()}
+}
+
+func TestParseSliceHeader(t *testing.T) {
+	params := amcrestParameterSets(t)
+	sps, _ := params.GetSPS(0)
+	// Decoding order I0 P6 B2 B4 and the next IDR.
+	units := []*NALUnit{
+		slice(0x65, 0, 7, 0, 3, 0),
+		slice(0x65, 1800, 7, 0, 3, 0),
+		slice(0x41, 0, 5, 1, 0, 12),
+		slice(0x01, 0, 6, 2, 0, 4),
+		slice(0x01, 0, 6, 2, 0, 8),
+		slice(0x65, 0, 7, 0, 4, 0),
+	}
+	expected := []struct {
+		typ   int
+		first bool
+		poc   int32
+	}{
+		{SliceI, true, 0}, {SliceI, false, 0}, {SliceP, true, 12}, {SliceB, true, 4}, {SliceB, true, 8}, {SliceI, true, 0},
+	}
+	var prev *SliceHeader
+	counter := &PicOrderCounter{}
+	for i, u := range units {
+		h, err := params.ParseSliceHeader(u)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if h.Type() != expected[i].typ || h.IsIntra() != (expected[i].typ == SliceI) || h.IsB() != (expected[i].typ == SliceB) {
+			t.Errorf("slice %d: expected type %d, got %d", i, expected[i].typ, h.Type())
+		}
+		if first := h.NewPicture(prev); first != expected[i].first {
+			t.Errorf("slice %d: expected new picture %t", i, expected[i].first)
+		} else if first {
+			if poc := counter.Count(sps, h); poc != expected[i].poc {
+				t.Errorf("slice %d: expected picture order %d, got %d", i, expected[i].poc, poc)
+			}
+		}
+		prev = h
+	}
+	if prev.IdrPicID != 4 || !prev.IDR || prev.RefIdc != 3 {
+		t.Errorf("unexpected IDR header %+v", prev)
+	}
+	if _, err := params.ParseSliceHeader(&NALUnit{Header: 0x67}); err != errNotSlice {
+		t.Error("expected error for SPS")
+	}
+}
+
+func TestPicOrderCountWraps(t *testing.T) {
+	params := amcrestParameterSets(t)
+	sps, _ := params.GetSPS(0)
+	counter := &PicOrderCounter{}
+	poc := int32(0)
+	for i := uint32(0); i < 300; i++ {
+		h, err := params.ParseSliceHeader(slice(0x41, 0, 5, i%512, 0, (i*2)%512))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			h.IDR = true
+		}
+		if poc = counter.Count(sps, h); poc != int32(i*2) {
+			t.Fatalf("picture %d: expected order %d, got %d", i, i*2, poc)
+		}
+	}
+}
+
+func TestPicOrderCountType2(t *testing.T) {
+	params := NewParameterSets()
+	// Baseline 320x240 with 4 bits of frame number and picture order derived from it.
+	sps := (&bitWriter{}).bits(66, 8).bits(0, 8).bits(30, 8).ue(0).ue(0).ue(2).ue(1).bits(0, 1).ue(19).ue(14).bits(1, 1).bits(1, 1).bits(0, 1).bits(0, 1).rbsp()
+	pps := (&bitWriter{}).ue(0).ue(0).bits(0, 1).bits(0, 1).ue(0).ue(0).ue(0).bits(0, 1).bits(0, 2).se(0).se(0).se(0).bits(1, 1).bits(0, 1).bits(0, 1).rbsp()
+	if err := params.ParseSPS(sps); err != nil {
+		t.Fatal(err)
+	}
+	if err := params.ParsePPS(pps); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := params.GetSPS(0)
+	if info.Width != 320 || info.Height != 240 {
+		t.Errorf("expected 320x240, got %dx%d", info.Width, info.Height)
+	}
+	counter := &PicOrderCounter{}
+	for i, expected := range []int32{0, 2, 4, 5, 30, 32, 34} {
+		frameNum := uint32(i)
+		header := byte(0x41)
+		switch i {
+		case 0:
+			header = 0x65
+		case 3:
+			header = 0x01
+			frameNum = 3
+		case 4:
+			frameNum = 15
+		}
+		if i > 4 {
+			frameNum = uint32(i - 5)
+		}
+		w := (&bitWriter{}).ue(0).ue(5).ue(0).bits(frameNum, 4)
+		if header == 0x65 {
+			w.ue(0)
+		}
+		h, err := params.ParseSliceHeader(&NALUnit{Header: header, Data: w.rbsp()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if poc := counter.Count(info, h); poc != expected {
+			t.Errorf("picture %d: expected order %d, got %d", i, expected, poc)
+		}
+	}
+}
@@ -1,12 +1,20 @@
 package h264
 
 import (
+	"bytes"
+	"errors"
 	"math"
+	"sort"
+)
+
+var (
+	errInvalidSPS = errors.New("Sequence parameter set has values out of range")
+	errInvalidPPS = errors.New("Picture parameter set has values out of range")
 )
 
 type (
 	// SPSInfo holds Sequence Parameter Set information parsed from out-of-band or in-band data in the stream.
-	// Most of the information here is unused but parsed anyway.  VUI parameters are no parsed at all.
+	// Most of the information here is unused but parsed anyway.
 	SPSInfo struct {
 		ProfileIdc                     byte
 		ConstraintSet                  byte
@@ -14,25 +22,26 @@ type (
 		SpsID                          uint32
 		ChromaFormatIdc                uint32
 		SeparateColorPlane             bool
-		BitDepthLuma                   uint32
-		BitDepthChroma                 uint32
+		BitDepthLuma                   uint32 // Minus 8 as coded.
+		BitDepthChroma                 uint32 // Minus 8 as coded.
 		ZeroTransformBypass            bool
 		ScalingMatrixPresent           bool
 		ScalingListPresent             uint32
 		ScalingList                    [6*16 + 6*64]int32
 		UseDefaultScalingMatrix        [12]bool
-		Log2MaxFrameNum                uint32
+		Log2MaxFrameNum                uint32 // Minus 4 as coded.
 		PicOrderCntType                uint32
-		Log2MaxPicOrderCnt             uint32
+		Log2MaxPicOrderCnt             uint32 // Minus 4 as coded.
 		DeltaPicOrderAlways0           bool
 		OffsetForNonRefPic             int32
 		OffsetForTopToBottomField      int32
 		NumRefFramesInPicOrderCntCycle uint32
+		OffsetForRefFrame              []int32
 		MaxNumRefFrames                uint32
 		GapsInFrameNumValueAllowed     bool
 		PicWidthInMbsMinus1            uint32
 		PicHeightInMapUnitsMinus1      uint32
-		FrameMbsOnly                   uint32
+		FrameMbsOnly                   uint32 // 1 if there are no fields, 0 otherwise.
 		MbAdaptiveFrameField           bool
 		Direct8x8Inference             bool
 		FrameCropping                  bool
@@ -41,6 +50,38 @@ type (
 		FrameCropTopOffset             uint32
 		FrameCropBottomOffset          uint32
 		VuiParametersPresent           bool
+		AspectRatioInfoPresent         bool
+		AspectRatioIdc                 byte
+		SarWidth                       uint32
+		SarHeight                      uint32
+		OverscanInfoPresent            bool
+		OverscanAppropriate            bool
+		VideoSignalTypePresent         bool
+		VideoFormat                    byte
+		VideoFullRange                 bool
+		ColourDescriptionPresent       bool
+		ColourPrimaries                byte
+		TransferCharacteristics        byte
+		MatrixCoefficients             byte
+		ChromaLocInfoPresent           bool
+		ChromaSampleLocTypeTopField    uint32
+		ChromaSampleLocTypeBottomField uint32
+		TimingInfoPresent              bool
+		NumUnitsInTick                 uint32
+		TimeScale                      uint32
+		FixedFrameRate                 bool
+		NalHrd                         *HRDInfo
+		VclHrd                         *HRDInfo
+		LowDelayHrd                    bool
+		PicStructPresent               bool
+		BitstreamRestriction           bool
+		MotionVectorsOverPicBoundaries bool
+		MaxBytesPerPicDenom            uint32
+		MaxBitsPerMbDenom              uint32
+		Log2MaxMvLengthHorizontal      uint32
+		Log2MaxMvLengthVertical        uint32
+		MaxNumReorderFrames            uint32
+		MaxDecFrameBuffering           uint32
 		Width                          uint32
 		Height                         uint32
 	}
@@ -80,8 +121,10 @@ type (
 
 	// ParameterSets keep all current parsed and indexed parameter sets for quick access.
 	ParameterSets struct {
-		spset map[uint32]*SPSInfo
-		ppset map[uint32]*PPSInfo
+		spset  map[uint32]*SPSInfo
+		ppset  map[uint32]*PPSInfo
+		sunits map[uint32][]byte // Encapsulated SPS units including NAL header.
+		punits map[uint32][]byte // Encapsulated PPS units including NAL header.
 	}
 )
 
@@ -89,8 +132,10 @@ type (
 // and picture parameter sets.
 func NewParameterSets() *ParameterSets {
 	return &ParameterSets{
-		spset: make(map[uint32]*SPSInfo),
-		ppset: make(map[uint32]*PPSInfo),
+		spset:  make(map[uint32]*SPSInfo),
+		ppset:  make(map[uint32]*PPSInfo),
+		sunits: make(map[uint32][]byte),
+		punits: make(map[uint32][]byte),
 	}
 }
 
@@ -118,6 +163,9 @@ func (s *ParameterSets) ParseSPS(buf []byte) (err error) {
 	if sps.SpsID, err = br.ReadUnsignedGolomb(); err != nil {
 		return
 	}
+	if sps.SpsID > 31 {
+		return errInvalidSPS
+	}
 	if sps.ProfileIdc == 100 || sps.ProfileIdc == 110 || sps.ProfileIdc == 122 || sps.ProfileIdc == 244 ||
 		sps.ProfileIdc == 44 || sps.ProfileIdc == 83 || sps.ProfileIdc == 86 || sps.ProfileIdc == 118 ||
 		sps.ProfileIdc == 128 || sps.ProfileIdc == 138 {
@@ -174,7 +222,7 @@ func (s *ParameterSets) ParseSPS(buf []byte) (err error) {
 		if sps.Log2MaxPicOrderCnt, err = br.ReadUnsignedGolomb(); err != nil {
 			return
 		}
-	} else {
+	} else if sps.PicOrderCntType == 1 {
 		if sps.DeltaPicOrderAlways0, err = br.ReadFlag(); err != nil {
 			return
 		}
@@ -187,8 +235,12 @@ func (s *ParameterSets) ParseSPS(buf []byte) (err error) {
 		if sps.NumRefFramesInPicOrderCntCycle, err = br.ReadUnsignedGolomb(); err != nil {
 			return
 		}
-		for i := uint32(0); i < sps.NumRefFramesInPicOrderCntCycle; i++ {
-			if _, err = br.ReadSignedGolomb(); err != nil {
+		if sps.NumRefFramesInPicOrderCntCycle > 255 {
+			return errInvalidSPS
+		}
+		sps.OffsetForRefFrame = make([]int32, sps.NumRefFramesInPicOrderCntCycle)
+		for i := range sps.OffsetForRefFrame {
+			if sps.OffsetForRefFrame[i], err = br.ReadSignedGolomb(); err != nil {
 				return
 			}
 		}
@@ -205,13 +257,10 @@ func (s *ParameterSets) ParseSPS(buf []byte) (err error) {
 	if sps.PicHeightInMapUnitsMinus1, err = br.ReadUnsignedGolomb(); err != nil {
 		return
 	}
-	if sps.FrameMbsOnly, err = br.ReadUnsignedGolomb(); err != nil {
+	if sps.FrameMbsOnly, err = br.ReadBits(1); err != nil {
 		return
 	}
-	if (sps.ConstraintSet&0x2) != 0 && (sps.ProfileIdc == 77 || sps.ProfileIdc == 88 || sps.ProfileIdc == 100) {
-		sps.FrameMbsOnly = 1
-	}
-	if sps.FrameMbsOnly != 0 {
+	if sps.FrameMbsOnly == 0 {
 		if sps.MbAdaptiveFrameField, err = br.ReadFlag(); err != nil {
 			return
 		}
@@ -239,28 +288,18 @@ func (s *ParameterSets) ParseSPS(buf []byte) (err error) {
 	if sps.VuiParametersPresent, err = br.ReadFlag(); err != nil {
 		return
 	}
-	// Macroblock size divisors for chroma as defined in the ITU standard.
-	subWidthC := uint32(1)
-	subHeightC := uint32(1)
-	if !sps.SeparateColorPlane {
-		// Chroma format 0 - monochrome, 1 - 4:2:0, 2 - 4:2:2, 3 - 4:4:4
-		if sps.ChromaFormatIdc == 1 || sps.ChromaFormatIdc == 2 {
-			subWidthC = 2
-		}
-		if sps.ChromaFormatIdc == 1 {
-			subHeightC = 2
+	if sps.VuiParametersPresent {
+		// Truncated or unusual VUI is common with cameras and does not affect decoding of pictures.
+		// Parameter set is kept without it.
+		base := *sps
+		if sps.parseVUI(br) != nil {
+			*sps = base
+			sps.VuiParametersPresent = false
 		}
 	}
-
-	// Macroblock width is 16 for luma.
-	sps.Width = (sps.PicWidthInMbsMinus1 + 1) * 16 // / subWidthC
-	// FrameMbsOnly designates either full frame or field (half frame).  Hence multiplier.
-	sps.Height = (2 - sps.FrameMbsOnly) * (sps.PicHeightInMapUnitsMinus1 + 1) * 16 / subHeightC
-	// Adjust for crop if present.  This accounts for monochrome vs. various color chroma formats.
-	cropUnitX := subWidthC
-	cropUnitY := (2 - sps.FrameMbsOnly) * subHeightC
-	sps.Width = sps.Width - (sps.FrameCropLeftOffset+sps.FrameCropRightOffset)*cropUnitX
-	sps.Height = sps.Height - (sps.FrameCropTopOffset+sps.FrameCropBottomOffset)*cropUnitY
+	left, right, top, bottom := sps.Crop()
+	sps.Width = sps.CodedWidth() - left - right
+	sps.Height = sps.CodedHeight() - top - bottom
 	s.spset[sps.SpsID] = sps
 	return
 }
@@ -279,6 +318,9 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 	if pps.SpsID, err = br.ReadUnsignedGolomb(); err != nil {
 		return
 	}
+	if pps.PpsID > 255 || pps.SpsID > 31 {
+		return errInvalidPPS
+	}
 	if sps, ok := s.spset[pps.SpsID]; ok {
 		chromaFormatIdc = sps.ChromaFormatIdc
 	}
@@ -291,6 +333,9 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 	if pps.NumSliceGroupsMinus1, err = br.ReadUnsignedGolomb(); err != nil {
 		return
 	}
+	if pps.NumSliceGroupsMinus1 > 7 {
+		return errInvalidPPS
+	}
 	if pps.NumSliceGroupsMinus1 > 0 {
 		if pps.SliceGroupMapType, err = br.ReadUnsignedGolomb(); err != nil {
 			return
@@ -302,7 +347,6 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 					return
 				}
 			}
-			break
 		case 2:
 			for i := uint32(0); i < pps.NumSliceGroupsMinus1; i++ {
 				if pps.TopLeft[i], err = br.ReadUnsignedGolomb(); err != nil {
@@ -312,23 +356,23 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 					return
 				}
 			}
-			break
-		case 3:
-		case 4:
-		case 5:
+		case 3, 4, 5:
 			if pps.SliceGroupChangeDirection, err = br.ReadFlag(); err != nil {
 				return
 			}
 			if pps.SliceGroupChangeRateMinus1, err = br.ReadUnsignedGolomb(); err != nil {
 				return
 			}
-			break
 		case 6:
 			if pps.PicSizeInMapUnitsMinus1, err = br.ReadUnsignedGolomb(); err != nil {
 				return
 			}
-			pps.SliceGroupID = make([]byte, pps.PicSizeInMapUnitsMinus1+1)
 			bits := uint(math.Ceil(math.Log2(float64(pps.NumSliceGroupsMinus1 + 1))))
+			// Do not allocate more identifiers than there are bits left to read.
+			if (uint64(pps.PicSizeInMapUnitsMinus1)+1)*uint64(bits) > uint64(br.Available()) {
+				return errInvalidPPS
+			}
+			pps.SliceGroupID = make([]byte, pps.PicSizeInMapUnitsMinus1+1)
 			for i := uint32(0); i <= pps.PicSizeInMapUnitsMinus1; i++ {
 				if pps.SliceGroupID[i], err = br.ReadByteBits(bits); err != nil {
 					return
@@ -366,7 +410,7 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 	if pps.RedundantPicCntPresent, err = br.ReadFlag(); err != nil {
 		return
 	}
-	if br.Available() > 0 {
+	if br.MoreData() {
 		if pps.Transform8x8Mode, err = br.ReadFlag(); err != nil {
 			return
 		}
@@ -408,16 +452,71 @@ func (s *ParameterSets) ParsePPS(buf []byte) (err error) {
 }
 
 // ParseSprop analyzes value from SDP sprop parameter sets where first byte is a NAL header.
-// To simplify code we do not fully queue and process NAL.
 func (s *ParameterSets) ParseSprop(buf []byte) (err error) {
-	if buf == nil || len(buf) == 0 {
+	if len(buf) == 0 {
+		return
+	}
+	_, err = s.Update(&NALUnit{Header: buf[0], Data: EBSPToRaw(buf[1:])})
+	return
+}
+
+// Update parses parameter set carried by NAL unit, e.g. in-band in the stream, and reports whether it is
+// new or differs from the one with the same identifier.  Other NAL units are ignored.
+func (s *ParameterSets) Update(u *NALUnit) (changed bool, err error) {
+	typ := u.Type()
+	if typ != typeSPS && typ != typePPS {
 		return
 	}
-	typ := buf[0] & 0x1F
+	id, err := parameterSetID(u)
+	if err != nil {
+		return
+	}
+	units := s.punits
 	if typ == typeSPS {
-		return s.ParseSPS(buf[1:])
-	} else if typ == typePPS {
-		return s.ParsePPS(buf[1:])
+		units = s.sunits
+	}
+	b := u.Bytes()
+	if bytes.Equal(units[id], b) {
+		return
+	}
+	if typ == typeSPS {
+		err = s.ParseSPS(u.Data)
+	} else {
+		err = s.ParsePPS(u.Data)
+	}
+	if err != nil {
+		return
+	}
+	units[id] = b
+	return true, nil
+}
+
+// parameterSetID reads identifier of SPS or PPS carried by NAL unit.
+func parameterSetID(u *NALUnit) (uint32, error) {
+	br := NewBitReader(u.Data)
+	if u.Type() == typeSPS {
+		// Identifier follows profile, constraints, and level.
+		if err := br.SkipBits(24); err != nil {
+			return 0, err
+		}
+	}
+	return br.ReadUnsignedGolomb()
+}
+
+// Units returns parsed SPS and PPS units, including NAL header, ordered by identifier, e.g. to build
+// decoder configuration record.
+func (s *ParameterSets) Units() (sps, pps [][]byte) {
+	return sortedUnits(s.sunits), sortedUnits(s.punits)
+}
+
+func sortedUnits(units map[uint32][]byte) (list [][]byte) {
+	ids := make([]uint32, 0, len(units))
+	for id := range units {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		list = append(list, units[id])
 	}
 	return
 }
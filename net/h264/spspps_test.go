@@ -100,3 +100,23 @@ func TestParsePPSAmcrestSprops(t *testing.T) {
 		t.Errorf("PPS Id is %d, expected 0", pps.PpsID)
 	}
 }
+
+func TestParsePPSMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"9 slice groups", []byte{0xc1, 0x3f, 0xfe, 0x38, 0x80}},
+		{"pps_id 256", []byte{0x00, 0x80, 0xce, 0x38, 0x80}},
+		{"sps_id 32", []byte{0x82, 0x13, 0x8e, 0x20}},
+	} {
+		params := NewParameterSets()
+		if err := params.ParsePPS(tc.data); err != errInvalidPPS {
+			t.Errorf("%s: expected %v, got %v", tc.name, errInvalidPPS, err)
+		}
+		// In-band unit goes the same way.
+		if changed, err := params.Update(&NALUnit{Header: 0x68, Data: tc.data}); changed || err == nil {
+			t.Errorf("%s: malformed PPS is accepted in-band", tc.name)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package h264
+
+// Video usability information (Annex E) carried in sequence parameter set.
+
+import (
+	"errors"
+)
+
+var (
+	errInvalidHRD = errors.New("Invalid number of HRD schedules")
+)
+
+type (
+	// HRDInfo holds hypothetical reference decoder parameters for NAL or VCL conformance.
+	HRDInfo struct {
+		CpbCntMinus1                       uint32
+		BitRateScale                       byte
+		CpbSizeScale                       byte
+		BitRateValueMinus1                 []uint32
+		CpbSizeValueMinus1                 []uint32
+		CbrFlag                            []bool
+		InitialCpbRemovalDelayLengthMinus1 byte
+		CpbRemovalDelayLengthMinus1        byte
+		DpbOutputDelayLengthMinus1         byte
+		TimeOffsetLength                   byte
+	}
+)
+
+// Extended sample aspect ratio indicator.
+const aspectRatioExtendedSAR = 255
+
+// Sample aspect ratios for indicators 1 to 16, Table E-1.
+var sampleAspectRatios = [][2]uint32{
+	{1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11}, {32, 11},
+	{80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// parseVUI parses video usability information that follows the flag in sequence parameter set.
+func (sps *SPSInfo) parseVUI(br *BitReader) (err error) {
+	if sps.AspectRatioInfoPresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.AspectRatioInfoPresent {
+		if sps.AspectRatioIdc, err = br.ReadByteBits(8); err != nil {
+			return
+		}
+		if sps.AspectRatioIdc == aspectRatioExtendedSAR {
+			if sps.SarWidth, err = br.ReadBits(16); err != nil {
+				return
+			}
+			if sps.SarHeight, err = br.ReadBits(16); err != nil {
+				return
+			}
+		}
+	}
+	if sps.OverscanInfoPresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.OverscanInfoPresent {
+		if sps.OverscanAppropriate, err = br.ReadFlag(); err != nil {
+			return
+		}
+	}
+	if sps.VideoSignalTypePresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.VideoSignalTypePresent {
+		if sps.VideoFormat, err = br.ReadByteBits(3); err != nil {
+			return
+		}
+		if sps.VideoFullRange, err = br.ReadFlag(); err != nil {
+			return
+		}
+		if sps.ColourDescriptionPresent, err = br.ReadFlag(); err != nil {
+			return
+		}
+		if sps.ColourDescriptionPresent {
+			if sps.ColourPrimaries, err = br.ReadByteBits(8); err != nil {
+				return
+			}
+			if sps.TransferCharacteristics, err = br.ReadByteBits(8); err != nil {
+				return
+			}
+			if sps.MatrixCoefficients, err = br.ReadByteBits(8); err != nil {
+				return
+			}
+		}
+	}
+	if sps.ChromaLocInfoPresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.ChromaLocInfoPresent {
+		if sps.ChromaSampleLocTypeTopField, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.ChromaSampleLocTypeBottomField, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+	}
+	if sps.TimingInfoPresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.TimingInfoPresent {
+		if sps.NumUnitsInTick, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if sps.TimeScale, err = br.ReadBits(32); err != nil {
+			return
+		}
+		if sps.FixedFrameRate, err = br.ReadFlag(); err != nil {
+			return
+		}
+	}
+	var present bool
+	if present, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if present {
+		if sps.NalHrd, err = parseHRD(br); err != nil {
+			return
+		}
+	}
+	if present, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if present {
+		if sps.VclHrd, err = parseHRD(br); err != nil {
+			return
+		}
+	}
+	if sps.NalHrd != nil || sps.VclHrd != nil {
+		if sps.LowDelayHrd, err = br.ReadFlag(); err != nil {
+			return
+		}
+	}
+	if sps.PicStructPresent, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.BitstreamRestriction, err = br.ReadFlag(); err != nil {
+		return
+	}
+	if sps.BitstreamRestriction {
+		if sps.MotionVectorsOverPicBoundaries, err = br.ReadFlag(); err != nil {
+			return
+		}
+		if sps.MaxBytesPerPicDenom, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.MaxBitsPerMbDenom, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.Log2MaxMvLengthHorizontal, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.Log2MaxMvLengthVertical, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.MaxNumReorderFrames, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if sps.MaxDecFrameBuffering, err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// parseHRD parses hypothetical reference decoder parameters.
+func parseHRD(br *BitReader) (hrd *HRDInfo, err error) {
+	hrd = &HRDInfo{}
+	if hrd.CpbCntMinus1, err = br.ReadUnsignedGolomb(); err != nil {
+		return
+	}
+	if hrd.CpbCntMinus1 > 31 {
+		return nil, errInvalidHRD
+	}
+	if hrd.BitRateScale, err = br.ReadByteBits(4); err != nil {
+		return
+	}
+	if hrd.CpbSizeScale, err = br.ReadByteBits(4); err != nil {
+		return
+	}
+	n := hrd.CpbCntMinus1 + 1
+	hrd.BitRateValueMinus1 = make([]uint32, n)
+	hrd.CpbSizeValueMinus1 = make([]uint32, n)
+	hrd.CbrFlag = make([]bool, n)
+	for i := range hrd.CbrFlag {
+		if hrd.BitRateValueMinus1[i], err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if hrd.CpbSizeValueMinus1[i], err = br.ReadUnsignedGolomb(); err != nil {
+			return
+		}
+		if hrd.CbrFlag[i], err = br.ReadFlag(); err != nil {
+			return
+		}
+	}
+	if hrd.InitialCpbRemovalDelayLengthMinus1, err = br.ReadByteBits(5); err != nil {
+		return
+	}
+	if hrd.CpbRemovalDelayLengthMinus1, err = br.ReadByteBits(5); err != nil {
+		return
+	}
+	if hrd.DpbOutputDelayLengthMinus1, err = br.ReadByteBits(5); err != nil {
+		return
+	}
+	if hrd.TimeOffsetLength, err = br.ReadByteBits(5); err != nil {
+		return
+	}
+	return
+}
+
+// BitRate returns bit rate in bits per second of a given scheduling alternative.
+func (hrd *HRDInfo) BitRate(i int) uint64 {
+	return uint64(hrd.BitRateValueMinus1[i]+1) << (6 + hrd.BitRateScale)
+}
+
+// FrameRate returns frames per second from timing information.  Each frame takes two ticks as in fields.
+func (sps *SPSInfo) FrameRate() (fps float64, ok bool) {
+	if !sps.TimingInfoPresent || sps.NumUnitsInTick == 0 || sps.TimeScale == 0 {
+		return 0, false
+	}
+	return float64(sps.TimeScale) / float64(2*sps.NumUnitsInTick), true
+}
+
+// SampleAspectRatio returns width and height of a sample, i.e. pixel.  Unspecified ratio is reported as square.
+func (sps *SPSInfo) SampleAspectRatio() (w, h uint32) {
+	switch {
+	case !sps.AspectRatioInfoPresent:
+	case sps.AspectRatioIdc == aspectRatioExtendedSAR:
+		if sps.SarWidth != 0 && sps.SarHeight != 0 {
+			return sps.SarWidth, sps.SarHeight
+		}
+	case sps.AspectRatioIdc >= 1 && int(sps.AspectRatioIdc) <= len(sampleAspectRatios):
+		r := sampleAspectRatios[sps.AspectRatioIdc-1]
+		return r[0], r[1]
+	}
+	return 1, 1
+}
+
+// DisplayAspectRatio returns aspect ratio of the picture after cropping and scaling by sample aspect ratio,
+// e.g. 16:9.
+func (sps *SPSInfo) DisplayAspectRatio() (w, h uint32) {
+	sw, sh := sps.SampleAspectRatio()
+	w, h = sps.Width*sw, sps.Height*sh
+	if w == 0 || h == 0 {
+		return 0, 0
+	}
+	a, b := w, h
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return w / a, h / a
+}
+
+// ReorderDepth returns the largest number of frames that precede any frame in decoding order and follow it
+// in output order.  Without bitstream restrictions it is inferred from profile and level.
+func (sps *SPSInfo) ReorderDepth() uint32 {
+	if sps.BitstreamRestriction {
+		return sps.MaxNumReorderFrames
+	}
+	// Baseline and intra profiles have no B-frames.
+	if sps.ProfileIdc == 66 || sps.ConstraintSet&0x10 != 0 && (sps.ProfileIdc == 44 || sps.ProfileIdc == 86 ||
+		sps.ProfileIdc == 100 || sps.ProfileIdc == 110 || sps.ProfileIdc == 122 || sps.ProfileIdc == 244) {
+		return 0
+	}
+	return sps.MaxDpbFrames()
+}
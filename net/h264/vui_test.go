@@ -0,0 +1,75 @@
+package h264
+
+import (
+	"testing"
+)
+
+func TestParseVUI(t *testing.T) {
+	params := amcrestParameterSets(t)
+	sps, _ := params.GetSPS(0)
+	if fps, ok := sps.FrameRate(); !ok || fps != 15 {
+		t.Fatalf("expected 15 fps, got %v", fps)
+	}
+	if w, h := sps.DisplayAspectRatio(); w != 16 || h != 9 {
+		t.Fatalf("expected 16:9, got %d:%d", w, h)
+	}
+	if !sps.ColourDescriptionPresent || sps.ColourPrimaries != 1 || sps.TransferCharacteristics != 1 || sps.MatrixCoefficients != 1 || !sps.VideoFullRange {
+		t.Fatalf("unexpected colour description %+v", sps)
+	}
+	if sps.NalHrd == nil || sps.VclHrd == nil || sps.NalHrd.BitRate(0) != 10005<<10 {
+		t.Fatalf("unexpected HRD %+v", sps.NalHrd)
+	}
+	if d := sps.ReorderDepth(); d != 5 {
+		t.Fatalf("expected reorder depth 5, got %d", d)
+	}
+}
+
+func TestParseVUIExtended(t *testing.T) {
+	w := &bitWriter{}
+	// Anamorphic SAR.
+	w.bits(1, 1).bits(aspectRatioExtendedSAR, 8).bits(4, 16).bits(3, 16)
+	// No overscan, signal type, chroma location.
+	w.bits(0, 1).bits(0, 1).bits(0, 1)
+	// 29.97 fps.
+	w.bits(1, 1).bits(1001, 32).bits(60000, 32).bits(1, 1)
+	// NAL HRD with two schedules.
+	w.bits(1, 1).ue(1).bits(2, 4).bits(5, 4)
+	w.ue(999).ue(1999).bits(0, 1).ue(4999).ue(9999).bits(1, 1)
+	w.bits(23, 5).bits(23, 5).bits(23, 5).bits(24, 5)
+	// No VCL HRD, low delay, pic struct.
+	w.bits(0, 1).bits(0, 1).bits(0, 1)
+	// Bitstream restriction.
+	w.bits(1, 1).bits(1, 1).ue(2).ue(1).ue(16).ue(16).ue(2).ue(4)
+	sps := &SPSInfo{Width: 1440, Height: 1080}
+	if err := sps.parseVUI(NewBitReader(w.rbsp())); err != nil {
+		t.Fatal(err)
+	}
+	if fps, ok := sps.FrameRate(); !ok || fps < 29.97 || fps > 29.971 {
+		t.Fatalf("expected 29.97 fps, got %v", fps)
+	}
+	if w, h := sps.DisplayAspectRatio(); w != 16 || h != 9 {
+		t.Fatalf("expected 16:9, got %d:%d", w, h)
+	}
+	hrd := sps.NalHrd
+	if hrd == nil || sps.VclHrd != nil || len(hrd.CbrFlag) != 2 || !hrd.CbrFlag[1] || hrd.BitRate(1) != 5000<<8 || hrd.TimeOffsetLength != 24 {
+		t.Fatalf("unexpected HRD %+v", hrd)
+	}
+	if d := sps.ReorderDepth(); d != 2 || sps.MaxDecFrameBuffering != 4 {
+		t.Fatalf("expected reorder depth 2, got %d", d)
+	}
+}
+
+func TestParseVUITruncated(t *testing.T) {
+	params := NewParameterSets()
+	sps := []byte{
+		0x64, 0x00, 0x1f, 0xac, 0x34, 0xc8, 0x05, 0x00, 0x5b, 0xff, 0x01, 0x6e, 0x02, 0x02, 0x02, 0x80,
+		0x00, 0x01, 0xf4, 0x00, 0x00, 0x3a, 0x98, 0x74, 0x30, 0x00, 0x4e, 0x2a, 0x00, 0x01,
+	}
+	if err := params.ParseSPS(sps); err != nil {
+		t.Fatal(err)
+	}
+	info, ok := params.GetSPS(0)
+	if !ok || info.Width != 1280 || info.Height != 720 || info.VuiParametersPresent || info.NalHrd != nil {
+		t.Fatalf("unexpected SPS %+v", info)
+	}
+}
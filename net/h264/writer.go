@@ -0,0 +1,53 @@
+package h264
+
+import (
+	"io"
+)
+
+// Start code that precedes every NAL unit in Annex B byte stream.
+var startCode = []byte{0, 0, 0, 1}
+
+// AnnexBWriter writes access units as H.264 elementary stream in Annex B format, e.g. for ffprobe.
+// Parameter sets are inserted before every IDR picture that does not carry them in-band so that
+// playback can start from any key frame.
+type AnnexBWriter struct {
+	w   io.Writer
+	sps [][]byte
+	pps [][]byte
+}
+
+// NewAnnexBWriter creates writer of Annex B byte stream.
+func NewAnnexBWriter(w io.Writer) *AnnexBWriter {
+	return &AnnexBWriter{w: w}
+}
+
+// SetParameterSets sets SPS and PPS, including NAL header, e.g. from sprop-parameter-sets in SDP.
+func (b *AnnexBWriter) SetParameterSets(sps, pps [][]byte) {
+	b.sps = sps
+	b.pps = pps
+}
+
+// WriteAccessUnit writes NAL units of access unit each preceded by start code.  In-band parameter sets
+// replace ones set earlier.
+func (b *AnnexBWriter) WriteAccessUnit(au *AccessUnit) error {
+	sps, pps := au.ParameterSets()
+	if len(sps) > 0 {
+		b.sps = sps
+	}
+	if len(pps) > 0 {
+		b.pps = pps
+	}
+	var buf []byte
+	if au.IsIDR() && (len(sps) == 0 || len(pps) == 0) {
+		for _, nal := range append(b.sps, b.pps...) {
+			buf = append(buf, startCode...)
+			buf = append(buf, nal...)
+		}
+	}
+	for i := range au.Units {
+		buf = append(buf, startCode...)
+		buf = append(buf, au.Units[i].Bytes()...)
+	}
+	_, err := b.w.Write(buf)
+	return err
+}
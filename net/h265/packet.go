@@ -0,0 +1,267 @@
+package h265
+
+// Depacketization of H.265 video from RTP payload format defined in RFC 7798: single NAL unit packets,
+// aggregation packets, and fragmentation units.  NAL units are kept encapsulated as they are not parsed.
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// Types of NAL units and payload structures.
+const (
+	typeIRAPFirst = 16 // BLA_W_LP
+	typeIRAPLast  = 23 // RSV_IRAP_VCL23
+	typeAP        = 48 // Aggregation packet
+	typeFU        = 49 // Fragmentation unit
+	typePACI      = 50 // Payload content information
+)
+
+// DefaultMaxNALSize is the default limit of reassembled NAL unit size.
+const DefaultMaxNALSize = 4 << 20
+
+var (
+	errPacketTooShort = errors.New("Packet is too short")
+	errUnsupportedPkt = errors.New("Unsupported H.265 payload structure")
+)
+
+var be = binary.BigEndian
+
+type (
+	// AccessUnit is a set of NAL units sharing the same timestamp that make up a single picture.  Units
+	// include 2-byte NAL header and emulation prevention bytes.
+	AccessUnit struct {
+		TS    uint32
+		Units [][]byte
+	}
+
+	// Depacketizer collects NAL units from RTP payloads into access units.  Access unit ends with RTP packet
+	// that has marker bit set or when packet with different timestamp arrives.  Fragmented units with lost
+	// fragments are discarded.  Units carrying decoding order numbers are passed in transmission order.
+	Depacketizer struct {
+		VPS        [][]byte // Parameter sets from SDP including NAL header.
+		SPS        [][]byte
+		PPS        [][]byte
+		DONL       bool // Payloads carry decoding order numbers, sprop-max-don-diff is greater than 0.
+		MaxNALSize int  // Most bytes of reassembled NAL unit.  DefaultMaxNALSize if zero.
+		Incomplete int  // Number of fragmented NAL units discarded for lost or missing fragments.
+		Oversized  int  // Number of fragmented NAL units discarded for exceeding MaxNALSize.
+		au         AccessUnit
+		frag       []byte // NAL unit being reassembled from fragments.
+		assembling bool
+		skipping   bool   // Fragments of a discarded unit are skipped until its end or next start.
+		seq        uint16 // Expected sequence number of the next RTP packet.
+		sequenced  bool
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("H265", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		d, err := NewDepacketizer(m)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	})
+}
+
+// NewDepacketizer creates depacketizer for H.265 media with parameter sets and decoding order numbers
+// signalled in SDP.
+func NewDepacketizer(m *sdp.Media) (*Depacketizer, error) {
+	d := &Depacketizer{}
+	if diff, err := strconv.Atoi(m.Fmtp["sprop-max-don-diff"]); err == nil && diff > 0 {
+		d.DONL = true
+	}
+	for _, p := range []struct {
+		name string
+		sets *[][]byte
+	}{{"sprop-vps", &d.VPS}, {"sprop-sps", &d.SPS}, {"sprop-pps", &d.PPS}} {
+		sets, err := parseSprop(m.Fmtp[p.name])
+		if err != nil {
+			return nil, err
+		}
+		*p.sets = sets
+	}
+	return d, nil
+}
+
+// parseSprop decodes comma separated list of base64 encoded parameter sets.
+func parseSprop(val string) (sets [][]byte, err error) {
+	if val == "" {
+		return
+	}
+	for _, field := range strings.Split(val, ",") {
+		b, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 2 {
+			sets = append(sets, b)
+		}
+	}
+	return
+}
+
+// Type returns type of NAL unit with its header.
+func Type(nal []byte) byte {
+	return nal[0] >> 1 & 0x3F
+}
+
+// IsIRAP reports whether access unit contains intra random access point picture, e.g. IDR or CRA.
+func (au *AccessUnit) IsIRAP() bool {
+	for _, u := range au.Units {
+		if typ := Type(u); typ >= typeIRAPFirst && typ <= typeIRAPLast {
+			return true
+		}
+	}
+	return false
+}
+
+// HVC formats access unit as a sequence of units each prefixed with 4-byte length as stored in MP4.
+func (au *AccessUnit) HVC() []byte {
+	var buf []byte
+	for _, nal := range au.Units {
+		buf = append(buf, byte(len(nal)>>24), byte(len(nal)>>16), byte(len(nal)>>8), byte(len(nal)))
+		buf = append(buf, nal...)
+	}
+	return buf
+}
+
+// Push takes RTP packet and returns frames for access units completed by it.  Frames carry NAL units
+// prefixed by 4-byte length and *AccessUnit as details.
+func (d *Depacketizer) Push(p *rtp.Packet) (frames []rtp.Frame, err error) {
+	if len(d.au.Units) > 0 && d.au.TS != p.TS {
+		frames = append(frames, d.complete())
+	}
+	d.sequence(p.SN)
+	d.au.TS = p.TS
+	err = d.parse(p.PL)
+	if p.M() && len(d.au.Units) > 0 {
+		frames = append(frames, d.complete())
+	}
+	return
+}
+
+// complete returns frame for access unit being assembled and starts the next one.
+func (d *Depacketizer) complete() rtp.Frame {
+	au := d.au
+	d.au = AccessUnit{}
+	return rtp.Frame{TS: au.TS, Data: au.HVC(), Sync: au.IsIRAP(), Info: &au}
+}
+
+// sequence checks continuity of RTP sequence numbers and discards unit being reassembled if any
+// packets were lost or reordered.
+func (d *Depacketizer) sequence(sn uint16) {
+	if d.sequenced && sn != d.seq && d.assembling {
+		d.Incomplete++
+		d.assembling, d.skipping = false, true
+		d.frag = nil
+	}
+	d.seq, d.sequenced = sn+1, true
+}
+
+// parse adds NAL units from RTP payload to access unit being assembled.
+func (d *Depacketizer) parse(buf []byte) error {
+	if len(buf) < 3 {
+		return errPacketTooShort
+	}
+	switch Type(buf) {
+	case typeAP:
+		return d.parseAP(buf[2:])
+	case typeFU:
+		return d.parseFU(buf)
+	case typePACI:
+		return errUnsupportedPkt
+	}
+	if d.DONL {
+		if len(buf) < 5 {
+			return errPacketTooShort
+		}
+		// Decoding order number follows NAL header.
+		buf = append([]byte{buf[0], buf[1]}, buf[4:]...)
+	}
+	d.au.Units = append(d.au.Units, buf)
+	return nil
+}
+
+// parseAP splits aggregation packet into units.  Decoding order number precedes the first unit and its
+// difference precedes each subsequent one.
+func (d *Depacketizer) parseAP(buf []byte) error {
+	for i := 0; len(buf) > 0; i++ {
+		if d.DONL {
+			skip := 1
+			if i == 0 {
+				skip = 2
+			}
+			if len(buf) < skip {
+				return errPacketTooShort
+			}
+			buf = buf[skip:]
+		}
+		if len(buf) < 2 {
+			return errPacketTooShort
+		}
+		size := int(be.Uint16(buf))
+		if size < 2 || len(buf) < size+2 {
+			return errPacketTooShort
+		}
+		d.au.Units = append(d.au.Units, buf[2:size+2])
+		buf = buf[size+2:]
+	}
+	return nil
+}
+
+// parseFU appends fragment to the unit being reassembled, starting a new one upon receiving first fragment.
+// Unit missing its first fragment, or too large, is discarded together with the rest of its fragments.
+func (d *Depacketizer) parseFU(buf []byte) error {
+	header := buf[2]
+	start, end := header&0x80 != 0, header&0x40 != 0
+	data := buf[3:]
+	if start {
+		if d.DONL {
+			if len(data) < 2 {
+				return errPacketTooShort
+			}
+			data = data[2:]
+		}
+		if d.assembling {
+			d.Incomplete++ // Previous unit never got its last fragment.
+		}
+		// NAL header restores type from FU header and the rest from payload header.
+		d.frag = append(make([]byte, 0, len(data)+2), buf[0]&0x81|(header&0x3F)<<1, buf[1])
+		d.assembling, d.skipping = true, false
+	} else if !d.assembling {
+		if !d.skipping {
+			d.Incomplete++
+		}
+		d.skipping = !end
+		return nil
+	}
+	if len(d.frag)+len(data) > d.maxNALSize() {
+		d.Oversized++
+		d.assembling, d.skipping = false, !end
+		d.frag = nil
+		return nil
+	}
+	d.frag = append(d.frag, data...)
+	if end {
+		d.au.Units = append(d.au.Units, d.frag)
+		d.assembling = false
+		d.frag = nil
+	}
+	return nil
+}
+
+// maxNALSize returns limit of reassembled NAL unit size.
+func (d *Depacketizer) maxNALSize() int {
+	if d.MaxNALSize > 0 {
+		return d.MaxNALSize
+	}
+	return DefaultMaxNALSize
+}
@@ -0,0 +1,58 @@
+package h265
+
+import (
+	"testing"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// fu composes fragmentation unit of IDR_W_RADL slice with start and end flags.
+func fu(start, end bool, data ...byte) []byte {
+	header := byte(19)
+	if start {
+		header |= 0x80
+	}
+	if end {
+		header |= 0x40
+	}
+	return append([]byte{typeFU << 1, 1, header}, data...)
+}
+
+func TestDepacketizer(t *testing.T) {
+	d, err := NewDepacketizer(&sdp.Media{Fmtp: map[string]string{"sprop-vps": "QAEMAf//", "sprop-sps": "QgEB", "sprop-pps": "RAHA8vA8kA=="}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.VPS) != 1 || len(d.SPS) != 1 || len(d.PPS) != 1 || d.PPS[0][0] != 0x44 || d.DONL {
+		t.Fatalf("Wrong parameter sets %+v", d)
+	}
+	packets := []*rtp.Packet{
+		// Aggregation packet with VPS and SPS.
+		rtp.NewPacket(96, false, 1, 3000, 1, []byte{typeAP << 1, 1, 0, 3, 0x40, 1, 0xaa, 0, 3, 0x42, 1, 0xbb}),
+		rtp.NewPacket(96, false, 2, 3000, 1, fu(true, false, 1, 2)),
+		rtp.NewPacket(96, true, 3, 3000, 1, fu(false, true, 3)),
+		// Lost middle fragment discards the unit, single NAL unit of trailing picture remains.
+		rtp.NewPacket(96, false, 4, 6000, 1, fu(true, false, 4)),
+		rtp.NewPacket(96, false, 6, 6000, 1, fu(false, true, 6)),
+		rtp.NewPacket(96, true, 7, 6000, 1, []byte{0x02, 1, 0xcc}),
+	}
+	var frames []rtp.Frame
+	for _, p := range packets {
+		f, err := d.Push(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, f...)
+	}
+	if len(frames) != 2 || !frames[0].Sync || frames[1].Sync || frames[1].TS != 6000 || d.Incomplete != 1 {
+		t.Fatalf("Unexpected frames %+v", frames)
+	}
+	au := frames[0].Info.(*AccessUnit)
+	if len(au.Units) != 3 || Type(au.Units[1]) != 33 || string(au.Units[2]) != "\x26\x01\x01\x02\x03" {
+		t.Errorf("Unexpected access unit % x", au.Units)
+	}
+	if string(frames[1].Data) != "\x00\x00\x00\x03\x02\x01\xcc" {
+		t.Errorf("Unexpected frame % x", frames[1].Data)
+	}
+}
@@ -18,9 +18,10 @@ const (
 type (
 	// Segment describes a chunk of streaming media.
 	Segment struct {
-		Duration float64
-		Position int64
-		Length   int64
+		Duration      float64
+		Position      int64
+		Length        int64
+		Discontinuity bool // Format or timestamps of the segment do not continue the previous one.
 	}
 
 	// Playlist encapsulates HLS playlist.
@@ -35,6 +36,7 @@ type (
 		SegSize  float64   // Desired segment size.
 		First    int       // First segment in the ring.
 		Last     int       // Last segment in the ring.
+		pending  bool      // Next segment starts discontinuity.
 	}
 )
 
@@ -68,6 +70,9 @@ func (pl Playlist) String() string {
 		nseg := len(pl.Segments)
 		for i := pl.First; ; i = (i + 1) % nseg {
 			seg := pl.Segments[i]
+			if seg.Discontinuity {
+				buf.WriteString("#EXT-X-DISCONTINUITY\n")
+			}
 			buf.WriteString("#EXTINF:")
 			buf.WriteString(strconv.FormatFloat(seg.Duration, 'f', -1, 64))
 			buf.WriteByte('\n')
@@ -99,9 +104,16 @@ func (pl *Playlist) AddSegment(duration float64, length int64) {
 	pl.Segments[pl.Last].Duration = duration
 	pl.Segments[pl.Last].Position = pos
 	pl.Segments[pl.Last].Length = length
+	pl.Segments[pl.Last].Discontinuity = pl.pending
+	pl.pending = false
 	if pl.First == pl.Last {
 		pl.First++
 	} else if pl.First < 0 {
 		pl.First = pl.Last
 	}
 }
+
+// Discontinue marks the next segment as discontinuity, e.g. when stream format changes.
+func (pl *Playlist) Discontinue() {
+	pl.pending = true
+}
@@ -46,3 +46,31 @@ https://example.com/media/video.ts
 		t.Error(pl)
 	}
 }
+
+func TestPlaylistDiscontinuity(t *testing.T) {
+	list := `#EXTM3U
+#EXT-X-VERSION:4
+#EXTINF:3
+#EXT-X-BYTERANGE:1000@0
+https://example.com/media/video.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:2.5
+#EXT-X-BYTERANGE:800@1000
+https://example.com/media/video.ts
+#EXTINF:3
+#EXT-X-BYTERANGE:900@1800
+https://example.com/media/video.ts
+#EXT-X-ENDLIST
+`
+	pl, err := NewPlaylist("https://example.com/media", "/var/media/video.ts", 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl.AddSegment(3, 1000)
+	pl.Discontinue()
+	pl.AddSegment(2.5, 800)
+	pl.AddSegment(3, 900)
+	if pl.String() != list {
+		t.Error(pl)
+	}
+}
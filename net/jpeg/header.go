@@ -0,0 +1,155 @@
+package jpeg
+
+// Reconstruction of JFIF headers stripped by RTP/JPEG payload format, RFC 2435 appendix A and B.
+
+// JPEG markers.
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOF0 = 0xC0
+	markerDHT  = 0xC4
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+	markerAPP0 = 0xE0
+)
+
+// Natural order positions of coefficients in zigzag order.
+var zigzag = [64]byte{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Quantization tables of luminance and chrominance in natural order, Table K.1 and K.2 of ITU-T T.81.
+var (
+	lumaQuantizer = [64]int{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	}
+	chromaQuantizer = [64]int{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+// Huffman tables of baseline JPEG as code counts by length and symbols, Table K.3 to K.6 of ITU-T T.81.
+var (
+	lumaDCCodes   = []byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0}
+	dcSymbols     = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	chromaDCCodes = []byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0}
+	lumaACCodes   = []byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 0x7d}
+	lumaACSymbols = []byte{
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}
+	chromaACCodes   = []byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 0x77}
+	chromaACSymbols = []byte{
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	}
+)
+
+// MakeTables returns luminance and chrominance quantization tables in zigzag order for Q factor 1 to 99
+// scaled from standard ones the way IJG library does.
+func MakeTables(q int) (luma, chroma []byte) {
+	if q < 1 {
+		q = 1
+	} else if q > 99 {
+		q = 99
+	}
+	scale := 200 - q*2
+	if q < 50 {
+		scale = 5000 / q
+	}
+	luma, chroma = make([]byte, 64), make([]byte, 64)
+	for i, pos := range zigzag {
+		luma[i] = scaleQuantizer(lumaQuantizer[pos], scale)
+		chroma[i] = scaleQuantizer(chromaQuantizer[pos], scale)
+	}
+	return
+}
+
+func scaleQuantizer(val, scale int) byte {
+	val = (val*scale + 50) / 100
+	if val < 1 {
+		return 1
+	}
+	if val > 255 {
+		return 255
+	}
+	return byte(val)
+}
+
+// appendSegment appends marker segment with 2-byte length that covers itself and payload.
+func appendSegment(buf []byte, marker byte, payload ...[]byte) []byte {
+	size := 2
+	for _, b := range payload {
+		size += len(b)
+	}
+	buf = append(buf, 0xFF, marker, byte(size>>8), byte(size))
+	for _, b := range payload {
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+// appendHeaders appends JFIF headers that precede entropy coded scan of a frame.  Tables are quantization
+// tables in zigzag order of 64 bytes, or 128 bytes for 16-bit precision, used by luminance and chrominance.
+func appendHeaders(buf []byte, h *header, tables [][]byte) []byte {
+	buf = append(buf, 0xFF, markerSOI)
+	buf = appendSegment(buf, markerAPP0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+	for i, t := range tables {
+		pq := byte(0)
+		if len(t) > 64 {
+			pq = 1
+		}
+		buf = appendSegment(buf, markerDQT, []byte{pq<<4 | byte(i)}, t)
+	}
+	if h.restartInterval > 0 {
+		buf = appendSegment(buf, markerDRI, []byte{byte(h.restartInterval >> 8), byte(h.restartInterval)})
+	}
+	// Luminance is sampled twice horizontally, and also vertically for 4:2:0, relative to chrominance.
+	sampling := byte(0x21)
+	if h.typ&0x3F == 1 {
+		sampling = 0x22
+	}
+	chromaTable := byte(len(tables) - 1)
+	buf = appendSegment(buf, markerSOF0, []byte{8, byte(h.height >> 8), byte(h.height), byte(h.width >> 8), byte(h.width), 3,
+		1, sampling, 0, 2, 0x11, chromaTable, 3, 0x11, chromaTable})
+	buf = appendSegment(buf, markerDHT,
+		[]byte{0x00}, lumaDCCodes, dcSymbols, []byte{0x10}, lumaACCodes, lumaACSymbols,
+		[]byte{0x01}, chromaDCCodes, dcSymbols, []byte{0x11}, chromaACCodes, chromaACSymbols)
+	return appendSegment(buf, markerSOS, []byte{3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0})
+}
@@ -0,0 +1,189 @@
+package jpeg
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// MaxFrameSize is the limit of reassembled frame.
+const MaxFrameSize = 4 << 20
+
+var (
+	errPacketTooShort       = errors.New("RTP/JPEG packet is too short")
+	errUnsupportedType      = errors.New("Unsupported RTP/JPEG type")
+	errInvalidSize          = errors.New("Invalid RTP/JPEG frame size")
+	errMissingTables        = errors.New("Missing RTP/JPEG quantization tables")
+	errInvalidQuantHeader   = errors.New("Invalid RTP/JPEG quantization table header")
+	errFrameTooLarge        = errors.New("RTP/JPEG frame exceeds maximum size")
+	errUnexpectedFragment   = errors.New("Unexpected RTP/JPEG fragment offset")
+	errInconsistentFragment = errors.New("RTP/JPEG fragment does not match frame")
+)
+
+var be = binary.BigEndian
+
+type (
+	// header holds fields of main and restart marker headers of RTP/JPEG payload that describe the frame.
+	//  0                   1                   2                   3
+	//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// | Type-specific |              Fragment Offset                  |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |      Type     |       Q       |     Width     |     Height    |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	header struct {
+		typ             byte
+		q               byte
+		width           int // In pixels, 8 times the value in header.
+		height          int
+		restartInterval uint16
+	}
+
+	// Depacketizer reconstructs JFIF frames from RTP/JPEG payload format defined in RFC 2435.  Headers
+	// stripped by the sender are rebuilt with quantization tables computed from Q factor or carried in-band.
+	// Frame with lost fragments is dropped.
+	Depacketizer struct {
+		Snapshot *Snapshot // Receives every complete frame if set.
+		hdr      header
+		tables   [][]byte
+		scan     []byte // Entropy coded data of the frame being reassembled.
+		ts       uint32
+		next     int // Fragment offset expected in the next packet.
+		started  bool
+		cache    map[byte][][]byte // In-band tables of Q factors 128-254 that may be sent once.
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("JPEG", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		return &Depacketizer{}, nil
+	})
+}
+
+// Push takes RTP packet and returns frame completed by it.
+func (d *Depacketizer) Push(p *rtp.Packet) ([]rtp.Frame, error) {
+	frame, err := d.Unpack(p.PL, p.TS, p.M())
+	if frame == nil {
+		return nil, err
+	}
+	return []rtp.Frame{{TS: p.TS, Data: frame, Sync: true}}, err
+}
+
+// Unpack takes RTP payload with timestamp and marker bit and returns complete JFIF frame once packet with
+// marker bit arrives.  Fragments of incomplete frame are discarded.
+func (d *Depacketizer) Unpack(buf []byte, ts uint32, marker bool) (frame []byte, err error) {
+	if len(buf) < 8 {
+		return nil, errPacketTooShort
+	}
+	offset := int(be.Uint32(buf)) & 0xFFFFFF
+	h := header{typ: buf[4], q: buf[5], width: int(buf[6]) * 8, height: int(buf[7]) * 8}
+	buf = buf[8:]
+	if h.typ&0x3F > 1 || h.typ >= 128 {
+		d.reset()
+		return nil, errUnsupportedType
+	}
+	if h.width == 0 || h.height == 0 {
+		d.reset()
+		return nil, errInvalidSize
+	}
+	if h.typ >= 64 {
+		if len(buf) < 4 {
+			d.reset()
+			return nil, errPacketTooShort
+		}
+		h.restartInterval = be.Uint16(buf)
+		buf = buf[4:]
+	}
+	if offset == 0 {
+		d.reset()
+		if d.tables, buf, err = d.quantTables(h.q, buf); err != nil {
+			return nil, err
+		}
+		d.hdr, d.ts, d.started = h, ts, true
+	} else if !d.started {
+		return nil, nil // Wait for the first fragment of the next frame.
+	} else if offset != d.next {
+		d.reset()
+		return nil, errUnexpectedFragment
+	} else if ts != d.ts || h != d.hdr {
+		d.reset()
+		return nil, errInconsistentFragment
+	}
+	if len(d.scan)+len(buf) > MaxFrameSize {
+		d.reset()
+		return nil, errFrameTooLarge
+	}
+	d.scan = append(d.scan, buf...)
+	d.next = offset + len(buf)
+	if !marker {
+		return nil, nil
+	}
+	frame = appendHeaders(make([]byte, 0, len(d.scan)+1024), &d.hdr, d.tables)
+	frame = append(frame, d.scan...)
+	if n := len(frame); frame[n-2] != 0xFF || frame[n-1] != markerEOI {
+		frame = append(frame, 0xFF, markerEOI)
+	}
+	d.reset()
+	if d.Snapshot != nil {
+		d.Snapshot.Update(frame, ts)
+	}
+	return frame, nil
+}
+
+// reset discards frame being reassembled.
+func (d *Depacketizer) reset() {
+	d.scan = d.scan[:0]
+	d.next = 0
+	d.started = false
+}
+
+// quantTables returns quantization tables for Q factor and the rest of payload.  Q factors 1-99 select scaled
+// standard tables, and 128-255 come with tables in quantization table header of the first fragment, which
+// may be omitted for 128-254 once they were sent.
+func (d *Depacketizer) quantTables(q byte, buf []byte) (tables [][]byte, rest []byte, err error) {
+	if q < 128 {
+		luma, chroma := MakeTables(int(q))
+		return [][]byte{luma, chroma}, buf, nil
+	}
+	//  0                   1                   2                   3
+	//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |      MBZ      |   Precision   |             Length            |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	if len(buf) < 4 {
+		return nil, nil, errPacketTooShort
+	}
+	precision := buf[1]
+	size := int(be.Uint16(buf[2:]))
+	buf = buf[4:]
+	if size == 0 {
+		if tables = d.cache[q]; q == 255 || tables == nil {
+			return nil, nil, errMissingTables
+		}
+		return tables, buf, nil
+	}
+	if size > len(buf) {
+		return nil, nil, errPacketTooShort
+	}
+	data := buf[:size]
+	for i := 0; len(data) > 0; i++ {
+		n := 64
+		if precision&(1<<uint(i)) != 0 {
+			n = 128
+		}
+		if len(data) < n || i == 4 {
+			return nil, nil, errInvalidQuantHeader
+		}
+		tables = append(tables, append([]byte{}, data[:n]...))
+		data = data[n:]
+	}
+	if q < 255 {
+		if d.cache == nil {
+			d.cache = make(map[byte][][]byte)
+		}
+		d.cache[q] = tables
+	}
+	return tables, buf[size:], nil
+}
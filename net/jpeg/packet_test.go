@@ -0,0 +1,169 @@
+package jpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encode returns JPEG image of 4:2:0 baseline profile together with its quantization tables and scan.
+func encode(t *testing.T, quality int) (img image.Image, tables [][]byte, scan []byte) {
+	t.Helper()
+	src := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			src.Set(x, y, color.RGBA{byte(x * 4), byte(y * 5), byte(x * y), 255})
+		}
+	}
+	buf := &bytes.Buffer{}
+	if err := stdjpeg.Encode(buf, src, &stdjpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	img, err := stdjpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 2; off+4 <= len(data); {
+		marker, size := data[off+1], int(data[off+2])<<8|int(data[off+3])
+		seg := data[off+4 : off+2+size]
+		switch marker {
+		case markerDQT:
+			for ; len(seg) >= 65; seg = seg[65:] {
+				tables = append(tables, seg[1:65])
+			}
+		case markerSOS:
+			return img, tables, data[off+2+size:]
+		}
+		off += 2 + size
+	}
+	t.Fatal("No scan in JPEG image")
+	return
+}
+
+// packetize splits scan into RTP/JPEG payloads of type 1 with optional quantization table header.
+func packetize(scan []byte, q byte, tables [][]byte, size int) (packets [][]byte) {
+	for off := 0; off < len(scan); off += size {
+		buf := []byte{0, byte(off >> 16), byte(off >> 8), byte(off), 1, q, 64 / 8, 48 / 8}
+		if q >= 128 && off == 0 {
+			buf = append(buf, 0, 0, 0, byte(64*len(tables)))
+			for _, table := range tables {
+				buf = append(buf, table...)
+			}
+		}
+		end := off + size
+		if end > len(scan) {
+			end = len(scan)
+		}
+		packets = append(packets, append(buf, scan[off:end]...))
+	}
+	return
+}
+
+// unpack pushes payloads into depacketizer and returns frames, failing on errors.
+func unpack(t *testing.T, d *Depacketizer, packets [][]byte, ts uint32) (frames [][]byte) {
+	t.Helper()
+	for i, p := range packets {
+		frame, err := d.Unpack(p, ts, i == len(packets)-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame != nil {
+			frames = append(frames, frame)
+		}
+	}
+	return
+}
+
+// decodes checks that frame decodes into the same image.
+func decodes(t *testing.T, frame []byte, img image.Image) {
+	t.Helper()
+	got, err := stdjpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := got.(*image.YCbCr), img.(*image.YCbCr)
+	if a.SubsampleRatio != image.YCbCrSubsampleRatio420 || !bytes.Equal(a.Y, b.Y) || !bytes.Equal(a.Cb, b.Cb) || !bytes.Equal(a.Cr, b.Cr) {
+		t.Error("Reconstructed frame differs from original")
+	}
+}
+
+func TestMakeTables(t *testing.T) {
+	_, tables, _ := encode(t, 75)
+	luma, chroma := MakeTables(75)
+	if !bytes.Equal(luma, tables[0]) || !bytes.Equal(chroma, tables[1]) {
+		t.Errorf("Tables differ from encoder\n% x\n% x", luma, chroma)
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	img, tables, scan := encode(t, 50)
+	d := &Depacketizer{Snapshot: &Snapshot{}}
+
+	// Tables computed from Q factor.
+	frames := unpack(t, d, packetize(scan, 50, nil, 200), 3000)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 frame, got %d", len(frames))
+	}
+	decodes(t, frames[0], img)
+
+	// In-band tables that are cached for subsequent frames.
+	frames = unpack(t, d, packetize(scan, 128, tables, 200), 6000)
+	if len(frames) != 1 {
+		t.Fatalf("Expected 1 frame, got %d", len(frames))
+	}
+	decodes(t, frames[0], img)
+	packets := packetize(scan, 128, nil, 100)
+	if frames = unpack(t, d, packets, 9000); len(frames) != 1 {
+		t.Fatalf("Expected 1 frame with cached tables, got %d", len(frames))
+	}
+	decodes(t, frames[0], img)
+	if frame, ts := d.Snapshot.Frame(); !bytes.Equal(frame, frames[0]) || ts != 9000 {
+		t.Error("Snapshot is not updated")
+	}
+	if _, err := d.Unpack(packetize(scan, 255, nil, 200)[0], 12000, false); err != errMissingTables {
+		t.Errorf("Expected missing tables, got %v", err)
+	}
+
+	// Lost fragment drops the frame.
+	d.Unpack(packets[0], 12000, false)
+	if _, err := d.Unpack(packets[2], 12000, false); err != errUnexpectedFragment {
+		t.Errorf("Expected unexpected fragment, got %v", err)
+	}
+	if frame, err := d.Unpack(packets[3], 12000, true); frame != nil || err != nil {
+		t.Errorf("Incomplete frame is not dropped %v", err)
+	}
+
+	// Restart marker header is turned into restart interval.
+	payload := []byte{0, 0, 0, 0, 65, 50, 8, 8, 0x00, 0x04, 0xFF, 0xFF, 0x01, 0x02}
+	frame, err := d.Unpack(payload, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(frame, []byte{0xFF, markerDRI, 0, 4, 0, 4}) || !bytes.HasSuffix(frame, []byte{0x01, 0x02, 0xFF, markerEOI}) {
+		t.Errorf("Unexpected frame % x", frame)
+	}
+}
+
+func TestSnapshotWriteFile(t *testing.T) {
+	s := &Snapshot{}
+	name := filepath.Join(t.TempDir(), "thumbnail.jpg")
+	if err := s.WriteFile(name); err != errNoFrame {
+		t.Errorf("Expected no frame error, got %v", err)
+	}
+	s.Update([]byte{0xFF, markerSOI, 0xFF, markerEOI}, 0)
+	if err := s.WriteFile(name); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil || string(data) != "\xff\xd8\xff\xd9" {
+		t.Errorf("Unexpected snapshot % x, %v", data, err)
+	}
+	if files, _ := os.ReadDir(filepath.Dir(name)); len(files) != 1 {
+		t.Errorf("Temporary files are left behind %v", files)
+	}
+}
@@ -0,0 +1,65 @@
+package jpeg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	errNoFrame = errors.New("No frame for snapshot")
+)
+
+type (
+	// Snapshot keeps the latest complete frame, e.g. for thumbnails.  It is safe for concurrent use.
+	Snapshot struct {
+		mu    sync.Mutex
+		frame []byte
+		ts    uint32
+	}
+)
+
+// Update replaces the latest frame.  Snapshot keeps the frame, which must not be modified afterwards.
+func (s *Snapshot) Update(frame []byte, ts uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frame, s.ts = frame, ts
+}
+
+// Frame returns the latest frame with its RTP timestamp, nil if there is none yet.
+func (s *Snapshot) Frame() ([]byte, uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame, s.ts
+}
+
+// WriteFile writes the latest frame into a file, e.g. thumbnail.jpg.  The file is replaced at once so that
+// readers never see partially written image.
+func (s *Snapshot) WriteFile(name string) error {
+	frame, _ := s.Frame()
+	if frame == nil {
+		return errNoFrame
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	if err = tmp.Chmod(0644); err == nil {
+		_, err = tmp.Write(frame)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
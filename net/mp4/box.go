@@ -0,0 +1,179 @@
+package mp4
+
+// Building and parsing of ISO base media file format boxes as defined in ISO/IEC 14496-12.
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	errBoxTooShort = errors.New("Box is too short")
+	errBoxTooLarge = errors.New("Box extends past the end of file")
+)
+
+var be = binary.BigEndian
+
+type (
+	// buffer accumulates nested boxes.  Box size is filled in when box is closed.
+	buffer struct {
+		b     []byte
+		stack []int
+	}
+
+	// box is a parsed box.  Children are populated only for container boxes.
+	box struct {
+		typ      string
+		offset   int64 // Position of box header in the file.
+		size     int64 // Size of the box including header.
+		data     []byte
+		children []*box
+	}
+)
+
+// Container boxes that are parsed recursively.
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "mvex": true,
+	"moof": true, "traf": true, "dinf": true, "edts": true,
+}
+
+func (w *buffer) open(typ string) {
+	w.stack = append(w.stack, len(w.b))
+	w.b = append(w.b, 0, 0, 0, 0)
+	w.b = append(w.b, typ...)
+}
+
+// full opens a box with version and flags.
+func (w *buffer) full(typ string, version byte, flags uint32) {
+	w.open(typ)
+	w.u32(uint32(version)<<24 | flags&0xFFFFFF)
+}
+
+func (w *buffer) close() {
+	pos := w.stack[len(w.stack)-1]
+	w.stack = w.stack[:len(w.stack)-1]
+	be.PutUint32(w.b[pos:], uint32(len(w.b)-pos))
+}
+
+func (w *buffer) u8(v byte) {
+	w.b = append(w.b, v)
+}
+
+func (w *buffer) u16(v uint16) {
+	w.b = append(w.b, byte(v>>8), byte(v))
+}
+
+func (w *buffer) u32(v uint32) {
+	w.b = append(w.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (w *buffer) u64(v uint64) {
+	w.u32(uint32(v >> 32))
+	w.u32(uint32(v))
+}
+
+func (w *buffer) bytes(b []byte) {
+	w.b = append(w.b, b...)
+}
+
+func (w *buffer) zeros(n int) {
+	for i := 0; i < n; i++ {
+		w.b = append(w.b, 0)
+	}
+}
+
+// matrix writes unity transformation matrix.
+func (w *buffer) matrix() {
+	for _, v := range []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000} {
+		w.u32(v)
+	}
+}
+
+// readBox reads box header at the offset and, for container or requested boxes, its contents.
+// Contents of other boxes are skipped.
+func readBox(r io.ReadSeeker, offset, end int64, load func(typ string) bool) (*box, error) {
+	var hdr [16]byte
+	if end-offset < 8 {
+		return nil, errBoxTooShort
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, hdr[:8]); err != nil {
+		return nil, err
+	}
+	b := &box{typ: string(hdr[4:8]), offset: offset, size: int64(be.Uint32(hdr[:]))}
+	head := int64(8)
+	switch b.size {
+	case 0:
+		b.size = end - offset
+	case 1:
+		if _, err := io.ReadFull(r, hdr[8:]); err != nil {
+			return nil, err
+		}
+		b.size = int64(be.Uint64(hdr[8:]))
+		head = 16
+	}
+	if b.size < head {
+		return nil, errBoxTooShort
+	}
+	if offset+b.size > end {
+		return b, errBoxTooLarge
+	}
+	switch {
+	case containers[b.typ]:
+		children, err := readBoxes(r, offset+head, offset+b.size, load)
+		if err != nil {
+			return b, err
+		}
+		b.children = children
+	case load(b.typ):
+		b.data = make([]byte, b.size-head)
+		if _, err := io.ReadFull(r, b.data); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+// readBoxes reads all boxes between offsets.
+func readBoxes(r io.ReadSeeker, offset, end int64, load func(typ string) bool) (boxes []*box, err error) {
+	for offset < end {
+		b, err := readBox(r, offset, end, load)
+		if err != nil {
+			return boxes, err
+		}
+		boxes = append(boxes, b)
+		offset += b.size
+	}
+	return
+}
+
+// child finds the first child box with a given path of types.
+func (b *box) child(path ...string) *box {
+	for _, typ := range path {
+		var next *box
+		for _, c := range b.children {
+			if c.typ == typ {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		b = next
+	}
+	return b
+}
+
+// all finds all child boxes of a given type.
+func (b *box) all(typ string) (boxes []*box) {
+	for _, c := range b.children {
+		if c.typ == typ {
+			boxes = append(boxes, c)
+		}
+	}
+	return
+}
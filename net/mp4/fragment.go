@@ -0,0 +1,182 @@
+package mp4
+
+import (
+	"errors"
+	"io"
+)
+
+// Sample flags for sync and non-sync samples in track run.
+const (
+	flagsSync    = 0x02000000 // Does not depend on other samples.
+	flagsNonSync = 0x01010000 // Depends on other samples, not a sync sample.
+)
+
+var (
+	errNoTracks     = errors.New("No tracks to write")
+	errTrackCount   = errors.New("Number of sample lists does not match number of tracks")
+	errNoMovie      = errors.New("Missing movie box")
+	errUnknownTrack = errors.New("Fragment refers to unknown track")
+)
+
+type (
+	// FragmentWriter writes fragmented MP4: initialization segment followed by movie fragments.
+	// Every fragment is self-contained so that file remains usable up to the last complete fragment
+	// if writing is interrupted.
+	FragmentWriter struct {
+		w      io.Writer
+		Tracks []*Track
+		seq    uint32
+		times  []uint64 // Decode time of the next sample in each track.
+		size   int64
+	}
+)
+
+// NewFragmentWriter writes initialization segment for tracks and returns writer of fragments.
+// Tracks are numbered in order starting from 1.
+func NewFragmentWriter(w io.Writer, tracks []*Track) (*FragmentWriter, error) {
+	if len(tracks) == 0 {
+		return nil, errNoTracks
+	}
+	f := &FragmentWriter{w: w, Tracks: tracks, times: make([]uint64, len(tracks))}
+	for i, t := range tracks {
+		t.ID = uint32(i + 1)
+	}
+
+	b := &buffer{}
+	writeFileType(b, "iso5", "iso5", "iso6", "mp41")
+	b.open("moov")
+	writeMovieHeader(b, 0, uint32(len(tracks)+1))
+	for _, t := range tracks {
+		t.write(b, 0, func(w *buffer) {
+			for _, typ := range []string{"stts", "stsc", "stsz", "stco"} {
+				w.full(typ, 0, 0)
+				if typ == "stsz" {
+					w.u32(0)
+				}
+				w.u32(0)
+				w.close()
+			}
+		})
+	}
+	b.open("mvex")
+	for _, t := range tracks {
+		b.full("trex", 0, 0)
+		b.u32(t.ID)
+		b.u32(1) // Sample description index.
+		b.zeros(12)
+		b.close()
+	}
+	b.close()
+	b.close()
+	return f, f.write(b.b)
+}
+
+// Size returns number of bytes written so far.
+func (f *FragmentWriter) Size() int64 {
+	return f.size
+}
+
+// WriteFragment writes movie fragment with samples for each track.  Lists of samples are in order of tracks
+// and may be empty.
+func (f *FragmentWriter) WriteFragment(samples [][]Sample) error {
+	if len(samples) != len(f.Tracks) {
+		return errTrackCount
+	}
+	f.seq++
+	b := &buffer{}
+	b.open("moof")
+	b.full("mfhd", 0, 0)
+	b.u32(f.seq)
+	b.close()
+	var offsets []int // Positions of data offset fields to patch.
+	for i, list := range samples {
+		if len(list) == 0 {
+			continue
+		}
+		b.open("traf")
+		b.full("tfhd", 0, 0x020000) // Default base is moof.
+		b.u32(f.Tracks[i].ID)
+		b.close()
+		b.full("tfdt", 1, 0)
+		b.u64(f.times[i])
+		b.close()
+		b.full("trun", 0, 0x000701) // Data offset, sample duration, size, and flags present.
+		b.u32(uint32(len(list)))
+		offsets = append(offsets, len(b.b))
+		b.u32(0)
+		for _, s := range list {
+			b.u32(s.Duration)
+			b.u32(uint32(len(s.Data)))
+			if s.Sync {
+				b.u32(flagsSync)
+			} else {
+				b.u32(flagsNonSync)
+			}
+			f.times[i] += uint64(s.Duration)
+		}
+		b.close()
+		b.close()
+	}
+	b.close()
+
+	// Data for each track follows the previous one in media data box.
+	pos := len(b.b) + 8
+	n := 0
+	for _, list := range samples {
+		if len(list) == 0 {
+			continue
+		}
+		be.PutUint32(b.b[offsets[n]:], uint32(pos))
+		n++
+		for _, s := range list {
+			pos += len(s.Data)
+		}
+	}
+	b.open("mdat")
+	for _, list := range samples {
+		for _, s := range list {
+			b.bytes(s.Data)
+		}
+	}
+	b.close()
+	return f.write(b.b)
+}
+
+func (f *FragmentWriter) write(b []byte) error {
+	n, err := f.w.Write(b)
+	f.size += int64(n)
+	return err
+}
+
+// writeFileType writes file type box with major and compatible brands.
+func writeFileType(b *buffer, major string, compatible ...string) {
+	b.open("ftyp")
+	b.bytes([]byte(major))
+	b.u32(0x200)
+	for _, brand := range compatible {
+		b.bytes([]byte(brand))
+	}
+	b.close()
+}
+
+// writeMovieHeader writes movie header box with duration in milliseconds.
+func writeMovieHeader(b *buffer, duration uint64, next uint32) {
+	if duration > 0xFFFFFFFF {
+		b.full("mvhd", 1, 0)
+		b.zeros(16)
+		b.u32(movieTimeScale)
+		b.u64(duration)
+	} else {
+		b.full("mvhd", 0, 0)
+		b.zeros(8)
+		b.u32(movieTimeScale)
+		b.u32(uint32(duration))
+	}
+	b.u32(0x00010000) // Rate 1.0.
+	b.u16(0x0100)     // Full volume.
+	b.zeros(10)
+	b.matrix()
+	b.zeros(24)
+	b.u32(next) // Next track ID.
+	b.close()
+}
@@ -0,0 +1,388 @@
+package mp4
+
+// Conversion of fragmented MP4 into a regular one with movie box in front of media data (faststart).
+
+import (
+	"io"
+)
+
+type (
+	// entry describes a sample in a track run.
+	entry struct {
+		size     uint32
+		duration uint32
+		sync     bool
+	}
+
+	// run is a contiguous sequence of samples of the same track.
+	run struct {
+		track   int
+		offset  int64 // Position of the first sample in the file.
+		size    int64
+		entries []entry
+	}
+
+	// fragmented is the parsed structure of fragmented MP4.
+	fragmented struct {
+		tracks    []*Track
+		runs      []run
+		size      int64 // Size of intact part of the file.
+		fragments int
+	}
+)
+
+// Boxes which content is needed to restore tracks and samples.
+var loaded = map[string]bool{
+	"tkhd": true, "mdhd": true, "hdlr": true, "stsd": true, "mfhd": true, "tfhd": true, "tfdt": true, "trun": true,
+}
+
+func load(typ string) bool {
+	return loaded[typ]
+}
+
+// parseFragmented reads initialization segment and all complete fragments.  Incomplete fragment at the end,
+// e.g. after crash, is ignored.
+func parseFragmented(r io.ReadSeeker) (*fragmented, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	f := &fragmented{}
+	var moof *box
+	for offset := int64(0); offset < end; {
+		b, err := readBox(r, offset, end, load)
+		if err != nil {
+			if f.tracks == nil {
+				return nil, err
+			}
+			// Truncated box.
+			break
+		}
+		offset += b.size
+		switch b.typ {
+		case "ftyp":
+			f.size = offset
+		case "moov":
+			for _, trak := range b.all("trak") {
+				t, err := parseTrack(trak)
+				if err != nil {
+					return nil, err
+				}
+				f.tracks = append(f.tracks, t)
+			}
+			f.size = offset
+		case "moof":
+			moof = b
+		case "mdat":
+			if moof == nil {
+				continue
+			}
+			runs, err := f.parseFragment(moof)
+			if err != nil {
+				return nil, err
+			}
+			f.runs = append(f.runs, runs...)
+			f.fragments++
+			f.size = offset
+			moof = nil
+		}
+	}
+	if f.tracks == nil {
+		return nil, errNoMovie
+	}
+	return f, nil
+}
+
+// parseFragment extracts track runs from movie fragment.
+func (f *fragmented) parseFragment(moof *box) (runs []run, err error) {
+	for _, traf := range moof.all("traf") {
+		tfhd := traf.child("tfhd")
+		if tfhd == nil || len(tfhd.data) < 8 {
+			return nil, errMalformedTrack
+		}
+		flags := be.Uint32(tfhd.data) & 0xFFFFFF
+		id := be.Uint32(tfhd.data[4:])
+		track := -1
+		for i, t := range f.tracks {
+			if t.ID == id {
+				track = i
+			}
+		}
+		if track < 0 {
+			return nil, errUnknownTrack
+		}
+		base := moof.offset
+		var dflt entry
+		data := tfhd.data[8:]
+		fields := []struct {
+			flag uint32
+			size int
+		}{{0x01, 8}, {0x02, 4}, {0x08, 4}, {0x10, 4}, {0x20, 4}}
+		for _, fld := range fields {
+			if flags&fld.flag == 0 {
+				continue
+			}
+			if len(data) < fld.size {
+				return nil, errMalformedTrack
+			}
+			switch fld.flag {
+			case 0x01:
+				base = int64(be.Uint64(data))
+			case 0x08:
+				dflt.duration = be.Uint32(data)
+			case 0x10:
+				dflt.size = be.Uint32(data)
+			case 0x20:
+				dflt.sync = be.Uint32(data)&0x00010000 == 0
+			}
+			data = data[fld.size:]
+		}
+		for _, trun := range traf.all("trun") {
+			r, err := parseRun(trun.data, dflt)
+			if err != nil {
+				return nil, err
+			}
+			r.track = track
+			r.offset += base
+			runs = append(runs, r)
+		}
+	}
+	return
+}
+
+// parseRun parses track run box.  Offset of the run is relative to base data offset.
+func parseRun(data []byte, dflt entry) (r run, err error) {
+	if len(data) < 8 {
+		return r, errMalformedTrack
+	}
+	flags := be.Uint32(data) & 0xFFFFFF
+	count := int(be.Uint32(data[4:]))
+	data = data[8:]
+	if flags&0x01 != 0 {
+		if len(data) < 4 {
+			return r, errMalformedTrack
+		}
+		r.offset = int64(int32(be.Uint32(data)))
+		data = data[4:]
+	}
+	first := dflt.sync
+	if flags&0x04 != 0 {
+		if len(data) < 4 {
+			return r, errMalformedTrack
+		}
+		first = be.Uint32(data)&0x00010000 == 0
+		data = data[4:]
+	}
+	size := 0
+	for _, flag := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&flag != 0 {
+			size += 4
+		}
+	}
+	if len(data) < count*size {
+		return r, errMalformedTrack
+	}
+	for i := 0; i < count; i++ {
+		e := dflt
+		if i == 0 {
+			e.sync = first
+		}
+		if flags&0x100 != 0 {
+			e.duration = be.Uint32(data)
+			data = data[4:]
+		}
+		if flags&0x200 != 0 {
+			e.size = be.Uint32(data)
+			data = data[4:]
+		}
+		if flags&0x400 != 0 {
+			e.sync = be.Uint32(data)&0x00010000 == 0
+			data = data[4:]
+		}
+		if flags&0x800 != 0 {
+			data = data[4:] // Composition offsets are not used.
+		}
+		r.size += int64(e.size)
+		r.entries = append(r.entries, e)
+	}
+	return
+}
+
+// Scan checks fragmented MP4 and reports the size of its intact part, i.e. initialization segment followed
+// by complete fragments, and the number of such fragments.
+func Scan(r io.ReadSeeker) (size int64, fragments int, err error) {
+	f, err := parseFragmented(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	return f.size, f.fragments, nil
+}
+
+// Defragment converts fragmented MP4 into a regular one with movie box preceding media data so that
+// playback can start before the whole file is downloaded.  Incomplete fragment at the end is dropped.
+func Defragment(dst io.Writer, src io.ReadSeeker) error {
+	f, err := parseFragmented(src)
+	if err != nil {
+		return err
+	}
+	var payload int64
+	for _, r := range f.runs {
+		payload += r.size
+	}
+	head := int64(8)
+	if payload+8 > 0xFFFFFFFF {
+		head = 16
+	}
+
+	b := &buffer{}
+	writeFileType(b, "isom", "isom", "iso2", "avc1", "mp41")
+	prefix := len(b.b)
+	f.writeMovie(b, 0, false)
+	large := int64(len(b.b))+head+payload > 0xFFFFFFFF
+	if large {
+		b.b = b.b[:prefix]
+		f.writeMovie(b, 0, true)
+	}
+	// Offsets do not change size of the movie box so it is written again with actual offsets.
+	base := int64(len(b.b)) + head
+	b.b = b.b[:prefix]
+	f.writeMovie(b, base, large)
+	if head == 16 {
+		b.u32(1)
+		b.bytes([]byte("mdat"))
+		b.u64(uint64(payload + head))
+	} else {
+		b.u32(uint32(payload + head))
+		b.bytes([]byte("mdat"))
+	}
+	if _, err = dst.Write(b.b); err != nil {
+		return err
+	}
+	for _, r := range f.runs {
+		if _, err = src.Seek(r.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = io.CopyN(dst, src, r.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMovie writes movie box with sample tables.  Each track run becomes a chunk in media data
+// starting at base offset.
+func (f *fragmented) writeMovie(b *buffer, base int64, large bool) {
+	durations := make([]uint64, len(f.tracks))
+	offsets := make([]int64, len(f.runs))
+	pos := base
+	for i, r := range f.runs {
+		offsets[i] = pos
+		pos += r.size
+		for _, e := range r.entries {
+			durations[r.track] += uint64(e.duration)
+		}
+	}
+	var movie uint64
+	for i, t := range f.tracks {
+		if d := durations[i] * movieTimeScale / uint64(t.TimeScale); d > movie {
+			movie = d
+		}
+	}
+
+	b.open("moov")
+	writeMovieHeader(b, movie, uint32(len(f.tracks)+1))
+	for i, t := range f.tracks {
+		t.write(b, durations[i], func(w *buffer) {
+			f.writeSampleTables(w, i, offsets, large)
+		})
+	}
+	b.close()
+}
+
+// writeSampleTables writes time to sample, sync sample, sample to chunk, sample size, and chunk offset boxes.
+func (f *fragmented) writeSampleTables(w *buffer, track int, offsets []int64, large bool) {
+	var entries []entry
+	var chunks []int64
+	var counts []int
+	for i, r := range f.runs {
+		if r.track != track || len(r.entries) == 0 {
+			continue
+		}
+		entries = append(entries, r.entries...)
+		chunks = append(chunks, offsets[i])
+		counts = append(counts, len(r.entries))
+	}
+
+	// Time to sample: run-length encoded durations.
+	w.full("stts", 0, 0)
+	n := len(w.b)
+	w.u32(0)
+	var stts uint32
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].duration == entries[i].duration {
+			j++
+		}
+		w.u32(uint32(j - i))
+		w.u32(entries[i].duration)
+		stts++
+		i = j
+	}
+	be.PutUint32(w.b[n:], stts)
+	w.close()
+
+	// Sync samples are listed only if some samples are not sync.
+	var sync []uint32
+	for i, e := range entries {
+		if e.sync {
+			sync = append(sync, uint32(i+1))
+		}
+	}
+	if len(sync) < len(entries) {
+		w.full("stss", 0, 0)
+		w.u32(uint32(len(sync)))
+		for _, v := range sync {
+			w.u32(v)
+		}
+		w.close()
+	}
+
+	// Sample to chunk: entry for every change in number of samples per chunk.
+	w.full("stsc", 0, 0)
+	n = len(w.b)
+	w.u32(0)
+	var stsc uint32
+	for i, count := range counts {
+		if i == 0 || count != counts[i-1] {
+			w.u32(uint32(i + 1))
+			w.u32(uint32(count))
+			w.u32(1)
+			stsc++
+		}
+	}
+	be.PutUint32(w.b[n:], stsc)
+	w.close()
+
+	w.full("stsz", 0, 0)
+	w.u32(0)
+	w.u32(uint32(len(entries)))
+	for _, e := range entries {
+		w.u32(e.size)
+	}
+	w.close()
+
+	if large {
+		w.full("co64", 0, 0)
+		w.u32(uint32(len(chunks)))
+		for _, off := range chunks {
+			w.u64(uint64(off))
+		}
+	} else {
+		w.full("stco", 0, 0)
+		w.u32(uint32(len(chunks)))
+		for _, off := range chunks {
+			w.u32(uint32(off))
+		}
+	}
+	w.close()
+}
@@ -0,0 +1,133 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/aboukirev/ouro/net/opus"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+func testTracks(t *testing.T) []*Track {
+	sps, _ := base64.StdEncoding.DecodeString("Z2QAH6w0yAUAW/8BbgICAoAAAfQAADqYdDAATioAATioXeXGhgAJxUAAJxULvLhQAA==")
+	pps, _ := base64.StdEncoding.DecodeString("aO48MA==")
+	video, err := NewH264Track([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Width != 1280 || video.Height != 720 {
+		t.Errorf("Wrong dimensions %dx%d", video.Width, video.Height)
+	}
+	audio, err := NewAACTrack([]byte{0x14, 0x08})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audio.TimeScale != 16000 {
+		t.Errorf("Wrong audio time scale %d", audio.TimeScale)
+	}
+	return []*Track{video, audio}
+}
+
+// writeFragments writes fragments with three video frames, first one key, and two audio frames each.
+func writeFragments(t *testing.T, n int) []byte {
+	buf := &bytes.Buffer{}
+	fw, err := NewFragmentWriter(buf, testTracks(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		video := []Sample{
+			{Data: []byte{0, 0, 0, 2, 0x65, byte(i)}, Duration: 3000, Sync: true},
+			{Data: []byte{0, 0, 0, 2, 0x41, byte(i)}, Duration: 3000},
+			{Data: []byte{0, 0, 0, 2, 0x41, byte(i)}, Duration: 3000},
+		}
+		audio := []Sample{{Data: []byte{0x21, byte(i)}, Duration: 1024, Sync: true}, {Data: []byte{0x21, byte(i)}, Duration: 1024, Sync: true}}
+		if err := fw.WriteFragment([][]Sample{video, audio}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fw.Size() != int64(buf.Len()) {
+		t.Errorf("Size %d does not match written %d", fw.Size(), buf.Len())
+	}
+	return buf.Bytes()
+}
+
+func TestOpusTrack(t *testing.T) {
+	c := opus.NewConfig(&sdp.Media{Fmtp: map[string]string{"sprop-stereo": "1"}})
+	tr := NewOpusTrack(c)
+	if tr.TimeScale != 48000 || string(tr.entry[4:8]) != "Opus" || tr.entry[25] != 2 {
+		t.Fatalf("Wrong sample entry % x", tr.entry)
+	}
+	if box := tr.entry[36:]; string(box[4:8]) != "dOps" || !bytes.Equal(box[8:], c.DOps()) {
+		t.Errorf("Wrong dOps box % x", box)
+	}
+}
+
+func TestScan(t *testing.T) {
+	data := writeFragments(t, 3)
+	size, fragments, err := Scan(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(data)) || fragments != 3 {
+		t.Errorf("Expected %d bytes in 3 fragments, got %d in %d", len(data), size, fragments)
+	}
+	// Cut the last fragment short as if writing was interrupted.
+	complete := size
+	size, fragments, err = Scan(bytes.NewReader(data[:len(data)-5]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size >= complete || fragments != 2 {
+		t.Errorf("Expected 2 fragments in less than %d bytes, got %d in %d", complete, fragments, size)
+	}
+}
+
+func TestDefragment(t *testing.T) {
+	data := writeFragments(t, 2)
+	out := &bytes.Buffer{}
+	if err := Defragment(out, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(out.Bytes())
+	boxes, err := readBoxes(r, 0, int64(out.Len()), func(typ string) bool {
+		return typ == "stsz" || typ == "stco" || typ == "stss" || typ == "mvhd"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, b := range boxes {
+		types = append(types, b.typ)
+	}
+	if len(boxes) != 3 || boxes[1].typ != "moov" || boxes[2].typ != "mdat" {
+		t.Fatalf("Expected ftyp, moov, mdat, got %v", types)
+	}
+	traks := boxes[1].all("trak")
+	if len(traks) != 2 {
+		t.Fatalf("Expected 2 tracks, got %d", len(traks))
+	}
+	video := traks[0].child("mdia", "minf", "stbl")
+	if n := be.Uint32(video.child("stsz").data[8:]); n != 6 {
+		t.Errorf("Expected 6 video samples, got %d", n)
+	}
+	if stss := video.child("stss"); stss == nil || be.Uint32(stss.data[4:]) != 2 {
+		t.Error("Expected 2 sync samples")
+	}
+	if traks[1].child("mdia", "minf", "stbl", "stss") != nil {
+		t.Error("Audio track must not have sync sample table")
+	}
+	stco := video.child("stco").data
+	if n := be.Uint32(stco[4:]); n != 2 {
+		t.Fatalf("Expected 2 video chunks, got %d", n)
+	}
+	off := be.Uint32(stco[12:])
+	if got := out.Bytes()[off : off+6]; string(got) != "\x00\x00\x00\x02\x65\x01" {
+		t.Errorf("Chunk offset points to % x", got)
+	}
+	// Movie lasts as long as the longest track: 4 AAC frames at 16 kHz rather than 6 video frames at 30 fps.
+	if d := be.Uint32(boxes[1].child("mvhd").data[16:]); d != 256 {
+		t.Errorf("Expected duration 256ms, got %d", d)
+	}
+}
@@ -0,0 +1,300 @@
+package mp4
+
+import (
+	"errors"
+
+	"github.com/aboukirev/ouro/net/aac"
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/opus"
+)
+
+// Handler types of tracks.
+const (
+	HandlerVideo = "vide"
+	HandlerAudio = "soun"
+)
+
+// Time scale of the movie header, i.e. milliseconds.
+const movieTimeScale = 1000
+
+var (
+	errMissingParameterSets = errors.New("Missing SPS or PPS for H.264 track")
+	errMalformedTrack       = errors.New("Malformed track box")
+)
+
+type (
+	// Track describes media track and its codec configuration.
+	Track struct {
+		ID        uint32
+		Handler   string // Either HandlerVideo or HandlerAudio.
+		TimeScale uint32
+		Width     uint16
+		Height    uint16
+		entry     []byte // Sample entry box with codec configuration.
+	}
+
+	// Sample is a single access unit, e.g. video frame.
+	Sample struct {
+		Data     []byte
+		Duration uint32 // Duration in track time scale.
+		Sync     bool   // Sample is a random access point, e.g. IDR.
+	}
+)
+
+// NewH264Track creates video track from H.264 sequence and picture parameter sets.  Each set includes NAL header.
+func NewH264Track(sps, pps [][]byte) (*Track, error) {
+	if len(sps) == 0 || len(pps) == 0 || len(sps[0]) < 4 {
+		return nil, errMissingParameterSets
+	}
+	raw := h264.EBSPToRaw(sps[0][1:])
+	sets := h264.NewParameterSets()
+	if err := sets.ParseSPS(raw); err != nil {
+		return nil, err
+	}
+	// Identifier follows profile, constraints, and level.
+	id, err := h264.NewBitReader(raw[3:]).ReadUnsignedGolomb()
+	if err != nil {
+		return nil, err
+	}
+	info, _ := sets.GetSPS(id)
+	t := &Track{Handler: HandlerVideo, TimeScale: 90000, Width: uint16(info.Width), Height: uint16(info.Height)}
+
+	w := &buffer{}
+	w.open("avc1")
+	w.zeros(6)
+	w.u16(1) // Data reference index.
+	w.zeros(16)
+	w.u16(t.Width)
+	w.u16(t.Height)
+	w.u32(0x00480000) // 72 dpi.
+	w.u32(0x00480000)
+	w.u32(0)
+	w.u16(1) // Frame count.
+	w.zeros(32)
+	w.u16(0x0018) // Depth.
+	w.u16(0xFFFF)
+
+	config, err := h264.NewDecoderConfig(sps, pps)
+	if err != nil {
+		return nil, err
+	}
+	w.open("avcC")
+	w.bytes(config.Bytes())
+	w.close()
+
+	if info.ColourDescriptionPresent {
+		w.open("colr")
+		w.bytes([]byte("nclx"))
+		w.u16(uint16(info.ColourPrimaries))
+		w.u16(uint16(info.TransferCharacteristics))
+		w.u16(uint16(info.MatrixCoefficients))
+		if info.VideoFullRange {
+			w.u8(0x80)
+		} else {
+			w.u8(0)
+		}
+		w.close()
+	}
+	if sw, sh := info.SampleAspectRatio(); sw != sh {
+		w.open("pasp")
+		w.u32(sw)
+		w.u32(sh)
+		w.close()
+	}
+
+	w.close()
+	t.entry = w.b
+	return t, nil
+}
+
+// NewAACTrack creates audio track from AudioSpecificConfig.
+func NewAACTrack(config []byte) (*Track, error) {
+	c, err := aac.ParseConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	t := &Track{Handler: HandlerAudio, TimeScale: uint32(c.SampleRate)}
+
+	w := &buffer{}
+	w.open("mp4a")
+	w.zeros(6)
+	w.u16(1) // Data reference index.
+	w.zeros(8)
+	w.u16(uint16(c.Channels))
+	w.u16(16) // Sample size.
+	w.zeros(4)
+	if c.SampleRate < 0x10000 {
+		w.u32(uint32(c.SampleRate) << 16)
+	} else {
+		w.u32(0)
+	}
+
+	w.full("esds", 0, 0)
+	dsi := descriptor(5, config)
+	dcd := []byte{0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	dcd = descriptor(4, append(dcd, dsi...))
+	es := append([]byte{0, 0, 0}, dcd...)
+	es = append(es, descriptor(6, []byte{2})...)
+	w.bytes(descriptor(3, es))
+	w.close()
+
+	w.close()
+	t.entry = w.b
+	return t, nil
+}
+
+// NewOpusTrack creates audio track from Opus decoder configuration.
+func NewOpusTrack(c *opus.Config) *Track {
+	t := &Track{Handler: HandlerAudio, TimeScale: opus.SampleRate}
+
+	w := &buffer{}
+	w.open("Opus")
+	w.zeros(6)
+	w.u16(1) // Data reference index.
+	w.zeros(8)
+	w.u16(uint16(c.Channels))
+	w.u16(16) // Sample size.
+	w.zeros(4)
+	w.u32(opus.SampleRate << 16)
+
+	w.open("dOps")
+	w.bytes(c.DOps())
+	w.close()
+
+	w.close()
+	t.entry = w.b
+	return t
+}
+
+// descriptor formats MPEG-4 descriptor with a given tag.
+func descriptor(tag byte, payload []byte) []byte {
+	n := len(payload)
+	b := []byte{tag, 0x80 | byte(n>>21), 0x80 | byte(n>>14), 0x80 | byte(n>>7), byte(n & 0x7F)}
+	return append(b, payload...)
+}
+
+// IsVideo reports whether track is video.
+func (t *Track) IsVideo() bool {
+	return t.Handler == HandlerVideo
+}
+
+// write formats track box.  Function stbl adds sample tables after sample description.
+func (t *Track) write(w *buffer, duration uint64, stbl func(w *buffer)) {
+	w.open("trak")
+
+	version := byte(0)
+	mduration := duration * movieTimeScale / uint64(t.TimeScale)
+	if duration > 0xFFFFFFFF {
+		version = 1
+	}
+	w.full("tkhd", version, 3) // Enabled and in movie.
+	if version == 1 {
+		w.zeros(16)
+		w.u32(t.ID)
+		w.u32(0)
+		w.u64(mduration)
+	} else {
+		w.zeros(8)
+		w.u32(t.ID)
+		w.u32(0)
+		w.u32(uint32(mduration))
+	}
+	w.zeros(8)
+	w.u16(0) // Layer.
+	w.u16(0) // Alternate group.
+	if t.IsVideo() {
+		w.u16(0)
+	} else {
+		w.u16(0x0100) // Full volume.
+	}
+	w.u16(0)
+	w.matrix()
+	w.u32(uint32(t.Width) << 16)
+	w.u32(uint32(t.Height) << 16)
+	w.close()
+
+	w.open("mdia")
+	w.full("mdhd", version, 0)
+	if version == 1 {
+		w.zeros(16)
+		w.u32(t.TimeScale)
+		w.u64(duration)
+	} else {
+		w.zeros(8)
+		w.u32(t.TimeScale)
+		w.u32(uint32(duration))
+	}
+	w.u16(0x55C4) // Language "und".
+	w.u16(0)
+	w.close()
+
+	w.full("hdlr", 0, 0)
+	w.u32(0)
+	w.bytes([]byte(t.Handler))
+	w.zeros(12)
+	if t.IsVideo() {
+		w.bytes([]byte("VideoHandler\x00"))
+	} else {
+		w.bytes([]byte("SoundHandler\x00"))
+	}
+	w.close()
+
+	w.open("minf")
+	if t.IsVideo() {
+		w.full("vmhd", 0, 1)
+		w.zeros(8)
+	} else {
+		w.full("smhd", 0, 0)
+		w.zeros(4)
+	}
+	w.close()
+	w.open("dinf")
+	w.full("dref", 0, 0)
+	w.u32(1)
+	w.full("url ", 0, 1) // Media is in the same file.
+	w.close()
+	w.close()
+	w.close()
+
+	w.open("stbl")
+	w.full("stsd", 0, 0)
+	w.u32(1)
+	w.bytes(t.entry)
+	w.close()
+	stbl(w)
+	w.close()
+
+	w.close() // minf
+	w.close() // mdia
+	w.close() // trak
+}
+
+// parseTrack restores track description from track box.
+func parseTrack(trak *box) (*Track, error) {
+	tkhd := trak.child("tkhd")
+	mdhd := trak.child("mdia", "mdhd")
+	hdlr := trak.child("mdia", "hdlr")
+	stsd := trak.child("mdia", "minf", "stbl", "stsd")
+	if tkhd == nil || mdhd == nil || hdlr == nil || stsd == nil || len(tkhd.data) < 84 || len(mdhd.data) < 24 || len(hdlr.data) < 12 || len(stsd.data) < 16 {
+		return nil, errMalformedTrack
+	}
+	t := &Track{}
+	off := 12
+	if tkhd.data[0] == 1 {
+		off = 20
+	}
+	t.ID = be.Uint32(tkhd.data[off:])
+	t.Width = uint16(be.Uint32(tkhd.data[len(tkhd.data)-8:]) >> 16)
+	t.Height = uint16(be.Uint32(tkhd.data[len(tkhd.data)-4:]) >> 16)
+	off = 12
+	if mdhd.data[0] == 1 {
+		off = 20
+	}
+	t.TimeScale = be.Uint32(mdhd.data[off:])
+	t.Handler = string(hdlr.data[8:12])
+	t.entry = stsd.data[8:]
+	if size := int(be.Uint32(t.entry)); size >= 8 && size <= len(t.entry) {
+		t.entry = t.entry[:size]
+	}
+	return t, nil
+}
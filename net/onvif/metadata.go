@@ -0,0 +1,202 @@
+package onvif
+
+// Parsing of ONVIF metadata stream with video analytics and event notifications, ONVIF Streaming
+// Specification section 5.1.2.1 and Analytics Service Specification.
+
+import (
+	"encoding/xml"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of metadata events.
+const (
+	EventOther        EventKind = iota // Notification not classified otherwise.
+	EventMotion                        // Motion detection state.
+	EventLineCrossing                  // Object crossed a line.
+	EventObjects                       // Objects detected by video analytics in a frame.
+)
+
+type (
+	// EventKind classifies metadata events.
+	EventKind int
+
+	// Rect is a bounding box in normalized coordinates from -1 to 1 unless stream specifies transformation.
+	Rect struct {
+		Left   float64
+		Top    float64
+		Right  float64
+		Bottom float64
+	}
+
+	// Object is an object detected by video analytics.
+	Object struct {
+		ID         string
+		Box        Rect
+		Class      string  // Most likely class, e.g. Human or Vehicle, if reported.
+		Likelihood float64 // Likelihood of the class from 0 to 1.
+	}
+
+	// Event is an event notification or video analytics frame from metadata stream.
+	Event struct {
+		Kind      EventKind
+		Time      time.Time         // Time of event or analytics frame in UTC.
+		Topic     string            // Topic without namespace prefixes, e.g. RuleEngine/CellMotionDetector/Motion.
+		Operation string            // Property operation: Initialized, Changed, or Deleted.
+		Source    map[string]string // Items that identify source, e.g. VideoSourceConfigurationToken and Rule.
+		Data      map[string]string // Items with event data, e.g. IsMotion or ObjectId.
+		Active    bool              // State of motion for motion events.
+		Objects   []Object          // Detected objects with bounding boxes, or the crossing object.
+	}
+
+	// metadataStream mirrors tt:MetadataStream matching elements by local names.
+	metadataStream struct {
+		Frames        []analyticsFrame `xml:"VideoAnalytics>Frame"`
+		Notifications []notification   `xml:"Event>NotificationMessage"`
+	}
+
+	analyticsFrame struct {
+		UtcTime string         `xml:"UtcTime,attr"`
+		Objects []objectRecord `xml:"Object"`
+	}
+
+	objectRecord struct {
+		ID  string `xml:"ObjectId,attr"`
+		Box struct {
+			Left   float64 `xml:"left,attr"`
+			Top    float64 `xml:"top,attr"`
+			Right  float64 `xml:"right,attr"`
+			Bottom float64 `xml:"bottom,attr"`
+		} `xml:"Appearance>Shape>BoundingBox"`
+		Types      []classCandidate `xml:"Appearance>Class>Type"`
+		Candidates []classCandidate `xml:"Appearance>Class>ClassCandidate"`
+	}
+
+	// classCandidate covers both tt:Type of ONVIF 2 and tt:ClassCandidate of ONVIF 1 schema.
+	classCandidate struct {
+		Value      string  `xml:",chardata"`
+		Type       string  `xml:"Type"`
+		Likelihood float64 `xml:"Likelihood"`
+		Attr       float64 `xml:"Likelihood,attr"`
+	}
+
+	notification struct {
+		Topic   string `xml:"Topic"`
+		Message struct {
+			UtcTime           string       `xml:"UtcTime,attr"`
+			PropertyOperation string       `xml:"PropertyOperation,attr"`
+			Source            []simpleItem `xml:"Source>SimpleItem"`
+			Data              []simpleItem `xml:"Data>SimpleItem"`
+		} `xml:"Message>Message"`
+	}
+
+	simpleItem struct {
+		Name  string `xml:"Name,attr"`
+		Value string `xml:"Value,attr"`
+	}
+)
+
+// String returns name of event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventMotion:
+		return "motion"
+	case EventLineCrossing:
+		return "line crossing"
+	case EventObjects:
+		return "objects"
+	}
+	return "other"
+}
+
+// ParseMetadata parses XML document of metadata stream into analytics frames and event notifications in
+// order of appearance within each kind.
+func ParseMetadata(buf []byte) (events []Event, err error) {
+	var doc metadataStream
+	if err = xml.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	for _, f := range doc.Frames {
+		e := Event{Kind: EventObjects, Time: parseTime(f.UtcTime)}
+		for _, o := range f.Objects {
+			obj := Object{ID: o.ID, Box: Rect{o.Box.Left, o.Box.Top, o.Box.Right, o.Box.Bottom}}
+			obj.Class, obj.Likelihood = likeliest(append(o.Types, o.Candidates...))
+			e.Objects = append(e.Objects, obj)
+		}
+		events = append(events, e)
+	}
+	for _, n := range doc.Notifications {
+		e := Event{
+			Time:      parseTime(n.Message.UtcTime),
+			Topic:     trimTopic(n.Topic),
+			Operation: n.Message.PropertyOperation,
+			Source:    items(n.Message.Source),
+			Data:      items(n.Message.Data),
+		}
+		classify(&e)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// parseTime parses UTC time of ONVIF messages, zero time if absent or malformed.
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+	return t
+}
+
+// trimTopic removes namespace prefixes from topic path, e.g. tns1:VideoSource/MotionAlarm.
+func trimTopic(topic string) string {
+	parts := strings.Split(strings.TrimSpace(topic), "/")
+	for i, part := range parts {
+		if j := strings.IndexByte(part, ':'); j >= 0 {
+			parts[i] = part[j+1:]
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func items(list []simpleItem) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(list))
+	for _, item := range list {
+		m[item.Name] = item.Value
+	}
+	return m
+}
+
+// likeliest returns the class with the highest likelihood.
+func likeliest(candidates []classCandidate) (class string, likelihood float64) {
+	for _, c := range candidates {
+		name, l := strings.TrimSpace(c.Type), c.Likelihood
+		if name == "" {
+			name, l = strings.TrimSpace(c.Value), c.Attr
+		}
+		if name != "" && (class == "" || l > likelihood) {
+			class, likelihood = name, l
+		}
+	}
+	return
+}
+
+// classify determines kind of notification by its topic and extracts data of known kinds.
+func classify(e *Event) {
+	switch {
+	case strings.HasSuffix(e.Topic, "/Motion") || strings.HasSuffix(e.Topic, "/MotionAlarm"):
+		e.Kind = EventMotion
+		for _, name := range []string{"IsMotion", "State"} {
+			if active, err := strconv.ParseBool(e.Data[name]); err == nil {
+				e.Active = active
+				break
+			}
+		}
+	case strings.Contains(e.Topic, "LineDetector") || strings.Contains(e.Topic, "LineCross"):
+		e.Kind = EventLineCrossing
+		if id, ok := e.Data["ObjectId"]; ok {
+			e.Objects = []Object{{ID: id}}
+		}
+	}
+}
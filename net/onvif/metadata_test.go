@@ -0,0 +1,117 @@
+package onvif
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtp"
+)
+
+const metadata = `<?xml version="1.0" encoding="UTF-8"?>
+<tt:MetadataStream xmlns:tt="http://www.onvif.org/ver10/schema" xmlns:wsnt="http://docs.oasis-open.org/wsn/b-2" xmlns:tns1="http://www.onvif.org/ver10/topics">
+<tt:VideoAnalytics>
+<tt:Frame UtcTime="2024-03-01T10:15:30.250Z">
+<tt:Object ObjectId="12">
+<tt:Appearance>
+<tt:Shape><tt:BoundingBox left="-0.5" top="0.25" right="0.1" bottom="-0.75"/></tt:Shape>
+<tt:Class><tt:Type Likelihood="0.4">Vehicle</tt:Type><tt:Type Likelihood="0.9">Human</tt:Type></tt:Class>
+</tt:Appearance>
+</tt:Object>
+<tt:Object ObjectId="13">
+<tt:Appearance><tt:Class><tt:ClassCandidate><tt:Type>Animal</tt:Type><tt:Likelihood>0.7</tt:Likelihood></tt:ClassCandidate></tt:Class></tt:Appearance>
+</tt:Object>
+</tt:Frame>
+</tt:VideoAnalytics>
+<tt:Event>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:RuleEngine/CellMotionDetector/Motion</wsnt:Topic>
+<wsnt:Message><tt:Message UtcTime="2024-03-01T10:15:31Z" PropertyOperation="Changed">
+<tt:Source><tt:SimpleItem Name="VideoSourceConfigurationToken" Value="VideoSourceToken"/><tt:SimpleItem Name="Rule" Value="MyMotionDetectorRule"/></tt:Source>
+<tt:Data><tt:SimpleItem Name="IsMotion" Value="true"/></tt:Data>
+</tt:Message></wsnt:Message>
+</wsnt:NotificationMessage>
+<wsnt:NotificationMessage>
+<wsnt:Topic Dialect="http://www.onvif.org/ver10/tev/topicExpression/ConcreteSet">tns1:RuleEngine/LineDetector/Crossed</wsnt:Topic>
+<wsnt:Message><tt:Message UtcTime="2024-03-01T10:15:32Z">
+<tt:Data><tt:SimpleItem Name="ObjectId" Value="12"/></tt:Data>
+</tt:Message></wsnt:Message>
+</wsnt:NotificationMessage>
+<wsnt:NotificationMessage>
+<wsnt:Topic>tns1:Device/Trigger/DigitalInput</wsnt:Topic>
+<wsnt:Message><tt:Message UtcTime="2024-03-01T10:15:33Z"><tt:Data><tt:SimpleItem Name="LogicalState" Value="true"/></tt:Data></tt:Message></wsnt:Message>
+</wsnt:NotificationMessage>
+</tt:Event>
+</tt:MetadataStream>`
+
+func TestParseMetadata(t *testing.T) {
+	events, err := ParseMetadata([]byte(metadata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events, got %d", len(events))
+	}
+	e := events[0]
+	if e.Kind != EventObjects || !e.Time.Equal(time.Date(2024, 3, 1, 10, 15, 30, 250e6, time.UTC)) || len(e.Objects) != 2 {
+		t.Fatalf("Unexpected analytics frame %+v", e)
+	}
+	if o := e.Objects[0]; o.ID != "12" || o.Box != (Rect{-0.5, 0.25, 0.1, -0.75}) || o.Class != "Human" || o.Likelihood != 0.9 {
+		t.Errorf("Unexpected object %+v", o)
+	}
+	if o := e.Objects[1]; o.ID != "13" || o.Class != "Animal" || o.Likelihood != 0.7 {
+		t.Errorf("Unexpected object %+v", o)
+	}
+	e = events[1]
+	if e.Kind != EventMotion || !e.Active || e.Topic != "RuleEngine/CellMotionDetector/Motion" || e.Operation != "Changed" || e.Source["Rule"] != "MyMotionDetectorRule" {
+		t.Errorf("Unexpected motion event %+v", e)
+	}
+	if e = events[2]; e.Kind != EventLineCrossing || len(e.Objects) != 1 || e.Objects[0].ID != "12" || e.Time.Second() != 32 {
+		t.Errorf("Unexpected line crossing event %+v", e)
+	}
+	if e = events[3]; e.Kind != EventOther || e.Topic != "Device/Trigger/DigitalInput" || e.Data["LogicalState"] != "true" {
+		t.Errorf("Unexpected event %+v", e)
+	}
+	if _, err = ParseMetadata([]byte("<tt:MetadataStream>")); err == nil {
+		t.Error("Expected error on truncated document")
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	d := NewDepacketizer()
+	half := len(metadata) / 2
+	if frames, err := d.Push(rtp.NewPacket(107, false, 1, 9000, 1, []byte(metadata[:half]))); frames != nil || err != nil {
+		t.Fatalf("Unexpected frames %+v, %v", frames, err)
+	}
+	frames, err := d.Push(rtp.NewPacket(107, true, 2, 9000, 1, []byte(metadata[half:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || string(frames[0].Data) != metadata {
+		t.Fatalf("XML is not reassembled %+v", frames)
+	}
+	if events, ok := frames[0].Info.([]Event); !ok || len(events) != 4 || len(d.Events) != 4 {
+		t.Errorf("Unexpected events %+v", frames[0].Info)
+	}
+	if e := <-d.Events; e.Kind != EventObjects {
+		t.Errorf("Unexpected first event %+v", e)
+	}
+}
+
+func TestDepacketizerClose(t *testing.T) {
+	d := NewDepacketizer()
+	if _, err := d.Push(rtp.NewPacket(107, true, 1, 9000, 1, []byte(metadata))); err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	d.Close()
+	n := 0
+	for range d.Events {
+		n++
+	}
+	if n != 4 {
+		t.Errorf("expected 4 events before close, got %d", n)
+	}
+	if _, err := d.Push(rtp.NewPacket(107, true, 2, 9000, 1, []byte(metadata))); err != nil || d.Dropped != 4 {
+		t.Errorf("expected events after close to be dropped, got %d, %v", d.Dropped, err)
+	}
+}
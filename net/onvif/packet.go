@@ -0,0 +1,66 @@
+package onvif
+
+import (
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// EventBuffer is the capacity of event channel of depacketizer.
+const EventBuffer = 64
+
+type (
+	// Depacketizer reassembles XML documents of metadata stream by marker bit and parses them into events.
+	// Frames carry XML document and []Event as details.  Events are also sent to the channel so that
+	// application can handle them alongside video.
+	Depacketizer struct {
+		Events  chan Event // Receives parsed events.  Events are dropped when it is full.
+		Dropped int        // Number of events dropped because nobody received them.
+		marker  rtp.MarkerDepacketizer
+		closed  bool
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("vnd.onvif.metadata", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		return NewDepacketizer(), nil
+	})
+}
+
+// NewDepacketizer creates depacketizer of metadata stream with buffered event channel.
+func NewDepacketizer() *Depacketizer {
+	return &Depacketizer{Events: make(chan Event, EventBuffer)}
+}
+
+// Push takes RTP packet and returns frame with XML document completed by it.
+func (d *Depacketizer) Push(p *rtp.Packet) ([]rtp.Frame, error) {
+	frames, err := d.marker.Push(p)
+	for i := range frames {
+		events, e := ParseMetadata(frames[i].Data)
+		if e != nil {
+			err = e
+			continue
+		}
+		frames[i].Info = events
+		for _, ev := range events {
+			if d.closed {
+				d.Dropped++
+				continue
+			}
+			select {
+			case d.Events <- ev:
+			default:
+				d.Dropped++
+			}
+		}
+	}
+	return frames, err
+}
+
+// Close closes event channel so that whoever ranges over it stops, e.g. when feed is gone.  Events parsed
+// afterwards are dropped.  It must not be called concurrently with Push.
+func (d *Depacketizer) Close() {
+	if !d.closed {
+		d.closed = true
+		close(d.Events)
+	}
+}
@@ -0,0 +1,72 @@
+package opus
+
+import (
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// Opus always runs RTP clock and decoder output at 48 kHz regardless of the audio bandwidth, RFC 7587.
+const SampleRate = 48000
+
+// DefaultPreSkip is the number of samples libopus encoder adds at start, 6.5 ms.  RTP does not signal it.
+const DefaultPreSkip = 312
+
+var be = binary.BigEndian
+
+type (
+	// Config holds Opus decoder configuration from format specific parameters in SDP as defined in RFC 7587.
+	Config struct {
+		Channels        int  // 2 if sender is likely to produce stereo audio, sprop-stereo=1, otherwise 1.
+		Stereo          bool // Receiver prefers stereo audio, stereo=1.
+		MaxPlaybackRate int  // Maximum output sampling rate receiver can render, maxplaybackrate.
+		MaxCaptureRate  int  // Maximum input sampling rate of sender, sprop-maxcapturerate.
+		PreSkip         int  // Samples to discard at start of decoding.
+		OutputGain      int  // Gain in Q7.8 dB to apply to output.
+	}
+)
+
+// NewConfig creates configuration from format specific parameters of media.  Absent parameters take defaults
+// of RFC 7587, i.e. mono and 48 kHz.
+func NewConfig(m *sdp.Media) *Config {
+	c := &Config{Channels: 1, MaxPlaybackRate: SampleRate, MaxCaptureRate: SampleRate, PreSkip: DefaultPreSkip}
+	if m.Fmtp["sprop-stereo"] == "1" {
+		c.Channels = 2
+	}
+	c.Stereo = m.Fmtp["stereo"] == "1"
+	if rate, err := strconv.Atoi(m.Fmtp["maxplaybackrate"]); err == nil && rate > 0 && rate < SampleRate {
+		c.MaxPlaybackRate = rate
+	}
+	if rate, err := strconv.Atoi(m.Fmtp["sprop-maxcapturerate"]); err == nil && rate > 0 && rate < SampleRate {
+		c.MaxCaptureRate = rate
+	}
+	return c
+}
+
+// DOps returns payload of Opus specific box for MP4 sample entry as defined in Encapsulation of Opus in ISO
+// Base Media File Format.  Channel mapping family 0 covers mono and stereo.
+func (c *Config) DOps() []byte {
+	buf := make([]byte, 11)
+	buf[1] = byte(c.Channels)
+	be.PutUint16(buf[2:], uint16(c.PreSkip))
+	be.PutUint32(buf[4:], uint32(c.MaxCaptureRate))
+	be.PutUint16(buf[8:], uint16(int16(c.OutputGain)))
+	return buf
+}
+
+// Fmtp formats format specific parameters to offer the stream to a WebRTC peer, e.g. in SDP answer.
+func (c *Config) Fmtp() string {
+	params := []string{"minptime=10", "useinbandfec=1"}
+	if c.Channels == 2 {
+		params = append(params, "stereo=1", "sprop-stereo=1")
+	}
+	if c.MaxPlaybackRate != SampleRate {
+		params = append(params, "maxplaybackrate="+strconv.Itoa(c.MaxPlaybackRate))
+	}
+	if c.MaxCaptureRate != SampleRate {
+		params = append(params, "sprop-maxcapturerate="+strconv.Itoa(c.MaxCaptureRate))
+	}
+	return strings.Join(params, ";")
+}
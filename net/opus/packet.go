@@ -0,0 +1,74 @@
+package opus
+
+import (
+	"errors"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// MaxPacketDuration is the limit of audio in one Opus packet, 120 ms.
+const MaxPacketDuration = SampleRate * 120 / 1000
+
+var (
+	errEmptyPacket   = errors.New("Empty Opus packet")
+	errInvalidPacket = errors.New("Invalid Opus packet")
+)
+
+// Frame durations in samples at 48 kHz for each TOC configuration group of 4: SILK, hybrid, and CELT modes.
+var frameSamples = [32]int{
+	480, 960, 1920, 2880, 480, 960, 1920, 2880, 480, 960, 1920, 2880,
+	480, 960, 480, 960,
+	120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960, 120, 240, 480, 960,
+}
+
+type (
+	// Depacketizer returns each RTP payload as an Opus packet, RFC 7587.  Frames carry packet duration in
+	// samples as details.
+	Depacketizer struct {
+		Config *Config
+	}
+)
+
+func init() {
+	rtp.RegisterDepacketizer("OPUS", func(m *sdp.Media) (rtp.Depacketizer, error) {
+		return NewDepacketizer(m), nil
+	})
+}
+
+// NewDepacketizer creates depacketizer with decoder configuration from format specific parameters of media.
+func NewDepacketizer(m *sdp.Media) *Depacketizer {
+	return &Depacketizer{Config: NewConfig(m)}
+}
+
+// Push takes RTP packet and returns frame with the Opus packet it carries.
+func (d *Depacketizer) Push(p *rtp.Packet) ([]rtp.Frame, error) {
+	n, err := PacketDuration(p.PL)
+	if err != nil {
+		return nil, err
+	}
+	return []rtp.Frame{{TS: p.TS, Data: p.PL, Sync: true, Info: n}}, nil
+}
+
+// PacketDuration returns number of samples at 48 kHz in Opus packet from its TOC byte and frame count, RFC
+// 6716 section 3.1.
+func PacketDuration(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, errEmptyPacket
+	}
+	n := 1
+	switch buf[0] & 3 {
+	case 1, 2:
+		n = 2
+	case 3:
+		if len(buf) < 2 {
+			return 0, errInvalidPacket
+		}
+		n = int(buf[1] & 0x3F)
+	}
+	d := n * frameSamples[buf[0]>>3]
+	if n == 0 || d > MaxPacketDuration {
+		return 0, errInvalidPacket
+	}
+	return d, nil
+}
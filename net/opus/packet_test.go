@@ -0,0 +1,48 @@
+package opus
+
+import (
+	"testing"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+func TestConfig(t *testing.T) {
+	c := NewConfig(&sdp.Media{Fmtp: map[string]string{"sprop-stereo": "1", "maxplaybackrate": "16000"}})
+	if c.Channels != 2 || c.Stereo || c.MaxPlaybackRate != 16000 || c.MaxCaptureRate != SampleRate {
+		t.Errorf("Wrong config %+v", c)
+	}
+	if b := c.DOps(); string(b) != "\x00\x02\x01\x38\x00\x00\xbb\x80\x00\x00\x00" {
+		t.Errorf("Wrong dOps % x", b)
+	}
+	if s := c.Fmtp(); s != "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1;maxplaybackrate=16000" {
+		t.Errorf("Wrong fmtp %s", s)
+	}
+	if c = NewConfig(&sdp.Media{}); c.Channels != 1 || c.MaxPlaybackRate != SampleRate {
+		t.Errorf("Wrong default config %+v", c)
+	}
+}
+
+func TestPacketDuration(t *testing.T) {
+	tests := []struct {
+		pkt []byte
+		n   int
+	}{
+		{[]byte{0x08 | 0}, 960},        // SILK 20 ms.
+		{[]byte{0x78 | 1}, 1920},       // Hybrid 20 ms, two frames.
+		{[]byte{0xF8 | 3, 0x03}, 2880}, // CELT 20 ms, three frames.
+		{[]byte{0x80 | 3, 0x31}, 0},    // CELT 2.5 ms, 49 frames exceed 120 ms.
+		{[]byte{0x18 | 3}, 0},
+		{nil, 0},
+	}
+	for _, test := range tests {
+		if n, err := PacketDuration(test.pkt); n != test.n || (err == nil) != (test.n > 0) {
+			t.Errorf("Packet % x: expected %d, got %d, %v", test.pkt, test.n, n, err)
+		}
+	}
+	d := NewDepacketizer(&sdp.Media{})
+	frames, err := d.Push(rtp.NewPacket(111, true, 1, 4800, 1, []byte{0xFC, 0x01, 0x02}))
+	if err != nil || len(frames) != 1 || frames[0].TS != 4800 || frames[0].Info != 960 {
+		t.Errorf("Unexpected frames %+v, %v", frames, err)
+	}
+}
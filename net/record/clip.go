@@ -0,0 +1,118 @@
+package record
+
+// Event-triggered recording of clips with pre-roll, e.g. on motion or alarm.
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aboukirev/ouro/net/mp4"
+	"github.com/aboukirev/ouro/net/rtsp"
+)
+
+// Longest interval between key frames for which pre-roll is kept.  Buffer is reset if key frames are further apart.
+const maxKeyFrameInterval = 30 * time.Second
+
+var (
+	errNotClipRecorder = errors.New("Recorder is not set up to record clips")
+)
+
+type (
+	// kept is a sample kept in pre-roll buffer.
+	kept struct {
+		t  *track
+		s  mp4.Sample
+		at time.Duration // Clock of the recorder when sample arrived.
+	}
+)
+
+// NewClipRecorder creates recorder that writes files only when triggered.  It keeps recent samples in
+// a buffer so that clip starts with the latest key frame that is at least pre-roll before the trigger.
+func NewClipRecorder(dir string, feeds []*rtsp.Feed, preroll time.Duration) (*Recorder, error) {
+	r, err := NewRecorder(dir, feeds)
+	if err != nil {
+		return nil, err
+	}
+	r.clip = true
+	r.PreRoll = preroll
+	return r, nil
+}
+
+// Trigger starts recording of clip that includes pre-roll and continues for a given duration.  Trigger that
+// arrives while clip is being recorded extends it so that overlapping events end up in a single file.
+// If there is no key frame in the buffer yet, clip starts with the next one.
+func (r *Recorder) Trigger(d time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	if !r.clip {
+		return errNotClipRecorder
+	}
+	if until := r.clock + d; until > r.until {
+		r.until = until
+	}
+	if r.fw != nil {
+		return nil
+	}
+	return r.startClip()
+}
+
+// emitClip writes sample into active clip or keeps it for pre-roll.
+func (r *Recorder) emitClip(t *track, s mp4.Sample) error {
+	if r.fw != nil {
+		if r.clock > r.until {
+			return r.finish()
+		}
+		return r.write(t, s)
+	}
+	r.keep(t, s)
+	if r.clock < r.until {
+		// Trigger is pending until a key frame arrives.
+		return r.startClip()
+	}
+	return nil
+}
+
+// keep adds sample to pre-roll buffer.  Buffer always starts with key frame.
+func (r *Recorder) keep(t *track, s mp4.Sample) {
+	key := t == r.lead && s.Sync
+	if len(r.ring) > 0 && r.clock-r.ring[0].at > r.PreRoll+maxKeyFrameInterval {
+		r.ring = r.ring[:0]
+	}
+	if len(r.ring) == 0 && !key {
+		return
+	}
+	r.ring = append(r.ring, kept{t: t, s: s, at: r.clock})
+	if key {
+		r.trim()
+	}
+}
+
+// trim drops samples that precede the latest key frame which is at least pre-roll old.
+func (r *Recorder) trim() {
+	cut := 0
+	for i, k := range r.ring {
+		if k.t == r.lead && k.s.Sync && r.clock-k.at >= r.PreRoll {
+			cut = i
+		}
+	}
+	r.ring = append(r.ring[:0], r.ring[cut:]...)
+}
+
+// startClip opens file and writes buffered samples into it.
+func (r *Recorder) startClip() error {
+	if len(r.ring) == 0 {
+		return nil
+	}
+	r.trim()
+	at := r.now().Add(r.ring[0].at - r.clock)
+	if err := r.start(at); err != nil || r.fw == nil {
+		return err
+	}
+	for _, k := range r.ring {
+		if err := r.write(k.t, k.s); err != nil {
+			return err
+		}
+	}
+	r.ring = r.ring[:0]
+	return nil
+}
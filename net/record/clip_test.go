@@ -0,0 +1,102 @@
+package record
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/rtsp/rtsptest"
+)
+
+// findBox returns contents of the first box at a given path.
+func findBox(data []byte, path ...string) []byte {
+	for len(data) >= 8 {
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil
+		}
+		if string(data[4:8]) == path[0] {
+			if len(path) == 1 {
+				return data[8:size]
+			}
+			return findBox(data[8:size], path[1:]...)
+		}
+		data = data[size:]
+	}
+	return nil
+}
+
+// videoSamples counts samples in the first track of regular MP4 file.
+func videoSamples(t *testing.T, name string) int {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stsz := findBox(data, "moov", "trak", "mdia", "minf", "stbl", "stsz")
+	if len(stsz) < 12 {
+		t.Fatalf("Missing sample sizes in %s", name)
+	}
+	return int(binary.BigEndian.Uint32(stsz[8:]))
+}
+
+func TestClipRecorder(t *testing.T) {
+	packets, err := rtsptest.LoadPackets("../rtsp/rtsptest/testdata/h264_aac.rtp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		preroll time.Duration
+		frames  int
+	}{
+		{200 * time.Millisecond, 23}, // Second key frame is too recent, clip starts with the first one.
+		{50 * time.Millisecond, 8},   // Clip starts with the second key frame.
+	} {
+		dir := t.TempDir()
+		feeds, _ := rtsp.ParseFeeds(rtsp.ProtoTCP, []byte(rtsptest.SDP))
+		r, err := NewClipRecorder(dir, feeds, tc.preroll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames := 0
+		for _, p := range packets {
+			if err = r.WritePacket(rtsp.RawPacket{Channel: byte(p.Track * 2), Payload: p.Data}); err != nil {
+				t.Fatal(err)
+			}
+			if p.Track != 0 || p.Data[1]&0x80 == 0 {
+				continue
+			}
+			frames++
+			switch frames {
+			case 20, 22:
+				// Overlapping triggers produce a single clip.
+				err = r.Trigger(90 * time.Millisecond)
+			case 29:
+				// There is no key frame to start clip with after the previous one ended.
+				err = r.Trigger(time.Second)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		names, _ := filepath.Glob(filepath.Join(dir, "*"))
+		if len(names) != 1 {
+			t.Fatalf("Expected single clip, got %v", names)
+		}
+		if n := videoSamples(t, names[0]); n != tc.frames {
+			t.Errorf("Pre-roll %v: expected %d frames, got %d", tc.preroll, tc.frames, n)
+		}
+	}
+}
+
+func TestTriggerContinuous(t *testing.T) {
+	r := newRecorder(t, t.TempDir())
+	if err := r.Trigger(time.Second); err != errNotClipRecorder {
+		t.Errorf("Expected error, got %v", err)
+	}
+}
@@ -0,0 +1,440 @@
+package record
+
+// Recording of camera sessions into a series of MP4 files.
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aboukirev/ouro/net/aac"
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/mp4"
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+const (
+	// DefaultLayout is the default layout of wall-clock start time in file names.
+	DefaultLayout = "2006-01-02T15-04-05"
+	// DefaultFragmentDuration is the default duration of fragments written to disk while recording.
+	DefaultFragmentDuration = time.Second
+	// Extension of files being recorded.
+	partExt = ".part"
+	// Number of samples in AAC access unit.
+	aacFrameSize = 1024
+)
+
+var (
+	errNoRecordableFeeds = errors.New("No feeds with supported codecs to record")
+)
+
+type (
+	// Recorder writes media feeds of a session into MP4 files named by wall-clock start time.
+	// Files are rotated on video key frame once maximum duration or size is reached.
+	//
+	// While recording, file is written as fragmented MP4 with ".part" extension so that no more than
+	// one fragment is lost if process crashes.  Complete file is either renamed or converted into a regular
+	// MP4 with movie box in front (faststart).  Recover finalizes files left behind by a crash.
+	Recorder struct {
+		sync.Mutex
+		Dir              string        // Directory for recorded files.
+		Prefix           string        // Prefix of file names, e.g. camera name.
+		Layout           string        // Layout of start time in file names.  DefaultLayout if empty.
+		Fragmented       bool          // Keep files fragmented instead of converting them to regular MP4.
+		MaxDuration      time.Duration // Duration after which file is rotated.  No limit if zero.
+		MaxSize          int64         // Size in bytes after which file is rotated.  No limit if zero.
+		FragmentDuration time.Duration // Duration of fragments.  DefaultFragmentDuration if zero.
+		PreRoll          time.Duration // Duration of media before trigger to include in clip.
+		Now              func() time.Time
+		tracks           []*track
+		lead             *track // Track that drives rotation and fragmentation, video if present.
+		file             *os.File
+		fw               *mp4.FragmentWriter
+		name             string // Name of the final file.
+		fragment         [][]mp4.Sample
+		buffered         int64         // Size of samples in current fragment.
+		clock            time.Duration // Duration of lead track samples since recording started.
+		clip             bool          // Files are written only when triggered.
+		until            time.Duration // Clock at which triggered clip ends.
+		ring             []kept        // Recent samples starting with key frame kept for pre-roll.
+	}
+
+	// track keeps depacketizer and timing state of recorded feed.
+	track struct {
+		ch       byte
+		video    bool
+		h264     *h264.Assembler
+		aac      *aac.Depacketizer
+		mp4      *mp4.Track
+		last     *sample // Sample waiting for the next one to know its duration.
+		duration uint32  // Duration of the previous sample.
+		elapsed  uint64  // Duration of samples in current file.
+		queued   uint64  // Duration of samples in current fragment.
+	}
+
+	sample struct {
+		ts     uint32
+		data   []byte
+		sync   bool
+		format bool // Stream format changes with the sample.
+	}
+)
+
+// NewRecorder creates recorder for session feeds that carry H.264 video or AAC audio.  Other feeds are ignored.
+func NewRecorder(dir string, feeds []*rtsp.Feed) (*Recorder, error) {
+	r := &Recorder{Dir: dir}
+	for _, f := range feeds {
+		t := &track{ch: f.Channel()}
+		switch f.Type {
+		case sdp.H264:
+			t.video = true
+			t.h264 = h264.NewAssembler()
+			t.h264.SetFmtp(f.Fmtp)
+			if f.Sets != nil {
+				// Share parameter sets with the feed so that it sees in-band updates.
+				t.h264.Sets = f.Sets
+			}
+			for _, b := range f.SpropParameterSets {
+				t.h264.Sets.ParseSprop(b)
+			}
+		case sdp.AAC:
+			mt, err := mp4.NewAACTrack(f.Config)
+			if err != nil {
+				continue
+			}
+			t.mp4 = mt
+			t.aac = aac.NewDepacketizer(&f.Media)
+			t.duration = aacFrameSize
+		default:
+			continue
+		}
+		r.tracks = append(r.tracks, t)
+		if t.video && r.lead == nil {
+			r.lead = t
+		}
+	}
+	if len(r.tracks) == 0 {
+		return nil, errNoRecordableFeeds
+	}
+	if r.lead == nil {
+		r.lead = r.tracks[0]
+	}
+	return r, nil
+}
+
+// WritePacket depacketizes RTP packet from session and records resulting samples.  Packets on channels
+// that do not belong to recorded feeds, e.g. RTCP, are ignored.
+func (r *Recorder) WritePacket(pkt rtsp.RawPacket) error {
+	r.Lock()
+	defer r.Unlock()
+	var t *track
+	for _, tt := range r.tracks {
+		if tt.ch == pkt.Channel {
+			t = tt
+		}
+	}
+	if t == nil || len(pkt.Payload) < rtp.HeaderSize {
+		return nil
+	}
+	p, err := rtp.Unpack(pkt.Payload)
+	if err != nil {
+		return err
+	}
+	if t.video {
+		units, err := t.h264.Push(p.PL, p.SN, p.TS, p.M())
+		if err != nil {
+			return err
+		}
+		for _, au := range units {
+			if err = r.add(t, &sample{ts: au.TS, data: au.AVC(), sync: au.IsIDR(), format: au.Format != nil}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	units, err := t.aac.Unpack(p.PL, p.M())
+	if err != nil {
+		return err
+	}
+	for i, au := range units {
+		if err = r.add(t, &sample{ts: p.TS + uint32(i*aacFrameSize), data: au, sync: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// add queues sample and emits the previous one now that its duration is known.
+func (r *Recorder) add(t *track, s *sample) error {
+	prev := t.last
+	t.last = s
+	if prev == nil {
+		return nil
+	}
+	// Keep previous duration if timestamps do not advance or jump, e.g. after discontinuity.
+	if d := s.ts - prev.ts; d > 0 && d < 10*t.timeScale() {
+		t.duration = d
+	}
+	if prev.format {
+		if err := r.reformat(); err != nil {
+			return err
+		}
+	}
+	return r.emit(t, mp4.Sample{Data: prev.data, Duration: t.duration, Sync: prev.sync})
+}
+
+// reformat ends current file or clip when stream format changes so that the next one starts with
+// initialization segment that has new parameter sets.  Pre-roll of the old format is dropped.
+func (r *Recorder) reformat() error {
+	r.ring = r.ring[:0]
+	if r.fw == nil {
+		return nil
+	}
+	return r.finish()
+}
+
+func (t *track) timeScale() uint32 {
+	if t.mp4 != nil {
+		return t.mp4.TimeScale
+	}
+	return 90000
+}
+
+// emit writes sample into current file, starting new file or fragment as necessary.
+func (r *Recorder) emit(t *track, s mp4.Sample) (err error) {
+	if t == r.lead {
+		r.clock += t.toDuration(uint64(s.Duration))
+	}
+	if r.clip {
+		return r.emitClip(t, s)
+	}
+	if t == r.lead && s.Sync {
+		if r.fw != nil && r.full() {
+			if err = r.finish(); err != nil {
+				return
+			}
+		}
+		if r.fw == nil {
+			if err = r.start(r.now()); err != nil {
+				return
+			}
+		}
+	}
+	if r.fw == nil {
+		// File starts with key frame of the lead track.
+		return nil
+	}
+	return r.write(t, s)
+}
+
+// write adds sample to current fragment.  Fragment is written to file first if it is long enough.
+func (r *Recorder) write(t *track, s mp4.Sample) error {
+	if t == r.lead && t.queued >= uint64(r.fragmentDuration().Seconds()*float64(t.timeScale())) {
+		if err := r.flush(); err != nil {
+			return err
+		}
+	}
+	r.append(t, s)
+	return nil
+}
+
+func (r *Recorder) append(t *track, s mp4.Sample) {
+	for i, tt := range r.tracks {
+		if tt == t {
+			r.fragment[i] = append(r.fragment[i], s)
+		}
+	}
+	r.buffered += int64(len(s.Data))
+	t.elapsed += uint64(s.Duration)
+	t.queued += uint64(s.Duration)
+}
+
+// toDuration converts duration in track time scale to time.
+func (t *track) toDuration(d uint64) time.Duration {
+	return time.Duration(float64(d) / float64(t.timeScale()) * float64(time.Second))
+}
+
+// full reports whether current file has reached maximum duration or size.
+func (r *Recorder) full() bool {
+	elapsed := r.lead.toDuration(r.lead.elapsed)
+	return (r.MaxDuration > 0 && elapsed >= r.MaxDuration) || (r.MaxSize > 0 && r.fw.Size()+r.buffered >= r.MaxSize)
+}
+
+func (r *Recorder) fragmentDuration() time.Duration {
+	if r.FragmentDuration > 0 {
+		return r.FragmentDuration
+	}
+	return DefaultFragmentDuration
+}
+
+func (r *Recorder) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// start opens a new file named by wall-clock time of its first sample.  File is not opened until
+// configuration of video track is known.
+func (r *Recorder) start(at time.Time) (err error) {
+	tracks := make([]*mp4.Track, len(r.tracks))
+	for i, t := range r.tracks {
+		if t.video {
+			if t.mp4, err = mp4.NewH264Track(t.h264.Sets.Units()); err != nil {
+				return nil // Wait for the next key frame with in-band parameter sets.
+			}
+		}
+		tracks[i] = t.mp4
+	}
+	layout := r.Layout
+	if layout == "" {
+		layout = DefaultLayout
+	}
+	if err = os.MkdirAll(r.Dir, 0755); err != nil {
+		return
+	}
+	// Files started within the same second get a sequence number.
+	base := filepath.Join(r.Dir, r.Prefix+at.Format(layout))
+	r.name = base + ".mp4"
+	for i := 1; exists(r.name) || exists(r.name+partExt); i++ {
+		r.name = base + "-" + strconv.Itoa(i) + ".mp4"
+	}
+	if r.file, err = os.Create(r.name + partExt); err != nil {
+		return
+	}
+	if r.fw, err = mp4.NewFragmentWriter(r.file, tracks); err != nil {
+		r.file.Close()
+		r.file = nil
+		r.fw = nil
+		return
+	}
+	r.fragment = make([][]mp4.Sample, len(r.tracks))
+	r.buffered = 0
+	for _, t := range r.tracks {
+		t.elapsed = 0
+		t.queued = 0
+	}
+	return nil
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// flush writes current fragment to file.
+func (r *Recorder) flush() error {
+	empty := true
+	for _, list := range r.fragment {
+		empty = empty && len(list) == 0
+	}
+	if empty {
+		return nil
+	}
+	err := r.fw.WriteFragment(r.fragment)
+	for i := range r.fragment {
+		r.fragment[i] = r.fragment[i][:0]
+	}
+	r.buffered = 0
+	for _, t := range r.tracks {
+		t.queued = 0
+	}
+	return err
+}
+
+// finish writes the last fragment, closes file and finalizes it.
+func (r *Recorder) finish() error {
+	err := r.flush()
+	if e := r.file.Close(); err == nil {
+		err = e
+	}
+	r.file = nil
+	r.fw = nil
+	if err != nil {
+		return err
+	}
+	return r.finalize(r.name+partExt, r.name)
+}
+
+// finalize turns fragmented file being recorded into a complete one.
+func (r *Recorder) finalize(part, name string) error {
+	if r.Fragmented {
+		return os.Rename(part, name)
+	}
+	src, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = mp4.Defragment(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(part)
+}
+
+// Close records pending samples and finalizes current file, e.g. on TEARDOWN.
+func (r *Recorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+	if r.fw == nil {
+		return nil
+	}
+	for _, t := range r.tracks {
+		if t.last != nil {
+			r.append(t, mp4.Sample{Data: t.last.data, Duration: t.duration, Sync: t.last.sync})
+			t.last = nil
+		}
+	}
+	return r.finish()
+}
+
+// Recover finalizes files left incomplete in the directory, e.g. after crash.  Data is kept up to the last
+// complete fragment.  Files without any complete fragment are removed.  It returns names of recovered files.
+func (r *Recorder) Recover() (names []string, err error) {
+	r.Lock()
+	defer r.Unlock()
+	parts, err := filepath.Glob(filepath.Join(r.Dir, r.Prefix+"*.mp4"+partExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range parts {
+		if r.file != nil && part == r.file.Name() {
+			continue
+		}
+		f, err := os.Open(part)
+		if err != nil {
+			return names, err
+		}
+		size, fragments, err := mp4.Scan(f)
+		f.Close()
+		if err != nil || fragments == 0 {
+			if err = os.Remove(part); err != nil {
+				return names, err
+			}
+			continue
+		}
+		if err = os.Truncate(part, size); err != nil {
+			return names, err
+		}
+		name := strings.TrimSuffix(part, partExt)
+		if err = r.finalize(part, name); err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
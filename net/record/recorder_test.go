@@ -0,0 +1,175 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/mp4"
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/rtsp/rtsptest"
+)
+
+// newRecorder creates recorder for fixture stream with clock advancing a second on every call.
+func newRecorder(t *testing.T, dir string) *Recorder {
+	feeds, err := rtsp.ParseFeeds(rtsp.ProtoTCP, []byte(rtsptest.SDP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRecorder(dir, feeds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+	r.Prefix = "cam-"
+	return r
+}
+
+func writePackets(t *testing.T, r *Recorder) {
+	packets, err := rtsptest.LoadPackets("../rtsp/rtsptest/testdata/h264_aac.rtp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range packets {
+		if err := r.WritePacket(rtsp.RawPacket{Channel: byte(p.Track * 2), Payload: p.Data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// topBoxes lists types of top level boxes in the file.
+func topBoxes(t *testing.T, name string) (types []string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for len(data) >= 8 {
+		size := binary.BigEndian.Uint32(data)
+		types = append(types, string(data[4:8]))
+		if size < 8 || int(size) > len(data) {
+			t.Fatalf("Malformed box %s of size %d in %s", data[4:8], size, name)
+		}
+		data = data[size:]
+	}
+	return
+}
+
+func TestRecorderRotation(t *testing.T) {
+	dir := t.TempDir()
+	r := newRecorder(t, dir)
+	r.MaxDuration = 400 * time.Millisecond
+	r.FragmentDuration = 200 * time.Millisecond
+	writePackets(t, r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"cam-2020-01-02T03-04-06.mp4", "cam-2020-01-02T03-04-07.mp4"} {
+		types := topBoxes(t, filepath.Join(dir, name))
+		if len(types) != 3 || types[0] != "ftyp" || types[1] != "moov" || types[2] != "mdat" {
+			t.Errorf("Expected faststart layout in %s, got %v", name, types)
+		}
+	}
+	if parts, _ := filepath.Glob(filepath.Join(dir, "*"+partExt)); len(parts) != 0 {
+		t.Errorf("Left over files %v", parts)
+	}
+}
+
+func TestRecorderFragmented(t *testing.T) {
+	dir := t.TempDir()
+	r := newRecorder(t, dir)
+	r.Fragmented = true
+	r.FragmentDuration = 200 * time.Millisecond
+	writePackets(t, r)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "cam-2020-01-02T03-04-06.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, fragments, err := mp4.Scan(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 30 frames at 30 fps split into fragments of 200ms.
+	if fragments != 5 {
+		t.Errorf("Expected 5 fragments, got %d", fragments)
+	}
+}
+
+func TestRecorderRecover(t *testing.T) {
+	dir := t.TempDir()
+	r := newRecorder(t, dir)
+	r.FragmentDuration = 200 * time.Millisecond
+	writePackets(t, r)
+	// Simulate crash: file is left behind with incomplete last fragment.
+	part := r.file.Name()
+	r.fw.WriteFragment(r.fragment)
+	r.file.Close()
+	fi, err := os.Stat(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(part, fi.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := newRecorder(t, dir).Recover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || filepath.Base(names[0]) != "cam-2020-01-02T03-04-06.mp4" {
+		t.Fatalf("Unexpected recovered files %v", names)
+	}
+	if types := topBoxes(t, names[0]); len(types) != 3 || types[1] != "moov" {
+		t.Errorf("Expected faststart layout, got %v", types)
+	}
+	if _, err = os.Stat(part); !os.IsNotExist(err) {
+		t.Error("Incomplete file has not been removed")
+	}
+}
+
+func TestRecorderFormatChange(t *testing.T) {
+	dir := t.TempDir()
+	r := newRecorder(t, dir)
+	packets, err := rtsptest.LoadPackets("../rtsp/rtsptest/testdata/h264_aac.rtp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Second in-band SPS raises level as if camera was reconfigured.
+	seen := 0
+	for _, p := range packets {
+		data := p.Data
+		if p.Track == 0 && data[12]&0x1F == 7 {
+			if seen++; seen == 2 {
+				data = append([]byte{}, data...)
+				data[15] = 0x28
+			}
+		}
+		if err := r.WritePacket(rtsp.RawPacket{Channel: byte(p.Track * 2), Payload: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.mp4"))
+	if len(names) != 2 {
+		t.Fatalf("Expected new file after format change, got %v", names)
+	}
+	data, err := os.ReadFile(names[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := bytes.Index(data, []byte("avcC")); i < 0 || data[i+7] != 0x28 {
+		t.Error("New file does not have new parameter sets")
+	}
+}
@@ -0,0 +1,104 @@
+package rtp
+
+// Depacketizers extract media frames from RTP payloads.  Codec packages register them by SDP encoding name.
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+// MaxFrameSize is the limit of frame reassembled from payloads of several packets.
+const MaxFrameSize = 1 << 20
+
+type (
+	// Frame is a unit of media extracted from one or more RTP packets, e.g. video access unit or audio frame.
+	Frame struct {
+		TS   uint32      // RTP timestamp
+		Data []byte      // Payload in codec specific format, e.g. length prefixed NAL units for H.264.
+		Sync bool        // Frame can be decoded without preceding ones.
+		Info interface{} // Codec specific details, e.g. *h264.AccessUnit.
+	}
+
+	// Depacketizer extracts frames from RTP packets of a media stream.  Packets are pushed in order of
+	// arrival and frames are returned once complete.
+	Depacketizer interface {
+		Push(p *Packet) ([]Frame, error)
+	}
+
+	// DepacketizerFactory creates depacketizer for a media stream described in SDP.
+	DepacketizerFactory func(m *sdp.Media) (Depacketizer, error)
+
+	// rawDepacketizer returns each payload as a frame, e.g. for G.711 or Opus.
+	rawDepacketizer struct{}
+
+	// MarkerDepacketizer concatenates payloads with the same timestamp until marker bit, e.g. for ONVIF
+	// metadata in XML.
+	MarkerDepacketizer struct {
+		ts  uint32
+		buf []byte
+	}
+)
+
+var (
+	mu            sync.RWMutex
+	depacketizers = map[string]DepacketizerFactory{
+		"PCMU":               newRawDepacketizer,
+		"PCMA":               newRawDepacketizer,
+		"OPUS":               newRawDepacketizer,
+		"VND.ONVIF.METADATA": newMarkerDepacketizer,
+	}
+)
+
+// RegisterDepacketizer makes depacketizer available for the encoding name.  Names are case insensitive.
+// Registering the same name again replaces the factory.
+func RegisterDepacketizer(name string, factory DepacketizerFactory) {
+	mu.Lock()
+	defer mu.Unlock()
+	depacketizers[strings.ToUpper(name)] = factory
+}
+
+// NewDepacketizer creates depacketizer registered for encoding name of the media.  It returns nil
+// depacketizer without error if none is registered.
+func NewDepacketizer(m *sdp.Media) (Depacketizer, error) {
+	mu.RLock()
+	factory, ok := depacketizers[strings.ToUpper(m.EncodingName)]
+	mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return factory(m)
+}
+
+func newRawDepacketizer(m *sdp.Media) (Depacketizer, error) {
+	return rawDepacketizer{}, nil
+}
+
+// Push returns payload of the packet as a frame.
+func (rawDepacketizer) Push(p *Packet) ([]Frame, error) {
+	if len(p.PL) == 0 {
+		return nil, nil
+	}
+	return []Frame{{TS: p.TS, Data: p.PL, Sync: true}}, nil
+}
+
+func newMarkerDepacketizer(m *sdp.Media) (Depacketizer, error) {
+	return &MarkerDepacketizer{}, nil
+}
+
+// Push accumulates payload and returns frame upon marker bit.  Partial frame is dropped when timestamp
+// changes before marker bit, e.g. after packet loss, or when it grows past MaxFrameSize.
+func (d *MarkerDepacketizer) Push(p *Packet) ([]Frame, error) {
+	if d.ts != p.TS || len(d.buf)+len(p.PL) > MaxFrameSize {
+		d.buf = d.buf[:0]
+	}
+	d.ts = p.TS
+	d.buf = append(d.buf, p.PL...)
+	if !p.M() || len(d.buf) == 0 {
+		return nil, nil
+	}
+	data := d.buf
+	d.buf = nil
+	return []Frame{{TS: p.TS, Data: data, Sync: true}}, nil
+}
@@ -0,0 +1,34 @@
+package rtp
+
+import (
+	"testing"
+
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+func TestMarkerDepacketizer(t *testing.T) {
+	d, err := NewDepacketizer(&sdp.Media{EncodingName: "vnd.onvif.metadata"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := []*Packet{
+		NewPacket(107, false, 1, 100, 1, []byte("<a>")),
+		NewPacket(107, false, 2, 200, 1, []byte("<b>")), // Previous frame lost its end.
+		NewPacket(107, true, 3, 200, 1, []byte("</b>")),
+		NewPacket(107, true, 4, 300, 1, []byte("<c/>")),
+	}
+	var frames []Frame
+	for _, p := range packets {
+		out, err := d.Push(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, out...)
+	}
+	if len(frames) != 2 || string(frames[0].Data) != "<b></b>" || frames[0].TS != 200 || string(frames[1].Data) != "<c/>" {
+		t.Errorf("Unexpected frames %+v", frames)
+	}
+	if d, err = NewDepacketizer(&sdp.Media{EncodingName: "X-UNKNOWN"}); d != nil || err != nil {
+		t.Errorf("Unexpected depacketizer %T, %v", d, err)
+	}
+}
@@ -112,15 +112,41 @@ func (p *Packet) Pack() []byte {
 	if p == nil {
 		return nil
 	}
-	sz := uint16(HeaderSize + p.CC()*4)
+	sz := HeaderSize + int(p.CC())*4
 	if p.X() {
-		sz = sz + 4*(1+p.XL)
+		sz = sz + 4*(1+int(p.XL))
 	}
-	b := make([]byte, sz)
+	b := make([]byte, sz, sz+len(p.PL))
 	b[0] = p.VPXCC
 	b[1] = p.MPT
 	be.PutUint16(b[2:], p.SN)
 	be.PutUint32(b[4:], p.TS)
-	// TODO: Finish this
-	return b
+	be.PutUint32(b[8:], p.SSRC)
+	off := HeaderSize
+	for i := 0; i < int(p.CC()) && i < len(p.CSRC); i++ {
+		be.PutUint32(b[off:], p.CSRC[i])
+		off += 4
+	}
+	if p.X() {
+		be.PutUint16(b[off:], p.XH)
+		be.PutUint16(b[off+2:], p.XL)
+		copy(b[off+4:], p.XD)
+	}
+	return append(b, p.PL...)
+}
+
+// NewPacket creates RTP packet with given payload type, marker, sequence number, timestamp, and SSRC.
+func NewPacket(pt byte, marker bool, sn uint16, ts uint32, ssrc uint32, payload []byte) *Packet {
+	p := &Packet{
+		VPXCC: RtpVersion,
+		MPT:   pt & 0x7F,
+		SN:    sn,
+		TS:    ts,
+		SSRC:  ssrc,
+		PL:    payload,
+	}
+	if marker {
+		p.MPT |= 0x80
+	}
+	return p
 }
@@ -0,0 +1,169 @@
+package rtsp
+
+// Server side of Basic and Digest authentication.
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultNonceLifetime is how long Digest nonce stays valid unless authenticator specifies otherwise.
+const DefaultNonceLifetime = 5 * time.Minute
+
+type (
+	// Authenticator verifies credentials clients provide in Authorization header.
+	Authenticator struct {
+		Realm         string
+		Users         map[string]string // Passwords by user name.
+		Digest        bool              // Challenge clients with Digest rather than Basic authentication.
+		NonceLifetime time.Duration     // How long nonce stays valid.  DefaultNonceLifetime if zero.
+	}
+
+	// Nonce is Digest nonce issued to a single client connection.  Nonce expires after a while and nonce
+	// counts may not be reused so that captured Authorization header cannot be replayed.  Zero value is ready
+	// to use.
+	Nonce struct {
+		value  string
+		issued time.Time
+		count  uint64 // The highest nonce count client has used.
+		seen   uint64 // Bit i is set if nonce count that is i less than the highest one has been used.
+		stale  bool   // Client used expired nonce with valid credentials.
+	}
+)
+
+// NewAuthenticator creates authenticator for a realm and a set of users.
+func NewAuthenticator(realm string, users map[string]string, digest bool) *Authenticator {
+	return &Authenticator{Realm: realm, Users: users, Digest: digest}
+}
+
+// Challenge returns value for WWW-Authenticate header.  Digest challenge issues a new nonce to the
+// connection if it has none or it has expired.
+func (a *Authenticator) Challenge(n *Nonce) string {
+	if !a.Digest {
+		return "Basic realm=\"" + a.Realm + "\""
+	}
+	if n.value == "" || a.expired(n) {
+		b := make([]byte, 16)
+		rand.Read(b)
+		*n = Nonce{value: hex.EncodeToString(b), issued: time.Now(), stale: n.stale}
+	}
+	challenge := "Digest realm=\"" + a.Realm + "\", nonce=\"" + n.value + "\", algorithm=MD5, qop=\"auth\""
+	if n.stale {
+		challenge += ", stale=true"
+		n.stale = false
+	}
+	return challenge
+}
+
+func (a *Authenticator) expired(n *Nonce) bool {
+	lifetime := a.NonceLifetime
+	if lifetime <= 0 {
+		lifetime = DefaultNonceLifetime
+	}
+	return time.Since(n.issued) > lifetime
+}
+
+// Verify checks value of Authorization header of a request with nonce issued to the connection.
+// Digest URI must be that of the request or of the aggregate resource it belongs to.
+func (a *Authenticator) Verify(n *Nonce, req *Request) bool {
+	auth := strings.TrimSpace(req.Header.Get(HeaderAuthorization))
+	if strings.HasPrefix(auth, "Basic ") {
+		if a.Digest {
+			// Do not allow downgrade to Basic when Digest is required.
+			return false
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(auth[6:]))
+		if err != nil {
+			return false
+		}
+		creds := strings.SplitN(string(b), ":", 2)
+		if len(creds) != 2 {
+			return false
+		}
+		password, ok := a.Users[creds[0]]
+		return ok && subtle.ConstantTimeCompare([]byte(password), []byte(creds[1])) == 1
+	}
+	if !a.Digest || !strings.HasPrefix(auth, "Digest ") {
+		return false
+	}
+	params := parseAuthParams(auth[7:])
+	password, ok := a.Users[params["username"]]
+	if !ok || n.value == "" || params["nonce"] != n.value || params["realm"] != a.Realm || !coversURI(params["uri"], req.URI) {
+		return false
+	}
+	alg := params["algorithm"]
+	if _, ok := algorithms[strings.ToLower(alg)]; alg != "" && !ok {
+		return false
+	}
+	ha1 := hashhex(alg, colonnade(params["username"], a.Realm, password))
+	if strings.HasSuffix(strings.ToLower(alg), "-sess") {
+		ha1 = hashhex(alg, colonnade(ha1, n.value, params["cnonce"]))
+	}
+	ha2 := hashhex(alg, colonnade(req.Verb, params["uri"]))
+	var expected string
+	qop := params["qop"]
+	if qop != "" {
+		expected = hashhex(alg, colonnade(ha1, n.value, params["nc"], params["cnonce"], qop, ha2))
+	} else {
+		expected = hashhex(alg, colonnade(ha1, n.value, ha2))
+	}
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		return false
+	}
+	if a.expired(n) {
+		// Credentials are fine.  Client should retry with a new nonce.
+		n.stale = true
+		return false
+	}
+	if qop != "" {
+		nc, err := strconv.ParseUint(params["nc"], 16, 64)
+		return err == nil && n.use(nc)
+	}
+	return true
+}
+
+// use marks nonce count as used.  It fails if the count has been used already or is too old to tell.
+// Counts may arrive slightly out of order when requests are sent from several goroutines.
+func (n *Nonce) use(nc uint64) bool {
+	switch {
+	case nc > n.count:
+		if shift := nc - n.count; shift < 64 {
+			n.seen = n.seen<<shift | 1
+		} else {
+			n.seen = 1
+		}
+		n.count = nc
+		return true
+	case n.count-nc < 64 && n.seen&(1<<(n.count-nc)) == 0:
+		n.seen |= 1 << (n.count - nc)
+		return true
+	}
+	return false
+}
+
+// coversURI reports whether Digest URI is the request URI or its parent, e.g. aggregate URI of presentation
+// used by clients to authorize SETUP of individual tracks.
+func coversURI(uri, reqURI string) bool {
+	if uri == "" || !strings.HasPrefix(reqURI, uri) {
+		return false
+	}
+	return len(reqURI) == len(uri) || strings.HasSuffix(uri, "/") || reqURI[len(uri)] == '/'
+}
+
+// parseAuthParams splits comma separated list of authentication parameters into a map with lower case keys.
+func parseAuthParams(value string) map[string]string {
+	params := make(map[string]string)
+	for _, item := range splitList(value) {
+		keyval := strings.SplitN(item, "=", 2)
+		if len(keyval) != 2 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(keyval[0]))] = strings.Trim(strings.TrimSpace(keyval[1]), "\"")
+	}
+	return params
+}
@@ -0,0 +1,55 @@
+package rtsp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator(t *testing.T) {
+	a := NewAuthenticator("camera", map[string]string{"admin": "secret"}, true)
+	base := "rtsp://10.0.0.1/live"
+	var n, other Nonce
+	challenge := a.Challenge(&n)
+	// Algorithm is a token, not a quoted string.
+	if !strings.Contains(challenge, ", algorithm=MD5, ") {
+		t.Errorf("Unexpected challenge %s", challenge)
+	}
+	digest, err := NewDigest(base, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := digest.Authenticate("admin", "secret")
+	request := func(verb, uri, value string) *Request {
+		req := &Request{Verb: verb, URI: uri, Header: make(MessageHeader)}
+		req.Header.Set(HeaderAuthorization, value)
+		return req
+	}
+
+	req := request(VerbDescribe, base, auth(VerbDescribe, nil))
+	if !a.Verify(&n, req) {
+		t.Fatal("Valid credentials are rejected")
+	}
+	if a.Verify(&n, req) {
+		t.Error("Replayed nonce count is accepted")
+	}
+	if a.Verify(&other, request(VerbDescribe, base, auth(VerbDescribe, nil))) {
+		t.Error("Nonce of another connection is accepted")
+	}
+	if !a.Verify(&n, request(VerbSetup, base+"/trackID=1", auth(VerbSetup, nil))) {
+		t.Error("Aggregate URI is rejected for a track")
+	}
+	if a.Verify(&n, request(VerbDescribe, "rtsp://10.0.0.1/other", auth(VerbDescribe, nil))) {
+		t.Error("Authorization for another resource is accepted")
+	}
+
+	// Expired nonce with valid credentials is reported stale and replaced.
+	old := n.value
+	n.issued = time.Now().Add(-2 * DefaultNonceLifetime)
+	if a.Verify(&n, request(VerbPlay, base, auth(VerbPlay, nil))) {
+		t.Error("Expired nonce is accepted")
+	}
+	if challenge := a.Challenge(&n); !strings.Contains(challenge, "stale=true") || n.value == old {
+		t.Errorf("Expected stale challenge with a new nonce, got %s", challenge)
+	}
+}
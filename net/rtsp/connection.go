@@ -1,10 +1,10 @@
 package rtsp
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
+	"log"
 	"math/rand"
 	"net"
 	"net/url"
@@ -16,25 +16,38 @@ import (
 type (
 	// Conn encapsulates low level network connection and hides buffering and packetization.
 	Conn struct {
-		conn      *net.TCPConn   // Main in/out connection in regular RTSP, GET connection in RTSP over HTTP
-		post      *net.TCPConn   // POST connection in RTSP over HTTP
-		rdr       *bufio.Reader  // For line-oriented text protocol
+		*Reader                  // Splits incoming stream into messages and interleaved packets
+		conn      net.Conn       // Main in/out connection in regular RTSP, GET connection in RTSP over HTTP
+		post      net.Conn       // POST connection in RTSP over HTTP
 		connected bool           // Indicates that POST has been issued for RTSP over HTTP connection
 		Data      chan RawPacket // Channel to feed incoming data and control packet to for further processing
 		guid      string
 		Proto     int
 		Timeout   time.Duration
-		URL       *url.URL    // Parsed out original URI with user credentials.
-		BaseURI   string      // Formatted URI without user credentials.
-		addr      *net.IPAddr // Local address for UDP listeners
-		sinks     []udpsink   // UDP listeners, 2 per media stream: data and control
+		URL       *url.URL  // Parsed out original URI with user credentials.
+		BaseURI   string    // Formatted URI without user credentials.
+		sinks     []udpsink // UDP listeners, 2 per media stream: data and control
+		accept    func(ch byte, payload []byte) bool
+		// Tap, if set, observes all traffic on connection, e.g. to capture session into a file.  It is called
+		// from multiple goroutines and must not retain data.  Set it before session is attached.
+		Tap func(seg Segment)
+	}
+
+	// Segment is a piece of traffic on connection: bytes of RTSP stream or RTP/RTCP datagram exchanged over UDP.
+	Segment struct {
+		At      time.Time
+		In      bool // Received from the peer rather than sent to it.
+		UDP     bool // Datagram rather than part of RTSP stream.
+		Channel byte // Channel of datagram.
+		Local   int  // Local port of datagram.
+		Remote  int  // Remote port of datagram.
+		Data    []byte
 	}
 
 	// Maintains UDP connection for RTP and RTCP channel pair.
 	udpsink struct {
 		*net.UDPConn
-		done chan int
-		ch   byte
+		ch byte
 	}
 
 	// RawPacket represents channel number and raw data buffer of RTP/RTCP packet.
@@ -64,21 +77,30 @@ func Dial(uri string, proto int) (*Conn, error) {
 		url1.Host = url1.Host + ":554"
 	}
 
-	addr, err := net.ResolveTCPAddr("tcp", url1.Host)
+	conn, err := net.Dial("tcp", url1.Host)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTCP("tcp", nil, addr)
+	c, err := NewConn(conn, url1.String(), proto)
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return c, nil
+}
 
+// NewConn wraps an established network connection to RTSP server, e.g. one made through a proxy or
+// an in-memory pipe in tests.
+func NewConn(conn net.Conn, uri string, proto int) (*Conn, error) {
+	url1, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
 	url2 := *url1
 	url2.User = nil
 
 	c := &Conn{
 		conn:      conn,
-		rdr:       bufio.NewReaderSize(conn, 2048),
 		connected: false,
 		Data:      make(chan RawPacket, 20),
 		guid:      fmt.Sprintf("%016x", rand.Uint64()),
@@ -87,6 +109,7 @@ func Dial(uri string, proto int) (*Conn, error) {
 		URL:       url1,
 		BaseURI:   url2.String(),
 	}
+	c.Reader = NewReader(deadlineReader{c})
 
 	if c.Proto == ProtoHTTP {
 		// Issue GET and read response.  Afterwards we'll be getting incoming RTSP/RTP/RTCP responses in a stream.
@@ -95,7 +118,7 @@ func Dial(uri string, proto int) (*Conn, error) {
 			return nil, err
 		}
 		// Read response but only headers and prepare to receive content stream of unknown length.
-		if err := ReceiveHTTP(c); err != nil {
+		if err := ReceiveHTTP(c.Reader); err != nil {
 			return nil, err
 		}
 	}
@@ -116,6 +139,7 @@ func (c *Conn) Write(p []byte) (n int, err error) {
 			}
 			c.connected = true
 		}
+		c.tap(Segment{Data: p})
 		w := base64.NewEncoder(base64.StdEncoding, c.post)
 		defer w.Close()
 		return w.Write(p)
@@ -123,111 +147,132 @@ func (c *Conn) Write(p []byte) (n int, err error) {
 	if c.Timeout > 0 {
 		c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
 	}
+	c.tap(Segment{Data: p})
 	return c.conn.Write(p)
 }
 
-// Peek returns the next n bytes without advancing the reader.
-func (c *Conn) Peek(n int) ([]byte, error) {
-	if c.Timeout > 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+// tap passes segment to the observer, if any.
+func (c *Conn) tap(seg Segment) {
+	if c.Tap != nil && len(seg.Data) > 0 {
+		seg.At = time.Now()
+		c.Tap(seg)
 	}
-	return c.rdr.Peek(n)
 }
 
-// ReadLine reads until the first occurrence of newline,
-// returning a string containing the data up to and including newline.
-func (c *Conn) ReadLine() (string, error) {
-	if c.Timeout > 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
-	}
-	return c.rdr.ReadString('\n')
+// LocalAddr returns local address of RTSP connection.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
 }
 
-// ReadUint16 reads and returns an unsigned 2-byte integer.
-func (c *Conn) ReadUint16() (data uint16, err error) {
-	if c.Timeout > 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+// RemoteAddr returns address of RTSP server.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close stops UDP listeners and closes network connections.
+func (c *Conn) Close() error {
+	c.Stop()
+	if c.post != nil {
+		c.post.Close()
 	}
-	err = binary.Read(c.rdr, be, &data)
-	return
+	return c.conn.Close()
 }
 
-// Discard skips the next n bytes, returning the number of bytes discarded.
-func (c *Conn) Discard(n int) (discarded int, err error) {
-	return c.rdr.Discard(n)
+// deadlineReader extends read deadline of the connection before every read.
+type deadlineReader struct {
+	c *Conn
 }
 
-// ReadByte reads and returns a single byte.
-func (c *Conn) ReadByte() (byte, error) {
-	if c.Timeout > 0 {
-		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+func (d deadlineReader) Read(p []byte) (int, error) {
+	if d.c.Timeout > 0 {
+		d.c.conn.SetReadDeadline(time.Now().Add(d.c.Timeout))
 	}
-	return c.rdr.ReadByte()
+	n, err := d.c.conn.Read(p)
+	d.c.tap(Segment{In: true, Data: p[:n]})
+	return n, err
 }
 
-// ReadBytes reads requested number of bytes, returning a slice containing the data.
-func (c *Conn) ReadBytes(n int) ([]byte, error) {
-	b, err := c.Peek(n)
-	if err == nil {
-		c.rdr.Discard(n)
+// deliver copies packet and passes it on to the data channel unless filtered out.
+func (c *Conn) deliver(ch byte, payload []byte) {
+	if c.accept != nil && !c.accept(ch, payload) {
+		return
+	}
+	select {
+	case c.Data <- RawPacket{Channel: ch, Payload: append([]byte{}, payload...)}:
 	}
-	return b, err
 }
 
 // AddSink creates a listener on UDP port for RTP data or control channel.
 func (c *Conn) AddSink(ch byte, port int) error {
-	if c.addr == nil {
-		var err error
-		if c.addr, err = net.ResolveIPAddr("ip", "127.0.0.1"); err != nil {
-			return err
-		}
-	}
-
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: c.addr.IP, Port: port, Zone: ""})
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
 	if err != nil {
 		return err
 	}
 	sink := udpsink{
 		UDPConn: conn,
-		done:    make(chan int),
 		ch:      ch,
 	}
 	c.sinks = append(c.sinks, sink)
 	return nil
 }
 
+// port returns local port of the listener.
+func (s udpsink) port() int {
+	return s.LocalAddr().(*net.UDPAddr).Port
+}
+
 // Start initiates processing of incoming packets on all UDP listeners created thus far.
 func (c *Conn) Start() {
 	for _, s := range c.sinks {
-		fmt.Printf("Reading from UDP sink %d", s.ch)
+		log.Printf("Reading from UDP sink %d\n", s.ch)
 		go func(sink udpsink) {
 			var buf [2048]byte
 			for {
-				select {
-				case <-sink.done:
-					break
-				}
-				n, _, err := sink.ReadFromUDP(buf[:])
+				n, addr, err := sink.ReadFromUDP(buf[:])
 				if err == nil {
-					select {
-					case c.Data <- RawPacket{Channel: sink.ch, Payload: append([]byte{}, buf[:n]...)}:
-					}
+					c.tap(Segment{In: true, UDP: true, Channel: sink.ch, Local: sink.port(), Remote: addr.Port, Data: buf[:n]})
+					c.deliver(sink.ch, buf[:n])
 				} else if !isTimeoutOrTemp(err) {
-					break
+					// Listener has been closed.
+					// TODO: Notify caller about closed connection.
+					return
 				}
 			}
-			sink.Close()
-			// TODO: Notify caller about closed connection.
 		}(s)
 	}
 }
 
-// Stop tells all UDP listeners to suspend processing and close.  The list of listerners is reset.
+// Stop closes all UDP listeners which makes them suspend processing.  The list of listerners is reset.
 func (c *Conn) Stop() {
 	for _, sink := range c.sinks {
-		select {
-		case sink.done <- 1:
-		}
+		sink.Close()
 	}
 	c.sinks = c.sinks[:0]
 }
+
+// WriteInterleaved sends RTP or RTCP packet over RTSP connection on a given channel.
+func (c *Conn) WriteInterleaved(ch byte, payload []byte) error {
+	if len(payload) > 0xFFFF {
+		return errPacketTooLong
+	}
+	buf := make([]byte, 4, 4+len(payload))
+	buf[0] = '$'
+	buf[1] = ch
+	be.PutUint16(buf[2:], uint16(len(payload)))
+	_, err := c.Write(append(buf, payload...))
+	return err
+}
+
+// WriteUDP sends RTP or RTCP packet from UDP listener for a given channel to the server port.
+func (c *Conn) WriteUDP(ch byte, payload []byte, port int) error {
+	for _, sink := range c.sinks {
+		if sink.ch == ch {
+			host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+			addr := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+			c.tap(Segment{UDP: true, Channel: ch, Local: sink.port(), Remote: port, Data: payload})
+			_, err := sink.WriteToUDP(payload, addr)
+			return err
+		}
+	}
+	return errNoConnection
+}
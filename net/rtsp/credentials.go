@@ -0,0 +1,141 @@
+package rtsp
+
+// Sources of credentials to authenticate with cameras and redaction of secrets from logs.
+
+import (
+	"bufio"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrAuthRejected indicates that server rejected credentials twice in a row.
+	ErrAuthRejected = errors.New("Server rejected credentials")
+	// ErrNoCredentials indicates that credential provider has no credentials for the resource.
+	ErrNoCredentials = errors.New("No credentials for the resource")
+)
+
+type (
+	// CredentialProvider supplies user name and password when server requests authentication.
+	// Realm comes from the server challenge and may be used to tell apart several sets of credentials.
+	CredentialProvider interface {
+		Credentials(u *url.URL, realm string) (username, password string, err error)
+	}
+
+	// CredentialProviderFunc is an adapter to use ordinary function as credential provider.
+	CredentialProviderFunc func(u *url.URL, realm string) (username, password string, err error)
+
+	// StaticCredentials always provides the same user name and password.
+	StaticCredentials struct {
+		Username string
+		Password string
+	}
+
+	// EnvCredentials reads user name and password from environment variables.
+	EnvCredentials struct {
+		UsernameVar string
+		PasswordVar string
+	}
+
+	// FileCredentials reads credentials from a file containing "username:password" on the first line or
+	// from a directory, e.g. mounted secret, with separate "username" and "password" files.
+	FileCredentials struct {
+		Path string
+	}
+
+	// HostCredentials selects provider by host:port or host of the resource URL.
+	HostCredentials map[string]CredentialProvider
+
+	// URLCredentials takes credentials embedded in the resource URL.  This is the default.
+	URLCredentials struct{}
+)
+
+var (
+	// Matches value of Authorization header to the end of line.
+	authRedactor = regexp.MustCompile(`(?im)^(` + HeaderAuthorization + `:[ \t]*\S+[ \t])[^\r\n]*`)
+	// Matches password in URL user info.
+	urlRedactor = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:@/\s]*:)[^@/\s]*@`)
+)
+
+// Credentials calls f(u, realm).
+func (f CredentialProviderFunc) Credentials(u *url.URL, realm string) (string, string, error) {
+	return f(u, realm)
+}
+
+// Credentials returns static user name and password.
+func (c StaticCredentials) Credentials(u *url.URL, realm string) (string, string, error) {
+	return c.Username, c.Password, nil
+}
+
+// Credentials reads user name and password from environment.
+func (c EnvCredentials) Credentials(u *url.URL, realm string) (string, string, error) {
+	username, ok := os.LookupEnv(c.UsernameVar)
+	if !ok {
+		return "", "", ErrNoCredentials
+	}
+	return username, os.Getenv(c.PasswordVar), nil
+}
+
+// Credentials reads user name and password from file or directory.  Files are read on every call
+// so rotated secrets are picked up without restart.
+func (c FileCredentials) Credentials(u *url.URL, realm string) (string, string, error) {
+	fi, err := os.Stat(c.Path)
+	if err != nil {
+		return "", "", err
+	}
+	if fi.IsDir() {
+		username, err := os.ReadFile(filepath.Join(c.Path, "username"))
+		if err != nil {
+			return "", "", err
+		}
+		password, err := os.ReadFile(filepath.Join(c.Path, "password"))
+		if err != nil {
+			return "", "", err
+		}
+		return strings.TrimSpace(string(username)), strings.TrimRight(string(password), "\r\n"), nil
+	}
+	f, err := os.Open(c.Path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return "", "", ErrNoCredentials
+	}
+	creds := strings.SplitN(strings.TrimRight(sc.Text(), "\r\n"), ":", 2)
+	if len(creds) != 2 {
+		return "", "", ErrNoCredentials
+	}
+	return creds[0], creds[1], nil
+}
+
+// Credentials delegates to provider registered for host:port or host of the URL.
+func (c HostCredentials) Credentials(u *url.URL, realm string) (string, string, error) {
+	if p, ok := c[u.Host]; ok {
+		return p.Credentials(u, realm)
+	}
+	if p, ok := c[u.Hostname()]; ok {
+		return p.Credentials(u, realm)
+	}
+	return "", "", ErrNoCredentials
+}
+
+// Credentials returns user name and password from URL.
+func (URLCredentials) Credentials(u *url.URL, realm string) (string, string, error) {
+	if u == nil || u.User == nil {
+		return "", "", ErrNoCredentials
+	}
+	password, _ := u.User.Password()
+	return u.User.Username(), password, nil
+}
+
+// redact hides credentials in RTSP message or URL before it is logged.
+func redact(msg string) string {
+	msg = authRedactor.ReplaceAllString(msg, "${1}[redacted]")
+	return urlRedactor.ReplaceAllString(msg, "${1}[redacted]@")
+}
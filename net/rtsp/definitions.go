@@ -29,6 +29,15 @@ const (
 	VerbSetParameter = "SET_PARAMETER"
 	// VerbTeardown        TEARDOWN          C->S             P,S        required
 	VerbTeardown = "TEARDOWN"
+	// VerbPlayNotify      PLAY_NOTIFY       S->C             P,S        required (RTSP 2.0)
+	VerbPlayNotify = "PLAY_NOTIFY"
+)
+
+const (
+	// Version10 identifies RTSP 1.0 protocol as defined in RFC 2326.
+	Version10 = "RTSP/1.0"
+	// Version20 identifies RTSP 2.0 protocol as defined in RFC 7826.
+	Version20 = "RTSP/2.0"
 )
 
 const (
@@ -80,6 +89,40 @@ const (
 	HeaderPragma = "Pragma"
 	// HeaderCacheControl is HTTP Cache-Control header.
 	HeaderCacheControl = "Cache-Control"
+	// HeaderSupported is RTSP Supported header listing feature tags.
+	HeaderSupported = "Supported"
+	// HeaderRequire is RTSP Require header listing feature tags that must be supported.
+	HeaderRequire = "Require"
+	// HeaderAcceptRanges is RTSP 2.0 Accept-Ranges header listing supported range formats.
+	HeaderAcceptRanges = "Accept-Ranges"
+	// HeaderMediaProperties is RTSP 2.0 Media-Properties header.
+	HeaderMediaProperties = "Media-Properties"
+	// HeaderSeekStyle is RTSP 2.0 Seek-Style header.
+	HeaderSeekStyle = "Seek-Style"
+	// HeaderPipelinedRequests is RTSP 2.0 Pipelined-Requests header.
+	HeaderPipelinedRequests = "Pipelined-Requests"
+	// HeaderNotifyReason is RTSP 2.0 Notify-Reason header in PLAY_NOTIFY request.
+	HeaderNotifyReason = "Notify-Reason"
+	// HeaderRange is RTSP Range header.
+	HeaderRange = "Range"
+	// HeaderScale is RTSP Scale header.
+	HeaderScale = "Scale"
+	// HeaderSpeed is RTSP Speed header.
+	HeaderSpeed = "Speed"
+	// HeaderRTPInfo is RTSP RTP-Info header.
+	HeaderRTPInfo = "RTP-Info"
+	// HeaderRateControl is ONVIF Rate-Control header for replay.
+	HeaderRateControl = "Rate-Control"
+	// HeaderImmediate is ONVIF Immediate header for replay.
+	HeaderImmediate = "Immediate"
+	// HeaderLocation is RTSP Location header in REDIRECT request and redirection responses.
+	HeaderLocation = "Location"
+	// HeaderContentType is HTTP Content-Type header.
+	HeaderContentType = "Content-Type"
+	// HeaderContentBase is RTSP Content-Base header.
+	HeaderContentBase = "Content-Base"
+	// HeaderServer is RTSP Server header.
+	HeaderServer = "Server"
 )
 
 const (
@@ -87,12 +130,20 @@ const (
 	RtspOK = 200
 	// RtspNoContent indicates that server has successfully fulfilled the request and that there is no additional content to send in the response payload body.
 	RtspNoContent = 204
+	// RtspBadRequest indicates that request could not be understood due to malformed syntax.
+	RtspBadRequest = 400
+	// RtspMovedPermanently indicates that resource has moved permanently to location in Location header.
+	RtspMovedPermanently = 301
+	// RtspFound indicates that resource resides temporarily at location in Location header.
+	RtspFound = 302
 	// RtspNotModified indicates that client, which made the request conditional, already has a valid representation.
 	RtspNotModified = 304
 	// RtspUnauthorized indicates that clients should authorize to be served complete response to current request.
 	RtspUnauthorized = 401
 	// RtspLowOnStorageSpace indicates insufficient storage space on server to satisfy record request.
 	RtspLowOnStorageSpace = 250
+	// RtspNotFound indicates that server has not found anything matching the request URI.
+	RtspNotFound = 404
 	// RtspMethodNotAllowed indicates that method specified in the request is not allowed for the resource identified by the request URI.
 	RtspMethodNotAllowed = 405
 	// RtspParameterNotUnderstood indicates that recipient of the request does not support one or more parameters contained in the request.
@@ -119,20 +170,62 @@ const (
 	RtspUnsupportedTransport = 461
 	// RtspDestinationUnreachable indicates that data transmission channel could not be established because the client address could not be reached.
 	RtspDestinationUnreachable = 462
+	// RtspNotImplemented indicates that server does not support functionality required to fulfill the request.
+	RtspNotImplemented = 501
+	// RtspServiceUnavailable indicates that server is temporarily unable to handle the request.
+	RtspServiceUnavailable = 503
+	// RtspVersionNotSupported indicates that server does not support RTSP protocol version used in the request.
+	RtspVersionNotSupported = 505
 	// RtspOptionNotSupported indicates that option given in the Require or the Proxy-Require fields was not supported.
 	RtspOptionNotSupported = 551
 )
 
+var statusText = map[int]string{
+	RtspOK:                             "OK",
+	RtspNoContent:                      "No Content",
+	RtspLowOnStorageSpace:              "Low on Storage Space",
+	RtspMovedPermanently:               "Moved Permanently",
+	RtspFound:                          "Found",
+	RtspNotModified:                    "Not Modified",
+	RtspBadRequest:                     "Bad Request",
+	RtspUnauthorized:                   "Unauthorized",
+	RtspNotFound:                       "Not Found",
+	RtspMethodNotAllowed:               "Method Not Allowed",
+	RtspParameterNotUnderstood:         "Parameter Not Understood",
+	RtspConferenceNotFound:             "Conference Not Found",
+	RtspNotEnoughBandwidth:             "Not Enough Bandwidth",
+	RtspSessionNotFound:                "Session Not Found",
+	RtspMethodNotValidInThisState:      "Method Not Valid in This State",
+	RtspHeaderFieldNotValidForResource: "Header Field Not Valid for Resource",
+	RtspInvalidRange:                   "Invalid Range",
+	RtspParameterIsReadOnly:            "Parameter Is Read-Only",
+	RtspAggregateOperationNotAllowed:   "Aggregate Operation Not Allowed",
+	RtspOnlyAggregateOperationAllowed:  "Only Aggregate Operation Allowed",
+	RtspUnsupportedTransport:           "Unsupported Transport",
+	RtspDestinationUnreachable:         "Destination Unreachable",
+	RtspNotImplemented:                 "Not Implemented",
+	RtspServiceUnavailable:             "Service Unavailable",
+	RtspVersionNotSupported:            "RTSP Version Not Supported",
+	RtspOptionNotSupported:             "Option Not Supported",
+}
+
+// StatusText returns a text for the RTSP status code.  It returns the empty string if the code is unknown.
+func StatusText(code int) string {
+	return statusText[code]
+}
+
 // Errors
 var (
 	errMalformedResponse = errors.New("Malformed response header")
+	errMalformedRequest  = errors.New("Malformed request line")
 	errInvalidStatus     = errors.New("Missing or malformed status code")
 	errNotSupported      = errors.New("Unsupported protocol version")
-	errNoCredentials     = errors.New("Resource URI has no credentials to perform authorization")
 	errOutOfMemory       = errors.New("Out of memory")
 	errBadResponse       = errors.New("Bad or unexpected response")
 	errNoConnection      = errors.New("Connection to RTSP source is required")
 	errPacketTooShort    = errors.New("Packet is too short")
+	errPacketTooLong     = errors.New("Packet is too long")
+	errNoBackchannel     = errors.New("Source does not provide backchannel")
 	errInvalidParameter  = errors.New("Invalid parameter")
 )
 
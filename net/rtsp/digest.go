@@ -3,11 +3,15 @@ package rtsp
 import (
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"strings"
+	"sync"
 )
 
 var (
@@ -17,11 +21,22 @@ var (
 	ErrAuthNotImpemented = errors.New("Missing implementation for authentication method")
 )
 
+// Strength of supported digest algorithms as defined in RFC 7616.  Higher is preferred.  Basic is zero.
+var algorithms = map[string]int{
+	"md5":              1,
+	"md5-sess":         1,
+	"sha-256":          2,
+	"sha-256-sess":     2,
+	"sha-512-256":      3,
+	"sha-512-256-sess": 3,
+}
+
 // DigestAuth is function to call to encoude authorization for a particular verb in session.
 type DigestAuth func(verb string, body []byte) string
 
 // Digest encapsulates all information necessary to perform digest authentication against a remote site.
 type Digest struct {
+	sync.Mutex
 	basic     bool
 	username  string
 	realm     string
@@ -29,7 +44,10 @@ type Digest struct {
 	nonce     string
 	opaque    string
 	stale     string
-	algorithm string
+	algorithm string // Lower case algorithm name used to select hash function.
+	token     string // Algorithm name as sent by the server to echo back.
+	charset   string
+	userhash  bool
 	cnonce    string
 	qop       string
 	uri       string
@@ -39,62 +57,128 @@ type Digest struct {
 	count     int
 }
 
-// NewDigest parses challenge and creates a new basic/digest authentication processor.
+// ParseChallenges splits value of WWW-Authenticate header into individual challenges.
+// Multiple headers are joined with commas same as list parameters within a challenge.
+func ParseChallenges(value string) (challenges []string) {
+	for _, item := range splitList(value) {
+		if item == "" {
+			continue
+		}
+		// New challenge starts with authentication scheme token followed by space or nothing.
+		j := strings.IndexAny(item, " =")
+		if j == -1 || (item[j] == ' ' && !strings.HasPrefix(strings.TrimSpace(item[j:]), "=")) || len(challenges) == 0 {
+			challenges = append(challenges, item)
+			continue
+		}
+		challenges[len(challenges)-1] += ", " + item
+	}
+	return
+}
+
+// NewDigest parses challenges and creates a new basic/digest authentication processor for the strongest supported one.
 func NewDigest(uri, challenge string) (*Digest, error) {
+	var best *Digest
+	err := ErrAuthMalformedChallenge
+	for _, c := range ParseChallenges(challenge) {
+		d, e := parseChallenge(uri, c)
+		if e != nil {
+			if e == ErrAuthNotImpemented {
+				err = e
+			}
+			continue
+		}
+		if best == nil || d.strength() > best.strength() {
+			best = d
+		}
+	}
+	if best == nil {
+		return nil, err
+	}
+	return best, nil
+}
+
+// parseChallenge parses a single challenge.  Unknown parameters are ignored as required by RFC 7616.
+func parseChallenge(uri, challenge string) (*Digest, error) {
 	d := &Digest{count: 0, uri: uri}
 	d.algorithm = "md5"
+	d.token = "md5"
 	challenge = strings.TrimSpace(challenge)
-	if strings.HasPrefix(challenge, "Digest ") {
-		d.basic = false
-		challenge = challenge[7:]
-	} else if strings.HasPrefix(challenge, "Basic ") {
-		d.basic = true
-		challenge = challenge[6:]
+	if i := strings.IndexByte(challenge, ' '); i != -1 {
+		switch strings.ToLower(challenge[:i]) {
+		case "digest":
+			d.basic = false
+		case "basic":
+			d.basic = true
+		default:
+			return nil, ErrAuthNotImpemented
+		}
+		challenge = challenge[i+1:]
 	} else {
 		return nil, ErrAuthMalformedChallenge
 	}
-	parts := strings.Split(challenge, ",")
-	for i := 0; i < len(parts); i++ {
-		parts[i] = strings.TrimSpace(parts[i])
-		if len(parts[i]) == 0 {
+	for _, item := range splitList(challenge) {
+		j := strings.IndexByte(item, '=')
+		if j <= 0 {
 			continue
 		}
-
-		attr, val := parts[i], ""
-		if j := strings.Index(attr, "="); j > 0 {
-			attr, val = strings.ToLower(attr[:j]), attr[j+1:]
-			val = strings.Trim(val, "\"")
-			switch attr {
-			case "realm":
-				d.realm = val
-			case "domain":
-				d.domain = val
-			case "nonce":
-				d.nonce = val
-			case "opaque":
-				d.opaque = val
-			case "stale":
-				d.stale = val
-			case "algorithm":
-				d.algorithm = strings.ToLower(val)
-			case "qop":
-				vals := strings.Split(val, ",")
-				if len(vals) > 0 {
-					d.qop = strings.ToLower(vals[0])
+		attr, val := strings.ToLower(strings.TrimSpace(item[:j])), strings.TrimSpace(item[j+1:])
+		val = strings.Trim(val, "\"")
+		switch attr {
+		case "realm":
+			d.realm = val
+		case "domain":
+			d.domain = val
+		case "nonce":
+			d.nonce = val
+		case "opaque":
+			d.opaque = val
+		case "stale":
+			d.stale = strings.ToLower(val)
+		case "algorithm":
+			d.algorithm = strings.ToLower(val)
+			d.token = val
+		case "charset":
+			d.charset = val
+		case "userhash":
+			d.userhash = strings.EqualFold(val, "true")
+		case "qop":
+			// Prefer auth as we do not send bodies in requests.
+			for i, v := range strings.Split(val, ",") {
+				v = strings.ToLower(strings.TrimSpace(v))
+				if i == 0 || v == "auth" {
+					d.qop = v
 				}
-			default:
-				return nil, ErrAuthMalformedChallenge
 			}
 		}
 	}
-	if d.nonce != "" {
-		if d.algorithm != "md5" && d.algorithm != "md5-sess" {
+	if !d.basic {
+		if d.nonce == "" {
+			return nil, ErrAuthMalformedChallenge
+		}
+		if _, ok := algorithms[d.algorithm]; !ok {
 			return nil, ErrAuthNotImpemented
 		}
 	}
 	return d, nil
 }
 
+func (d *Digest) strength() int {
+	if d.basic {
+		return 0
+	}
+	return algorithms[d.algorithm]
+}
+
+// Realm returns protection space from the challenge.
+func (d *Digest) Realm() string {
+	return d.realm
+}
+
+// Stale reports whether server rejected previous request because of expired nonce rather than wrong credentials.
+func (d *Digest) Stale() bool {
+	return d.stale == "true"
+}
+
 // Authenticate creates value for Authorization header.
 func (d *Digest) Authenticate(username, password string) DigestAuth {
 	out := strings.Builder{}
@@ -107,22 +191,38 @@ func (d *Digest) Authenticate(username, password string) DigestAuth {
 		}
 	}
 	d.username = username
-	d.ha1 = md5hex(colonnade(username, d.realm, password))
-	out.WriteString("Digest username=\"")
-	out.WriteString(d.username)
-	out.WriteString("\", realm=\"")
+	d.ha1 = d.hash(colonnade(username, d.realm, password))
+	out.WriteString("Digest ")
+	switch {
+	case d.userhash:
+		out.WriteString("username=\"")
+		out.WriteString(d.hash(colonnade(username, d.realm)))
+		out.WriteByte('"')
+	case d.charset != "" && !isASCII(username):
+		// Extended notation as defined in RFC 5987.
+		out.WriteString("username*=UTF-8''")
+		out.WriteString(extValue(username))
+	default:
+		out.WriteString("username=\"")
+		out.WriteString(d.username)
+		out.WriteByte('"')
+	}
+	out.WriteString(", realm=\"")
 	out.WriteString(d.realm)
 	out.WriteString("\", nonce=\"")
 	out.WriteString(d.nonce)
 	out.WriteString("\", uri=\"")
 	out.WriteString(d.uri)
 	out.WriteString("\", algorithm=\"")
-	out.WriteString(d.algorithm)
+	out.WriteString(d.token)
 	if d.opaque != "" {
 		out.WriteString("\", opaque=\"")
 		out.WriteString(d.opaque)
 	}
 	out.WriteString("\"")
+	if d.userhash {
+		out.WriteString(", userhash=true")
+	}
 	auth := out.String()
 	d.Next()
 	return func(verb string, body []byte) string {
@@ -130,31 +230,35 @@ func (d *Digest) Authenticate(username, password string) DigestAuth {
 	}
 }
 
-// Next increments session count and generates new cnonce.
+// Next generates new cnonce and restarts nonce count.
 func (d *Digest) Next() {
-	if d.qop == "" {
-		return
-	}
-	d.count++
-	d.nc = fmt.Sprintf("%08x", d.count)
+	d.Lock()
+	defer d.Unlock()
+	d.count = 0
 	b := make([]byte, 8)
 	rand.Read(b)
 	d.cnonce = hex.EncodeToString(b)[:8]
 }
 
+// response computes response parameters for a request.  Nonce count advances with every request.
 func (d *Digest) response(verb string, body []byte) string {
+	d.Lock()
+	defer d.Unlock()
 	if d.qop == "auth-int" {
-		d.ha2 = md5hex(colonnade(verb, d.uri, md5hex(body)))
+		d.ha2 = d.hash(colonnade(verb, d.uri, d.hash(body)))
 	} else {
-		d.ha2 = md5hex(colonnade(verb, d.uri))
+		d.ha2 = d.hash(colonnade(verb, d.uri))
 	}
 	out := strings.Builder{}
 	if d.qop == "" {
 		out.WriteString(", response=\"")
-		out.WriteString(md5hex(colonnade(d.ha1, d.nonce, d.ha2)))
+		out.WriteString(d.hash(colonnade(d.ha1, d.nonce, d.ha2)))
 	} else {
-		if d.algorithm == "md5-sess" {
-			d.ha1 = md5hex(colonnade(d.ha1, d.nonce, d.cnonce))
+		d.count++
+		d.nc = fmt.Sprintf("%08x", d.count)
+		ha1 := d.ha1
+		if strings.HasSuffix(d.algorithm, "-sess") {
+			ha1 = d.hash(colonnade(d.ha1, d.nonce, d.cnonce))
 		}
 		out.WriteString(", qop=\"")
 		out.WriteString(d.qop)
@@ -163,18 +267,57 @@ func (d *Digest) response(verb string, body []byte) string {
 		out.WriteString(", cnonce=\"")
 		out.WriteString(d.cnonce)
 		out.WriteString("\", response=\"")
-		out.WriteString(md5hex(colonnade(d.ha1, d.nonce, d.nc, d.cnonce, d.qop, d.ha2)))
+		out.WriteString(d.hash(colonnade(ha1, d.nonce, d.nc, d.cnonce, d.qop, d.ha2)))
 	}
 	out.WriteByte('"')
 	return out.String()
 }
 
-func md5hex(data []byte) string {
-	hf := md5.New()
+func (d *Digest) hash(data []byte) string {
+	return hashhex(d.algorithm, data)
+}
+
+// hashhex computes hex encoded hash of data with function selected by digest algorithm name.
+func hashhex(algorithm string, data []byte) string {
+	var hf hash.Hash
+	switch strings.TrimSuffix(strings.ToLower(algorithm), "-sess") {
+	case "sha-256":
+		hf = sha256.New()
+	case "sha-512-256":
+		hf = sha512.New512_256()
+	default:
+		hf = md5.New()
+	}
 	hf.Write(data)
 	return hex.EncodeToString(hf.Sum(nil))
 }
 
+// extValue percent-encodes every byte of UTF-8 string other than attr-char defined in RFC 5987.
+func extValue(s string) string {
+	const hex = "0123456789ABCDEF"
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&0x0f])
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
 func colonnade(params ...string) []byte {
 	n := len(params) - 1
 	for i := 0; i < len(params); i++ {
@@ -1,6 +1,7 @@
 package rtsp
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -28,3 +29,68 @@ func TestBasicAuth(t *testing.T) {
 		t.Error(auth)
 	}
 }
+
+func TestDigestSHA256(t *testing.T) {
+	// Example from RFC 7616 section 3.9.1 with both challenges in a single header value.
+	challenge := `Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", ` +
+		`Digest realm="http-auth@example.org", qop="auth, auth-int", algorithm=MD5, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS", ` +
+		`Basic realm="http-auth@example.org"`
+	if n := len(ParseChallenges(challenge)); n != 3 {
+		t.Fatalf("expected 3 challenges, got %d", n)
+	}
+	digest, err := NewDigest("/dir/index.html", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authfunc := digest.Authenticate("Mufasa", "Circle of Life")
+	digest.cnonce = "f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ"
+	auth := authfunc("GET", nil)
+	if !strings.Contains(auth, `algorithm="SHA-256"`) || !strings.HasSuffix(auth, `nc=00000001, cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", response="753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"`) {
+		t.Error(auth)
+	}
+	// Nonce count advances with every request.
+	if auth = authfunc("GET", nil); !strings.Contains(auth, "nc=00000002") {
+		t.Error(auth)
+	}
+}
+
+func TestDigestUserhash(t *testing.T) {
+	challenge := `Digest realm="api@example.org", qop="auth", algorithm=SHA-512-256, nonce="5TsQWLVdgBdmrQ0XsxbDODV+57QdFR34I9HAbC/RVvkK", opaque="HRPCssKJSGjCrkzDg8OhwpzCiGPChXYjwrI2QmXDnsOS", charset=UTF-8, userhash=true, unknown="ignored"`
+	digest, err := NewDigest("/doe.json", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authfunc := digest.Authenticate("Jäsøn Doe", "Secret, or not?")
+	digest.cnonce = "NTg6RKcb9boFIAS3KrFK9BGeh+iDa/sm6jUMp2wds69v"
+	auth := authfunc("GET", nil)
+	if !strings.HasPrefix(auth, `Digest username="793263caabb707a56211940d90411ea4a575adeccb7e360aeb624ed06ece9b0b"`) ||
+		!strings.Contains(auth, "userhash=true") ||
+		!strings.HasSuffix(auth, `response="3798d4131c277846293534c3edc11bd8a5e4cdcbff78b05db9d95eeb1cec68a5"`) {
+		t.Error(auth)
+	}
+}
+
+func TestDigestExtendedUsername(t *testing.T) {
+	digest, err := NewDigest("/doe.json", `Digest realm="api@example.org", qop="auth", nonce="5TsQWLVdgBdmrQ0X", charset=UTF-8`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := digest.Authenticate("Jäsøn O'Doe@cam", "secret")("GET", nil)
+	// Only attr-char of RFC 5987 is left as is.
+	if !strings.HasPrefix(auth, `Digest username*=UTF-8''J%C3%A4s%C3%B8n%20O%27Doe%40cam, realm="api@example.org", `) {
+		t.Error(auth)
+	}
+}
+
+func TestDigestStale(t *testing.T) {
+	digest, err := NewDigest("/", `Digest realm="cam", nonce="abc", stale=TRUE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !digest.Stale() {
+		t.Error("expected stale nonce")
+	}
+	if _, err = NewDigest("/", `Negotiate abc, Digest realm="cam", nonce="abc", algorithm=SHA-1`); err != ErrAuthNotImpemented {
+		t.Error(err)
+	}
+}
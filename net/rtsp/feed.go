@@ -1,7 +1,19 @@
 package rtsp
 
 import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	// Register depacketizers of codecs.
+	_ "github.com/aboukirev/ouro/net/aac"
 	"github.com/aboukirev/ouro/net/h264"
+	_ "github.com/aboukirev/ouro/net/h265"
+	_ "github.com/aboukirev/ouro/net/jpeg"
+	_ "github.com/aboukirev/ouro/net/onvif"
+	_ "github.com/aboukirev/ouro/net/opus"
+	"github.com/aboukirev/ouro/net/rtp"
 	"github.com/aboukirev/ouro/net/sdp"
 )
 
@@ -10,21 +22,96 @@ type (
 	// channel, and utility functions.
 	Feed struct {
 		sdp.Media
-		transp *Transport
-		cseq   int
-		IsSet  bool
-		Sets   *h264.ParameterSets
+		mu       sync.Mutex // Guards alignment which is updated by session and checked by UDP listeners.
+		transp   *Transport
+		cseq     int
+		ch       byte   // Channel for RTP data, RTCP uses next one.
+		uri      string // Control URI used to SETUP the feed.
+		aligning bool   // Indicates that packets preceding the one in RTP-Info should be dropped.
+		IsSet    bool
+		Sets     *h264.ParameterSets
+		Info     RTPInfo // Sequence number and timestamp of the first packet after PLAY.
+		// Depacketizer registered for encoding of the feed, nil if there is none.
+		Depacketizer rtp.Depacketizer
+		// Wall-clock time and RTP timestamp from the last ONVIF replay extension.
+		clockBase time.Time
+		clockTS   uint32
 	}
 )
 
-// ParseFeeds creates media feeds from parsed SDP body.
+// ParseFeeds creates media feeds from parsed SDP body together with depacketizers for their encodings.
+// Feed with malformed codec parameters, e.g. sprop-parameter-sets, is left without depacketizer rather than
+// failing the others.
 func ParseFeeds(proto int, buf []byte) (feeds []*Feed, err error) {
 	for i, m := range sdp.Parse(buf) {
-		feeds = append(feeds, &Feed{Media: m, transp: NewTransport(proto, i*2), Sets: h264.NewParameterSets()})
+		f := &Feed{Media: m, transp: NewTransport(proto, i*2), ch: byte(i * 2), Sets: h264.NewParameterSets()}
+		if f.Depacketizer, err = rtp.NewDepacketizer(&f.Media); err != nil {
+			log.Printf("Feed %d %s: %v\n", i, m.EncodingName, err)
+			f.Depacketizer, err = nil, nil
+		}
+		if d, ok := f.Depacketizer.(*h264.Depacketizer); ok {
+			// Depacketizer sees in-band updates of parameter sets.
+			f.Sets = d.Assembler.Sets
+		}
+		feeds = append(feeds, f)
 	}
 	return
 }
 
+// Push depacketizes RTP packet of the feed into frames.  Nothing is returned if feed has no depacketizer.
+func (f *Feed) Push(p *rtp.Packet) ([]rtp.Frame, error) {
+	if f.Depacketizer == nil {
+		return nil, nil
+	}
+	return f.Depacketizer.Push(p)
+}
+
+// Channel returns channel of RTP data packets delivered for the feed.  RTCP packets come on the next one.
+func (f *Feed) Channel() byte {
+	return f.ch
+}
+
+// Available returns range of media available for playback as advertised in SDP.
+func (f *Feed) Available() (Range, error) {
+	return ParseRange(f.Range)
+}
+
+// Elapsed converts RTP timestamp into time elapsed since the first packet of current playback.
+func (f *Feed) Elapsed(ts uint32) time.Duration {
+	if f.TimeScale == 0 {
+		return 0
+	}
+	return time.Duration(int64(int32(ts-f.Info.RTPTime)) * int64(time.Second) / int64(f.TimeScale))
+}
+
+// matches checks if URL from RTP-Info header refers to this feed.
+func (f *Feed) matches(url string) bool {
+	return url == f.uri || url == f.Control || strings.HasSuffix(url, "/"+f.Control)
+}
+
+// align remembers first packet of the current playback from RTP-Info.
+func (f *Feed) align(info RTPInfo) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Info = info
+	f.aligning = info.HasSeq
+}
+
+// accept reports whether RTP packet with a given sequence number belongs to current playback.
+// Packets sent before PLAY took effect, e.g. prior to seeking, are rejected.
+func (f *Feed) accept(sn uint16) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.aligning {
+		return true
+	}
+	if int16(sn-f.Info.Seq) < 0 {
+		return false
+	}
+	f.aligning = false
+	return true
+}
+
 // TransportHeader returns a formatted transport header for SETUP request.
 func (f *Feed) TransportHeader() string {
 	return f.transp.String()
@@ -35,10 +122,14 @@ func (f *Feed) TransportSetup(value string) error {
 	if err := f.transp.Parse(value); err != nil {
 		return err
 	}
-	// Parse sprop parameter sets from the SDP.
+	if f.transp.IsInterleaved {
+		// Server may assign different channels than requested.
+		f.ch = byte(f.transp.Interleave.One)
+	}
+	// Parse sprop parameter sets from the SDP.  Malformed ones are skipped as sets may come in-band.
 	for _, b := range f.SpropParameterSets {
 		if err := f.Sets.ParseSprop(b); err != nil {
-			return err
+			log.Println(err)
 		}
 	}
 	f.IsSet = true
@@ -0,0 +1,56 @@
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/aboukirev/ouro/net/aac"
+	"github.com/aboukirev/ouro/net/h264"
+	"github.com/aboukirev/ouro/net/rtp"
+)
+
+func TestFeedDepacketizer(t *testing.T) {
+	body := "v=0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=fmtp:96 packetization-mode=1;sprop-parameter-sets=Z0IAH5WoFAFuQA==,aM48gA==\r\n" +
+		"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/16000/1\r\na=fmtp:97 streamtype=5;mode=AAC-hbr;config=1408;sizelength=13;indexlength=3;indexdeltalength=3\r\n" +
+		"m=audio 0 RTP/AVP 8\r\n" +
+		"m=video 0 RTP/AVP 100\r\na=rtpmap:100 H266/90000\r\n" +
+		"m=video 0 RTP/AVP 101\r\na=rtpmap:101 H264/90000\r\na=fmtp:101 sprop-parameter-sets=Z2Q=,aM48gA==\r\n"
+	feeds, err := ParseFeeds(ProtoTCP, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 5 {
+		t.Fatalf("Expected 5 feeds, got %d", len(feeds))
+	}
+	if d, ok := feeds[0].Depacketizer.(*h264.Depacketizer); !ok || d.Assembler.Sets != feeds[0].Sets {
+		t.Errorf("Wrong H.264 depacketizer %T", feeds[0].Depacketizer)
+	}
+	if d, ok := feeds[1].Depacketizer.(*aac.Depacketizer); !ok || d.IndexDeltaLength != 3 {
+		t.Errorf("Wrong AAC depacketizer %+v", feeds[1].Depacketizer)
+	}
+	// Malformed parameter sets do not fail other feeds.
+	if feeds[2].Depacketizer == nil || feeds[3].Depacketizer != nil || feeds[4].Depacketizer != nil {
+		t.Errorf("Unexpected depacketizers %T, %T, %T", feeds[2].Depacketizer, feeds[3].Depacketizer, feeds[4].Depacketizer)
+	}
+
+	s := &Session{feeds: feeds}
+	pkt := RawPacket{Channel: 0, Payload: rtp.NewPacket(96, true, 1, 3000, 1, []byte{0x65, 0x88, 0x84}).Pack()}
+	f, frames, err := s.Frames(pkt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != feeds[0] || len(frames) != 1 || !frames[0].Sync || frames[0].TS != 3000 {
+		t.Fatalf("Unexpected frames %+v", frames)
+	}
+	if au, ok := frames[0].Info.(*h264.AccessUnit); !ok || len(au.Units) != 1 {
+		t.Errorf("Unexpected access unit %+v", frames[0].Info)
+	}
+	pkt = RawPacket{Channel: 5, Payload: rtp.NewPacket(8, false, 1, 160, 1, []byte{0xd5, 0xd5}).Pack()}
+	if f, frames, _ = s.Frames(pkt); f != nil || frames != nil {
+		t.Errorf("Packet on RTCP channel is depacketized %+v", frames)
+	}
+	pkt.Channel = 4
+	if f, frames, _ = s.Frames(pkt); f != feeds[2] || len(frames) != 1 || string(frames[0].Data) != "\xd5\xd5" {
+		t.Errorf("Unexpected G.711 frames %+v", frames)
+	}
+}
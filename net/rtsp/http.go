@@ -47,7 +47,8 @@ func ConnectHTTP(verb, uri, cookie string) []byte {
 }
 
 // ReceiveHTTP reads headers form the response to GET.
-func ReceiveHTTP(rdr *Conn) error {
+func ReceiveHTTP(rdr *Reader) error {
+	rdr.header = 0
 	line, err := rdr.ReadLine()
 	if err != nil {
 		return err
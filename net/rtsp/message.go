@@ -3,13 +3,16 @@ package rtsp
 import (
 	"bytes"
 	"net/textproto"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type (
-	// An MessageHeader represents a MIME-style header mapping  keys to values.
-	MessageHeader map[string]string
+	// An MessageHeader represents a MIME-style header mapping keys to sets of values the same way http.Header does.
+	// Repeated headers keep the order they were added or received in.  Like http.Header.Write, packing writes
+	// different headers sorted by key rather than in order of insertion so that wire output is deterministic.
+	MessageHeader map[string][]string
 
 	// Headers facilitates passing additional headers to the RTSP command formatter.
 	Headers map[string]string
@@ -17,11 +20,13 @@ type (
 	// Request encapsulates verb, uri, and headers for RTSP command.
 	Request struct {
 		Verb    string
+		Proto   string
 		Cseq    int
 		URI     string
 		Auth    string
 		Session string
 		Header  MessageHeader
+		Body    []byte
 	}
 
 	// Response encapsulates RTSP response.  It is modeled by http.Response but includes only what is needed to handle RTSP.
@@ -44,9 +49,32 @@ var (
 	colsp = []byte{':', ' '}
 )
 
-// Set sets the header associated with key to the given value.
+// RTSP header names that do not follow MIME canonical form.
+var canonicalKeys = map[string]string{
+	"Cseq":             HeaderCSeq,
+	"Www-Authenticate": HeaderAuthenticate,
+	"Rtp-Info":         HeaderRTPInfo,
+	"X-Sessioncookie":  HeaderXSessionCookie,
+}
+
+// canonicalKey returns canonical format of header key as used in RFC 2326 and RFC 7826.
+func canonicalKey(key string) string {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	if k, ok := canonicalKeys[key]; ok {
+		return k
+	}
+	return key
+}
+
+// Add adds the value to the header associated with key.  It appends to any existing values.
+func (h MessageHeader) Add(key string, value string) {
+	key = canonicalKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Set sets the header associated with key to the single value.  It replaces any existing values.
 func (h MessageHeader) Set(key string, value string) {
-	h[textproto.CanonicalMIMEHeaderKey(key)] = value
+	h[canonicalKey(key)] = []string{value}
 }
 
 // Get gets the first value associated with the given key.
@@ -54,16 +82,60 @@ func (h MessageHeader) Get(key string) string {
 	if h == nil {
 		return ""
 	}
-	v, ok := h[textproto.CanonicalMIMEHeaderKey(key)]
-	if ok {
-		return v
+	v := h[canonicalKey(key)]
+	if len(v) == 0 {
+		return ""
 	}
-	return ""
+	return v[0]
+}
+
+// Values returns all values associated with the given key in the order they were received.
+func (h MessageHeader) Values(key string) []string {
+	if h == nil {
+		return nil
+	}
+	return h[canonicalKey(key)]
 }
 
 // Del deletes the values associated with key.
 func (h MessageHeader) Del(key string) {
-	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+	delete(h, canonicalKey(key))
+}
+
+// Clone returns a copy of the header.
+func (h MessageHeader) Clone() MessageHeader {
+	if h == nil {
+		return nil
+	}
+	h2 := make(MessageHeader, len(h))
+	for key, values := range h {
+		h2[key] = append([]string(nil), values...)
+	}
+	return h2
+}
+
+// write writes header lines sorted by key, except for excluded keys, so that output is deterministic.
+// Values of the same key are written in order.
+func (h MessageHeader) write(buf *bytes.Buffer, exclude ...string) {
+	keys := make([]string, 0, len(h))
+outer:
+	for key := range h {
+		for _, ex := range exclude {
+			if key == canonicalKey(ex) {
+				continue outer
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, val := range h[key] {
+			buf.WriteString(key)
+			buf.Write(colsp)
+			buf.WriteString(val)
+			buf.Write(crnl)
+		}
+	}
 }
 
 // Pack request into RTSP message.
@@ -72,19 +144,20 @@ func (r *Request) Pack() []byte {
 	buf.WriteString(r.Verb)
 	buf.WriteByte(' ')
 	buf.WriteString(r.URI)
-	buf.WriteString(" RTSP/1.0")
+	buf.WriteByte(' ')
+	if r.Proto != "" {
+		buf.WriteString(r.Proto)
+	} else {
+		buf.WriteString(Version10)
+	}
 	buf.Write(crnl)
 	buf.WriteString(HeaderCSeq)
 	buf.Write(colsp)
 	buf.WriteString(strconv.Itoa(r.Cseq))
 	buf.Write(crnl)
-	for key, val := range r.Header {
-		buf.WriteString(key)
-		buf.Write(colsp)
-		buf.WriteString(val)
-		buf.Write(crnl)
-	}
-	if r.Session != "" && r.Verb != VerbOptions && r.Verb != VerbSetup {
+	r.Header.write(buf, HeaderCSeq, HeaderSession, HeaderAuthorization, HeaderUserAgent, HeaderContentLength)
+	// RTSP 2.0 requires session identifier on subsequent SETUP requests to aggregate streams.
+	if r.Session != "" && r.Verb != VerbOptions && (r.Verb != VerbSetup || r.Proto == Version20) {
 		buf.WriteString(HeaderSession)
 		buf.Write(colsp)
 		buf.WriteString(r.Session)
@@ -102,14 +175,16 @@ func (r *Request) Pack() []byte {
 	buf.Write(crnl)
 	buf.WriteString(HeaderContentLength)
 	buf.Write(colsp)
-	buf.WriteByte('0') // We do not have any content in Client -> Server commands.
+	buf.WriteString(strconv.Itoa(len(r.Body))) // Client commands have no content, requests from server may have.
 	buf.Write(crnl)
 	buf.Write(crnl)
+	buf.Write(r.Body)
 	return buf.Bytes()
 }
 
 // Unpack RTSP message into response structure.
-func Unpack(rdr *Conn) (*Response, error) {
+func Unpack(rdr *Reader) (*Response, error) {
+	rdr.header = 0
 	line, err := rdr.ReadLine()
 	if err != nil {
 		return nil, err
@@ -118,7 +193,7 @@ func Unpack(rdr *Conn) (*Response, error) {
 	if i == -1 {
 		return nil, errMalformedResponse
 	}
-	if line[:i] != "RTSP/1.0" {
+	if line[:i] != Version10 && line[:i] != Version20 {
 		return nil, errNotSupported
 	}
 	r := &Response{
@@ -138,20 +213,8 @@ func Unpack(rdr *Conn) (*Response, error) {
 		return nil, errInvalidStatus
 	}
 
-	// Parse the response headers.
-	for {
-		line, err = rdr.ReadLine()
-		if err != nil {
-			return nil, err
-		}
-		if line = strings.TrimSpace(line); line == "" {
-			break
-		}
-		keyval := strings.SplitN(line, ":", 2)
-		if len(keyval) != 2 {
-			return nil, errMalformedResponse
-		}
-		r.Header.Set(strings.TrimSpace(keyval[0]), strings.TrimSpace(keyval[1]))
+	if err = readHeader(rdr, r.Header); err != nil {
+		return nil, err
 	}
 
 	r.Cseq, _ = strconv.Atoi(r.Header.Get(HeaderCSeq))
@@ -162,30 +225,99 @@ func Unpack(rdr *Conn) (*Response, error) {
 		r.ContentLength = 0
 	}
 
-	if r.ContentLength > 0 {
-		r.Body, err = rdr.ReadBytes(int(r.ContentLength))
+	r.Body, err = rdr.readBody(int(r.ContentLength))
+
+	return r, err
+}
+
+// UnpackRequest reads RTSP message sent by the server into request structure.
+func UnpackRequest(rdr *Reader) (*Request, error) {
+	rdr.header = 0
+	line, err := rdr.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return nil, errMalformedRequest
+	}
+	if fields[2] != Version10 && fields[2] != Version20 {
+		return nil, errNotSupported
+	}
+	r := &Request{
+		Verb:   fields[0],
+		URI:    fields[1],
+		Proto:  fields[2],
+		Header: make(MessageHeader),
+	}
+
+	if err = readHeader(rdr, r.Header); err != nil {
+		return nil, err
 	}
 
+	r.Cseq, _ = strconv.Atoi(r.Header.Get(HeaderCSeq))
+	r.Session = r.Header.Get(HeaderSession)
+
+	length, err := strconv.Atoi(r.Header.Get(HeaderContentLength))
+	if err != nil {
+		err = nil
+		length = 0
+	}
+
+	r.Body, err = rdr.readBody(length)
+
 	return r, err
 }
 
+// readHeader parses message headers up to and including empty line separating them from the body.
+// Lines starting with white space continue value of the previous header.
+func readHeader(rdr *Reader, h MessageHeader) error {
+	var key string
+	for {
+		line, err := rdr.ReadLine()
+		if err != nil {
+			return err
+		}
+		folded := len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+		if line = strings.TrimSpace(line); line == "" {
+			return nil
+		}
+		if folded {
+			if key == "" {
+				return errMalformedResponse
+			}
+			values := h[key]
+			values[len(values)-1] += " " + line
+			continue
+		}
+		keyval := strings.SplitN(line, ":", 2)
+		if len(keyval) != 2 {
+			return errMalformedResponse
+		}
+		key = canonicalKey(strings.TrimSpace(keyval[0]))
+		h.Add(key, strings.TrimSpace(keyval[1]))
+	}
+}
+
 // Pack response into RTSP message.
 func (r Response) Pack() []byte {
 	buf := &bytes.Buffer{}
-	buf.WriteString("RTSP/1.0")
+	if r.Proto != "" {
+		buf.WriteString(r.Proto)
+	} else {
+		buf.WriteString(Version10)
+	}
 	buf.WriteByte(' ')
 	buf.WriteString(r.Status)
 	buf.Write(crnl)
-	for key, val := range r.Header {
-		buf.WriteString(key)
+	r.Header.write(buf, HeaderContentLength)
+	if len(r.Body) > 0 {
+		buf.WriteString(HeaderContentLength)
 		buf.Write(colsp)
-		buf.WriteString(val)
+		buf.WriteString(strconv.Itoa(len(r.Body)))
 		buf.Write(crnl)
 	}
 	buf.Write(crnl)
-	if r.ContentLength > 0 {
-		buf.Write(r.Body)
-		buf.Write(crnl)
-	}
+	buf.Write(r.Body)
 	return buf.Bytes()
 }
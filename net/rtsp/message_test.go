@@ -0,0 +1,58 @@
+package rtsp
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// golden compares output with the content of golden file in testdata.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch:\n%q\nwant:\n%q", name, got, want)
+	}
+}
+
+func TestRequestPack(t *testing.T) {
+	req := &Request{Verb: VerbSetup, Proto: Version20, URI: "rtsp://10.0.0.1/live/trackID=1", Cseq: 4, Session: "12345678", Header: make(MessageHeader)}
+	req.Header.Add(HeaderTransport, "RTP/AVP/TCP;unicast;interleaved=2-3")
+	req.Header.Add(HeaderTransport, "RTP/AVP;unicast;client_port=50002-50003")
+	req.Header.Set(HeaderRequire, RequireOnvifReplay)
+	req.Header.Set(HeaderPipelinedRequests, "7")
+	req.Auth = "Basic YWRtaW46c2VjcmV0"
+	golden(t, "setup_request", req.Pack())
+}
+
+func TestUnpackFolded(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "unauthorized.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := Unpack(NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.StatusCode != RtspUnauthorized || rsp.Cseq != 3 {
+		t.Error(rsp.Status, rsp.Cseq)
+	}
+	values := rsp.Header.Values(HeaderAuthenticate)
+	if len(values) != 2 || values[0] != `Digest realm="cam", nonce="a1b2", algorithm=SHA-256, qop="auth"` || values[1] != `Basic realm="cam"` {
+		t.Errorf("%q", values)
+	}
+	golden(t, "unauthorized_response", rsp.Pack())
+}
@@ -0,0 +1,142 @@
+package rtsp
+
+// ONVIF extensions to RTSP: Profile G replay and Profile S/T audio backchannel.
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+const (
+	// RequireOnvifReplay is a feature tag to request replay of recordings from ONVIF Profile G device.
+	RequireOnvifReplay = "onvif-replay"
+	// RequireBackchannel is a feature tag to request audio backchannel from ONVIF Profile S/T device.
+	RequireBackchannel = "www.onvif.org/ver20/backchannel"
+)
+
+const (
+	// replayProfile identifies RTP header extension used by ONVIF replay.
+	replayProfile = 0xABAC
+	// replayLength is the length of RTP header extension used by ONVIF replay in 32-bit words.
+	replayLength = 3
+)
+
+// Offset between NTP epoch (1900) and Unix epoch (1970) in seconds.
+const ntpEpochOffset = 2208988800
+
+type (
+	// ReplayExtension holds data from RTP header extension that ONVIF devices add to replayed packets.
+	//  0                   1                   2                   3
+	//  0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |      0xABAC                   |        length=3               |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |                          NTP timestamp...                     |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |                          ...NTP timestamp                     |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |C|E|D|T|mbz    |       CSeq    |        padding                |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// Clean point, End of contiguous section, Discontinuity, Terminal (last packet of the stream).
+	ReplayExtension struct {
+		Time          time.Time // Absolute wall-clock time when the data was recorded.
+		Clean         bool      // Access unit is a clean point, e.g. IDR.
+		End           bool      // Last access unit before a gap in recording.
+		Discontinuity bool      // There is a gap in recording before this access unit.
+		Terminal      bool      // Last access unit of the replayed range.
+		Cseq          byte      // Low order byte of CSeq of the PLAY request that produced this packet.
+	}
+
+	// Backchannel sends audio to camera speaker over feed that device advertised for it.
+	Backchannel struct {
+		s    *Session
+		feed *Feed
+		ssrc uint32
+		sn   uint16
+	}
+)
+
+// ParseReplayExtension extracts ONVIF replay extension from RTP packet if present.
+func ParseReplayExtension(p *rtp.Packet) (ext ReplayExtension, ok bool) {
+	if !p.X() || p.XH != replayProfile || p.XL < replayLength || len(p.XD) < replayLength*4 {
+		return
+	}
+	ext.Time = NTPTime(be.Uint64(p.XD))
+	flags := p.XD[8]
+	ext.Clean = (flags & 0x80) != 0
+	ext.End = (flags & 0x40) != 0
+	ext.Discontinuity = (flags & 0x20) != 0
+	ext.Terminal = (flags & 0x10) != 0
+	ext.Cseq = p.XD[9]
+	return ext, true
+}
+
+// NTPTime converts 64-bit NTP timestamp into time.
+func NTPTime(ntp uint64) time.Time {
+	secs := int64(ntp>>32) - ntpEpochOffset
+	nsecs := (int64(ntp&0xFFFFFFFF) * int64(time.Second)) >> 32
+	return time.Unix(secs, nsecs).UTC()
+}
+
+// WallClock returns absolute time of RTP packet in replayed stream.  Replay extension is only
+// present in the first packet of each access unit so time of other packets is derived from timestamps.
+func (f *Feed) WallClock(p *rtp.Packet) (time.Time, bool) {
+	if ext, ok := ParseReplayExtension(p); ok {
+		f.clockBase = ext.Time
+		f.clockTS = p.TS
+		return ext.Time, true
+	}
+	if f.clockBase.IsZero() || f.TimeScale == 0 {
+		return time.Time{}, false
+	}
+	delta := int64(int32(p.TS-f.clockTS)) * int64(time.Second) / int64(f.TimeScale)
+	return f.clockBase.Add(time.Duration(delta)), true
+}
+
+// Backchannel looks up the feed that device provides to send audio to it.  Session must be created
+// with RequireBackchannel in Require and the feed must have been set up.
+func (s *Session) Backchannel() (*Backchannel, error) {
+	for _, f := range s.feeds {
+		if f.Direction == sdp.SendOnly && f.IsSet {
+			return &Backchannel{s: s, feed: f, ssrc: rand.Uint32(), sn: uint16(rand.Uint32())}, nil
+		}
+	}
+	return nil, errNoBackchannel
+}
+
+// Feed returns media feed used by the backchannel.  Encoding name and clock rate define what to send.
+func (b *Backchannel) Feed() *Feed {
+	return b.feed
+}
+
+// Write sends a single RTP packet with payload such as G.711 samples.  Timestamp is in feed clock rate units.
+func (b *Backchannel) Write(payload []byte, ts uint32) error {
+	p := rtp.NewPacket(byte(b.feed.PayloadType), true, b.sn, ts, b.ssrc, payload)
+	b.sn++
+	buf := p.Pack()
+	if b.feed.transp.IsTCP {
+		return b.s.WriteInterleaved(b.feed.ch, buf)
+	}
+	return b.s.WriteUDP(b.feed.ch, buf, b.feed.transp.ServerPort.One)
+}
+
+// WriteAAC sends a single AAC access unit packetized as MPEG4-GENERIC payload as defined in RFC 3640.
+func (b *Backchannel) WriteAAC(au []byte, ts uint32) error {
+	sizeLength := b.feed.SizeLength
+	indexLength := b.feed.IndexLength
+	if sizeLength == 0 {
+		// Default to AAC-hbr mode.
+		sizeLength, indexLength = 13, 3
+	}
+	bits := sizeLength + indexLength
+	header := uint32(len(au)) << uint(32-sizeLength)
+	payload := make([]byte, 2, 2+(bits+7)/8+len(au))
+	be.PutUint16(payload, uint16(bits))
+	for i := 0; i < (bits+7)/8; i++ {
+		payload = append(payload, byte(header>>uint(24-8*i)))
+	}
+	return b.Write(append(payload, au...), ts)
+}
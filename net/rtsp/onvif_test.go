@@ -0,0 +1,46 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+func TestReplayExtension(t *testing.T) {
+	when := time.Date(2019, 3, 14, 15, 9, 26, 500000000, time.UTC)
+	xd := make([]byte, 12)
+	be.PutUint64(xd, uint64(when.Unix()+ntpEpochOffset)<<32|0x80000000)
+	xd[8] = 0xA0 // Clean point and discontinuity
+	xd[9] = 7
+	out := rtp.NewPacket(96, true, 1000, 90000, 0x1234, []byte{0x65, 0x88})
+	out.VPXCC |= 0x10
+	out.XH = replayProfile
+	out.XL = replayLength
+	out.XD = xd
+
+	p, err := rtp.Unpack(out.Pack())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext, ok := ParseReplayExtension(p)
+	if !ok {
+		t.Fatal("Replay extension not found")
+	}
+	if !ext.Time.Equal(when) {
+		t.Errorf("Wrong time %v, expected %v", ext.Time, when)
+	}
+	if !ext.Clean || ext.End || !ext.Discontinuity || ext.Terminal || ext.Cseq != 7 {
+		t.Errorf("Wrong flags %#v", ext)
+	}
+
+	f := &Feed{Media: sdp.Media{TimeScale: 90000}}
+	if clock, ok := f.WallClock(p); !ok || !clock.Equal(when) {
+		t.Errorf("Wrong wall clock %v", clock)
+	}
+	next := rtp.NewPacket(96, false, 1001, 90000+45000, 0x1234, []byte{0x41})
+	if clock, ok := f.WallClock(next); !ok || !clock.Equal(when.Add(500*time.Millisecond)) {
+		t.Errorf("Wrong derived wall clock %v", clock)
+	}
+}
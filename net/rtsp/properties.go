@@ -0,0 +1,182 @@
+package rtsp
+
+// Header values introduced in RTSP 2.0 (RFC 7826) that describe media and playback behavior.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SeekRAP seeks to the closest random access point prior to requested position.
+	SeekRAP = "RAP"
+	// SeekCoRAP seeks to the closest random access point prior to requested position that is common to all media.
+	SeekCoRAP = "CoRAP"
+	// SeekFirstPrior seeks to the first unit prior to requested position that can be rendered.
+	SeekFirstPrior = "First-Prior"
+	// SeekNext seeks to the first unit following requested position that can be rendered.
+	SeekNext = "Next"
+)
+
+const (
+	// NotifyEndOfStream indicates that server has reached the end of the media.
+	NotifyEndOfStream = "end-of-stream"
+	// NotifyMediaPropertiesUpdate indicates that Media-Properties header has changed.
+	NotifyMediaPropertiesUpdate = "media-properties-update"
+	// NotifyScaleChange indicates that playback scale has changed.
+	NotifyScaleChange = "scale-change"
+)
+
+type (
+	// ScaleRange is either a single supported scale value or a range of them.
+	ScaleRange struct {
+		Min float64
+		Max float64
+	}
+
+	// MediaProperties describes properties of the media in RTSP 2.0 Media-Properties header.
+	//
+	// Media-Properties  =  "Media-Properties" ":" [media-prop-list]
+	// media-prop-list   =  media-prop-value *(COMMA media-prop-value)
+	// media-prop-value  =  ("Random-Access" [EQUAL POS-FLOAT])
+	//                   /  "Beginning-Only" / "No-Seeking"
+	//                   /  "Immutable" / "Dynamic" / "Time-Progressing"
+	//                   /  "Unlimited" / ("Time-Limited" EQUAL utc-clock)
+	//                   /  ("Time-Duration" EQUAL POS-FLOAT)
+	//                   /  ("Scales" EQUAL scale-value-list)
+	MediaProperties struct {
+		RandomAccess    bool
+		MaxDelta        float64 // Maximum time between random access points, in seconds.
+		BeginningOnly   bool
+		NoSeeking       bool
+		Immutable       bool
+		Dynamic         bool
+		TimeProgressing bool
+		Unlimited       bool
+		TimeLimited     time.Time
+		TimeDuration    float64
+		Scales          []ScaleRange
+	}
+)
+
+// ParseMediaProperties parses value of Media-Properties header.
+func ParseMediaProperties(value string) (p MediaProperties, err error) {
+	for _, field := range splitList(value) {
+		keyval := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(keyval[0]))
+		val := ""
+		if len(keyval) == 2 {
+			val = strings.Trim(strings.TrimSpace(keyval[1]), "\"")
+		}
+		switch key {
+		case "random-access":
+			p.RandomAccess = true
+			if val != "" {
+				if p.MaxDelta, err = strconv.ParseFloat(val, 64); err != nil {
+					return p, errInvalidParameter
+				}
+			}
+		case "beginning-only":
+			p.BeginningOnly = true
+		case "no-seeking":
+			p.NoSeeking = true
+		case "immutable":
+			p.Immutable = true
+		case "dynamic":
+			p.Dynamic = true
+		case "time-progressing":
+			p.TimeProgressing = true
+		case "unlimited":
+			p.Unlimited = true
+		case "time-limited":
+			if p.TimeLimited, err = parseClock(val); err != nil {
+				return p, errInvalidParameter
+			}
+		case "time-duration":
+			if p.TimeDuration, err = strconv.ParseFloat(val, 64); err != nil {
+				return p, errInvalidParameter
+			}
+		case "scales":
+			if p.Scales, err = parseScales(val); err != nil {
+				return p, err
+			}
+		}
+	}
+	return
+}
+
+// parseScales parses list of scales where each item is either a value or a range in brackets.
+func parseScales(val string) (scales []ScaleRange, err error) {
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var r ScaleRange
+		if strings.HasPrefix(item, "[") && strings.HasSuffix(item, "]") {
+			bounds := strings.SplitN(item[1:len(item)-1], ":", 2)
+			if len(bounds) != 2 {
+				return nil, errInvalidParameter
+			}
+			if r.Min, err = strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64); err != nil {
+				return nil, errInvalidParameter
+			}
+			if r.Max, err = strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64); err != nil {
+				return nil, errInvalidParameter
+			}
+		} else {
+			if r.Min, err = strconv.ParseFloat(item, 64); err != nil {
+				return nil, errInvalidParameter
+			}
+			r.Max = r.Min
+		}
+		scales = append(scales, r)
+	}
+	return
+}
+
+// parseClock parses absolute UTC time in the format used by RTSP: YYYYMMDDThhmmss[.fraction]Z.
+func parseClock(val string) (time.Time, error) {
+	return time.Parse(clockLayout, val)
+}
+
+// ParseTokens parses comma separated list of tokens such as in Accept-Ranges, Supported, or Public headers.
+func ParseTokens(value string) (tokens []string) {
+	for _, token := range splitList(value) {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return
+}
+
+// splitList splits header value on commas that are not enclosed in quotes or brackets.
+func splitList(value string) (items []string) {
+	quoted := false
+	depth := 0
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case '[':
+			if !quoted {
+				depth++
+			}
+		case ']':
+			if !quoted && depth > 0 {
+				depth--
+			}
+		case ',':
+			if !quoted && depth == 0 {
+				items = append(items, strings.TrimSpace(value[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if rest := strings.TrimSpace(value[start:]); rest != "" || len(items) > 0 {
+		items = append(items, rest)
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMediaProperties(t *testing.T) {
+	props, err := ParseMediaProperties(`Random-Access=2.5, Immutable, Time-Limited=20081128T165900.00Z, Scales="-20, [-10:-0.5], 1, [0.5:10]"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !props.RandomAccess || props.MaxDelta != 2.5 {
+		t.Errorf("Wrong random access %t, %f", props.RandomAccess, props.MaxDelta)
+	}
+	if !props.Immutable || props.Dynamic || props.Unlimited {
+		t.Errorf("Wrong flags %#v", props)
+	}
+	if !props.TimeLimited.Equal(time.Date(2008, 11, 28, 16, 59, 0, 0, time.UTC)) {
+		t.Errorf("Wrong time limit %v", props.TimeLimited)
+	}
+	expected := []ScaleRange{{-20, -20}, {-10, -0.5}, {1, 1}, {0.5, 10}}
+	if len(props.Scales) != len(expected) {
+		t.Fatalf("Parsed %d scales, expected %d", len(props.Scales), len(expected))
+	}
+	for i := range expected {
+		if props.Scales[i] != expected[i] {
+			t.Errorf("Scale %d is %v, expected %v", i, props.Scales[i], expected[i])
+		}
+	}
+}
+
+func TestParseTokens(t *testing.T) {
+	tokens := ParseTokens("npt, clock,smpte ")
+	if len(tokens) != 3 || tokens[0] != "npt" || tokens[1] != "clock" || tokens[2] != "smpte" {
+		t.Error(tokens)
+	}
+}
@@ -0,0 +1,241 @@
+package rtsp
+
+// Range and RTP-Info header values used to control and align playback of recorded media.
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// RangeNPT identifies Normal Play Time range format, i.e. time relative to the beginning of the presentation.
+	RangeNPT = "npt"
+	// RangeClock identifies absolute time range format in UTC.
+	RangeClock = "clock"
+)
+
+const clockLayout = "20060102T150405.999999999Z"
+
+type (
+	// Range describes playback range in Normal Play Time or absolute time.
+	//
+	// Range       =  "Range" ":" 1#ranges-specifier [ ";" "time" "=" utc-time ]
+	// npt-range   =  "npt" "=" ( npt-time "-" [ npt-time ] ) | ( "-" npt-time )
+	// npt-time    =  "now" | npt-sec | npt-hhmmss
+	// utc-range   =  "clock" "=" utc-time "-" [ utc-time ]
+	// utc-time    =  utc-date "T" utc-time "Z"
+	Range struct {
+		Unit  string        // Either RangeNPT or RangeClock.
+		Now   bool          // Indicates live position in NPT range.
+		Start time.Duration // Start of NPT range.
+		End   time.Duration // End of NPT range, zero for open range.
+		From  time.Time     // Start of clock range.
+		To    time.Time     // End of clock range, zero for open range.
+	}
+
+	// RTPInfo carries sequence number and timestamp of the first RTP packet sent for the stream after PLAY.
+	//
+	// RTSP 1.0:  RTP-Info: url=rtsp://example.com/bar/trackID=1;seq=45102;rtptime=12345678
+	// RTSP 2.0:  RTP-Info: url="rtsp://example.com/bar/trackID=1" ssrc=0A13C760:seq=45102;rtptime=12345678
+	RTPInfo struct {
+		URL     string
+		SSRC    string
+		Seq     uint16
+		RTPTime uint32
+		HasSeq  bool
+		HasTime bool
+	}
+
+	// PlayOptions control position, direction and pace of playback.
+	PlayOptions struct {
+		Range     *Range  // Position to play from.  Live stream or current position if nil.
+		Scale     float64 // Playback rate relative to normal, negative for reverse.  Not sent if zero.
+		Speed     float64 // Delivery rate relative to normal.  Not sent if zero.
+		SeekStyle string  // RTSP 2.0 seek policy.  Server decides if empty.
+		// NoRateControl asks ONVIF server to stream replay as fast as possible rather than in real time.
+		NoRateControl bool
+		// Immediate asks ONVIF server to start replay from new position without waiting for pending data.
+		Immediate bool
+	}
+)
+
+// NPTRange creates an open range starting at a given offset from the beginning of presentation.
+func NPTRange(start time.Duration) *Range {
+	return &Range{Unit: RangeNPT, Start: start}
+}
+
+// ClockRange creates an open range starting at a given absolute time.
+func ClockRange(from time.Time) *Range {
+	return &Range{Unit: RangeClock, From: from}
+}
+
+// ParseRange parses value of Range header or SDP range attribute.
+func ParseRange(value string) (r Range, err error) {
+	// Drop time parameter specifying when the range takes effect.
+	if i := strings.IndexByte(value, ';'); i != -1 {
+		value = value[:i]
+	}
+	keyval := strings.SplitN(strings.TrimSpace(value), "=", 2)
+	if len(keyval) != 2 {
+		return r, errInvalidParameter
+	}
+	r.Unit = strings.ToLower(keyval[0])
+	bounds := strings.SplitN(keyval[1], "-", 2)
+	if r.Unit == RangeClock {
+		// Dates do not contain dashes so splitting on the first one is safe.
+		if len(bounds) != 2 {
+			return r, errInvalidParameter
+		}
+		if r.From, err = time.Parse(clockLayout, strings.TrimSpace(bounds[0])); err != nil {
+			return r, errInvalidParameter
+		}
+		if end := strings.TrimSpace(bounds[1]); end != "" {
+			if r.To, err = time.Parse(clockLayout, end); err != nil {
+				return r, errInvalidParameter
+			}
+		}
+		return
+	}
+	if r.Unit != RangeNPT || len(bounds) != 2 {
+		return r, errInvalidParameter
+	}
+	if start := strings.TrimSpace(bounds[0]); start == "now" {
+		r.Now = true
+	} else if start != "" {
+		if r.Start, err = parseNPT(start); err != nil {
+			return
+		}
+	}
+	if end := strings.TrimSpace(bounds[1]); end != "" {
+		r.End, err = parseNPT(end)
+	}
+	return
+}
+
+// parseNPT parses Normal Play Time either in seconds or in hours, minutes, and seconds.
+func parseNPT(val string) (time.Duration, error) {
+	parts := strings.Split(val, ":")
+	if len(parts) > 3 {
+		return 0, errInvalidParameter
+	}
+	secs, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, errInvalidParameter
+	}
+	mult := 60.0
+	for i := len(parts) - 2; i >= 0; i-- {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, errInvalidParameter
+		}
+		secs += float64(v) * mult
+		mult *= 60
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// String formats range as a value of Range header.
+func (r Range) String() string {
+	b := strings.Builder{}
+	if r.Unit == RangeClock {
+		b.WriteString("clock=")
+		b.WriteString(r.From.UTC().Format(clockLayout))
+		b.WriteByte('-')
+		if !r.To.IsZero() {
+			b.WriteString(r.To.UTC().Format(clockLayout))
+		}
+		return b.String()
+	}
+	b.WriteString("npt=")
+	if r.Now {
+		b.WriteString("now")
+	} else {
+		b.WriteString(strconv.FormatFloat(r.Start.Seconds(), 'f', -1, 64))
+	}
+	b.WriteByte('-')
+	if r.End > 0 {
+		b.WriteString(strconv.FormatFloat(r.End.Seconds(), 'f', -1, 64))
+	}
+	return b.String()
+}
+
+// Advance returns open range starting at a position reached after playing for a given time at a given scale.
+func (r Range) Advance(elapsed time.Duration, scale float64) Range {
+	offset := time.Duration(float64(elapsed) * scale)
+	if r.Unit == RangeClock {
+		return Range{Unit: RangeClock, From: r.From.Add(offset)}
+	}
+	if r.Now {
+		return r
+	}
+	return Range{Unit: RangeNPT, Start: r.Start + offset}
+}
+
+// ParseRTPInfo parses value of RTP-Info header in either RTSP 1.0 or RTSP 2.0 format.
+func ParseRTPInfo(value string) (infos []RTPInfo, err error) {
+	for _, item := range splitList(value) {
+		if !strings.HasPrefix(item, "url=") {
+			return nil, errInvalidParameter
+		}
+		var info RTPInfo
+		rest := item[4:]
+		if strings.HasPrefix(rest, "\"") {
+			j := strings.IndexByte(rest[1:], '"')
+			if j == -1 {
+				return nil, errInvalidParameter
+			}
+			info.URL, rest = rest[1:j+1], rest[j+2:]
+		} else if j := strings.IndexAny(rest, "; "); j != -1 {
+			info.URL, rest = rest[:j], rest[j:]
+		} else {
+			info.URL, rest = rest, ""
+		}
+		rest = strings.TrimLeft(rest, "; ")
+		if !strings.HasPrefix(rest, "ssrc=") {
+			if err = info.parseParams(rest); err != nil {
+				return nil, err
+			}
+			infos = append(infos, info)
+			continue
+		}
+		// RTSP 2.0 provides a block of parameters per synchronization source.
+		for _, block := range strings.Fields(rest) {
+			keyval := strings.SplitN(strings.TrimPrefix(block, "ssrc="), ":", 2)
+			src := RTPInfo{URL: info.URL, SSRC: keyval[0]}
+			if len(keyval) == 2 {
+				if err = src.parseParams(keyval[1]); err != nil {
+					return nil, err
+				}
+			}
+			infos = append(infos, src)
+		}
+	}
+	return
+}
+
+func (info *RTPInfo) parseParams(params string) error {
+	for _, param := range strings.Split(params, ";") {
+		keyval := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(keyval) != 2 {
+			continue
+		}
+		switch strings.ToLower(keyval[0]) {
+		case "seq":
+			v, err := strconv.ParseUint(keyval[1], 10, 16)
+			if err != nil {
+				return errInvalidParameter
+			}
+			info.Seq = uint16(v)
+			info.HasSeq = true
+		case "rtptime":
+			v, err := strconv.ParseUint(keyval[1], 10, 32)
+			if err != nil {
+				return errInvalidParameter
+			}
+			info.RTPTime = uint32(v)
+			info.HasTime = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRangeNPT(t *testing.T) {
+	tests := []struct {
+		value string
+		start time.Duration
+		end   time.Duration
+		now   bool
+	}{
+		{"npt=0-", 0, 0, false},
+		{"npt=10.5-20", 10500 * time.Millisecond, 20 * time.Second, false},
+		{"npt=1:02:03.5-", time.Hour + 2*time.Minute + 3500*time.Millisecond, 0, false},
+		{"npt=now-", 0, 0, true},
+		{"npt=-30", 0, 30 * time.Second, false},
+	}
+	for _, test := range tests {
+		r, err := ParseRange(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if r.Unit != RangeNPT || r.Start != test.start || r.End != test.end || r.Now != test.now {
+			t.Errorf("%s: parsed into %#v", test.value, r)
+		}
+	}
+}
+
+func TestParseRangeClock(t *testing.T) {
+	r, err := ParseRange("clock=19961108T142300Z-19961108T143520.25Z;time=19970123T143720Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.From.Equal(time.Date(1996, 11, 8, 14, 23, 0, 0, time.UTC)) {
+		t.Errorf("Wrong start %v", r.From)
+	}
+	if !r.To.Equal(time.Date(1996, 11, 8, 14, 35, 20, 250000000, time.UTC)) {
+		t.Errorf("Wrong end %v", r.To)
+	}
+	if s := r.String(); s != "clock=19961108T142300Z-19961108T143520.25Z" {
+		t.Error(s)
+	}
+}
+
+func TestRangeAdvance(t *testing.T) {
+	r := NPTRange(10*time.Second).Advance(4*time.Second, -2)
+	if s := r.String(); s != "npt=2-" {
+		t.Error(s)
+	}
+	from := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r = ClockRange(from).Advance(time.Minute, 4)
+	if s := r.String(); s != "clock=20200102T030805Z-" {
+		t.Error(s)
+	}
+}
+
+func TestParseRTPInfo(t *testing.T) {
+	infos, err := ParseRTPInfo("url=rtsp://foo.com/bar.avi/streamid=0;seq=45102;rtptime=12345678,url=rtsp://foo.com/bar.avi/streamid=1;seq=30211")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Parsed %d infos, expected 2", len(infos))
+	}
+	if infos[0].URL != "rtsp://foo.com/bar.avi/streamid=0" || infos[0].Seq != 45102 || infos[0].RTPTime != 12345678 || !infos[0].HasTime {
+		t.Errorf("Wrong first info %#v", infos[0])
+	}
+	if infos[1].Seq != 30211 || infos[1].HasTime {
+		t.Errorf("Wrong second info %#v", infos[1])
+	}
+}
+
+func TestParseRTPInfo20(t *testing.T) {
+	infos, err := ParseRTPInfo(`url="rtsp://example.com/foo/audio" ssrc=0A13C760:seq=45102;rtptime=12345678, url="rtsp://example.com/foo/video" ssrc=9A9DE123:seq=30211;rtptime=29567112`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("Parsed %d infos, expected 2", len(infos))
+	}
+	if infos[1].URL != "rtsp://example.com/foo/video" || infos[1].SSRC != "9A9DE123" || infos[1].Seq != 30211 || infos[1].RTPTime != 29567112 {
+		t.Errorf("Wrong info %#v", infos[1])
+	}
+}
+
+func TestParseRTPInfo20Sources(t *testing.T) {
+	// Parameters of one synchronization source do not carry over to the next.
+	infos, err := ParseRTPInfo(`url="rtsp://example.com/foo/video" ssrc=0A13C760:seq=45102;rtptime=12345678 ssrc=9A9DE123:rtptime=29567112 ssrc=4D5E6F70`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RTPInfo{
+		{URL: "rtsp://example.com/foo/video", SSRC: "0A13C760", Seq: 45102, RTPTime: 12345678, HasSeq: true, HasTime: true},
+		{URL: "rtsp://example.com/foo/video", SSRC: "9A9DE123", RTPTime: 29567112, HasTime: true},
+		{URL: "rtsp://example.com/foo/video", SSRC: "4D5E6F70"},
+	}
+	if len(infos) != len(expected) {
+		t.Fatalf("Parsed %d infos, expected %d", len(infos), len(expected))
+	}
+	for i, e := range expected {
+		if infos[i] != e {
+			t.Errorf("Wrong info %#v, expected %#v", infos[i], e)
+		}
+	}
+}
+
+func TestFeedAccept(t *testing.T) {
+	f := &Feed{}
+	f.align(RTPInfo{Seq: 2, HasSeq: true})
+	if f.accept(65530) || f.accept(1) {
+		t.Error("Accepted packet preceding the first one")
+	}
+	if !f.accept(3) || !f.accept(1) {
+		t.Error("Rejected packet after alignment")
+	}
+}
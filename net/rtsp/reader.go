@@ -0,0 +1,137 @@
+package rtsp
+
+// Reader splits a stream coming from RTSP server or client into interleaved packets, responses, and requests.
+// It works over any io.Reader so that protocol handling can be tested with recorded byte streams.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// DefaultMaxHeaderBytes is the default limit on the size of message headers including start line.
+	DefaultMaxHeaderBytes = 64 << 10
+	// DefaultMaxBodyBytes is the default limit on the size of message body.
+	DefaultMaxBodyBytes = 4 << 20
+)
+
+var (
+	// ErrHeaderTooLarge indicates that message headers exceed the limit.
+	ErrHeaderTooLarge = errors.New("Message header is too large")
+	// ErrBodyTooLarge indicates that message body exceeds the limit.
+	ErrBodyTooLarge = errors.New("Message body is too large")
+)
+
+// Reader reads RTSP messages and interleaved binary packets from a stream.
+type Reader struct {
+	rdr            *bufio.Reader
+	MaxHeaderBytes int // Limit on the size of message headers.  DefaultMaxHeaderBytes if zero.
+	MaxBodyBytes   int // Limit on the size of message body.  DefaultMaxBodyBytes if zero.
+	header         int // Bytes of message header read so far.
+}
+
+// NewReader creates a reader over a stream.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{rdr: bufio.NewReaderSize(r, 2048)}
+}
+
+// ReadMessage reads the next unit from the stream.  It returns either RawPacket for `$` framed
+// interleaved data, *Response for a message starting with protocol version, or *Request otherwise.
+func (r *Reader) ReadMessage() (interface{}, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] == '$' {
+		return r.ReadInterleaved()
+	}
+	// Responses start with protocol version, anything else is a request.
+	if b, err = r.Peek(5); err != nil {
+		return nil, err
+	}
+	if string(b) == "RTSP/" {
+		return Unpack(r)
+	}
+	return UnpackRequest(r)
+}
+
+// ReadInterleaved reads packet framed with `$`, channel number, and 2-byte length.
+func (r *Reader) ReadInterleaved() (pkt RawPacket, err error) {
+	var hdr [4]byte
+	if _, err = io.ReadFull(r.rdr, hdr[:]); err != nil {
+		return
+	}
+	if hdr[0] != '$' {
+		return pkt, errBadResponse
+	}
+	pkt.Channel = hdr[1]
+	pkt.Payload, err = r.ReadBytes(int(be.Uint16(hdr[2:])))
+	return
+}
+
+// Peek returns the next n bytes without advancing the reader.
+func (r *Reader) Peek(n int) ([]byte, error) {
+	return r.rdr.Peek(n)
+}
+
+// ReadLine reads until the first occurrence of newline,
+// returning a string containing the data up to and including newline.
+// Lines count towards the header limit which is reset when the whole message is read.
+func (r *Reader) ReadLine() (string, error) {
+	limit := r.MaxHeaderBytes
+	if limit <= 0 {
+		limit = DefaultMaxHeaderBytes
+	}
+	var line []byte
+	for {
+		b, err := r.rdr.ReadSlice('\n')
+		r.header += len(b)
+		if r.header > limit {
+			return "", ErrHeaderTooLarge
+		}
+		line = append(line, b...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// ReadUint16 reads and returns an unsigned 2-byte integer.
+func (r *Reader) ReadUint16() (data uint16, err error) {
+	err = binary.Read(r.rdr, be, &data)
+	return
+}
+
+// Discard skips the next n bytes, returning the number of bytes discarded.
+func (r *Reader) Discard(n int) (discarded int, err error) {
+	return r.rdr.Discard(n)
+}
+
+// ReadByte reads and returns a single byte.
+func (r *Reader) ReadByte() (byte, error) {
+	return r.rdr.ReadByte()
+}
+
+// ReadBytes reads requested number of bytes, returning a slice containing the data.
+func (r *Reader) ReadBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r.rdr, b)
+	return b, err
+}
+
+// readBody reads message body of a given length enforcing the limit.
+func (r *Reader) readBody(length int) ([]byte, error) {
+	limit := r.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	if length > limit {
+		return nil, ErrBodyTooLarge
+	}
+	if length <= 0 {
+		return nil, nil
+	}
+	return r.ReadBytes(length)
+}
@@ -0,0 +1,425 @@
+// Package rtsptest provides a fake IP camera for testing RTSP clients without hardware.
+//
+// Camera listens on loopback, describes media with configurable SDP, challenges clients with Basic or
+// Digest authentication, and streams recorded RTP packets over interleaved TCP or UDP.  Quirks
+// emulate misbehaving devices: packet loss and reordering, slow responses, missing CSeq, wrong
+// Content-Length, sessions that expire, and connections dropped on RTSP 2.0 requests.
+package rtsptest
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+var be = binary.BigEndian
+
+type (
+	// Quirks make camera misbehave in ways real devices do.
+	Quirks struct {
+		Loss               float64       // Fraction of packets to drop, 0 to 1.
+		Reorder            int           // Swap every Nth packet with the one that follows it.  Disabled if zero.
+		Delay              time.Duration // Delay before sending each response.
+		NoCSeq             bool          // Omit CSeq header from responses.
+		ContentLengthDelta int           // Added to actual length of body in Content-Length header.
+		SessionTimeout     time.Duration // Close connection if client sends no requests within timeout.
+		CloseOnVersion20   bool          // Close connection upon RTSP 2.0 request like some RTSP 1.0 firmware.
+	}
+
+	// Camera is a fake RTSP server emulating IP camera.
+	Camera struct {
+		SDP      []byte              // Session description.  Control attributes identify tracks.
+		Packets  []Packet            // RTP packets to stream after PLAY.
+		Interval time.Duration       // Pause between packets.  Default is 1ms.
+		Loop     bool                // Restart from the first packet after the last one is sent.
+		Auth     *rtsp.Authenticator // Challenges clients if not nil.
+		Quirks   Quirks
+		URL      string // Address of the stream, available after Start.
+
+		mu       sync.Mutex
+		listener net.Listener
+		udp      *net.UDPConn
+		rtcp     *net.UDPConn // Receives RTCP reports from clients.
+		conns    map[*conn]struct{}
+		requests []string
+		reports  int
+		rnd      *rand.Rand
+	}
+
+	// Packet is a single RTP packet of a track.  Track is the index of media in SDP.
+	Packet struct {
+		Track int
+		Data  []byte
+	}
+
+	// conn is a client connection and its session state.  Camera supports a single session per connection.
+	conn struct {
+		net.Conn
+		wmu     sync.Mutex
+		cam     *Camera
+		session string
+		nonce   rtsp.Nonce // Digest nonce issued to the client.
+		tracks  map[int]*track
+		stop    chan struct{}
+	}
+
+	// track is transport set up for a single media.
+	track struct {
+		tcp  bool
+		ch   byte
+		addr *net.UDPAddr
+	}
+)
+
+// Start listens on loopback and serves clients until Close is called.
+func (cam *Camera) Start() error {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		l.Close()
+		return err
+	}
+	rtcp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		udp.Close()
+		l.Close()
+		return err
+	}
+	cam.listener = l
+	cam.udp = udp
+	cam.rtcp = rtcp
+	cam.conns = make(map[*conn]struct{})
+	cam.rnd = rand.New(rand.NewSource(1))
+	cam.URL = "rtsp://" + l.Addr().String() + "/stream"
+	if cam.Interval == 0 {
+		cam.Interval = time.Millisecond
+	}
+	go cam.serve()
+	go cam.receive()
+	return nil
+}
+
+// Close stops camera and closes all client connections.
+func (cam *Camera) Close() error {
+	cam.mu.Lock()
+	for c := range cam.conns {
+		c.Close()
+	}
+	cam.mu.Unlock()
+	cam.udp.Close()
+	cam.rtcp.Close()
+	return cam.listener.Close()
+}
+
+// Requests returns verbs of requests camera has received so far in order.
+func (cam *Camera) Requests() []string {
+	cam.mu.Lock()
+	defer cam.mu.Unlock()
+	return append([]string(nil), cam.requests...)
+}
+
+// Reports returns number of RTCP packets clients have sent to the camera over UDP.
+func (cam *Camera) Reports() int {
+	cam.mu.Lock()
+	defer cam.mu.Unlock()
+	return cam.reports
+}
+
+// receive counts RTCP packets until the listener is closed.
+func (cam *Camera) receive() {
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := cam.rtcp.ReadFromUDP(buf); err != nil {
+			return
+		}
+		cam.mu.Lock()
+		cam.reports++
+		cam.mu.Unlock()
+	}
+}
+
+func (cam *Camera) serve() {
+	for {
+		nc, err := cam.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc, cam: cam, tracks: make(map[int]*track)}
+		cam.mu.Lock()
+		cam.conns[c] = struct{}{}
+		cam.mu.Unlock()
+		go c.serve()
+	}
+}
+
+// media returns descriptions of tracks from SDP.
+func (cam *Camera) media() []sdp.Media {
+	return sdp.Parse(cam.SDP)
+}
+
+// drop decides whether to lose a packet.
+func (cam *Camera) drop() bool {
+	if cam.Quirks.Loss <= 0 {
+		return false
+	}
+	cam.mu.Lock()
+	defer cam.mu.Unlock()
+	return cam.rnd.Float64() < cam.Quirks.Loss
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.teardown()
+		c.Close()
+		c.cam.mu.Lock()
+		delete(c.cam.conns, c)
+		c.cam.mu.Unlock()
+	}()
+	rdr := rtsp.NewReader(c)
+	for {
+		if c.cam.Quirks.SessionTimeout > 0 {
+			c.SetReadDeadline(time.Now().Add(c.cam.Quirks.SessionTimeout))
+		}
+		msg, err := rdr.ReadMessage()
+		if err != nil {
+			return
+		}
+		req, ok := msg.(*rtsp.Request)
+		if !ok {
+			// Ignore receiver reports and responses.
+			continue
+		}
+		c.cam.mu.Lock()
+		c.cam.requests = append(c.cam.requests, req.Verb)
+		c.cam.mu.Unlock()
+		if c.cam.Quirks.CloseOnVersion20 && req.Proto == rtsp.Version20 {
+			return
+		}
+		if err = c.handle(req); err != nil {
+			return
+		}
+	}
+}
+
+func (c *conn) handle(req *rtsp.Request) error {
+	cam := c.cam
+	if req.Verb != rtsp.VerbOptions && cam.Auth != nil && !cam.Auth.Verify(&c.nonce, req) {
+		return c.reply(req, rtsp.RtspUnauthorized, rtsp.Headers{rtsp.HeaderAuthenticate: cam.Auth.Challenge(&c.nonce)}, nil)
+	}
+	if req.Verb != rtsp.VerbOptions && req.Verb != rtsp.VerbDescribe && req.Verb != rtsp.VerbSetup {
+		if id := strings.SplitN(req.Header.Get(rtsp.HeaderSession), ";", 2)[0]; id == "" || id != c.session {
+			return c.reply(req, rtsp.RtspSessionNotFound, nil, nil)
+		}
+	}
+	switch req.Verb {
+	case rtsp.VerbOptions:
+		return c.reply(req, rtsp.RtspOK, rtsp.Headers{rtsp.HeaderPublic: "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER"}, nil)
+	case rtsp.VerbDescribe:
+		return c.reply(req, rtsp.RtspOK, rtsp.Headers{rtsp.HeaderContentType: "application/sdp", rtsp.HeaderContentBase: cam.URL + "/"}, cam.SDP)
+	case rtsp.VerbSetup:
+		return c.setup(req)
+	case rtsp.VerbPlay:
+		return c.play(req)
+	case rtsp.VerbPause:
+		c.pause()
+		return c.reply(req, rtsp.RtspOK, rtsp.Headers{rtsp.HeaderSession: c.session}, nil)
+	case rtsp.VerbTeardown:
+		c.teardown()
+		return c.reply(req, rtsp.RtspOK, nil, nil)
+	case rtsp.VerbGetParameter:
+		return c.reply(req, rtsp.RtspOK, rtsp.Headers{rtsp.HeaderSession: c.session}, nil)
+	}
+	return c.reply(req, rtsp.RtspNotImplemented, nil, nil)
+}
+
+// setup matches control URI against tracks from SDP and remembers transport for the track.
+func (c *conn) setup(req *rtsp.Request) error {
+	idx := -1
+	for i, m := range c.cam.media() {
+		if m.Control != "" && strings.HasSuffix(req.URI, m.Control) {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return c.reply(req, rtsp.RtspNotFound, nil, nil)
+	}
+	tr := &rtsp.Transport{}
+	if err := tr.Parse(strings.Split(req.Header.Get(rtsp.HeaderTransport), ",")[0]); err != nil || tr.IsMulticast {
+		return c.reply(req, rtsp.RtspUnsupportedTransport, nil, nil)
+	}
+	t := &track{}
+	reply := &rtsp.Transport{}
+	if tr.IsTCP {
+		if !tr.IsInterleaved {
+			tr.Interleave = rtsp.Pair{One: idx * 2, Two: idx*2 + 1}
+		}
+		t.tcp = true
+		t.ch = byte(tr.Interleave.One)
+		reply.IsTCP = true
+		reply.IsInterleaved = true
+		reply.Interleave = tr.Interleave
+	} else {
+		t.addr = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tr.ClientPort.One}
+		reply.ClientPort = tr.ClientPort
+		reply.ServerPort = rtsp.Pair{One: c.cam.udp.LocalAddr().(*net.UDPAddr).Port, Two: c.cam.rtcp.LocalAddr().(*net.UDPAddr).Port}
+	}
+	if c.session == "" {
+		c.session = strconv.FormatUint(uint64(rand.Uint32()), 16)
+	}
+	c.tracks[idx] = t
+	return c.reply(req, rtsp.RtspOK, rtsp.Headers{rtsp.HeaderTransport: reply.String(), rtsp.HeaderSession: c.session}, nil)
+}
+
+// play starts streaming packets of tracks that have been set up.
+func (c *conn) play(req *rtsp.Request) error {
+	c.pause()
+	// Tell client sequence number and timestamp of the first packet of every track.
+	var infos []string
+	for i, m := range c.cam.media() {
+		if _, ok := c.tracks[i]; !ok {
+			continue
+		}
+		for _, p := range c.cam.Packets {
+			if p.Track == i && len(p.Data) >= 12 {
+				infos = append(infos, "url="+c.cam.URL+"/"+m.Control+
+					";seq="+strconv.Itoa(int(be.Uint16(p.Data[2:])))+
+					";rtptime="+strconv.FormatUint(uint64(be.Uint32(p.Data[4:])), 10))
+				break
+			}
+		}
+	}
+	headers := rtsp.Headers{rtsp.HeaderSession: c.session, rtsp.HeaderRange: "npt=0.000-"}
+	if len(infos) > 0 {
+		headers[rtsp.HeaderRTPInfo] = strings.Join(infos, ",")
+	}
+	if err := c.reply(req, rtsp.RtspOK, headers, nil); err != nil {
+		return err
+	}
+	c.stop = make(chan struct{})
+	go c.stream(c.stop)
+	return nil
+}
+
+func (c *conn) pause() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+func (c *conn) teardown() {
+	c.pause()
+	c.session = ""
+	c.tracks = make(map[int]*track)
+}
+
+// stream sends packets to the client applying loss and reordering quirks.
+func (c *conn) stream(stop chan struct{}) {
+	cam := c.cam
+	tick := time.NewTicker(cam.Interval)
+	defer tick.Stop()
+	tracks := make(map[int]*track, len(c.tracks))
+	for i, t := range c.tracks {
+		tracks[i] = t
+	}
+	// deliver waits for the next tick and sends packet unless it is lost.
+	deliver := func(t *track, data []byte) bool {
+		select {
+		case <-stop:
+			return false
+		case <-tick.C:
+		}
+		if cam.drop() {
+			return true
+		}
+		return c.send(t, data) == nil
+	}
+	for {
+		n := 0
+		var held []byte
+		var heldTrack *track
+		for _, p := range cam.Packets {
+			t, ok := tracks[p.Track]
+			if !ok {
+				continue
+			}
+			n++
+			if cam.Quirks.Reorder > 0 && n%cam.Quirks.Reorder == 0 {
+				// Hold the packet back and send it after the next one.
+				held, heldTrack = p.Data, t
+				continue
+			}
+			if !deliver(t, p.Data) {
+				return
+			}
+			if held != nil {
+				if !deliver(heldTrack, held) {
+					return
+				}
+				held = nil
+			}
+		}
+		if held != nil && !deliver(heldTrack, held) {
+			return
+		}
+		if !cam.Loop {
+			return
+		}
+	}
+}
+
+func (c *conn) send(t *track, data []byte) error {
+	if !t.tcp {
+		_, err := c.cam.udp.WriteToUDP(data, t.addr)
+		return err
+	}
+	buf := make([]byte, 4, 4+len(data))
+	buf[0] = '$'
+	buf[1] = t.ch
+	buf[2] = byte(len(data) >> 8)
+	buf[3] = byte(len(data))
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(append(buf, data...))
+	return err
+}
+
+func (c *conn) reply(req *rtsp.Request, code int, headers rtsp.Headers, body []byte) error {
+	if d := c.cam.Quirks.Delay; d > 0 {
+		time.Sleep(d)
+	}
+	rsp := &rtsp.Response{
+		Proto:  rtsp.Version10,
+		Status: strconv.Itoa(code) + " " + rtsp.StatusText(code),
+		Header: make(rtsp.MessageHeader),
+	}
+	if !c.cam.Quirks.NoCSeq {
+		rsp.Header.Set(rtsp.HeaderCSeq, strconv.Itoa(req.Cseq))
+	}
+	rsp.Header.Set(rtsp.HeaderServer, "rtsptest")
+	for key, value := range headers {
+		rsp.Header.Set(key, value)
+	}
+	buf := rsp.Pack()
+	if len(body) > 0 {
+		// Pack body separately to be able to lie about its length.
+		buf = buf[:len(buf)-2]
+		buf = append(buf, rtsp.HeaderContentLength+": "+strconv.Itoa(len(body)+c.cam.Quirks.ContentLengthDelta)+"\r\n\r\n"...)
+		buf = append(buf, body...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.Write(buf)
+	return err
+}
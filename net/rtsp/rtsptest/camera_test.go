@@ -0,0 +1,220 @@
+package rtsptest
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+)
+
+func start(t *testing.T, cam *Camera) {
+	t.Helper()
+	packets, err := LoadPackets(filepath.Join("testdata", "h264_aac.rtp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cam.SDP = []byte(SDP)
+	cam.Packets = packets
+	if err = cam.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cam.Close() })
+}
+
+// dial connects to the camera bypassing session so that raw responses can be checked.
+func dial(t *testing.T, cam *Camera) (net.Conn, *rtsp.Reader) {
+	t.Helper()
+	nc, err := net.Dial("tcp", cam.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { nc.Close() })
+	nc.SetDeadline(time.Now().Add(5 * time.Second))
+	return nc, rtsp.NewReader(nc)
+}
+
+// exchange sends request over raw connection and reads response to it.
+func exchange(t *testing.T, nc net.Conn, rdr *rtsp.Reader, req *rtsp.Request) *rtsp.Response {
+	t.Helper()
+	if req.Header == nil {
+		req.Header = make(rtsp.MessageHeader)
+	}
+	if _, err := nc.Write(req.Pack()); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := rdr.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, ok := msg.(*rtsp.Response)
+	if !ok {
+		t.Fatalf("expected response, got %T", msg)
+	}
+	return rsp
+}
+
+// play opens session to the camera, plays it, and collects packets until stream stops.
+func play(t *testing.T, uri string, proto int) (s *rtsp.Session, packets []rtsp.RawPacket) {
+	t.Helper()
+	s = rtsp.NewSession()
+	if err := s.Open(uri, proto); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Conn.Close() })
+	ExpectStage(t, s, rtsp.StageReady)
+	if err := s.Play(nil); err != nil {
+		t.Fatal(err)
+	}
+	ExpectStage(t, s, rtsp.StagePlay)
+	for {
+		select {
+		case pkt := <-s.Data:
+			packets = append(packets, pkt)
+		case <-time.After(500 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestCameraDigestTCP(t *testing.T) {
+	cam := &Camera{Auth: rtsp.NewAuthenticator("rtsptest", map[string]string{"admin": "secret"}, true)}
+	start(t, cam)
+	s, packets := play(t, strings.Replace(cam.URL, "rtsp://", "rtsp://admin:secret@", 1), rtsp.ProtoTCP)
+	if len(packets) != len(cam.Packets) {
+		t.Errorf("expected %d packets, got %d", len(cam.Packets), len(packets))
+	}
+	if err := s.Teardown(); err != nil {
+		t.Fatal(err)
+	}
+	ExpectStage(t, s, rtsp.StageDone)
+	verbs := strings.Join(cam.Requests(), " ")
+	if !strings.Contains(verbs, "DESCRIBE DESCRIBE SETUP SETUP PLAY") {
+		t.Error(verbs)
+	}
+}
+
+func TestCameraUDP(t *testing.T) {
+	cam := &Camera{}
+	start(t, cam)
+	_, packets := play(t, cam.URL, rtsp.ProtoUnicast)
+	if len(packets) != len(cam.Packets) {
+		t.Errorf("expected %d packets, got %d", len(cam.Packets), len(packets))
+	}
+}
+
+func TestCameraLossReorder(t *testing.T) {
+	cam := &Camera{Quirks: Quirks{Loss: 0.2, Reorder: 4, Delay: 10 * time.Millisecond}}
+	start(t, cam)
+	_, packets := play(t, cam.URL, rtsp.ProtoTCP)
+	if len(packets) == 0 || len(packets) >= len(cam.Packets) {
+		t.Errorf("expected some of %d packets to be lost, got %d", len(cam.Packets), len(packets))
+	}
+	reordered := false
+	last := map[byte]uint16{}
+	for _, pkt := range packets {
+		sn := be.Uint16(pkt.Payload[2:])
+		if prev, ok := last[pkt.Channel]; ok && int16(sn-prev) < 0 {
+			reordered = true
+		}
+		last[pkt.Channel] = sn
+	}
+	if !reordered {
+		t.Error("expected packets out of order")
+	}
+}
+
+func TestCameraCloseOnVersion20(t *testing.T) {
+	cam := &Camera{Quirks: Quirks{CloseOnVersion20: true}}
+	start(t, cam)
+	s, packets := play(t, cam.URL, rtsp.ProtoTCP)
+	if len(packets) != len(cam.Packets) || s.Version != rtsp.Version10 {
+		t.Errorf("expected %d packets over %s, got %d over %s", len(cam.Packets), rtsp.Version10, len(packets), s.Version)
+	}
+	if verbs := strings.Join(cam.Requests(), " "); !strings.HasPrefix(verbs, "OPTIONS OPTIONS DESCRIBE") {
+		t.Error(verbs)
+	}
+}
+
+func TestCameraBasic(t *testing.T) {
+	cam := &Camera{Auth: rtsp.NewAuthenticator("rtsptest", map[string]string{"admin": "secret"}, false)}
+	start(t, cam)
+	_, packets := play(t, cam.URL[:7]+"admin:secret@"+cam.URL[7:], rtsp.ProtoTCP)
+	if len(packets) != len(cam.Packets) {
+		t.Errorf("expected %d packets, got %d", len(cam.Packets), len(packets))
+	}
+	if verbs := strings.Join(cam.Requests(), " "); !strings.Contains(verbs, "DESCRIBE DESCRIBE SETUP") {
+		t.Error(verbs)
+	}
+}
+
+func TestCameraRTCP(t *testing.T) {
+	cam := &Camera{}
+	start(t, cam)
+	nc, rdr := dial(t, cam)
+	req := &rtsp.Request{Verb: rtsp.VerbSetup, URI: cam.URL + "/trackID=1", Cseq: 1, Header: make(rtsp.MessageHeader)}
+	req.Header.Set(rtsp.HeaderTransport, "RTP/AVP;unicast;client_port=50100-50101")
+	rsp := exchange(t, nc, rdr, req)
+	tr := &rtsp.Transport{}
+	if err := tr.Parse(rsp.Header.Get(rtsp.HeaderTransport)); err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: tr.ServerPort.Two})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	// Empty receiver report.
+	if _, err = udp.Write([]byte{0x80, 201, 0, 1, 0, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); cam.Reports() == 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no report received on server port %d", tr.ServerPort.Two)
+		}
+	}
+}
+
+func TestCameraNoCSeq(t *testing.T) {
+	cam := &Camera{Quirks: Quirks{NoCSeq: true}}
+	start(t, cam)
+	nc, rdr := dial(t, cam)
+	rsp := exchange(t, nc, rdr, &rtsp.Request{Verb: rtsp.VerbOptions, URI: cam.URL, Cseq: 7})
+	if rsp.StatusCode != rtsp.RtspOK || rsp.Header.Get(rtsp.HeaderCSeq) != "" {
+		t.Errorf("expected OK without CSeq, got %s with CSeq %q", rsp.Status, rsp.Header.Get(rtsp.HeaderCSeq))
+	}
+}
+
+func TestCameraContentLengthDelta(t *testing.T) {
+	cam := &Camera{Quirks: Quirks{ContentLengthDelta: -10}}
+	start(t, cam)
+	nc, rdr := dial(t, cam)
+	rsp := exchange(t, nc, rdr, &rtsp.Request{Verb: rtsp.VerbDescribe, URI: cam.URL, Cseq: 1})
+	if rsp.ContentLength != int64(len(SDP)-10) || string(rsp.Body) != SDP[:len(SDP)-10] {
+		t.Errorf("expected body truncated to %d bytes, got %d", len(SDP)-10, len(rsp.Body))
+	}
+}
+
+func TestCameraSessionTimeout(t *testing.T) {
+	timeout := 200 * time.Millisecond
+	cam := &Camera{Quirks: Quirks{SessionTimeout: timeout}}
+	start(t, cam)
+	nc, rdr := dial(t, cam)
+	// Requests keep connection open past the timeout.
+	for i := 1; i <= 3; i++ {
+		if i > 1 {
+			time.Sleep(timeout * 2 / 3)
+		}
+		exchange(t, nc, rdr, &rtsp.Request{Verb: rtsp.VerbOptions, URI: cam.URL, Cseq: i})
+	}
+	idle := time.Now()
+	if _, err := rdr.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected connection to be closed, got %v", err)
+	}
+	if elapsed := time.Since(idle); elapsed < timeout/2 {
+		t.Errorf("connection closed after %v of inactivity", elapsed)
+	}
+}
@@ -0,0 +1,67 @@
+package rtsptest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/rtsp"
+)
+
+// SDP describes H.264 video and AAC audio in fixture streams from testdata.
+const SDP = "v=0\r\n" +
+	"o=- 1 1 IN IP4 127.0.0.1\r\n" +
+	"s=rtsptest\r\n" +
+	"t=0 0\r\n" +
+	"a=control:*\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1;profile-level-id=64001F;sprop-parameter-sets=Z2QAH6w0yAUAW/8BbgICAoAAAfQAADqYdDAATioAATioXeXGhgAJxUAAJxULvLhQAA==,aO48MA==\r\n" +
+	"a=control:trackID=1\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/16000/1\r\n" +
+	"a=fmtp:97 streamtype=5;profile-level-id=15;mode=AAC-hbr;config=1408;sizelength=13;indexlength=3;indexdeltalength=3\r\n" +
+	"a=control:trackID=2\r\n"
+
+// LoadPackets reads RTP packets from a file in RTSP interleaved format, i.e. a sequence of frames
+// consisting of '$', channel, 2-byte length, and packet.  Track is the channel divided by 2.
+// Frames on odd channels carry RTCP and are skipped.
+func LoadPackets(path string) ([]Packet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ReadPackets(bytes.NewReader(data))
+}
+
+// ReadPackets reads RTP packets in RTSP interleaved format from a stream until it ends.
+func ReadPackets(r io.Reader) (packets []Packet, err error) {
+	rdr := rtsp.NewReader(r)
+	for {
+		pkt, err := rdr.ReadInterleaved()
+		if err == io.EOF {
+			return packets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pkt.Channel&1 == 0 {
+			packets = append(packets, Packet{Track: int(pkt.Channel / 2), Data: pkt.Payload})
+		}
+	}
+}
+
+// ExpectStage fails test unless the next stage session signals is a given one.  It waits up to 5 seconds.
+func ExpectStage(t testing.TB, s *rtsp.Session, stage int) {
+	t.Helper()
+	select {
+	case got := <-s.State:
+		if got != stage {
+			t.Fatalf("expected stage %d, got %d", stage, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for stage %d", stage)
+	}
+}
@@ -0,0 +1,480 @@
+package rtsp
+
+// Server re-publishes media from a single upstream session to many downstream clients.
+// Cameras usually allow only a handful of concurrent sessions, so clients connect here instead.
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+var (
+	serverTimeout = time.Second * 60
+	writeTimeout  = time.Second * 2
+)
+
+// Number of interleaved packets queued for a client before it starts losing them.
+const clientQueue = 256
+
+// Verbs that server handles.
+const publicVerbs = "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER, SET_PARAMETER"
+
+type (
+	// Server accepts RTSP clients and fans out packets from upstream session to them.
+	Server struct {
+		sync.Mutex
+		Addr     string         // TCP address to listen on, ":554" if empty.
+		Source   *Session       // Upstream session providing media feeds.
+		Auth     *Authenticator // Authenticates clients if not nil.
+		UDPPort  int            // Port to send RTP over UDP from, next one is for RTCP.  Random if zero.
+		listener net.Listener
+		rtp      *net.UDPConn
+		rtcp     *net.UDPConn
+		sessions map[string]*serverSession
+		done     chan struct{}
+		closing  sync.Once
+	}
+
+	// serverConn is a single client connection.
+	serverConn struct {
+		*Conn
+		sync.Mutex // Serializes writes of responses and interleaved packets.
+		srv        *Server
+		nonce      Nonce          // Digest nonce issued to the client.
+		out        chan RawPacket // Interleaved packets waiting to be sent to the client.
+		done       chan struct{}  // Closed with the connection.
+	}
+
+	// serverSession tracks transports and playback state of a client session.
+	serverSession struct {
+		id      string
+		conn    *serverConn
+		tracks  map[int]*serverTrack
+		playing bool
+		last    time.Time
+	}
+
+	// serverTrack is a transport for a single media feed in client session.
+	serverTrack struct {
+		tcp  bool
+		ch   byte
+		rtp  *net.UDPAddr
+		rtcp *net.UDPAddr
+	}
+)
+
+// ListenAndServe listens on TCP address and serves RTSP clients.
+func (srv *Server) ListenAndServe() error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":554"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(l)
+}
+
+// Serve accepts incoming connections on the listener and serves RTSP clients.
+func (srv *Server) Serve(l net.Listener) (err error) {
+	srv.Lock()
+	srv.listener = l
+	srv.sessions = make(map[string]*serverSession)
+	srv.done = make(chan struct{})
+	if srv.rtp, err = net.ListenUDP("udp", &net.UDPAddr{Port: srv.UDPPort}); err != nil {
+		srv.Unlock()
+		return err
+	}
+	port := 0
+	if srv.UDPPort > 0 {
+		port = srv.UDPPort + 1
+	}
+	if srv.rtcp, err = net.ListenUDP("udp", &net.UDPAddr{Port: port}); err != nil {
+		srv.Unlock()
+		return err
+	}
+	srv.Unlock()
+	go srv.expire()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-srv.done:
+				return nil
+			default:
+			}
+			if isTimeoutOrTemp(err) {
+				continue
+			}
+			return err
+		}
+		c := &serverConn{
+			Conn: &Conn{conn: conn, Reader: NewReader(conn)},
+			srv:  srv,
+			out:  make(chan RawPacket, clientQueue),
+			done: make(chan struct{}),
+		}
+		go c.serve()
+		go c.send()
+	}
+}
+
+// Close stops accepting clients and closes all connections.  Subsequent calls do nothing.
+func (srv *Server) Close() (err error) {
+	srv.closing.Do(func() {
+		err = srv.close()
+	})
+	return
+}
+
+func (srv *Server) close() error {
+	srv.Lock()
+	defer srv.Unlock()
+	if srv.done != nil {
+		close(srv.done)
+	}
+	for _, sess := range srv.sessions {
+		sess.conn.conn.Close()
+	}
+	srv.sessions = nil
+	if srv.rtp != nil {
+		srv.rtp.Close()
+		srv.rtcp.Close()
+	}
+	if srv.listener != nil {
+		return srv.listener.Close()
+	}
+	return nil
+}
+
+// Publish sends packet received from upstream session to all clients that are playing respective feed.
+// Application calls it for every packet it receives from Data channel of the source session.
+func (srv *Server) Publish(pkt RawPacket) {
+	idx := -1
+	for i, f := range srv.feeds() {
+		if f.ch == pkt.Channel&^1 {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return
+	}
+	rtcp := pkt.Channel&1 != 0
+	// Clients are served outside the lock so that sending to them does not hold up requests.
+	type target struct {
+		sess  *serverSession
+		track serverTrack
+	}
+	var targets []target
+	srv.Lock()
+	for _, sess := range srv.sessions {
+		if t, ok := sess.tracks[idx]; ok && sess.playing {
+			targets = append(targets, target{sess, *t})
+		}
+	}
+	srv.Unlock()
+	for _, tg := range targets {
+		t := tg.track
+		var err error
+		switch {
+		case t.tcp && rtcp:
+			tg.sess.conn.queue(t.ch+1, pkt.Payload)
+		case t.tcp:
+			tg.sess.conn.queue(t.ch, pkt.Payload)
+		case rtcp:
+			_, err = srv.rtcp.WriteToUDP(pkt.Payload, t.rtcp)
+		default:
+			_, err = srv.rtp.WriteToUDP(pkt.Payload, t.rtp)
+		}
+		if err != nil && !isTimeoutOrTemp(err) {
+			// Client is gone.
+			log.Println(err)
+			srv.Lock()
+			delete(srv.sessions, tg.sess.id)
+			srv.Unlock()
+		}
+	}
+}
+
+// feeds returns upstream feeds that are set up to receive media.  Backchannel is not re-published.
+func (srv *Server) feeds() (feeds []*Feed) {
+	for _, f := range srv.Source.Feeds() {
+		if f.IsSet && f.Direction != sdp.SendOnly {
+			feeds = append(feeds, f)
+		}
+	}
+	return
+}
+
+// expire removes sessions that have not sent any requests within timeout.
+func (srv *Server) expire() {
+	tick := time.NewTicker(serverTimeout / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-srv.done:
+			return
+		case <-tick.C:
+			srv.Lock()
+			for id, sess := range srv.sessions {
+				// Clients with interleaved transport are kept alive by the connection itself.
+				if !sess.isTCP() && time.Since(sess.last) > serverTimeout {
+					delete(srv.sessions, id)
+				}
+			}
+			srv.Unlock()
+		}
+	}
+}
+
+func (sess *serverSession) isTCP() bool {
+	for _, t := range sess.tracks {
+		if t.tcp {
+			return true
+		}
+	}
+	return false
+}
+
+// serve reads requests from the client connection until it is closed.
+func (c *serverConn) serve() {
+	defer c.close()
+	for {
+		msg, err := c.ReadMessage()
+		if err != nil {
+			if err != io.EOF {
+				log.Println(err)
+			}
+			return
+		}
+		// Client sends receiver reports over interleaved channels.  Nothing to do with them.
+		req, ok := msg.(*Request)
+		if !ok {
+			continue
+		}
+
+		log.Println(req.Verb, redact(req.URI), req.Proto)
+
+		if err = c.handle(req); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+}
+
+// close removes sessions bound to the connection.
+func (c *serverConn) close() {
+	c.conn.Close()
+	close(c.done)
+	c.srv.Lock()
+	defer c.srv.Unlock()
+	for id, sess := range c.srv.sessions {
+		if sess.conn == c && sess.isTCP() {
+			delete(c.srv.sessions, id)
+		}
+	}
+}
+
+func (c *serverConn) handle(req *Request) error {
+	srv := c.srv
+	if req.Verb != VerbOptions && srv.Auth != nil && !srv.Auth.Verify(&c.nonce, req) {
+		return c.reply(req, RtspUnauthorized, Headers{HeaderAuthenticate: srv.Auth.Challenge(&c.nonce)}, nil)
+	}
+	var sess *serverSession
+	if id := req.Header.Get(HeaderSession); id != "" {
+		if i := strings.IndexByte(id, ';'); i != -1 {
+			id = id[:i]
+		}
+		srv.Lock()
+		sess = srv.sessions[strings.TrimSpace(id)]
+		if sess != nil {
+			sess.last = time.Now()
+		}
+		srv.Unlock()
+		if sess == nil {
+			return c.reply(req, RtspSessionNotFound, nil, nil)
+		}
+	}
+
+	switch req.Verb {
+	case VerbOptions:
+		return c.reply(req, RtspOK, Headers{HeaderPublic: publicVerbs}, nil)
+	case VerbDescribe:
+		return c.describe(req)
+	case VerbSetup:
+		return c.setup(req, sess)
+	case VerbGetParameter, VerbSetParameter:
+		return c.reply(req, RtspOK, nil, nil)
+	}
+
+	if sess == nil {
+		return c.reply(req, RtspSessionNotFound, nil, nil)
+	}
+	switch req.Verb {
+	case VerbPlay:
+		srv.Lock()
+		sess.playing = true
+		srv.Unlock()
+		return c.reply(req, RtspOK, Headers{HeaderSession: sess.id, HeaderRange: "npt=now-"}, nil)
+	case VerbPause:
+		srv.Lock()
+		sess.playing = false
+		srv.Unlock()
+		return c.reply(req, RtspOK, Headers{HeaderSession: sess.id}, nil)
+	case VerbTeardown:
+		srv.Lock()
+		delete(srv.sessions, sess.id)
+		srv.Unlock()
+		return c.reply(req, RtspOK, nil, nil)
+	}
+	return c.reply(req, RtspNotImplemented, nil, nil)
+}
+
+// describe responds with session description generated from upstream feeds.
+func (c *serverConn) describe(req *Request) error {
+	feeds := c.srv.feeds()
+	if len(feeds) == 0 {
+		return c.reply(req, RtspServiceUnavailable, nil, nil)
+	}
+	media := make([]sdp.Media, 0, len(feeds))
+	for _, f := range feeds {
+		media = append(media, f.Media)
+	}
+	host, _, _ := net.SplitHostPort(c.conn.LocalAddr().String())
+	// Track URIs in SETUP requests are resolved relative to content base.
+	base := strings.TrimSuffix(req.URI, "/") + "/"
+	return c.reply(req, RtspOK, Headers{HeaderContentType: "application/sdp", HeaderContentBase: base}, sdp.Format(host, media))
+}
+
+// setup binds transport for the feed identified by trackID in the URI to the client session.
+func (c *serverConn) setup(req *Request, sess *serverSession) error {
+	srv := c.srv
+	i := strings.LastIndex(req.URI, "trackID=")
+	if i == -1 {
+		return c.reply(req, RtspBadRequest, nil, nil)
+	}
+	idx, err := strconv.Atoi(req.URI[i+8:])
+	if err != nil || idx < 0 || idx >= len(srv.feeds()) {
+		return c.reply(req, RtspNotFound, nil, nil)
+	}
+	// Use the first transport specification that we can handle.
+	tr := &Transport{}
+	if err = tr.Parse(strings.Split(req.Header.Get(HeaderTransport), ",")[0]); err != nil || tr.IsMulticast {
+		return c.reply(req, RtspUnsupportedTransport, nil, nil)
+	}
+	t := &serverTrack{}
+	reply := &Transport{}
+	if tr.IsTCP {
+		if !tr.IsInterleaved {
+			tr.Interleave = Pair{One: idx * 2, Two: idx*2 + 1}
+		}
+		t.tcp = true
+		t.ch = byte(tr.Interleave.One)
+		reply.IsTCP = true
+		reply.IsInterleaved = true
+		reply.Interleave = tr.Interleave
+	} else {
+		if tr.ClientPort.One == 0 {
+			return c.reply(req, RtspUnsupportedTransport, nil, nil)
+		}
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		ip := net.ParseIP(host)
+		t.rtp = &net.UDPAddr{IP: ip, Port: tr.ClientPort.One}
+		t.rtcp = &net.UDPAddr{IP: ip, Port: tr.ClientPort.Two}
+		reply.ClientPort = tr.ClientPort
+		reply.ServerPort = Pair{One: srv.rtp.LocalAddr().(*net.UDPAddr).Port, Two: srv.rtcp.LocalAddr().(*net.UDPAddr).Port}
+	}
+
+	srv.Lock()
+	if sess == nil {
+		sess = &serverSession{id: newSessionID(), conn: c, tracks: make(map[int]*serverTrack)}
+		srv.sessions[sess.id] = sess
+	} else if sess.conn != c {
+		// Packets of the session go over connection that created it.  Do not let another one join.
+		srv.Unlock()
+		return c.reply(req, RtspSessionNotFound, nil, nil)
+	}
+	sess.tracks[idx] = t
+	sess.last = time.Now()
+	srv.Unlock()
+
+	return c.reply(req, RtspOK, Headers{
+		HeaderTransport: reply.String(),
+		HeaderSession:   sess.id + ";timeout=" + strconv.Itoa(int(serverTimeout/time.Second)),
+	}, nil)
+}
+
+func (c *serverConn) reply(req *Request, code int, headers Headers, body []byte) error {
+	rsp := &Response{
+		Proto:      req.Proto,
+		StatusCode: code,
+		Status:     strconv.Itoa(code) + " " + StatusText(code),
+		Cseq:       req.Cseq,
+		Header:     make(MessageHeader),
+		Body:       body,
+	}
+	rsp.Header.Set(HeaderCSeq, strconv.Itoa(req.Cseq))
+	rsp.Header.Set(HeaderServer, Agent)
+	for key, value := range headers {
+		rsp.Header.Set(key, value)
+	}
+	buf := rsp.Pack()
+
+	log.Println(redact(string(buf)))
+
+	c.Lock()
+	defer c.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// queue adds interleaved packet to be sent to the client.  Slow client loses packets rather than holds up
+// the others.
+func (c *serverConn) queue(ch byte, payload []byte) {
+	select {
+	case c.out <- RawPacket{Channel: ch, Payload: payload}:
+	default:
+	}
+}
+
+// send writes queued interleaved packets to the client until connection is closed.
+func (c *serverConn) send() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case pkt := <-c.out:
+			if err := c.writeInterleaved(pkt.Channel, pkt.Payload); err != nil && !isTimeoutOrTemp(err) {
+				// Client is gone.  Reading loop cleans up its sessions.
+				log.Println(err)
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *serverConn) writeInterleaved(ch byte, payload []byte) error {
+	c.Lock()
+	defer c.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.WriteInterleaved(ch, payload)
+}
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
@@ -0,0 +1,191 @@
+package rtsp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aboukirev/ouro/net/sdp"
+)
+
+func TestServer(t *testing.T) {
+	media := sdp.Media{Type: sdp.H264, TimeScale: 90000, Rtpmap: 96, PayloadType: 96, EncodingName: "H264", Direction: sdp.SendRecv}
+	source := &Session{feeds: []*Feed{{Media: media, ch: 0, IsSet: true}}}
+	srv := &Server{Source: source, Auth: NewAuthenticator("camera", map[string]string{"admin": "secret"}, true)}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	tcp, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	tcp.SetDeadline(time.Now().Add(5 * time.Second))
+	conn := NewReader(tcp)
+	uri := "rtsp://" + l.Addr().String() + "/live"
+	cseq := 0
+	var auth DigestAuth
+	do := func(verb, uri string, header MessageHeader, session string) *Response {
+		cseq++
+		req := &Request{Verb: verb, URI: uri, Cseq: cseq, Header: header, Session: session}
+		if auth != nil {
+			req.Auth = auth(verb, nil)
+		}
+		if _, err := tcp.Write(req.Pack()); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := Unpack(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Cseq != cseq {
+			t.Fatalf("expected CSeq %d, got %d", cseq, rsp.Cseq)
+		}
+		return rsp
+	}
+
+	rsp := do(VerbOptions, uri, nil, "")
+	if rsp.StatusCode != RtspOK || !strings.Contains(rsp.Header.Get(HeaderPublic), VerbDescribe) {
+		t.Fatal(rsp.Status, rsp.Header)
+	}
+	rsp = do(VerbDescribe, uri, nil, "")
+	if rsp.StatusCode != RtspUnauthorized {
+		t.Fatal(rsp.Status)
+	}
+	digest, err := NewDigest(uri, rsp.Header.Get(HeaderAuthenticate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth = digest.Authenticate("admin", "secret")
+	rsp = do(VerbDescribe, uri, nil, "")
+	if rsp.StatusCode != RtspOK {
+		t.Fatal(rsp.Status)
+	}
+	m := sdp.Parse(rsp.Body)
+	if len(m) != 1 || m[0].EncodingName != "H264" || m[0].Control != "trackID=0" {
+		t.Fatalf("%q", rsp.Body)
+	}
+	base := rsp.Header.Get(HeaderContentBase)
+	transport := MessageHeader{HeaderTransport: {"RTP/AVP/TCP;unicast;interleaved=4-5"}}
+	if rsp = do(VerbSetup, base+"trackID=1", transport, ""); rsp.StatusCode != RtspNotFound {
+		t.Fatal(rsp.Status)
+	}
+	if rsp = do(VerbSetup, base, transport, ""); rsp.StatusCode != RtspBadRequest {
+		t.Fatal(rsp.Status)
+	}
+	rsp = do(VerbSetup, base+m[0].Control, transport, "")
+	if rsp.StatusCode != RtspOK || rsp.Header.Get(HeaderTransport) != "RTP/AVP/TCP;interleaved=4-5" {
+		t.Fatal(rsp.Status, rsp.Header)
+	}
+	session := strings.Split(rsp.Header.Get(HeaderSession), ";")[0]
+	rsp = do(VerbPlay, uri, nil, session)
+	if rsp.StatusCode != RtspOK {
+		t.Fatal(rsp.Status)
+	}
+
+	payload := []byte{0x80, 0x60, 0x00, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0x65}
+	srv.Publish(RawPacket{Channel: 0, Payload: payload})
+	if b, _ := conn.ReadByte(); b != '$' {
+		t.Fatalf("expected interleaved frame, got %x", b)
+	}
+	if ch, _ := conn.ReadByte(); ch != 4 {
+		t.Errorf("expected channel 4, got %d", ch)
+	}
+	length, _ := conn.ReadUint16()
+	buf, err := conn.ReadBytes(int(length))
+	if err != nil || !bytes.Equal(buf, payload) {
+		t.Errorf("payload mismatch: %x", buf)
+	}
+
+	rsp = do(VerbTeardown, uri, nil, session)
+	if rsp.StatusCode != RtspOK {
+		t.Fatal(rsp.Status)
+	}
+	rsp = do(VerbPlay, uri, nil, session)
+	if rsp.StatusCode != RtspSessionNotFound {
+		t.Fatal(rsp.Status)
+	}
+}
+
+func TestServerSlowClient(t *testing.T) {
+	media := sdp.Media{Type: sdp.H264, Direction: sdp.SendRecv}
+	srv := &Server{Source: &Session{feeds: []*Feed{{Media: media, ch: 0, IsSet: true}}}}
+	client, server := net.Pipe()
+	defer client.Close()
+	c := &serverConn{Conn: &Conn{conn: server}, srv: srv, out: make(chan RawPacket, clientQueue), done: make(chan struct{})}
+	go c.send()
+	defer c.close()
+	sess := &serverSession{id: "1", conn: c, tracks: map[int]*serverTrack{0: {tcp: true, ch: 2}}, playing: true}
+	srv.sessions = map[string]*serverSession{sess.id: sess}
+
+	// Client does not read, publishing must not wait for it.
+	start := time.Now()
+	for i := 0; i < 4*clientQueue; i++ {
+		srv.Publish(RawPacket{Channel: 0, Payload: []byte{0x80, 0x60, byte(i >> 8), byte(i)}})
+	}
+	if d := time.Since(start); d >= writeTimeout {
+		t.Errorf("Publishing is held up by slow client for %v", d)
+	}
+	buf := make([]byte, 8)
+	if _, err := io.ReadFull(client, buf); err != nil || buf[0] != '$' || buf[1] != 2 {
+		t.Errorf("Unexpected data % x, %v", buf, err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Error(err)
+	}
+	srv.Close()
+}
+
+func TestServerForeignSession(t *testing.T) {
+	media := sdp.Media{Type: sdp.H264, Direction: sdp.SendRecv}
+	srv := &Server{Source: &Session{feeds: []*Feed{{Media: media, ch: 0, IsSet: true}}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	uri := "rtsp://" + l.Addr().String() + "/live/trackID=0"
+	transport := MessageHeader{HeaderTransport: {"RTP/AVP/TCP;unicast;interleaved=0-1"}}
+	setup := func(session string) *Response {
+		tcp, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { tcp.Close() })
+		tcp.SetDeadline(time.Now().Add(5 * time.Second))
+		req := &Request{Verb: VerbSetup, URI: uri, Proto: Version20, Cseq: 1, Header: transport, Session: session}
+		if _, err = tcp.Write(req.Pack()); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := Unpack(NewReader(tcp))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rsp
+	}
+
+	rsp := setup("")
+	if rsp.StatusCode != RtspOK {
+		t.Fatal(rsp.Status)
+	}
+	session := strings.Split(rsp.Header.Get(HeaderSession), ";")[0]
+	// Another connection cannot add tracks to the session.
+	if rsp = setup(session); rsp.StatusCode != RtspSessionNotFound {
+		t.Fatal(rsp.Status)
+	}
+	srv.Lock()
+	tracks := len(srv.sessions[session].tracks)
+	srv.Unlock()
+	if tracks != 1 {
+		t.Errorf("expected 1 track in the session, got %d", tracks)
+	}
+}
@@ -4,15 +4,24 @@ package rtsp
 
 import (
 	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/aboukirev/ouro/net/rtp"
 )
 
 var (
 	keepAliveTimeout = time.Second * 2
 )
 
+// Verbs that client handles when sent by the server.
+const serverVerbs = "OPTIONS, ANNOUNCE, GET_PARAMETER, SET_PARAMETER, REDIRECT, PLAY_NOTIFY"
+
 type (
 	// Session maintains RTSP session and workflow.
 	Session struct {
@@ -25,13 +34,50 @@ type (
 		done    chan struct{}
 		session string
 		feeds   []*Feed
+		sdp     []byte // Session description from response to DESCRIBE.
 		feedidx int
 		verbs   map[string]struct{}
 		last    time.Time
 		cseq    int
+		// Version is RTSP protocol version to use.  Session starts with RTSP 2.0 and falls back to 1.0
+		// if server does not support it, closes connection, or does not respond.  Set it to Version10 before attaching to skip negotiation.
+		Version      string
+		version      string          // Version to start negotiation with, e.g. after redirect.
+		negotiating  bool            // First RTSP 2.0 OPTIONS request awaits response.
+		Properties   MediaProperties // Media properties reported by RTSP 2.0 server.
+		AcceptRanges []string        // Range formats accepted by RTSP 2.0 server.
+		SeekStyle    string          // Seek style used by RTSP 2.0 server.
+		pipeline     string          // Identifier to pipeline requests before session is established.
+		resume       bool            // Indicates that playback should resume after redirect.
+		playOpts     PlayOptions     // Options of the last PLAY request.
+		played       Range           // Position at which playback started as confirmed by server.
+		playedAt     time.Time       // Time when playback started.
+		scale        float64         // Current playback scale.
+		paused       *Range          // Position at which playback was paused.
+		// Handler processes requests sent by the server that session does not handle itself.
+		Handler RequestHandler
+		// Require lists feature tags server must support, e.g. ONVIF replay or backchannel.
+		Require []string
+		// Credentials provides user name and password on request.  Credentials from URL are used if nil.
+		Credentials CredentialProvider
+		rejected    bool // Indicates that server has already rejected credentials once.
+	}
+
+	// RequestHandler processes requests sent by the server to the client such as ANNOUNCE,
+	// GET_PARAMETER, or SET_PARAMETER.  It returns status code and additional headers for the response.
+	RequestHandler interface {
+		HandleRequest(req *Request) (code int, headers Headers)
 	}
+
+	// RequestHandlerFunc is an adapter to allow use of ordinary functions as request handlers.
+	RequestHandlerFunc func(req *Request) (code int, headers Headers)
 )
 
+// HandleRequest calls f(req).
+func (f RequestHandlerFunc) HandleRequest(req *Request) (int, Headers) {
+	return f(req)
+}
+
 // NewSession returns new RTSP session manager.
 func NewSession() *Session {
 	return &Session{
@@ -39,6 +85,8 @@ func NewSession() *Session {
 		stage: StageInit,
 		queue: make(Queue),
 		verbs: make(map[string]struct{}, 11),
+		// Negotiate the latest protocol version.
+		Version: Version20,
 	}
 }
 
@@ -48,29 +96,55 @@ func (s *Session) Open(uri string, proto int) error {
 	if err != nil {
 		return err
 	}
+	return s.Attach(conn)
+}
+
+// Attach starts session over connection established by the caller, see NewConn.
+func (s *Session) Attach(conn *Conn) error {
 	s.Conn = conn
+	s.Conn.accept = s.accept
+	s.version = s.Version
+	s.negotiating = s.Version == Version20
 	go s.process()
 	return s.Options()
 }
 
-func (s *Session) authorize(challenge string) error {
+// authorize responds to authentication challenge for a request.  It fails if server rejects credentials twice.
+func (s *Session) authorize(req *Request, challenge string) error {
 	digest, err := NewDigest(s.BaseURI, challenge)
 	if err != nil {
 		return err
 	}
-	if s.URL.User == nil {
-		return errNoCredentials
+	if digest.Stale() {
+		// Credentials were fine, nonce has expired.  Retry with the new one.
+		log.Println("Stale nonce, authorizing again")
+	} else if req.Auth != "" {
+		if s.rejected {
+			return ErrAuthRejected
+		}
+		s.rejected = true
+	}
+	var provider CredentialProvider = URLCredentials{}
+	if s.Credentials != nil {
+		provider = s.Credentials
+	}
+	username, password, err := provider.Credentials(s.URL, digest.Realm())
+	if err != nil {
+		return err
 	}
-	username := s.URL.User.Username()
-	password, _ := s.URL.User.Password()
 	s.auth = digest.Authenticate(username, password)
 	return nil
 }
 
+// enqueue assigns the next CSeq to a new request and tracks it until response arrives.
+// Retransmitted request keeps its CSeq.
 func (s *Session) enqueue(req *Request) {
 	s.Lock()
 	defer s.Unlock()
-	req.Cseq = s.cseq
+	if req.Cseq == 0 {
+		s.cseq++
+		req.Cseq = s.cseq
+	}
 	req.Session = s.session
 	s.queue[req.Cseq] = req
 }
@@ -86,30 +160,41 @@ func (s *Session) dequeue(seq int) (req *Request, ok bool) {
 }
 
 func (s *Session) command(verb, uri string, headers Headers) error {
+	_, err := s.request(verb, uri, headers)
+	return err
+}
+
+// request sends a new request to the server and returns it.
+func (s *Session) request(verb, uri string, headers Headers) (*Request, error) {
 	if s.Conn == nil {
-		return errNoConnection
+		return nil, errNoConnection
 	}
-	req := &Request{Verb: verb, URI: uri, Header: make(MessageHeader)}
+	req := &Request{Verb: verb, Proto: s.Version, URI: uri, Header: make(MessageHeader)}
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
+	if len(s.Require) > 0 && verb != VerbOptions && verb != VerbTeardown {
+		req.Header.Set(HeaderRequire, strings.Join(s.Require, ", "))
+	}
 	if s.auth != nil {
 		req.Auth = s.auth(req.Verb, nil)
 	}
-	s.cseq++
 	s.enqueue(req)
 	buf := req.Pack()
 
-	log.Println(string(buf))
+	log.Println(redact(string(buf)))
 
 	if _, err := s.Write(buf); err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return req, nil
 }
 
 // Options handles client OPTIONS request in RTSP.
 func (s *Session) Options() error {
+	if s.Version == Version20 {
+		return s.command(VerbOptions, s.BaseURI, Headers{HeaderSupported: "play.basic"})
+	}
 	return s.command(VerbOptions, s.BaseURI, nil)
 }
 
@@ -126,18 +211,84 @@ func (s *Session) Setup() error {
 		if !strings.HasPrefix(uri, "rtsp://") {
 			uri = s.BaseURI + "/" + uri
 		}
-		err := s.command(VerbSetup, uri, Headers{HeaderTransport: s.feeds[i].TransportHeader()})
+		headers := Headers{}
+		if s.Version == Version20 {
+			s.feeds[i].transp.UseAddresses()
+			// Let server know that SETUP requests sent before session is established belong together.
+			if s.session == "" && len(s.feeds) > 1 {
+				if s.pipeline == "" {
+					s.pipeline = strconv.Itoa(rand.Intn(100000000))
+				}
+				headers[HeaderPipelinedRequests] = s.pipeline
+			}
+		}
+		headers[HeaderTransport] = s.feeds[i].TransportHeader()
+		req, err := s.request(VerbSetup, uri, headers)
 		if err != nil {
 			return err
 		}
-		s.feeds[i].cseq = s.cseq
+		s.feeds[i].uri = uri
+		s.feeds[i].cseq = req.Cseq
 	}
 	return nil
 }
 
-// Play handles client PLAY request in RTSP.
-func (s *Session) Play() error {
-	return s.command(VerbPlay, s.BaseURI, nil)
+// Play handles client PLAY request in RTSP.  Options may be nil to play live stream
+// or to continue from the current position.
+func (s *Session) Play(opts *PlayOptions) error {
+	headers := Headers{}
+	if opts == nil {
+		opts = &PlayOptions{}
+	}
+	// Options may point to those of the last request, e.g. when playback resumes after redirect.
+	s.playOpts = *opts
+	if opts.Range != nil {
+		headers[HeaderRange] = opts.Range.String()
+	}
+	if opts.Scale != 0 {
+		headers[HeaderScale] = strconv.FormatFloat(opts.Scale, 'f', -1, 64)
+	}
+	if opts.Speed != 0 {
+		headers[HeaderSpeed] = strconv.FormatFloat(opts.Speed, 'f', -1, 64)
+	}
+	if opts.SeekStyle != "" && s.Version == Version20 {
+		headers[HeaderSeekStyle] = opts.SeekStyle
+	}
+	if opts.NoRateControl {
+		headers[HeaderRateControl] = "no"
+	}
+	if opts.Immediate {
+		headers[HeaderImmediate] = "yes"
+	}
+	return s.command(VerbPlay, s.BaseURI, headers)
+}
+
+// Resume continues paused playback from the position where it was paused with the same scale and speed.
+func (s *Session) Resume() error {
+	opts := s.playOpts
+	opts.Range = s.paused
+	return s.Play(&opts)
+}
+
+// Position returns estimated current playback position.  Returns false if position is unknown.
+func (s *Session) Position() (Range, bool) {
+	if s.stage == StagePause && s.paused != nil {
+		return *s.paused, true
+	}
+	if s.stage != StagePlay || s.played.Unit == "" {
+		return Range{}, false
+	}
+	return s.played.Advance(time.Since(s.playedAt), s.scale), true
+}
+
+// Feeds returns media feeds described by the source.
+func (s *Session) Feeds() []*Feed {
+	return s.feeds
+}
+
+// SDP returns session description the feeds were created from.
+func (s *Session) SDP() []byte {
+	return s.sdp
 }
 
 // Pause handles client PAUSE request in RTSP.
@@ -165,31 +316,19 @@ func (s *Session) KeepAlive() error {
 }
 
 func (s *Session) receive() error {
-	var ch byte
-	var buf []byte
-	b, err := s.Peek(1)
+	msg, err := s.ReadMessage()
 	if err != nil {
 		return err
 	}
-	if b[0] == '$' {
-		s.Discard(1)
-		// RTSP allows for up to 8 transports so valid ch values are limited.
-		if ch, err = s.ReadByte(); err != nil {
-			return err
-		}
-		length, err := s.ReadUint16()
-		if err != nil {
-			return err
-		}
-		buf, err = s.ReadBytes(int(length))
-		if err == nil {
-			select {
-			case s.Data <- RawPacket{Channel: ch, Payload: append([]byte{}, buf...)}:
-			}
-		}
-		return err
+	switch m := msg.(type) {
+	case RawPacket:
+		s.deliver(m.Channel, m.Payload)
+	case *Response:
+		return s.handleRtsp(m)
+	case *Request:
+		return s.handleRequest(m)
 	}
-	return s.handleRtsp()
+	return nil
 }
 
 func (s *Session) process() {
@@ -201,9 +340,14 @@ func (s *Session) process() {
 			if err := s.KeepAlive(); err != nil && s.stage == StageDone {
 				return
 			}
-			if err := s.receive(); err != nil && !isTimeoutOrTemp(err) {
-				log.Println(err)
-				return
+			if err := s.receive(); err != nil {
+				if s.negotiating && s.fallback(err) {
+					continue
+				}
+				if !isTimeoutOrTemp(err) {
+					log.Println(err)
+					return
+				}
 			}
 		}
 	}
@@ -217,47 +361,58 @@ func (s *Session) notify(stage int) {
 	}
 }
 
-func (s *Session) handleRtsp() (err error) {
-	var rsp *Response
-	if rsp, err = Unpack(s.Conn); err != nil {
-		return err
-	}
-
-	log.Println(string(rsp.Pack()))
+func (s *Session) handleRtsp(rsp *Response) (err error) {
+	log.Println(redact(string(rsp.Pack())))
 
 	req, ok := s.dequeue(rsp.Cseq)
 	if !ok {
-		// FIXME: Did not find matching request.  Maybe just drop response.
-		return errBadResponse
+		// Did not find matching request.  Drop response and keep going.
+		log.Println(errBadResponse)
+		return nil
+	}
+	s.negotiating = false
+
+	if req.Verb == VerbOptions && s.stage == StageInit && s.Version == Version20 &&
+		(rsp.Proto != Version20 || rsp.StatusCode == RtspVersionNotSupported || rsp.StatusCode == RtspBadRequest) {
+		// Server does not speak RTSP 2.0.  Start over with RTSP 1.0.
+		s.Version = Version10
+		return s.Options()
+	}
+
+	if rsp.StatusCode == RtspMovedPermanently || rsp.StatusCode == RtspFound {
+		if location := rsp.Header.Get(HeaderLocation); location != "" {
+			return s.redirect(location)
+		}
 	}
 
 	if rsp.StatusCode == RtspUnauthorized {
-		if err = s.authorize(rsp.Header.Get(HeaderAuthenticate)); err != nil {
+		if err = s.authorize(req, strings.Join(rsp.Header.Values(HeaderAuthenticate), ", ")); err != nil {
 			return err
 		}
 		req.Auth = s.auth(req.Verb, nil)
 		// Treat this as retransmit of the same message.
-		// If the following line is uncommented, it will break matching responses to transport setup commands based on checking CSeq.
-		// s.cseq++
+		// Request keeps its CSeq so that responses to transport setup commands still match feeds.
 		s.enqueue(req)
 		buf := req.Pack()
 
-		log.Println(string(buf))
+		log.Println(redact(string(buf)))
 
 		_, err = s.Write(buf)
 		return err
 	}
 
+	s.rejected = false
 	if sess := rsp.Header.Get(HeaderSession); sess != "" {
 		if fields := strings.Split(sess, ";"); len(fields) > 0 {
 			s.session = fields[0]
 		}
 	}
+	s.updateProperties(rsp.Header)
 
 	switch req.Verb {
 	case VerbOptions:
 		if s.stage == StageInit {
-			for _, v := range strings.Split(rsp.Header.Get(HeaderPublic), ", ") {
+			for _, v := range ParseTokens(strings.Join(rsp.Header.Values(HeaderPublic), ",")) {
 				s.verbs[v] = struct{}{}
 			}
 			return s.Describe()
@@ -267,14 +422,17 @@ func (s *Session) handleRtsp() (err error) {
 			if s.feeds, err = ParseFeeds(s.Proto, rsp.Body); err != nil {
 				return err
 			}
+			s.sdp = rsp.Body
 			return s.Setup()
 		}
 	case VerbPause:
 		if rsp.StatusCode == RtspOK {
+			s.handlePause(rsp)
 			s.notify(StagePause)
 		}
 	case VerbPlay:
 		if rsp.StatusCode == RtspOK {
+			s.handlePlay(rsp)
 			s.notify(StagePlay)
 		}
 	case VerbTeardown:
@@ -288,6 +446,296 @@ func (s *Session) handleRtsp() (err error) {
 	return
 }
 
+// handlePlay remembers playback position, scale, and the first packet for each feed.
+func (s *Session) handlePlay(rsp *Response) {
+	s.playedAt = time.Now()
+	s.paused = nil
+	s.scale = 1
+	if s.playOpts.Scale != 0 {
+		s.scale = s.playOpts.Scale
+	}
+	s.updateScale(rsp.Header)
+	s.played = Range{}
+	if val := rsp.Header.Get(HeaderRange); val != "" {
+		if r, err := ParseRange(val); err == nil {
+			s.played = r
+		} else {
+			log.Println(err)
+		}
+	} else if s.playOpts.Range != nil {
+		s.played = *s.playOpts.Range
+	}
+	if val := rsp.Header.Get(HeaderRTPInfo); val != "" {
+		infos, err := ParseRTPInfo(val)
+		if err != nil {
+			log.Println(err)
+		}
+		for _, info := range infos {
+			for _, f := range s.feeds {
+				if f.matches(info.URL) {
+					f.align(info)
+				}
+			}
+		}
+	}
+}
+
+// handlePause remembers position where playback has been paused to be able to resume from it.
+func (s *Session) handlePause(rsp *Response) {
+	s.paused = nil
+	if val := rsp.Header.Get(HeaderRange); val != "" {
+		if r, err := ParseRange(val); err == nil {
+			s.paused = &r
+			return
+		}
+	}
+	if s.played.Unit != "" {
+		r := s.played.Advance(time.Since(s.playedAt), s.scale)
+		s.paused = &r
+	}
+}
+
+// updateScale tracks changes to the playback scale.
+func (s *Session) updateScale(h MessageHeader) {
+	if val := h.Get(HeaderScale); val != "" {
+		if scale, err := strconv.ParseFloat(val, 64); err == nil {
+			s.scale = scale
+		}
+	}
+}
+
+// accept drops RTP packets that precede the first packet reported in RTP-Info after PLAY.
+func (s *Session) accept(ch byte, payload []byte) bool {
+	if len(payload) < 4 {
+		return true
+	}
+	if f := s.feed(ch); f != nil {
+		return f.accept(be.Uint16(payload[2:]))
+	}
+	return true
+}
+
+// feed finds feed that receives RTP packets on the channel.
+func (s *Session) feed(ch byte) *Feed {
+	for _, f := range s.feeds {
+		if f.ch == ch {
+			return f
+		}
+	}
+	return nil
+}
+
+// Frames depacketizes RTP packet received from the session into frames of the feed it belongs to.
+// Feed is nil for packets on other channels, e.g. RTCP.
+func (s *Session) Frames(pkt RawPacket) (f *Feed, frames []rtp.Frame, err error) {
+	if f = s.feed(pkt.Channel); f == nil || len(pkt.Payload) < rtp.HeaderSize {
+		return
+	}
+	p, err := rtp.Unpack(pkt.Payload)
+	if err != nil {
+		return
+	}
+	frames, err = f.Push(p)
+	return
+}
+
+// updateProperties keeps track of media properties reported by RTSP 2.0 server.
+func (s *Session) updateProperties(h MessageHeader) {
+	if val := h.Get(HeaderMediaProperties); val != "" {
+		if props, err := ParseMediaProperties(val); err == nil {
+			s.Properties = props
+		} else {
+			log.Println(err)
+		}
+	}
+	if val := h.Get(HeaderAcceptRanges); val != "" {
+		s.AcceptRanges = ParseTokens(val)
+	}
+	if val := h.Get(HeaderSeekStyle); val != "" {
+		s.SeekStyle = val
+	}
+}
+
+// handleRequest processes request sent by the server to the client.
+func (s *Session) handleRequest(req *Request) (err error) {
+	log.Println(req.Verb, redact(req.URI), req.Proto)
+
+	switch req.Verb {
+	case VerbOptions:
+		// Server checks that client is still alive.
+		return s.reply(req, RtspOK, Headers{HeaderPublic: serverVerbs})
+	case VerbPlayNotify:
+		return s.handlePlayNotify(req)
+	case VerbRedirect:
+		return s.handleRedirect(req)
+	}
+	if s.Handler != nil {
+		code, headers := s.Handler.HandleRequest(req)
+		return s.reply(req, code, headers)
+	}
+	switch req.Verb {
+	case VerbAnnounce, VerbGetParameter:
+		return s.reply(req, RtspOK, nil)
+	case VerbSetParameter:
+		if len(req.Body) > 0 {
+			return s.reply(req, RtspParameterNotUnderstood, nil)
+		}
+		return s.reply(req, RtspOK, nil)
+	}
+	return s.reply(req, RtspNotImplemented, nil)
+}
+
+// handleRedirect acknowledges REDIRECT request and moves session to the new location.
+func (s *Session) handleRedirect(req *Request) (err error) {
+	location := req.Header.Get(HeaderLocation)
+	if location == "" {
+		return s.reply(req, RtspBadRequest, nil)
+	}
+	if err = s.reply(req, RtspOK, nil); err != nil {
+		return err
+	}
+	return s.redirect(location)
+}
+
+// redirectURL resolves location server redirects to.  Credentials are carried over only if location is on
+// the same host and port, otherwise credential provider is asked for them upon challenge.
+func redirectURL(from *url.URL, location string) (*url.URL, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil && from.User != nil && hostPort(u) == hostPort(from) {
+		u.User = from.User
+	}
+	return u, nil
+}
+
+// hostPort returns host and port of RTSP URL in canonical form with default port.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "554"
+	}
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// redirect closes current connection and establishes a new session at a given location.
+// Playback is resumed automatically if it was in progress.
+func (s *Session) redirect(uri string) error {
+	u, err := redirectURL(s.URL, uri)
+	if err != nil {
+		return err
+	}
+	conn, err := Dial(u.String(), s.Proto)
+	if err != nil {
+		return err
+	}
+	s.replace(conn)
+
+	s.Lock()
+	s.queue = make(Queue)
+	s.session = ""
+	s.feeds = nil
+	s.feedidx = 0
+	s.auth = nil
+	s.rejected = false
+	s.verbs = make(map[string]struct{}, 11)
+	// New server negotiates protocol version anew.
+	s.Version = s.version
+	s.negotiating = s.Version == Version20
+	s.pipeline = ""
+	s.Unlock()
+
+	s.resume = s.stage == StagePlay
+	if pos, ok := s.Position(); ok && s.resume {
+		s.playOpts.Range = &pos
+	}
+	s.stage = StageInit
+	return s.Options()
+}
+
+// replace closes current connection and switches session over to a new one.  Packets keep coming over the
+// same channel the caller is already listening on.
+func (s *Session) replace(conn *Conn) {
+	conn.Data = s.Data
+	conn.accept = s.accept
+	conn.Tap = s.Tap
+	s.Close()
+	s.Lock()
+	s.Conn = conn
+	s.Unlock()
+}
+
+// fallback starts over with RTSP 1.0 when server closes connection or does not respond to the first
+// RTSP 2.0 request, as some RTSP 1.0 devices do.  It reports whether session goes on.
+func (s *Session) fallback(err error) bool {
+	s.negotiating = false
+	s.Version = Version10
+	if !isTimeoutOrTemp(err) {
+		conn, err := Dial(s.URL.String(), s.Proto)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		s.replace(conn)
+	}
+	s.Lock()
+	s.queue = make(Queue)
+	s.Unlock()
+	if err = s.Options(); err != nil {
+		log.Println(err)
+		return false
+	}
+	return true
+}
+
+// handlePlayNotify processes RTSP 2.0 PLAY_NOTIFY request.
+func (s *Session) handlePlayNotify(req *Request) (err error) {
+	if err = s.reply(req, RtspOK, nil); err != nil {
+		return err
+	}
+	switch req.Header.Get(HeaderNotifyReason) {
+	case NotifyEndOfStream:
+		// Session goes back to ready state upon reaching the end of media.
+		s.notify(StageReady)
+	case NotifyScaleChange:
+		// Rebase position so that it is estimated with the new scale from now on.
+		if pos, ok := s.Position(); ok {
+			s.played = pos
+			s.playedAt = time.Now()
+		}
+		s.updateScale(req.Header)
+		s.updateProperties(req.Header)
+	case NotifyMediaPropertiesUpdate:
+		s.updateProperties(req.Header)
+	}
+	return
+}
+
+// reply sends response to the request received from the server.
+func (s *Session) reply(req *Request, code int, headers Headers) error {
+	rsp := &Response{
+		Proto:      req.Proto,
+		StatusCode: code,
+		Status:     strconv.Itoa(code) + " " + StatusText(code),
+		Cseq:       req.Cseq,
+		Header:     make(MessageHeader),
+	}
+	rsp.Header.Set(HeaderCSeq, strconv.Itoa(req.Cseq))
+	if s.session != "" {
+		rsp.Header.Set(HeaderSession, s.session)
+	}
+	for key, value := range headers {
+		rsp.Header.Set(key, value)
+	}
+	buf := rsp.Pack()
+
+	log.Println(redact(string(buf)))
+
+	_, err := s.Write(buf)
+	return err
+}
+
 func (s *Session) handleSetup(rsp *Response) (err error) {
 	if rsp.StatusCode == RtspOK {
 		for _, f := range s.feeds {
@@ -297,11 +745,14 @@ func (s *Session) handleSetup(rsp *Response) (err error) {
 					return err
 				}
 				if !f.transp.IsTCP {
-					ch := byte(s.feedidx) * 2
-					if err := s.AddSink(ch, f.transp.Port.One); err != nil {
+					port := f.transp.ClientPort
+					if f.transp.IsMulticast {
+						port = f.transp.Port
+					}
+					if err := s.AddSink(f.ch, port.One); err != nil {
 						return err
 					}
-					if err := s.AddSink(ch+1, f.transp.Port.Two); err != nil {
+					if err := s.AddSink(f.ch+1, port.Two); err != nil {
 						return err
 					}
 				}
@@ -312,6 +763,12 @@ func (s *Session) handleSetup(rsp *Response) (err error) {
 		s.feedidx++
 	}
 	if s.feedidx >= len(s.feeds) {
+		if s.resume {
+			// Session has been redirected while playing.  Pick up where we left off.
+			s.resume = false
+			s.Start()
+			return s.Play(&s.playOpts)
+		}
 		s.notify(StageReady)
 		s.Start()
 	}
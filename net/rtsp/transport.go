@@ -42,6 +42,8 @@ type (
 		TTL           int
 		Destination   string
 		Source        string
+		DestAddr      []string // RTSP 2.0 destination addresses in host:port form, data first, control second.
+		SrcAddr       []string // RTSP 2.0 source addresses in host:port form, data first, control second.
 		SSRC          string
 		Mode          string
 	}
@@ -71,6 +73,12 @@ type (
 // channel             =   1*3(DIGIT)
 // address             =   host
 // mode                =   <"> *Method <"> | Method
+//
+// RTSP 2.0 replaces destination/source and port parameters for unicast with address lists:
+// parameter           =/  ";" "dest_addr" "=" addr-list
+//                     |   ";" "src_addr" "=" addr-list
+// addr-list           =   quoted-addr *("/" quoted-addr)
+// quoted-addr         =   <"> ( host [":" port] ) / ( ":" port ) <">
 
 // NewTransport creates default transport for media.
 func NewTransport(proto int, port int) *Transport {
@@ -116,6 +124,14 @@ func (t Transport) String() string {
 		b.WriteString(";source=")
 		b.WriteString(t.Source)
 	}
+	if len(t.DestAddr) > 0 {
+		b.WriteString(";dest_addr=")
+		b.WriteString(formatAddrList(t.DestAddr))
+	}
+	if len(t.SrcAddr) > 0 {
+		b.WriteString(";src_addr=")
+		b.WriteString(formatAddrList(t.SrcAddr))
+	}
 	if t.IsInterleaved {
 		b.WriteString(";interleaved=")
 		b.WriteString(t.Interleave.String())
@@ -160,7 +176,7 @@ func (t *Transport) Parse(value string) (err error) {
 	fields := strings.Split(value, ";")
 	for _, field := range fields {
 		keyval := strings.Split(field, "/")
-		if len(keyval) == 3 {
+		if len(keyval) == 3 && !strings.ContainsRune(field, '=') {
 			// Third part is lower protocol TCP/UDP
 			t.IsTCP = strings.ToUpper(keyval[2]) == "TCP"
 		}
@@ -182,8 +198,23 @@ func (t *Transport) Parse(value string) (err error) {
 			} else {
 				err = ErrMalformedTransport
 			}
+		case "dest_addr":
+			if len(keyval) == 2 {
+				t.DestAddr = parseAddrList(keyval[1])
+				t.ClientPort, err = addrPorts(t.DestAddr)
+			} else {
+				err = ErrMalformedTransport
+			}
+		case "src_addr":
+			if len(keyval) == 2 {
+				t.SrcAddr = parseAddrList(keyval[1])
+				t.ServerPort, err = addrPorts(t.SrcAddr)
+			} else {
+				err = ErrMalformedTransport
+			}
 		case "interleaved":
 			t.IsMulticast = false
+			t.IsInterleaved = true
 			if len(keyval) > 1 {
 				t.Interleave, err = ParsePair(keyval[1])
 			} else {
@@ -241,6 +272,53 @@ func (t *Transport) Parse(value string) (err error) {
 	return
 }
 
+// UseAddresses converts client ports into RTSP 2.0 destination address list.
+// Host part is omitted so that server sends data to the address request came from.
+func (t *Transport) UseAddresses() {
+	if t.ClientPort.One > 0 {
+		t.DestAddr = []string{":" + strconv.Itoa(t.ClientPort.One), ":" + strconv.Itoa(t.ClientPort.Two)}
+		t.ClientPort = Pair{}
+	}
+}
+
+func parseAddrList(val string) (addrs []string) {
+	for _, addr := range strings.Split(val, "/") {
+		addrs = append(addrs, strings.Trim(strings.TrimSpace(addr), "\""))
+	}
+	return
+}
+
+func formatAddrList(addrs []string) string {
+	b := strings.Builder{}
+	for i, addr := range addrs {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteByte('"')
+		b.WriteString(addr)
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// addrPorts extracts ports from the address list into a pair.  Missing ports are left as zeroes.
+func addrPorts(addrs []string) (p Pair, err error) {
+	ports := []*int{&p.One, &p.Two}
+	for i, addr := range addrs {
+		if i >= len(ports) {
+			break
+		}
+		j := strings.LastIndexByte(addr, ':')
+		if j == -1 || strings.HasSuffix(addr, "]") {
+			continue
+		}
+		if *ports[i], err = strconv.Atoi(addr[j+1:]); err != nil {
+			return p, ErrMalformedTransport
+		}
+	}
+	return
+}
+
 // ParsePair parses a pair of channels or ports in transport header.
 func ParsePair(val string) (p Pair, err error) {
 	parts := strings.Split(val, "-")
@@ -0,0 +1,42 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestTransportParse(t *testing.T) {
+	tr := NewTransport(ProtoUnicast, 0)
+	if err := tr.Parse("RTP/AVP;unicast;client_port=50000-50001;server_port=6970-6971;ssrc=1A2B3C4D;mode=PLAY"); err != nil {
+		t.Fatal(err)
+	}
+	if tr.IsTCP || tr.IsMulticast {
+		t.Errorf("Wrong lower transport TCP=%t, multicast=%t", tr.IsTCP, tr.IsMulticast)
+	}
+	if tr.ClientPort != (Pair{50000, 50001}) || tr.ServerPort != (Pair{6970, 6971}) {
+		t.Errorf("Wrong ports %v, %v", tr.ClientPort, tr.ServerPort)
+	}
+	if tr.SSRC != "1A2B3C4D" {
+		t.Errorf("Wrong SSRC %s", tr.SSRC)
+	}
+}
+
+func TestTransportAddresses(t *testing.T) {
+	tr := NewTransport(ProtoUnicast, 2)
+	tr.UseAddresses()
+	if s := tr.String(); s != `RTP/AVP;unicast;dest_addr=":50002"/":50003"` {
+		t.Error(s)
+	}
+	rsp := NewTransport(ProtoUnicast, 2)
+	if err := rsp.Parse(`RTP/AVP/UDP;unicast;dest_addr="192.0.2.5:50002"/"192.0.2.5:50003";src_addr="192.0.2.224:6256"/"192.0.2.224:6257"`); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.IsTCP {
+		t.Error("Expected UDP lower transport")
+	}
+	if rsp.ClientPort != (Pair{50002, 50003}) || rsp.ServerPort != (Pair{6256, 6257}) {
+		t.Errorf("Wrong ports %v, %v", rsp.ClientPort, rsp.ServerPort)
+	}
+	if len(rsp.SrcAddr) != 2 || rsp.SrcAddr[0] != "192.0.2.224:6256" {
+		t.Errorf("Wrong source addresses %v", rsp.SrcAddr)
+	}
+}
@@ -0,0 +1,83 @@
+package sdp
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+)
+
+// Format builds session description for a list of media.  Each media is controlled by its
+// position in the list, i.e. trackID=0, trackID=1, etc.  Address is used in origin field.
+func Format(address string, media []Media) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString("v=0\r\n")
+	buf.WriteString("o=- 0 0 IN IP4 ")
+	buf.WriteString(address)
+	buf.WriteString("\r\n")
+	buf.WriteString("s=Ouro\r\n")
+	buf.WriteString("c=IN IP4 0.0.0.0\r\n")
+	buf.WriteString("t=0 0\r\n")
+	buf.WriteString("a=control:*\r\n")
+	for i, m := range media {
+		kind := "video"
+		if m.audio {
+			kind = "audio"
+		} else if m.application {
+			kind = "application"
+		}
+		pt := strconv.Itoa(m.PayloadType)
+		buf.WriteString("m=")
+		buf.WriteString(kind)
+		buf.WriteString(" 0 RTP/AVP ")
+		buf.WriteString(pt)
+		buf.WriteString("\r\n")
+		if m.EncodingName != "" {
+			buf.WriteString("a=rtpmap:")
+			buf.WriteString(pt)
+			buf.WriteByte(' ')
+			buf.WriteString(m.EncodingName)
+			buf.WriteByte('/')
+			buf.WriteString(strconv.Itoa(m.TimeScale))
+			if m.audio && m.Channels > 1 {
+				buf.WriteByte('/')
+				buf.WriteString(strconv.Itoa(m.Channels))
+			}
+			buf.WriteString("\r\n")
+		}
+		if len(m.Fmtp) > 0 {
+			// Sort parameters to produce the same description every time.
+			keys := make([]string, 0, len(m.Fmtp))
+			for key := range m.Fmtp {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			buf.WriteString("a=fmtp:")
+			buf.WriteString(pt)
+			for j, key := range keys {
+				if j == 0 {
+					buf.WriteByte(' ')
+				} else {
+					buf.WriteByte(';')
+				}
+				buf.WriteString(key)
+				buf.WriteByte('=')
+				buf.WriteString(m.Fmtp[key])
+			}
+			buf.WriteString("\r\n")
+		}
+		if m.Range != "" {
+			buf.WriteString("a=range:")
+			buf.WriteString(m.Range)
+			buf.WriteString("\r\n")
+		}
+		if m.Direction != "" && m.Direction != SendRecv {
+			buf.WriteString("a=")
+			buf.WriteString(m.Direction)
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString("a=control:trackID=")
+		buf.WriteString(strconv.Itoa(i))
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
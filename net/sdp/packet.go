@@ -11,14 +11,24 @@ import (
 
 // Codecs
 const (
-	H264 = 96
-	AAC  = 97
+	H264     = 96
+	AAC      = 97
+	Metadata = 98 // ONVIF metadata stream with analytics and events.
+)
+
+// Media directions
+const (
+	SendRecv = "sendrecv"
+	SendOnly = "sendonly"
+	RecvOnly = "recvonly"
+	Inactive = "inactive"
 )
 
 type (
 	// Media represents descriptor for media stream supported by RTSP source.
 	Media struct {
 		audio              bool
+		application        bool
 		Type               uint
 		TimeScale          int
 		Control            string
@@ -28,12 +38,40 @@ type (
 		PayloadType        int
 		SizeLength         int
 		IndexLength        int
+		Range              string            // Range of media available for playback, e.g. npt=0-30.5
+		EncodingName       string            // Encoding name from rtpmap, e.g. H264, PCMU
+		Channels           int               // Number of audio channels
+		Direction          string            // Direction of media flow, sendrecv if not specified
+		Fmtp               map[string]string // Format specific parameters
 	}
 )
 
+// Static payload types that may be used without rtpmap attribute as defined in RFC 3551.
+var static = map[int]struct {
+	name  string
+	clock int
+}{
+	0:  {"PCMU", 8000},
+	8:  {"PCMA", 8000},
+	14: {"MPA", 90000},
+	26: {"JPEG", 90000},
+	32: {"MPV", 90000},
+}
+
+// IsAudio reports whether media is an audio stream.
+func (m Media) IsAudio() bool {
+	return m.audio
+}
+
+// IsApplication reports whether media is an application stream, e.g. ONVIF metadata.
+func (m Media) IsApplication() bool {
+	return m.application
+}
+
 // Parse parses body of RTSP response to DESCRIBE command and returns information about playable media feeds.
 func Parse(buf []byte) (feeds []Media) {
 	var m *Media
+	var sessionRange string
 	content := string(buf)
 
 	for _, line := range strings.Split(content, "\n") {
@@ -44,74 +82,126 @@ func Parse(buf []byte) (feeds []Media) {
 
 			switch keyval[0] {
 			case "m":
-				if len(fields) > 0 {
-					switch fields[0] {
-					case "video": // case "audio", "video":
-						feeds = append(feeds, Media{audio: fields[0] == "audio"})
-						m = &feeds[len(feeds)-1]
-						mfields := strings.Split(fields[1], " ")
-						if len(mfields) >= 3 {
-							m.PayloadType, _ = strconv.Atoi(mfields[2])
+				if len(fields) < 2 {
+					// Malformed media line.  Attributes that follow belong to no media.
+					m = nil
+					continue
+				}
+				switch fields[0] {
+				case "audio", "video", "application":
+					feeds = append(feeds, Media{audio: fields[0] == "audio", application: fields[0] == "application", Range: sessionRange, Direction: SendRecv})
+					m = &feeds[len(feeds)-1]
+					mfields := strings.Split(fields[1], " ")
+					if len(mfields) >= 3 {
+						m.PayloadType, _ = strconv.Atoi(mfields[2])
+						if st, ok := static[m.PayloadType]; ok {
+							m.EncodingName = st.name
+							m.TimeScale = st.clock
 						}
-					default:
-						m = nil
 					}
+				default:
+					m = nil
 				}
 			case "a":
-				if m != nil {
-					for _, field := range fields {
-						keyval = strings.SplitN(field, ":", 2)
-						if len(keyval) >= 2 {
-							key := keyval[0]
-							val := keyval[1]
-							switch key {
-							case "control":
-								m.Control = val
-							case "rtpmap":
-								m.Rtpmap, _ = strconv.Atoi(val)
-							}
-						}
-						keyval = strings.Split(field, "/")
-						if len(keyval) >= 2 {
-							key := keyval[0]
-							switch strings.ToUpper(key) {
-							case "MPEG4-GENERIC":
-								m.Type = AAC
-							case "H264":
-								m.Type = H264
-							}
-							if i, err := strconv.Atoi(keyval[1]); err == nil {
-								m.TimeScale = i
-							}
-						}
-						keyval = strings.Split(field, ";")
-						if len(keyval) > 1 {
-							for _, field := range keyval {
-								keyval := strings.SplitN(field, "=", 2)
-								if len(keyval) == 2 {
-									key := strings.TrimSpace(keyval[0])
-									val := keyval[1]
-									switch key {
-									case "config":
-										m.Config, _ = hex.DecodeString(val)
-									case "sizelength":
-										m.SizeLength, _ = strconv.Atoi(val)
-									case "indexlength":
-										m.IndexLength, _ = strconv.Atoi(val)
-									case "sprop-parameter-sets":
-										fields := strings.Split(val, ",")
-										for _, field := range fields {
-											val, _ := base64.StdEncoding.DecodeString(field)
-											m.SpropParameterSets = append(m.SpropParameterSets, val)
-										}
-									}
-								}
-							}
-						}
+				attr := strings.SplitN(keyval[1], ":", 2)
+				if m == nil {
+					if attr[0] == "range" && len(attr) == 2 {
+						// Session level range applies to all media unless overridden.
+						sessionRange = attr[1]
 					}
+					continue
 				}
+				switch attr[0] {
+				case SendRecv, SendOnly, RecvOnly, Inactive:
+					m.Direction = attr[0]
+				}
+				if len(attr) < 2 {
+					continue
+				}
+				val := attr[1]
+				switch attr[0] {
+				case "control":
+					m.Control = val
+				case "range":
+					m.Range = val
+				case "rtpmap":
+					m.parseRtpmap(val)
+				case "fmtp":
+					m.parseFmtp(val)
+				}
+			}
+		}
+	}
+	// Only application media carrying ONVIF metadata is playable.
+	playable := feeds[:0]
+	for _, m := range feeds {
+		if !m.application || m.Type == Metadata {
+			playable = append(playable, m)
+		}
+	}
+	return playable
+}
+
+// parseRtpmap parses payload type, encoding name, clock rate, and number of channels.
+// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<encoding parameters>]
+func (m *Media) parseRtpmap(val string) {
+	fields := strings.SplitN(val, " ", 2)
+	m.Rtpmap, _ = strconv.Atoi(fields[0])
+	if len(fields) < 2 {
+		return
+	}
+	parts := strings.Split(strings.TrimSpace(fields[1]), "/")
+	m.EncodingName = parts[0]
+	switch strings.ToUpper(m.EncodingName) {
+	case "MPEG4-GENERIC":
+		m.Type = AAC
+	case "H264":
+		m.Type = H264
+	case "VND.ONVIF.METADATA":
+		m.Type = Metadata
+	}
+	if len(parts) >= 2 {
+		if i, err := strconv.Atoi(parts[1]); err == nil {
+			m.TimeScale = i
+		}
+	}
+	if len(parts) >= 3 {
+		m.Channels, _ = strconv.Atoi(parts[2])
+	} else if m.audio {
+		m.Channels = 1
+	}
+}
+
+// parseFmtp parses format specific parameters.
+// a=fmtp:<format> <format specific parameters>
+func (m *Media) parseFmtp(val string) {
+	fields := strings.SplitN(val, " ", 2)
+	if len(fields) < 2 {
+		return
+	}
+	if m.Fmtp == nil {
+		m.Fmtp = make(map[string]string)
+	}
+	for _, field := range strings.Split(fields[1], ";") {
+		keyval := strings.SplitN(field, "=", 2)
+		if len(keyval) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(keyval[0])
+		val := strings.TrimSpace(keyval[1])
+		m.Fmtp[strings.ToLower(key)] = val
+		switch key {
+		case "config":
+			m.Config, _ = hex.DecodeString(val)
+		case "sizelength":
+			m.SizeLength, _ = strconv.Atoi(val)
+		case "indexlength":
+			m.IndexLength, _ = strconv.Atoi(val)
+		case "sprop-parameter-sets":
+			for _, field := range strings.Split(val, ",") {
+				val, _ := base64.StdEncoding.DecodeString(field)
+				m.SpropParameterSets = append(m.SpropParameterSets, val)
 			}
 		}
 	}
-	return
 }
@@ -27,6 +27,79 @@ a=control:trackID=2
 a=rtpmap:111 X-KATA/1000
 a=fmtp:111 octet-align=1
 b=AS:2
+m=application 0 RTP/AVP 107
+a=control:trackID=3
+a=rtpmap:107 vnd.onvif.metadata/90000
 `))
 	t.Logf("%v", feeds)
+	if len(feeds) == 0 || feeds[0].Range != "npt=0-" {
+		t.Error("Session level range is not applied to media")
+	}
+	if len(feeds) != 3 || !feeds[2].IsApplication() || feeds[2].Type != Metadata || feeds[2].Control != "trackID=3" {
+		t.Errorf("Wrong application feeds %#v", feeds)
+	}
+}
+
+func TestParseBackchannel(t *testing.T) {
+	feeds := Parse([]byte(`v=0
+o=- 2890842807 2890842807 IN IP4 192.168.0.1
+s=RTSP Session with audiobackchannel
+m=video 0 RTP/AVP 26
+a=control:rtsp://192.168.0.1/video
+a=recvonly
+m=audio 0 RTP/AVP 0
+a=control:rtsp://192.168.0.1/audio
+a=recvonly
+m=audio 0 RTP/AVP 0
+a=control:rtsp://192.168.0.1/audioback
+a=rtpmap:0 PCMU/8000
+a=sendonly
+`))
+	if len(feeds) != 3 {
+		t.Fatalf("Parsed %d feeds, expected 3", len(feeds))
+	}
+	if feeds[0].EncodingName != "JPEG" || feeds[0].TimeScale != 90000 || feeds[0].IsAudio() {
+		t.Errorf("Wrong video feed %#v", feeds[0])
+	}
+	if feeds[1].Direction != RecvOnly || feeds[1].EncodingName != "PCMU" || !feeds[1].IsAudio() {
+		t.Errorf("Wrong audio feed %#v", feeds[1])
+	}
+	if feeds[2].Direction != SendOnly || feeds[2].TimeScale != 8000 || feeds[2].Channels != 1 {
+		t.Errorf("Wrong backchannel feed %#v", feeds[2])
+	}
+}
+
+func TestParseMalformedMedia(t *testing.T) {
+	feeds := Parse([]byte("v=0\r\nm=video \r\na=control:video\r\nm=\r\nm=audio 0 RTP/AVP 0\r\na=control:audio\r\n"))
+	if len(feeds) != 1 {
+		t.Fatalf("Parsed %d feeds, expected 1", len(feeds))
+	}
+	if feeds[0].Control != "audio" || feeds[0].EncodingName != "PCMU" {
+		t.Errorf("Wrong audio feed %#v", feeds[0])
+	}
+}
+
+func TestFormat(t *testing.T) {
+	feeds := Parse([]byte(`v=0
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=control:trackID=3
+a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z2QAKKzoBQBbkA==,aO48sA==; profile-level-id=640028
+m=audio 0 RTP/AVP 97
+a=control:trackID=4
+a=rtpmap:97 MPEG4-GENERIC/16000/2
+a=fmtp:97 mode=AAC-hbr;sizelength=13;indexlength=3;config=1410
+`))
+	expected := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=Ouro\r\nc=IN IP4 0.0.0.0\r\nt=0 0\r\na=control:*\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1;profile-level-id=640028;sprop-parameter-sets=Z2QAKKzoBQBbkA==,aO48sA==\r\na=control:trackID=0\r\n" +
+		"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/16000/2\r\n" +
+		"a=fmtp:97 config=1410;indexlength=3;mode=AAC-hbr;sizelength=13\r\na=control:trackID=1\r\n"
+	if out := string(Format("127.0.0.1", feeds)); out != expected {
+		t.Error(out)
+	}
+	again := Parse(Format("127.0.0.1", feeds))
+	if len(again) != 2 || again[1].SizeLength != 13 || len(again[0].SpropParameterSets) != 2 {
+		t.Errorf("Description does not survive round trip %#v", again)
+	}
 }